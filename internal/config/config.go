@@ -1,8 +1,11 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,6 +17,8 @@ type Config struct {
 	Database DatabaseConfig
 	FFMPEG   FFMPEGConfig
 	Storage  StorageConfig
+	Webhook  WebhookConfig
+	Policy   CommandPolicyConfig
 }
 
 // ServerConfig holds HTTP server related configuration
@@ -23,6 +28,7 @@ type ServerConfig struct {
 	WriteTimeout   time.Duration
 	APITokenLength int
 	AllowedOrigins []string
+	AdminUsernames []string // users allowed to manage global presets
 }
 
 // DatabaseConfig holds database related configuration
@@ -35,22 +41,75 @@ type DatabaseConfig struct {
 // FFMPEGConfig holds FFMPEG related configuration
 type FFMPEGConfig struct {
 	BinaryPath             string
+	ProbePath              string // ffprobe binary used to inspect media files
+	ProbeSyncMaxSize       int64  // bytes up to which the probe endpoint answers synchronously
 	TempDirectory          string
-	ProgressUpdateInterval time.Duration
+	ProgressUpdateInterval time.Duration // how often FFMPEG progress is stored, live updates are not throttled
+	Workers                int           // concurrent FFMPEG jobs per node
+	QueuePollInterval      time.Duration
+	KillGracePeriod        time.Duration // time FFMPEG gets to exit after SIGINT before it is killed
+	NodeID                 string        // identifies this node's workers in the job queue
+	RecoveryMode           string        // "requeue" or "fail" for jobs interrupted by a restart
+	LeaseTimeout           time.Duration // time after its last heartbeat a job is taken over from its node
+	BatchMaxJobs           int           // most jobs one batch submission may create
+	StreamInputMinSize     int64         // bytes from which inputs in auto mode are streamed rather than downloaded, 0 for never
 }
 
 // StorageConfig holds storage related configuration
 type StorageConfig struct {
-	Provider        string // "local" or "minio"
-	TempDirectory   string
-	MinioEndpoint   string
-	MinioPort       string
-	MinioAccessKey  string
-	MinioSecretKey  string
-	MinioUseSSL     bool
-	MinioBucketName string
-	MinioRegion     string
-	MinioBucketURL  string
+	Provider           string // storage driver: "local", "filesystem", "minio", "s3", "gcs" or "memory"
+	TempDirectory      string
+	FSRoot             string // directory the filesystem driver keeps objects in
+	FSBaseURL          string // URL the filesystem driver's objects are served at
+	MinioEndpoint      string
+	MinioPort          string
+	MinioAccessKey     string
+	MinioSecretKey     string
+	MinioUseSSL        bool
+	MinioBucketName    string
+	MinioRegion        string
+	MinioBucketURL     string
+	S3Endpoint         string // defaults to AWS for "s3" and Google Cloud Storage for "gcs"
+	S3Region           string
+	S3Bucket           string
+	S3AccessKey        string // if empty, credentials come from the AWS environment, files or IAM role
+	S3SecretKey        string
+	S3SessionToken     string
+	S3UseSSL           bool
+	S3BucketLookup     string // "auto", "virtual-host" or "path"
+	S3PublicURL        string // URL objects are downloaded from, derived from the endpoint if empty
+	S3RoleARN          string // role assumed with STS using the access key, if set
+	S3STSEndpoint      string
+	CredentialsKey     string        // encrypts the storage credentials of users; they can't be stored without it
+	DestinationBuckets []string      // buckets outputs may be delivered to with the storage's own credentials
+	Private            bool          // keep objects private and hand out URLs to them that expire
+	URLExpiry          time.Duration // how long the URLs of private objects are valid
+	URLSigningKey      string        // signs the URLs of the filesystem driver's private objects and of served playlists
+	PlaylistBaseURL    string        // URL the playlists of private storage are served at
+	KeyTemplate        string        // object keys of output files under the user's prefix, e.g. "{job}/{path}"
+	Dedup              bool          // skip uploading output files whose content-addressed object exists
+	UploadMaxSize      int64         // bytes one uploaded input file may have
+	UploadQuota        int64         // bytes a user's uploads may take up in total, 0 for no limit
+	UploadExpiry       time.Duration // inactivity after which incomplete resumable uploads are deleted
+	UploadPartSize     int64         // bytes of the parts resumable uploads are written to storage in
+}
+
+// WebhookConfig holds outbound webhook related configuration
+type WebhookConfig struct {
+	Timeout        time.Duration
+	MaxAttempts    int
+	RetryBaseDelay time.Duration // doubled after every failed attempt
+	AllowPrivate   bool          // deliver to loopback, link-local and private addresses, for development
+}
+
+// CommandPolicyConfig restricts what user supplied FFMPEG commands may do
+type CommandPolicyConfig struct {
+	AllowedOptions      []string // if set, the only options a command may use
+	DeniedOptions       []string
+	DeniedFilters       []string
+	DeniedFilterOptions []string // filter options that are rejected on any filter, e.g. "textfile"
+	DeniedFormats       []string // formats that may not be forced with -f
+	ProtocolWhitelist   string   // protocols FFMPEG may open inputs with
 }
 
 // LoadConfig loads configuration from environment variables
@@ -65,14 +124,46 @@ func LoadConfig() (*Config, error) {
 	apiTokenLength, _ := strconv.Atoi(getEnv("API_TOKEN_LENGTH", "32"))
 	progressInterval, _ := strconv.Atoi(getEnv("PROGRESS_UPDATE_INTERVAL", "5"))
 	useSSL, _ := strconv.ParseBool(getEnv("MINIO_USE_SSL", "false"))
+	s3UseSSL, _ := strconv.ParseBool(getEnv("S3_USE_SSL", "true"))
+	storagePrivate, _ := strconv.ParseBool(getEnv("STORAGE_PRIVATE", "false"))
+	urlExpiry, _ := strconv.Atoi(getEnv("STORAGE_URL_EXPIRY_MINUTES", "60"))
+	storageDedup, _ := strconv.ParseBool(getEnv("STORAGE_DEDUP", "false"))
+	port := getEnv("SERVER_PORT", "8000")
+	workers, _ := strconv.Atoi(getEnv("FFMPEG_WORKERS", "2"))
+	queuePollInterval, _ := strconv.Atoi(getEnv("QUEUE_POLL_INTERVAL", "5"))
+	killGracePeriod, _ := strconv.Atoi(getEnv("FFMPEG_KILL_GRACE_PERIOD", "10"))
+	batchMaxJobs, _ := strconv.Atoi(getEnv("BATCH_MAX_JOBS", "1000"))
+	probeSyncMaxSize, _ := strconv.ParseInt(getEnv("PROBE_SYNC_MAX_SIZE_MB", "50"), 10, 64)
+	streamInputMinSize, _ := strconv.ParseInt(getEnv("FFMPEG_STREAM_INPUT_MIN_SIZE_MB", "0"), 10, 64)
+	uploadMaxSize, _ := strconv.ParseInt(getEnv("UPLOAD_MAX_SIZE_MB", "5120"), 10, 64)
+	uploadQuota, _ := strconv.ParseInt(getEnv("UPLOAD_QUOTA_MB", "0"), 10, 64)
+	uploadExpiry, _ := strconv.Atoi(getEnv("UPLOAD_EXPIRY_HOURS", "24"))
+	uploadPartSize, _ := strconv.ParseInt(getEnv("UPLOAD_PART_SIZE_MB", "16"), 10, 64)
+	leaseTimeout, _ := strconv.Atoi(getEnv("JOB_LEASE_TIMEOUT", "60"))
+	webhookTimeout, _ := strconv.Atoi(getEnv("WEBHOOK_TIMEOUT", "10"))
+	webhookMaxAttempts, _ := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "5"))
+	webhookRetryDelay, _ := strconv.Atoi(getEnv("WEBHOOK_RETRY_BASE_DELAY", "2"))
+	webhookAllowPrivate, _ := strconv.ParseBool(getEnv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "false"))
+
+	recoveryMode := getEnv("JOB_RECOVERY_MODE", "requeue")
+	if recoveryMode != "requeue" && recoveryMode != "fail" {
+		return nil, fmt.Errorf("JOB_RECOVERY_MODE must be requeue or fail, not %q", recoveryMode)
+	}
+
+	// Playlists of private storage are served at URLs signed with the key
+	urlSigningKey := getEnv("STORAGE_URL_SIGNING_KEY", "")
+	if storagePrivate && urlSigningKey == "" {
+		return nil, errors.New("STORAGE_PRIVATE needs STORAGE_URL_SIGNING_KEY to sign URLs")
+	}
 
 	return &Config{
 		Server: ServerConfig{
-			Port:           getEnv("SERVER_PORT", "8000"),
+			Port:           port,
 			ReadTimeout:    time.Second * 15,
 			WriteTimeout:   time.Second * 15,
 			APITokenLength: apiTokenLength,
 			AllowedOrigins: []string{"*"}, // Configure as needed
+			AdminUsernames: getEnvList("ADMIN_USERNAMES", ""),
 		},
 		Database: DatabaseConfig{
 			Driver: getEnv("DB_DRIVER", "sqlite"),
@@ -81,24 +172,99 @@ func LoadConfig() (*Config, error) {
 		},
 		FFMPEG: FFMPEGConfig{
 			BinaryPath:             getEnv("FFMPEG_PATH", "/usr/bin/ffmpeg"),
+			ProbePath:              getEnv("FFPROBE_PATH", "/usr/bin/ffprobe"),
+			ProbeSyncMaxSize:       probeSyncMaxSize * 1024 * 1024,
 			TempDirectory:          getEnv("TEMP_DIR", "tmp"),
 			ProgressUpdateInterval: time.Duration(progressInterval) * time.Second,
+			Workers:                workers,
+			QueuePollInterval:      time.Duration(queuePollInterval) * time.Second,
+			KillGracePeriod:        time.Duration(killGracePeriod) * time.Second,
+			NodeID:                 getEnv("NODE_ID", hostname()),
+			RecoveryMode:           recoveryMode,
+			LeaseTimeout:           time.Duration(max(leaseTimeout, 10)) * time.Second,
+			BatchMaxJobs:           batchMaxJobs,
+			StreamInputMinSize:     streamInputMinSize * 1024 * 1024,
 		},
 		Storage: StorageConfig{
-			Provider:        getEnv("STORAGE_PROVIDER", "local"),
-			TempDirectory:   getEnv("TEMP_DIR", "tmp"),
-			MinioEndpoint:   getEnv("MINIO_ENDPOINT", "127.0.0.1"),
-			MinioPort:       getEnv("MINIO_PORT", "56732"),
-			MinioAccessKey:  getEnv("MINIO_ACCESS_KEY", ""),
-			MinioSecretKey:  getEnv("MINIO_SECRET_KEY", ""),
-			MinioUseSSL:     useSSL,
-			MinioBucketName: getEnv("MINIO_BUCKET_NAME", "ffmpeg-files"),
-			MinioRegion:     getEnv("MINIO_REGION", "us-east-1"),
-			MinioBucketURL:  getEnv("MINIO_BUCKET_URL", "http://127.0.0.1:9000"),
+			Provider:           getEnv("STORAGE_PROVIDER", "local"),
+			TempDirectory:      getEnv("TEMP_DIR", "tmp"),
+			FSRoot:             getEnv("STORAGE_FS_ROOT", "storage"),
+			FSBaseURL:          strings.TrimSuffix(getEnv("STORAGE_FS_BASE_URL", "http://localhost:"+port+"/api/v1/storage"), "/"),
+			MinioEndpoint:      getEnv("MINIO_ENDPOINT", "127.0.0.1"),
+			MinioPort:          getEnv("MINIO_PORT", "56732"),
+			MinioAccessKey:     getEnv("MINIO_ACCESS_KEY", ""),
+			MinioSecretKey:     getEnv("MINIO_SECRET_KEY", ""),
+			MinioUseSSL:        useSSL,
+			MinioBucketName:    getEnv("MINIO_BUCKET_NAME", "ffmpeg-files"),
+			MinioRegion:        getEnv("MINIO_REGION", "us-east-1"),
+			MinioBucketURL:     getEnv("MINIO_BUCKET_URL", "http://127.0.0.1:9000"),
+			S3Endpoint:         getEnv("S3_ENDPOINT", ""),
+			S3Region:           getEnv("S3_REGION", "us-east-1"),
+			S3Bucket:           getEnv("S3_BUCKET", "ffmpeg-files"),
+			S3AccessKey:        getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey:        getEnv("S3_SECRET_KEY", ""),
+			S3SessionToken:     getEnv("S3_SESSION_TOKEN", ""),
+			S3UseSSL:           s3UseSSL,
+			S3BucketLookup:     getEnv("S3_BUCKET_LOOKUP", "auto"),
+			S3PublicURL:        strings.TrimSuffix(getEnv("S3_PUBLIC_URL", ""), "/"),
+			S3RoleARN:          getEnv("S3_ROLE_ARN", ""),
+			S3STSEndpoint:      getEnv("S3_STS_ENDPOINT", "https://sts.amazonaws.com"),
+			CredentialsKey:     getEnv("STORAGE_CREDENTIALS_KEY", ""),
+			DestinationBuckets: getEnvList("STORAGE_DESTINATION_BUCKETS", ""),
+			Private:            storagePrivate,
+			URLExpiry:          min(max(time.Duration(urlExpiry), 1)*time.Minute, 7*24*time.Hour), // the longest S3 presigned URLs last
+			URLSigningKey:      urlSigningKey,
+			PlaylistBaseURL:    strings.TrimSuffix(getEnv("STORAGE_PLAYLIST_BASE_URL", "http://localhost:"+port+"/api/v1/playlists"), "/"),
+			KeyTemplate:        getEnv("STORAGE_KEY_TEMPLATE", "{job}/{path}"),
+			Dedup:              storageDedup,
+			UploadMaxSize:      uploadMaxSize * 1024 * 1024,
+			UploadQuota:        uploadQuota * 1024 * 1024,
+			UploadExpiry:       time.Duration(uploadExpiry) * time.Hour,
+			UploadPartSize:     max(uploadPartSize, 5) * 1024 * 1024, // the minimum part size of S3
+		},
+		Webhook: WebhookConfig{
+			Timeout:        time.Duration(webhookTimeout) * time.Second,
+			MaxAttempts:    webhookMaxAttempts,
+			RetryBaseDelay: time.Duration(webhookRetryDelay) * time.Second,
+			AllowPrivate:   webhookAllowPrivate,
+		},
+		Policy: CommandPolicyConfig{
+			AllowedOptions: getEnvList("FFMPEG_ALLOWED_OPTIONS", ""),
+			DeniedOptions: getEnvList("FFMPEG_DENIED_OPTIONS",
+				"-filter_script,-filter_complex_script,-dump_attachment,-attach,-vstats,-vstats_file,"+
+					"-passlogfile,-report,-progress,-protocol_whitelist,-protocol_blacklist,-safe,-sdp_file,"+
+					"-fpre,-pre,-vpre,-apre,-spre,-stats_enc_pre,-stats_enc_post,-stats_mux_pre,"+
+					"-hls_key_info_file,-key_info_file"),
+			DeniedFilters: getEnvList("FFMPEG_DENIED_FILTERS",
+				"movie,amovie,sendcmd,asendcmd,zmq,azmq,subtitles,ass,ladspa,lv2,frei0r,frei0r_src"),
+			DeniedFilterOptions: getEnvList("FFMPEG_DENIED_FILTER_OPTIONS", "textfile,fontfile,file,filename"),
+			DeniedFormats:       getEnvList("FFMPEG_DENIED_FORMATS", "lavfi"),
+			ProtocolWhitelist:   getEnv("FFMPEG_PROTOCOL_WHITELIST", "file"),
 		},
 	}, nil
 }
 
+// hostname returns the machine's host name, used as the default node ID
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}
+
+// getEnvList gets a comma-separated environment variable as a list, or the
+// default list if it is not set
+func getEnvList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -14,6 +14,7 @@ type User struct {
 	Email          string    `gorm:"uniqueIndex" json:"email"`
 	Password       string    `json:"-"`
 	APIToken       string    `gorm:"uniqueIndex" json:"api_token"`
+	WebhookSecret  string    `json:"-"`
 	UsageCount     int       `gorm:"default:0" json:"usage_count"`
 	BytesProcessed int64     `gorm:"default:0" json:"bytes_processed"`
 	CreatedAt      time.Time `json:"created_at"`
@@ -27,8 +28,46 @@ type OutputFileMetadata struct {
 	FileType   string  `json:"file_type"`
 	FileFormat string  `json:"file_format"`
 	StorageURL string  `json:"storage_url"`
+	ObjectKey  string  `json:"object_key,omitempty"` // key StorageURL is issued for, empty for outputs delivered to a destination
 	Width      int     `json:"width"`
 	Height     int     `json:"height"`
+	// Filled from ffprobe, empty when the file could not be probed
+	Duration  float64       `json:"duration,omitempty"`  // seconds
+	Container string        `json:"container,omitempty"` // e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	BitRate   int64         `json:"bit_rate,omitempty"`  // bits per second
+	Streams   []MediaStream `json:"streams,omitempty"`
+	SHA256    string        `json:"sha256"` // hex encoded checksum of the file
+	// Set for HLS and DASH outputs: the URL of the playlist or manifest to play
+	ManifestURL string `json:"manifest_url,omitempty"`
+	// Set for directory and glob outputs: every file they designate
+	Files []OutputFile `json:"files,omitempty"`
+	// Set for the outputs of storyboard jobs: the layout of the sprite sheets
+	Storyboard *StoryboardLayout `json:"storyboard,omitempty"`
+}
+
+// OutputFile is one of the files of a directory or glob output
+type OutputFile struct {
+	Path       string `json:"path"` // relative to the job's directory, e.g. "hls/segment_000.ts"
+	StorageURL string `json:"storage_url"`
+	ObjectKey  string `json:"object_key,omitempty"`
+	SizeBytes  int64  `json:"size_bytes"`
+	SHA256     string `json:"sha256"`
+}
+
+// JobFile is an output file of a job with its URLs issued anew
+type JobFile struct {
+	JobUUID string
+	Key     string
+	OutputFileMetadata
+	ExpiresAt *time.Time // when the URLs expire, nil if they don't
+}
+
+// JobOutputFile indexes the job of an output file by its file ID, which is
+// otherwise only kept in the JSON of the job's output files
+type JobOutputFile struct {
+	FileID string `gorm:"primaryKey"`
+	JobID  uint   `gorm:"index"`
+	UserID uint
 }
 
 // OutputFilesMap is a custom type for handling the map of output files in the database
@@ -63,21 +102,186 @@ func (o *OutputFileMetadata) Scan(value interface{}) error {
 	return json.Unmarshal(value.([]byte), &o)
 }
 
+// Job status values. QUEUED jobs wait in the database until a worker claims
+// them, which moves them to PROCESSING. SUCCESS, FAILED and CANCELLED are terminal.
+const (
+	JobStatusQueued     = "QUEUED"
+	JobStatusProcessing = "PROCESSING"
+	JobStatusSuccess    = "SUCCESS"
+	JobStatusFailed     = "FAILED"
+	JobStatusCancelled  = "CANCELLED"
+)
+
+// Job types. FFMPEG jobs run the command of their request, probe jobs only
+// describe their single input with ffprobe, ABR jobs package their single
+// input as an adaptive bitrate ladder and storyboard jobs tile thumbnails of
+// their single input into sprite sheets.
+const (
+	JobTypeFFmpeg     = "ffmpeg"
+	JobTypeProbe      = "probe"
+	JobTypeABR        = "abr"
+	JobTypeStoryboard = "storyboard"
+)
+
+// IsTerminalJobStatus reports whether a job with the given status is done
+func IsTerminalJobStatus(status string) bool {
+	return status == JobStatusSuccess || status == JobStatusFailed || status == JobStatusCancelled
+}
+
 // JobStatus represents the status of an FFMPEG job.
 type JobStatus struct {
-	ID                      uint           `gorm:"primaryKey" json:"id"`
-	UUID                    string         `gorm:"uniqueIndex" json:"uuid"`
-	Status                  string         `json:"status"`
-	Result                  string         `json:"-"`
-	Progress                int            `json:"progress"`
-	Error                   string         `json:"error,omitempty"`
-	UserID                  uint           `json:"user_id"`
-	OriginalRequest         *FFMPEGRequest `json:"original_request,omitempty" gorm:"type:jsonb"`
-	OutputFiles             OutputFilesMap `json:"output_files,omitempty" gorm:"type:jsonb"`
-	FFmpegCommandRunSeconds float64        `json:"ffmpeg_command_run_seconds,omitempty"`
-	TotalProcessingSeconds  float64        `json:"total_processing_seconds,omitempty"`
-	CreatedAt               time.Time      `json:"created_at"`
-	UpdatedAt               time.Time      `json:"updated_at"`
+	ID                      uint             `gorm:"primaryKey" json:"id"`
+	UUID                    string           `gorm:"uniqueIndex" json:"uuid"`
+	Type                    string           `gorm:"default:ffmpeg" json:"type"`
+	Status                  string           `gorm:"index" json:"status"`
+	Result                  string           `json:"-"`
+	Progress                int              `json:"progress"`
+	QueuePosition           int              `gorm:"-" json:"queue_position,omitempty"`
+	Error                   string           `json:"error,omitempty"`
+	UserID                  uint             `gorm:"index:idx_job_user_created,priority:1;index:idx_job_user_updated,priority:1" json:"user_id"`
+	BatchID                 string           `gorm:"index" json:"batch_id,omitempty"` // set for jobs submitted in a batch
+	WorkerNode              string           `gorm:"index" json:"-"`
+	HeartbeatAt             *time.Time       `json:"-"` // last time the worker processing the job confirmed it still does
+	OriginalRequest         *FFMPEGRequest   `json:"original_request,omitempty" gorm:"type:jsonb"`
+	OutputFiles             OutputFilesMap   `json:"output_files,omitempty" gorm:"type:jsonb"`
+	OutputFileTypes         string           `json:"-"` // comma-delimited file types of the requested outputs, e.g. ",video,image,"
+	InputProbes             MediaProbesMap   `json:"input_probes,omitempty" gorm:"type:jsonb"`
+	ExpectedDuration        float64          `json:"expected_duration,omitempty"` // seconds of output FFMPEG should write, 0 when unknown
+	FFmpegProgress          *FFmpegProgress  `json:"ffmpeg_progress,omitempty" gorm:"type:jsonb"`
+	Steps                   StepStatuses     `json:"steps,omitempty" gorm:"type:jsonb"`      // state of each step of a multi-step job
+	Renditions              RenditionResults `json:"renditions,omitempty" gorm:"type:jsonb"` // outcome of each rendition of an ABR job
+	FFmpegCommandRunSeconds float64          `json:"ffmpeg_command_run_seconds,omitempty"`
+	TotalProcessingSeconds  float64          `json:"total_processing_seconds,omitempty"`
+	CreatedAt               time.Time        `gorm:"index:idx_job_user_created,priority:2" json:"created_at"`
+	UpdatedAt               time.Time        `gorm:"index:idx_job_user_updated,priority:2" json:"updated_at"`
+}
+
+// MediaProbe is the normalized ffprobe description of a media file
+type MediaProbe struct {
+	Format  MediaFormat   `json:"format"`
+	Streams []MediaStream `json:"streams"`
+}
+
+// MediaFormat describes the container of a media file
+type MediaFormat struct {
+	Name      string  `json:"name"`     // e.g. "mov,mp4,m4a,3gp,3g2,mj2"
+	Duration  float64 `json:"duration"` // seconds, 0 when unknown
+	StartTime float64 `json:"start_time"`
+	Size      int64   `json:"size"`
+	BitRate   int64   `json:"bit_rate"`
+}
+
+// MediaStream describes a single stream of a media file
+type MediaStream struct {
+	Index         int     `json:"index"`
+	CodecType     string  `json:"codec_type"` // "video", "audio", "subtitle", "data" or "attachment"
+	CodecName     string  `json:"codec_name"`
+	Duration      float64 `json:"duration,omitempty"`
+	BitRate       int64   `json:"bit_rate,omitempty"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	FrameRate     float64 `json:"frame_rate,omitempty"`
+	PixFmt        string  `json:"pix_fmt,omitempty"`
+	Rotation      int     `json:"rotation,omitempty"` // degrees the video is rotated by when displayed
+	SampleRate    int     `json:"sample_rate,omitempty"`
+	Channels      int     `json:"channels,omitempty"`
+	ChannelLayout string  `json:"channel_layout,omitempty"`
+}
+
+// MediaProbesMap holds the probes of a job's input files by placeholder key
+type MediaProbesMap map[string]MediaProbe
+
+// Scan implements the sql.Scanner interface for MediaProbesMap
+func (m *MediaProbesMap) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, &m)
+}
+
+// Value implements the driver.Valuer interface for MediaProbesMap
+func (m MediaProbesMap) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+// FFmpegProgress is the latest progress report FFMPEG wrote to its -progress
+// output while running a job
+type FFmpegProgress struct {
+	Frame      int64   `json:"frame"`
+	FPS        float64 `json:"fps"`
+	Bitrate    string  `json:"bitrate,omitempty"` // e.g. "1205.3kbits/s", empty while unknown
+	TotalSize  int64   `json:"total_size"`        // bytes written so far
+	OutTimeUs  int64   `json:"out_time_us"`       // position reached in the output, in microseconds
+	OutTime    string  `json:"out_time,omitempty"`
+	Speed      string  `json:"speed,omitempty"` // e.g. "1.5x", empty while unknown
+	DupFrames  int64   `json:"dup_frames"`
+	DropFrames int64   `json:"drop_frames"`
+	Done       bool    `json:"done"` // FFMPEG reported progress=end
+}
+
+// Scan implements the sql.Scanner interface for FFmpegProgress
+func (p *FFmpegProgress) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, &p)
+}
+
+// Value implements the driver.Valuer interface for FFmpegProgress
+func (p FFmpegProgress) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+// ProgressEvent is a live update of a job's status or FFMPEG progress
+type ProgressEvent struct {
+	JobUUID     string
+	Status      string
+	Progress    int
+	FFmpeg      *FFmpegProgress // set while FFMPEG runs
+	OutputFiles OutputFilesMap
+}
+
+// JobCursor marks the position of the last job on a page of a job listing
+type JobCursor struct {
+	CreatedAt time.Time `json:"v"`
+	ID        uint      `json:"id"`
+}
+
+// JobFilter describes which jobs of a user to list and in which order. Jobs
+// are ordered by creation time, which doesn't change, so pages neither skip
+// nor repeat jobs updated while they are fetched.
+type JobFilter struct {
+	UserID        uint
+	Statuses      []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	OutputType    string
+	BatchID       string
+	Descending    bool
+	Limit         int
+	After         *JobCursor
+}
+
+// JobList is a page of jobs
+type JobList struct {
+	Jobs       []JobStatus
+	NextCursor string
 }
 
 // FFMPEGRequest represents the request body for the /ffmpeg endpoint.
@@ -85,6 +289,116 @@ type FFMPEGRequest struct {
 	InputFiles    map[string]string `json:"input_files" gorm:"type:jsonb"`
 	OutputFiles   map[string]string `json:"output_files" gorm:"type:jsonb"`
 	FFmpegCommand string            `json:"ffmpeg_command"`
+	// Structured alternative to FFmpegCommand. When Inputs is set, the command
+	// is assembled from these and InputFiles and OutputFiles are derived from them.
+	GlobalOptions []string       `json:"global_options,omitempty"`
+	Inputs        []FFMPEGInput  `json:"inputs,omitempty"`
+	Outputs       []FFMPEGOutput `json:"outputs,omitempty"`
+	// Preset names a preset whose command is used instead of FFmpegCommand,
+	// with its variables set from PresetVariables or their defaults
+	Preset          string                 `json:"preset,omitempty"`
+	PresetVariables map[string]interface{} `json:"preset_variables,omitempty"`
+	// Steps makes the job run several FFMPEG commands in one directory, where
+	// a step reads the outputs of earlier steps as {{step.key}}. Upload lists
+	// the step outputs to upload; OutputFiles is derived from it.
+	Steps  []PipelineStep `json:"steps,omitempty"`
+	Upload []string       `json:"upload,omitempty"`
+	// ABR is the ladder an ABR job packages its single input file into
+	ABR *ABRLadder `json:"abr,omitempty"`
+	// Storyboard describes the sprite sheets of a storyboard job
+	Storyboard *StoryboardSpec `json:"storyboard,omitempty"`
+	// Destinations are the buckets outputs are delivered to instead of the
+	// configured storage, by output key
+	Destinations  map[string]OutputDestination `json:"destinations,omitempty"`
+	WebhookURL    string                       `json:"webhook_url,omitempty"`
+	WebhookEvents []string                     `json:"webhook_events,omitempty"`
+	// InputModes says how FFMPEG reads input files, by input key. Inputs
+	// without one are in InputModeAuto.
+	InputModes map[string]string `json:"input_modes,omitempty"`
+}
+
+// Modes in which FFMPEG reads input files
+const (
+	// InputModeAuto streams inputs of at least FFMPEG_STREAM_INPUT_MIN_SIZE_MB
+	// and downloads the others
+	InputModeAuto = "auto"
+	// InputModeDownload downloads inputs into the job's directory first
+	InputModeDownload = "download"
+	// InputModeURL has FFMPEG read inputs over HTTP(S), seeking with range
+	// requests; storage objects are read through presigned URLs
+	InputModeURL = "url"
+	// InputModePipe pipes inputs to FFMPEG, which can't seek in them
+	InputModePipe = "pipe"
+)
+
+// OutputDestination is a bucket an output is delivered to, under a prefix.
+// The bucket is accessed with a storage credential of the job's user, or with
+// the server's own credentials if none is named.
+type OutputDestination struct {
+	Bucket      string `json:"bucket"`
+	Prefix      string `json:"prefix,omitempty"`
+	Credentials string `json:"credentials,omitempty"` // name of a storage credential
+}
+
+// FFMPEGInput is an input file of a structured request with its options
+type FFMPEGInput struct {
+	Key     string   `json:"key"`
+	URL     string   `json:"url"`
+	Options []string `json:"options,omitempty"`
+}
+
+// FFMPEGOutput is an output file of a structured request with its options
+type FFMPEGOutput struct {
+	Key      string   `json:"key"`
+	Filename string   `json:"filename"`
+	Options  []string `json:"options,omitempty"`
+}
+
+// PipelineStep is one FFMPEG command of a multi-step job
+type PipelineStep struct {
+	ID              string                 `json:"id"`
+	FFmpegCommand   string                 `json:"ffmpeg_command"`
+	Preset          string                 `json:"preset,omitempty"`
+	PresetVariables map[string]interface{} `json:"preset_variables,omitempty"`
+	OutputFiles     map[string]string      `json:"output_files"`
+}
+
+// StepStatus is the state of a step of a multi-step job. Steps that have not
+// run have the QUEUED status.
+type StepStatus struct {
+	ID               string     `json:"id"`
+	Status           string     `json:"status"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+	RunSeconds       float64    `json:"run_seconds,omitempty"`
+	ExpectedDuration float64    `json:"expected_duration,omitempty"`
+	Log              string     `json:"log,omitempty"` // the last lines FFMPEG logged
+	Error            string     `json:"error,omitempty"`
+}
+
+// StepStatuses holds the step states of a multi-step job in execution order
+type StepStatuses []StepStatus
+
+// Scan implements the sql.Scanner interface for StepStatuses
+func (s *StepStatuses) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, &s)
+}
+
+// Value implements the driver.Valuer interface for StepStatuses
+func (s StepStatuses) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	return json.Marshal(s)
 }
 
 // Scan implements the sql.Scanner interface for FFMPEGRequest
@@ -112,10 +426,190 @@ func (f FFMPEGRequest) Value() (driver.Value, error) {
 	return json.Marshal(f)
 }
 
+// ProbeResult is the outcome of a probe request. Small files are probed right
+// away and the result has Probe set, larger ones are probed by a queued job
+// and the result has Job set.
+type ProbeResult struct {
+	Size  int64 // bytes, -1 when the storage did not report it
+	Probe *MediaProbe
+	Job   *FFMPEGResponse
+}
+
+// BatchRequest is a batch of processing requests, given either as Requests or
+// as a Template that is submitted once for every input set
+type BatchRequest struct {
+	Requests  []FFMPEGRequest
+	Template  *FFMPEGRequest
+	InputSets []BatchInputSet
+}
+
+// BatchInputSet sets the input files, and optionally output files, of one job
+// of a batch template by key
+type BatchInputSet struct {
+	InputFiles  map[string]string
+	OutputFiles map[string]string
+}
+
+// BatchResult is the outcome of a batch submission, with a job for every
+// request in the order they were given
+type BatchResult struct {
+	BatchID string
+	Jobs    []FFMPEGResponse
+}
+
+// Batch statuses, derived from the statuses of a batch's jobs
+const (
+	BatchStatusQueued         = "QUEUED"     // no job has started
+	BatchStatusProcessing     = "PROCESSING" // some jobs have not finished
+	BatchStatusSuccess        = "SUCCESS"    // every job succeeded
+	BatchStatusPartialSuccess = "PARTIAL_SUCCESS"
+	BatchStatusFailed         = "FAILED" // no job succeeded
+)
+
+// BatchStatus is the aggregate state of the jobs of a batch
+type BatchStatus struct {
+	BatchID  string
+	Status   string
+	Total    int
+	Counts   map[string]int // number of jobs by job status
+	Progress int            // average progress of the jobs
+}
+
+// JobStatusCount is the number of jobs with a status and their summed progress
+type JobStatusCount struct {
+	Status      string
+	Count       int
+	ProgressSum int
+}
+
 // FFMPEGResponse represents the response from the FFMPEG processing endpoint.
 type FFMPEGResponse struct {
-	UUID   string `json:"uuid"`
-	Status string `json:"status"`
+	UUID          string `json:"uuid"`
+	Status        string `json:"status"`
+	QueuePosition int    `json:"queue_position"`
+}
+
+// WebhookDelivery records one attempt to deliver a webhook for a job
+type WebhookDelivery struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	DeliveryUUID string     `gorm:"index" json:"delivery_uuid"` // shared by the retries of one delivery
+	JobUUID      string     `gorm:"index" json:"job_uuid"`
+	UserID       uint       `json:"user_id"`
+	Event        string     `json:"event"`
+	URL          string     `json:"url"`
+	Payload      string     `json:"payload"`
+	Attempt      int        `json:"attempt"`
+	StatusCode   int        `json:"status_code"`
+	Success      bool       `json:"success"`
+	Error        string     `json:"error,omitempty"`
+	DurationMs   int64      `json:"duration_ms"`
+	ScheduledAt  *time.Time `gorm:"index" json:"scheduled_at,omitempty"` // when the attempt is due, until it is made
+	ClaimedUntil *time.Time `json:"-"`                                   // when the claim of a node making the attempt expires
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Storage credential providers
+const (
+	StorageProviderS3  = "s3"
+	StorageProviderGCS = "gcs"
+)
+
+// StorageCredential is a user's access to the buckets of an S3 compatible
+// object store, such as AWS S3 or Google Cloud Storage, that jobs can deliver
+// outputs to. The secret key and session token are stored encrypted.
+type StorageCredential struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"uniqueIndex:idx_storage_credential_user_name" json:"user_id"`
+	Name         string    `gorm:"uniqueIndex:idx_storage_credential_user_name" json:"name"`
+	Provider     string    `json:"provider"` // "s3" or "gcs"
+	Endpoint     string    `json:"endpoint"` // defaults to the provider's
+	Region       string    `json:"region"`   // defaults to us-east-1
+	UseSSL       bool      `json:"use_ssl"`
+	BucketLookup string    `json:"bucket_lookup"` // "auto", "virtual-host" or "path"
+	PublicURL    string    `json:"public_url"`    // URL of the buckets' objects, with {bucket} for the bucket's name
+	AccessKey    string    `json:"access_key"`
+	SecretKey    string    `json:"-"`        // encrypted
+	SessionToken string    `json:"-"`        // encrypted
+	RoleARN      string    `json:"role_arn"` // role assumed with STS using the access key
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Preset variable types
+const (
+	PresetVariableString  = "string"
+	PresetVariableInteger = "integer"
+	PresetVariableNumber  = "number"
+	PresetVariableBoolean = "boolean"
+)
+
+// Preset is a named FFMPEG command template. Besides the file placeholders
+// such as {{in1}}, its command may contain {{name}} placeholders for its
+// variables. Presets of user 0 are global and visible to every user.
+type Preset struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	UserID      uint            `gorm:"uniqueIndex:idx_preset_user_name" json:"user_id"`
+	Name        string          `gorm:"uniqueIndex:idx_preset_user_name" json:"name"`
+	Description string          `json:"description"`
+	Command     string          `json:"command"`
+	Variables   PresetVariables `json:"variables" gorm:"type:jsonb"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// IsGlobal reports whether the preset is visible to every user
+func (p *Preset) IsGlobal() bool {
+	return p.UserID == 0
+}
+
+// PresetVariable declares a variable of a preset
+type PresetVariable struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`              // string, integer, number or boolean
+	Default     interface{}   `json:"default,omitempty"` // nil when the variable is required
+	Description string        `json:"description,omitempty"`
+	Min         *float64      `json:"min,omitempty"` // integer and number only
+	Max         *float64      `json:"max,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"` // allowed values, any when empty
+}
+
+// PresetVariables holds the variables of a preset
+type PresetVariables []PresetVariable
+
+// Scan implements the sql.Scanner interface for PresetVariables
+func (v *PresetVariables) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, &v)
+}
+
+// Value implements the driver.Valuer interface for PresetVariables
+func (v PresetVariables) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// WebhookPayload is the JSON body sent to a job's webhook URL
+type WebhookPayload struct {
+	Event       string         `json:"event"`
+	DeliveryID  string         `json:"delivery_id"`
+	Timestamp   time.Time      `json:"timestamp"`
+	JobUUID     string         `json:"job_uuid"`
+	Status      string         `json:"status"`
+	Progress    int            `json:"progress"`
+	Result      string         `json:"result,omitempty"`
+	OutputFiles OutputFilesMap `json:"output_files,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
 }
 
 // RegisterRequest represents the user registration request.
@@ -133,5 +627,132 @@ type LoginRequest struct {
 
 // AuthResponse represents the authentication response.
 type AuthResponse struct {
-	APIToken string `json:"api_token"`
+	APIToken      string `json:"api_token"`
+	WebhookSecret string `json:"webhook_secret"`
 }
+
+// ABR packaging formats
+const (
+	ABRFormatHLS  = "hls"
+	ABRFormatDASH = "dash"
+)
+
+// ABRLadder describes the renditions and formats of an ABR job
+type ABRLadder struct {
+	Formats         []string    `json:"formats"`          // ABRFormatHLS and/or ABRFormatDASH
+	SegmentDuration int         `json:"segment_duration"` // seconds
+	Renditions      []Rendition `json:"renditions"`
+}
+
+// Rendition is one quality level of an ABR ladder
+type Rendition struct {
+	Name         string `json:"name"`
+	Width        int    `json:"width,omitempty"` // 0 keeps the aspect ratio of the source
+	Height       int    `json:"height"`
+	VideoBitrate int    `json:"video_bitrate"`           // kbit/s
+	MaxBitrate   int    `json:"max_bitrate,omitempty"`   // kbit/s, 0 for VideoBitrate
+	AudioBitrate int    `json:"audio_bitrate,omitempty"` // kbit/s
+	Codec        string `json:"codec"`                   // "h264" or "hevc"
+}
+
+// Rendition statuses
+const (
+	RenditionEncoded = "encoded"
+	RenditionSkipped = "skipped"
+)
+
+// RenditionResult reports what became of a rendition of an ABR job
+type RenditionResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`           // RenditionEncoded or RenditionSkipped
+	Reason string `json:"reason,omitempty"` // why the rendition was skipped or changed
+	Codec  string `json:"codec"`
+	// Filled from ffprobe once the rendition is encoded
+	Width       int     `json:"width,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	BitRate     int64   `json:"bit_rate,omitempty"`     // bits per second
+	Duration    float64 `json:"duration,omitempty"`     // seconds
+	PlaylistURL string  `json:"playlist_url,omitempty"` // the HLS media playlist of the rendition
+}
+
+// RenditionResults is a custom type for handling the rendition results of a job in the database
+type RenditionResults []RenditionResult
+
+// Scan implements the sql.Scanner interface for RenditionResults
+func (r *RenditionResults) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("expected []byte, got %T", value)
+	}
+
+	return json.Unmarshal(bytes, &r)
+}
+
+// Value implements the driver.Valuer interface for RenditionResults
+func (r RenditionResults) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+// StoryboardSpec describes the thumbnails and sprite sheets of a storyboard job
+type StoryboardSpec struct {
+	Interval float64 `json:"interval"`         // seconds between thumbnails
+	Width    int     `json:"width"`            // of a thumbnail
+	Height   int     `json:"height,omitempty"` // of a thumbnail, 0 keeps the aspect ratio of the source
+	Columns  int     `json:"columns"`          // thumbnails per row of a sheet
+	Rows     int     `json:"rows"`             // rows of a sheet
+	Format   string  `json:"format"`           // "jpg" or "webp"
+}
+
+// StoryboardLayout describes the sprite sheets a storyboard job wrote. Every
+// sheet is Columns*TileWidth by Rows*TileHeight pixels, and the last one is
+// padded.
+type StoryboardLayout struct {
+	Interval   float64 `json:"interval"` // seconds
+	TileWidth  int     `json:"tile_width"`
+	TileHeight int     `json:"tile_height"`
+	Columns    int     `json:"columns"`
+	Rows       int     `json:"rows"`
+	Sheets     int     `json:"sheets"`
+	Thumbnails int     `json:"thumbnails"`
+}
+
+// Upload is a file a user uploaded to storage to use as a job input, which
+// input files refer to as upload://<uuid>. Resumable uploads are written over
+// several requests and can only be used once complete.
+type Upload struct {
+	ID          uint       `gorm:"primaryKey" json:"-"`
+	UUID        string     `gorm:"uniqueIndex" json:"id"`
+	UserID      uint       `gorm:"index" json:"user_id"`
+	Status      string     `gorm:"index;default:complete" json:"status"`
+	FileName    string     `json:"file_name"`
+	ContentType string     `json:"content_type"`
+	ObjectKey   string     `json:"object_key"` // user_<id>/input/<uuid>/<file name>
+	SizeBytes   int64      `json:"size_bytes"` // of a resumable upload, the length it was declared with
+	Offset      int64      `json:"offset"`     // bytes of a resumable upload received so far
+	SHA256      string     `json:"sha256"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at,omitempty"` // when an incomplete upload is deleted
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// State of an incomplete resumable upload
+	Metadata        string `json:"-"` // Upload-Metadata header it was created with
+	StorageUploadID string `json:"-"` // ID of the storage's multipart upload
+	Parts           int    `json:"-"` // parts written to storage
+	HashState       []byte `json:"-"` // SHA-256 state of the bytes received
+	// Claim of the request writing to an incomplete upload, on any node
+	LockToken   string     `json:"-"`
+	LockedUntil *time.Time `json:"-"`
+}
+
+// Upload statuses
+const (
+	UploadStatusInProgress = "in_progress"
+	UploadStatusComplete   = "complete"
+)
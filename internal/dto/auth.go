@@ -16,6 +16,7 @@ type LoginRequest struct {
 
 // AuthResponse represents the authentication response
 type AuthResponse struct {
-	APIToken string `json:"api_token"`
-	Username string `json:"username"`
+	APIToken      string `json:"api_token"`
+	Username      string `json:"username"`
+	WebhookSecret string `json:"webhook_secret"` // key for verifying webhook signatures
 }
@@ -4,25 +4,229 @@ import "ffmpeg-api/internal/domain"
 
 // FFMPEGRequest represents the FFMPEG processing request
 type FFMPEGRequest struct {
-	InputFiles    map[string]string `json:"input_files" validate:"required,min=1" example:"{\"in1\": \"https://storage.googleapis.com/ffmpeg-api-test-bucket/user_1/input/test.mp4\"}"`
-	OutputFiles   map[string]string `json:"output_files" validate:"required,min=1" example:"{\"out1\": \"string.mp4\"}"`
-	FFmpegCommand string            `json:"ffmpeg_command" validate:"required" example:"-i {{in1}} {{out1}}"`
+	InputFiles    map[string]string `json:"input_files,omitempty" validate:"required_without=Inputs,excluded_with=Inputs" example:"{\"in1\": \"https://storage.googleapis.com/ffmpeg-api-test-bucket/user_1/input/test.mp4\"}"`
+	OutputFiles   map[string]string `json:"output_files,omitempty" validate:"required_without_all=Inputs Steps,excluded_with=Inputs Steps" example:"{\"out1\": \"string.mp4\"}"`
+	FFmpegCommand string            `json:"ffmpeg_command,omitempty" validate:"required_without_all=Inputs Preset Steps,excluded_with=Inputs Preset Steps" example:"-i {{in1}} {{out1}}"`
+	// Name of a preset whose command is used instead of ffmpeg_command, with
+	// input_files and output_files
+	Preset          string                 `json:"preset,omitempty" validate:"excluded_with=Inputs Steps" example:"h264-720p"`
+	PresetVariables map[string]interface{} `json:"preset_variables,omitempty" validate:"excluded_without=Preset" swaggertype:"object"`
+	// Structured alternative to input_files, output_files and ffmpeg_command
+	GlobalOptions []string       `json:"global_options,omitempty" validate:"excluded_without=Inputs" example:"-y"`
+	Inputs        []FFMPEGInput  `json:"inputs,omitempty" validate:"omitempty,dive"`
+	Outputs       []FFMPEGOutput `json:"outputs,omitempty" validate:"required_with=Inputs,excluded_without=Inputs,omitempty,dive"`
+	// Steps run several FFMPEG commands on input_files in one job. A step reads
+	// the outputs of another step as {{step_id.key}}.
+	Steps []PipelineStep `json:"steps,omitempty" validate:"excluded_with=Inputs,omitempty,dive"`
+	// Step outputs to upload as step_id.key, by default those no step reads
+	Upload        []string `json:"upload,omitempty" validate:"excluded_without=Steps" example:"transcode.out1,thumbnail.out1"`
+	WebhookURL    string   `json:"webhook_url,omitempty" validate:"omitempty,url" example:"https://example.com/hooks/ffmpeg"`
+	WebhookEvents []string `json:"webhook_events,omitempty" validate:"omitempty,dive,oneof=PROCESSING SUCCESS FAILED CANCELLED" example:"SUCCESS,FAILED"`
+	// Buckets outputs are delivered to instead of your storage, by output key
+	Destinations map[string]OutputDestination `json:"destinations,omitempty" validate:"omitempty,dive"`
+	// How FFMPEG reads input files, by input key: auto, download, url or pipe
+	InputModes map[string]string `json:"input_modes,omitempty" validate:"omitempty,dive,oneof=auto download url pipe" example:"{\"in1\": \"url\"}"`
+}
+
+// FFMPEGInput represents an input file of a structured FFMPEG request. The key
+// defaults to in1, in2, ... by position.
+type FFMPEGInput struct {
+	Key     string   `json:"key,omitempty" example:"in1"`
+	URL     string   `json:"url" validate:"required" example:"https://storage.googleapis.com/ffmpeg-api-test-bucket/user_1/input/test.mp4"`
+	Options []string `json:"options,omitempty" example:"-ss,5"`
+}
+
+// FFMPEGOutput represents an output file of a structured FFMPEG request. The
+// key defaults to out1, out2, ... by position.
+type FFMPEGOutput struct {
+	Key      string   `json:"key,omitempty" example:"out1"`
+	Filename string   `json:"filename" validate:"required" example:"output.mp4"`
+	Options  []string `json:"options,omitempty" example:"-c:v,libx264,-crf,23"`
+}
+
+// PipelineStep represents a step of a multi-step FFMPEG request
+type PipelineStep struct {
+	ID              string                 `json:"id" validate:"required,max=64" example:"transcode"`
+	FFmpegCommand   string                 `json:"ffmpeg_command,omitempty" validate:"required_without=Preset,excluded_with=Preset" example:"-i {{normalize.out1}} -c:v libx264 {{out1}}"`
+	Preset          string                 `json:"preset,omitempty" example:"h264-720p"`
+	PresetVariables map[string]interface{} `json:"preset_variables,omitempty" validate:"excluded_without=Preset" swaggertype:"object"`
+	OutputFiles     map[string]string      `json:"output_files" validate:"required" example:"{\"out1\": \"transcoded.mp4\"}"`
+}
+
+// ABRRequest represents a request to package one input file as an adaptive
+// bitrate ladder
+type ABRRequest struct {
+	InputURL        string         `json:"input_url" validate:"required,url" example:"https://storage.googleapis.com/ffmpeg-api-test-bucket/user_1/input/test.mp4"`
+	Formats         []string       `json:"formats,omitempty" validate:"omitempty,dive,oneof=hls dash" example:"hls,dash"`
+	SegmentDuration int            `json:"segment_duration,omitempty" validate:"omitempty,min=1,max=60" example:"6"` // seconds, 6 by default
+	Renditions      []ABRRendition `json:"renditions" validate:"required,min=1,max=10,dive"`
+	WebhookURL      string         `json:"webhook_url,omitempty" validate:"omitempty,url" example:"https://example.com/hooks/ffmpeg"`
+	WebhookEvents   []string       `json:"webhook_events,omitempty" validate:"omitempty,dive,oneof=PROCESSING SUCCESS FAILED CANCELLED" example:"SUCCESS,FAILED"`
+	// Buckets the formats are delivered to instead of your storage, by format
+	Destinations map[string]OutputDestination `json:"destinations,omitempty" validate:"omitempty,dive"`
+}
+
+// OutputDestination represents a bucket an output is delivered to. Without
+// credentials, the bucket must be one the server delivers to with its own.
+type OutputDestination struct {
+	Bucket      string `json:"bucket" validate:"required,max=63" example:"my-videos"`
+	Prefix      string `json:"prefix,omitempty" validate:"max=1024" example:"transcoded/2024/"`
+	Credentials string `json:"credentials,omitempty" validate:"max=64" example:"my-aws"` // name of one of your storage credentials
+}
+
+// ABRRendition represents a rendition of an ABR ladder. Bitrates are in
+// kbit/s.
+type ABRRendition struct {
+	Name         string `json:"name" validate:"required,max=64" example:"720p"`
+	Width        int    `json:"width,omitempty" validate:"omitempty,min=2" example:"1280"` // keeps the source's aspect ratio when omitted
+	Height       int    `json:"height" validate:"required,min=2" example:"720"`
+	VideoBitrate int    `json:"video_bitrate" validate:"required,min=1" example:"3000"`
+	MaxBitrate   int    `json:"max_bitrate,omitempty" validate:"omitempty,min=1" example:"3300"`
+	AudioBitrate int    `json:"audio_bitrate,omitempty" validate:"omitempty,min=1" example:"128"`
+	Codec        string `json:"codec,omitempty" validate:"omitempty,oneof=h264 hevc" example:"h264"`
+}
+
+// StoryboardRequest represents a request to tile thumbnails of one input file
+// into sprite sheets with a WebVTT storyboard
+type StoryboardRequest struct {
+	InputURL      string   `json:"input_url" validate:"required,url" example:"https://storage.googleapis.com/ffmpeg-api-test-bucket/user_1/input/test.mp4"`
+	Interval      float64  `json:"interval,omitempty" validate:"omitempty,min=0.1,max=3600" example:"5"` // seconds between thumbnails, 5 by default
+	Width         int      `json:"width,omitempty" validate:"omitempty,min=16,max=1920" example:"160"`   // of a thumbnail, 160 by default
+	Height        int      `json:"height,omitempty" validate:"omitempty,min=16,max=1920" example:"90"`   // keeps the source's aspect ratio when omitted
+	Columns       int      `json:"columns,omitempty" validate:"omitempty,min=1,max=50" example:"5"`
+	Rows          int      `json:"rows,omitempty" validate:"omitempty,min=1,max=50" example:"5"`
+	Format        string   `json:"format,omitempty" validate:"omitempty,oneof=jpg webp" example:"jpg"`
+	WebhookURL    string   `json:"webhook_url,omitempty" validate:"omitempty,url" example:"https://example.com/hooks/ffmpeg"`
+	WebhookEvents []string `json:"webhook_events,omitempty" validate:"omitempty,dive,oneof=PROCESSING SUCCESS FAILED CANCELLED" example:"SUCCESS,FAILED"`
+	// Buckets the outputs are delivered to instead of your storage, by output
+	// key: sprites or vtt
+	Destinations map[string]OutputDestination `json:"destinations,omitempty" validate:"omitempty,dive"`
 }
 
 // FFMPEGResponse represents the FFMPEG processing response
 type FFMPEGResponse struct {
-	UUID   string `json:"uuid"`
-	Status string `json:"status"`
+	UUID          string `json:"uuid"`
+	Status        string `json:"status"`
+	QueuePosition int    `json:"queue_position"`
+}
+
+// BatchRequest represents a batch of FFMPEG processing requests, given either
+// as requests or as a template submitted once for every input set
+type BatchRequest struct {
+	Requests []FFMPEGRequest `json:"requests,omitempty" validate:"required_without=Template,excluded_with=Template,omitempty,dive"`
+	// The template's input_files and output_files name the files every input
+	// set may replace; inputs left empty in the template must be set
+	Template  *FFMPEGRequest  `json:"template,omitempty" validate:"required_without=Requests"`
+	InputSets []BatchInputSet `json:"input_sets,omitempty" validate:"required_with=Template,excluded_without=Template,omitempty,dive"`
+}
+
+// BatchInputSet represents the files of one job of a batch template
+type BatchInputSet struct {
+	InputFiles  map[string]string `json:"input_files" validate:"required" example:"{\"in1\": \"https://example.com/video1.mp4\"}"`
+	OutputFiles map[string]string `json:"output_files,omitempty" example:"{\"out1\": \"video1.mp4\"}"`
+}
+
+// BatchResponse represents the response of a batch submission, with a job for
+// every request in the order they were given
+type BatchResponse struct {
+	BatchID string           `json:"batch_id"`
+	Jobs    []FFMPEGResponse `json:"jobs"`
+}
+
+// BatchStatus represents the aggregate status of the jobs of a batch
+type BatchStatus struct {
+	BatchID  string         `json:"batch_id"`
+	Status   string         `json:"status" example:"PROCESSING" enums:"QUEUED,PROCESSING,SUCCESS,PARTIAL_SUCCESS,FAILED"`
+	Total    int            `json:"total"`
+	Counts   map[string]int `json:"counts" example:"SUCCESS:88,QUEUED:10,PROCESSING:2"`
+	Progress int            `json:"progress"` // average job progress, finished jobs count as 100
+}
+
+// ProbeRequest represents a media probe request
+type ProbeRequest struct {
+	URL string `json:"url" validate:"required" example:"https://storage.googleapis.com/ffmpeg-api-test-bucket/user_1/input/test.mp4"`
+}
+
+// ProbeResponse represents the response of the probe endpoint. Probe is set
+// when the file was probed right away, Job when a probe job was queued.
+type ProbeResponse struct {
+	Size  int64              `json:"size"` // bytes, -1 when unknown
+	Probe *domain.MediaProbe `json:"probe,omitempty"`
+	Job   *FFMPEGResponse    `json:"job,omitempty"`
 }
 
 // JobStatus represents the status of an FFMPEG job
 type JobStatus struct {
+	UUID             string                               `json:"uuid"`
+	Type             string                               `json:"type" example:"ffmpeg"`
+	BatchID          string                               `json:"batch_id,omitempty"`
+	Status           string                               `json:"status" validate:"required,oneof=QUEUED PROCESSING SUCCESS FAILED CANCELLED"`
+	Result           string                               `json:"result,omitempty"`
+	Progress         int                                  `json:"progress"`
+	FFmpegProgress   *domain.FFmpegProgress               `json:"ffmpeg_progress,omitempty"`
+	InputProbes      map[string]domain.MediaProbe         `json:"input_probes,omitempty"`
+	ExpectedDuration float64                              `json:"expected_duration,omitempty"`
+	Steps            []domain.StepStatus                  `json:"steps,omitempty"`
+	Renditions       []domain.RenditionResult             `json:"renditions,omitempty"`
+	QueuePosition    int                                  `json:"queue_position,omitempty"`
+	Error            string                               `json:"error,omitempty"`
+	CreatedAt        string                               `json:"created_at"`
+	UpdatedAt        string                               `json:"updated_at"`
+	OutputFiles      map[string]domain.OutputFileMetadata `json:"output_files,omitempty"`
+}
+
+// ProgressEvent represents the data of an event on the job progress stream
+type ProgressEvent struct {
 	UUID        string                               `json:"uuid"`
-	Status      string                               `json:"status" validate:"required,oneof=pending processing completed failed"`
-	Result      string                               `json:"result,omitempty"`
+	Status      string                               `json:"status"`
 	Progress    int                                  `json:"progress"`
-	Error       string                               `json:"error,omitempty"`
-	CreatedAt   string                               `json:"created_at"`
-	UpdatedAt   string                               `json:"updated_at"`
+	Time        string                               `json:"time,omitempty" example:"00:01:23.450000"`
+	FPS         float64                              `json:"fps,omitempty" example:"29.97"`
+	Bitrate     string                               `json:"bitrate,omitempty" example:"1205.3kbits/s"`
+	Speed       string                               `json:"speed,omitempty" example:"1.5x"`
+	FFmpeg      *domain.FFmpegProgress               `json:"ffmpeg,omitempty"`
 	OutputFiles map[string]domain.OutputFileMetadata `json:"output_files,omitempty"`
 }
+
+// JobListQuery represents the query parameters of the job listing endpoint
+type JobListQuery struct {
+	Status        string `query:"status" example:"SUCCESS,FAILED"`
+	CreatedAfter  string `query:"created_after" example:"2025-01-01T00:00:00Z"`
+	CreatedBefore string `query:"created_before" example:"2025-02-01T00:00:00Z"`
+	OutputType    string `query:"output_type" validate:"omitempty,oneof=image video audio unknown" example:"video"`
+	UpdatedAfter  string `query:"updated_after" example:"2025-01-15T00:00:00Z"`
+	BatchID       string `query:"batch_id"`
+	Order         string `query:"order" validate:"omitempty,oneof=asc desc" example:"desc"`
+	Limit         int    `query:"limit" example:"50"`
+	Cursor        string `query:"cursor"`
+}
+
+// JobList represents a page of jobs
+type JobList struct {
+	Jobs       []JobStatus `json:"jobs"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// WebhookDelivery represents one webhook delivery attempt
+type WebhookDelivery struct {
+	ID           uint   `json:"id"`
+	DeliveryUUID string `json:"delivery_uuid"`
+	Event        string `json:"event"`
+	URL          string `json:"url"`
+	Payload      string `json:"payload"`
+	Attempt      int    `json:"attempt"`
+	StatusCode   int    `json:"status_code"`
+	Success      bool   `json:"success"`
+	Error        string `json:"error,omitempty"`
+	DurationMs   int64  `json:"duration_ms"`
+	ScheduledAt  string `json:"scheduled_at,omitempty"` // set while the attempt is waiting to be made
+	CreatedAt    string `json:"created_at"`
+}
+
+// File represents an output file of a job with its URLs issued anew
+type File struct {
+	JobUUID string `json:"job_uuid"`
+	Key     string `json:"key" example:"out1"`
+	domain.OutputFileMetadata
+	ExpiresAt string `json:"expires_at,omitempty"` // when the URLs of private storage expire
+}
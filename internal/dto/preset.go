@@ -0,0 +1,36 @@
+package dto
+
+// PresetRequest represents a request to create or update a preset
+type PresetRequest struct {
+	Name        string           `json:"name" validate:"required,max=64" example:"h264-720p"`
+	Description string           `json:"description,omitempty" validate:"max=500" example:"H.264 at 720p"`
+	Command     string           `json:"command" validate:"required" example:"-i {{in1}} -vf scale=-2:{{height}} -c:v libx264 -crf {{crf}} -c:a aac {{out1}}"`
+	Variables   []PresetVariable `json:"variables,omitempty" validate:"omitempty,dive"`
+	// Global presets are visible to every user and can only be created by admins.
+	// Ignored on update.
+	Global bool `json:"global,omitempty"`
+}
+
+// PresetVariable represents a typed variable of a preset. A variable without
+// a default must be set by every request using the preset.
+type PresetVariable struct {
+	Name        string        `json:"name" validate:"required,max=64" example:"crf"`
+	Type        string        `json:"type" validate:"required,oneof=string integer number boolean" example:"integer"`
+	Default     interface{}   `json:"default,omitempty" swaggertype:"string" example:"23"`
+	Description string        `json:"description,omitempty" example:"Constant rate factor"`
+	Min         *float64      `json:"min,omitempty" example:"0"`
+	Max         *float64      `json:"max,omitempty" example:"51"`
+	Enum        []interface{} `json:"enum,omitempty" swaggertype:"array,string"`
+}
+
+// Preset represents a preset
+type Preset struct {
+	ID          uint             `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Command     string           `json:"command"`
+	Variables   []PresetVariable `json:"variables"`
+	Global      bool             `json:"global"`
+	CreatedAt   string           `json:"created_at"`
+	UpdatedAt   string           `json:"updated_at"`
+}
@@ -0,0 +1,16 @@
+package dto
+
+// Upload represents an uploaded input file
+type Upload struct {
+	ID          string `json:"id" example:"4f9c2b1e-8a3d-4e5f-9b6a-1c2d3e4f5a6b"`
+	Ref         string `json:"ref" example:"upload://4f9c2b1e-8a3d-4e5f-9b6a-1c2d3e4f5a6b"` // use as an input file of a job
+	FileName    string `json:"file_name" example:"camera.mov"`
+	ContentType string `json:"content_type,omitempty" example:"video/quicktime"`
+	ObjectKey   string `json:"object_key" example:"user_1/input/4f9c2b1e-8a3d-4e5f-9b6a-1c2d3e4f5a6b/camera.mov"`
+	Status      string `json:"status" example:"complete" enums:"in_progress,complete"` // in_progress for a resumable upload still receiving bytes
+	SizeBytes   int64  `json:"size_bytes" example:"104857600"`
+	Offset      int64  `json:"offset" example:"104857600"` // bytes received so far
+	SHA256      string `json:"sha256,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"` // when an incomplete upload is deleted
+	CreatedAt   string `json:"created_at"`
+}
@@ -10,17 +10,29 @@ import (
 
 // Handler holds all HTTP handlers and their dependencies
 type Handler struct {
-	authRoutes   *routes.AuthRoutes
-	ffmpegRoutes *routes.FFMPEGRoutes
-	indexRoutes  *routes.IndexRoutes
+	authRoutes              *routes.AuthRoutes
+	ffmpegRoutes            *routes.FFMPEGRoutes
+	presetRoutes            *routes.PresetRoutes
+	uploadRoutes            *routes.UploadRoutes
+	tusRoutes               *routes.TusRoutes
+	storageRoutes           *routes.StorageRoutes
+	storageCredentialRoutes *routes.StorageCredentialRoutes
+	fileRoutes              *routes.FileRoutes
+	indexRoutes             *routes.IndexRoutes
 }
 
 // NewHandler creates a new Handler instance
-func NewHandler(authService service.AuthService, ffmpegService service.FFMPEGService) *Handler {
+func NewHandler(authService service.AuthService, ffmpegService service.FFMPEGService, webhookService service.WebhookService, presetService service.PresetService, uploadService service.UploadService, storageService service.StorageService, storageCredentialService service.StorageCredentialService) *Handler {
 	return &Handler{
-		authRoutes:   routes.NewAuthRoutes(authService),
-		ffmpegRoutes: routes.NewFFMPEGRoutes(ffmpegService, authService),
-		indexRoutes:  routes.NewIndexRoutes(),
+		authRoutes:              routes.NewAuthRoutes(authService),
+		ffmpegRoutes:            routes.NewFFMPEGRoutes(ffmpegService, authService, webhookService),
+		presetRoutes:            routes.NewPresetRoutes(presetService, authService),
+		uploadRoutes:            routes.NewUploadRoutes(uploadService, authService),
+		tusRoutes:               routes.NewTusRoutes(uploadService, authService),
+		storageRoutes:           routes.NewStorageRoutes(storageService),
+		storageCredentialRoutes: routes.NewStorageCredentialRoutes(storageCredentialService, authService),
+		fileRoutes:              routes.NewFileRoutes(ffmpegService, authService),
+		indexRoutes:             routes.NewIndexRoutes(),
 	}
 }
 
@@ -34,6 +46,24 @@ func (h *Handler) RegisterRoutes(app *fiber.App) {
 
 	// Register FFMPEG routes
 	h.ffmpegRoutes.Register(app)
+
+	// Register preset routes
+	h.presetRoutes.Register(app)
+
+	// Register upload routes
+	h.uploadRoutes.Register(app)
+
+	// Register resumable upload routes
+	h.tusRoutes.Register(app)
+
+	// Register storage download routes
+	h.storageRoutes.Register(app)
+
+	// Register storage credential routes
+	h.storageCredentialRoutes.Register(app)
+
+	// Register output file routes
+	h.fileRoutes.Register(app)
 }
 
 // ErrorHandler handles errors returned from routes
@@ -57,9 +87,13 @@ func NewFiberApp() *fiber.App {
 	engine := html.New("./views", ".html")
 
 	// Create a new Fiber app with the template engine
+	// Request bodies above the body limit are streamed, so uploads don't have
+	// to fit in memory. Multipart bodies are parsed by the upload handler.
 	app := fiber.New(fiber.Config{
-		Views:        engine,
-		ErrorHandler: ErrorHandler,
+		Views:                        engine,
+		ErrorHandler:                 ErrorHandler,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 	})
 
 	return app
@@ -99,8 +99,9 @@ func (r *AuthRoutes) handleRegister(c *fiber.Ctx) error {
 
 	// Convert domain response to DTO
 	dtoResp := dto.AuthResponse{
-		APIToken: resp.APIToken,
-		Username: req.Username,
+		APIToken:      resp.APIToken,
+		Username:      req.Username,
+		WebhookSecret: resp.WebhookSecret,
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(response.Response{
@@ -164,8 +165,9 @@ func (r *AuthRoutes) handleLogin(c *fiber.Ctx) error {
 
 	// Convert domain response to DTO
 	dtoResp := dto.AuthResponse{
-		APIToken: resp.APIToken,
-		Username: req.Username,
+		APIToken:      resp.APIToken,
+		Username:      req.Username,
+		WebhookSecret: resp.WebhookSecret,
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response.Response{
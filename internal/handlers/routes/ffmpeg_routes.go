@@ -1,27 +1,42 @@
 package routes
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"ffmpeg-api/internal/domain"
 	"ffmpeg-api/internal/dto"
 	"ffmpeg-api/internal/logger"
 	"ffmpeg-api/internal/response"
 	"ffmpeg-api/internal/service"
 	"ffmpeg-api/internal/validation"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
 )
 
+// progressStreamPollInterval is how often a progress stream re-reads the job
+// from the database, which catches updates made by workers on other nodes, and
+// keeps the connection alive while nothing happens.
+const progressStreamPollInterval = 5 * time.Second
+
 // FFMPEGRoutes handles all FFMPEG related routes
 type FFMPEGRoutes struct {
-	ffmpegService service.FFMPEGService
-	authService   service.AuthService
+	ffmpegService  service.FFMPEGService
+	authService    service.AuthService
+	webhookService service.WebhookService
 }
 
 // NewFFMPEGRoutes creates a new FFMPEGRoutes instance
-func NewFFMPEGRoutes(ffmpegService service.FFMPEGService, authService service.AuthService) *FFMPEGRoutes {
+func NewFFMPEGRoutes(ffmpegService service.FFMPEGService, authService service.AuthService, webhookService service.WebhookService) *FFMPEGRoutes {
 	return &FFMPEGRoutes{
-		ffmpegService: ffmpegService,
-		authService:   authService,
+		ffmpegService:  ffmpegService,
+		authService:    authService,
+		webhookService: webhookService,
 	}
 }
 
@@ -30,13 +45,37 @@ func (r *FFMPEGRoutes) Register(router fiber.Router) {
 	ffmpeg := router.Group("/api/v1/ffmpeg")
 	ffmpeg.Use(r.authMiddleware)
 	ffmpeg.Post("/", r.handleProcessFFMPEG)
+	ffmpeg.Post("/abr", r.handleSubmitABR)
+	ffmpeg.Post("/storyboard", r.handleSubmitStoryboard)
+	ffmpeg.Post("/batch", r.handleSubmitBatch)
+	ffmpeg.Get("/batches/:id", r.handleGetBatchStatus)
+	ffmpeg.Post("/probe", r.handleProbe)
+	ffmpeg.Get("/jobs", r.handleListJobs)
 	ffmpeg.Get("/progress/:uuid", r.handleGetProgress)
+	ffmpeg.Get("/progress/:uuid/stream", r.handleStreamProgress)
+	ffmpeg.Delete("/:uuid", r.handleCancelJob)
+	ffmpeg.Get("/:uuid/webhooks", r.handleListWebhookDeliveries)
+	ffmpeg.Post("/:uuid/webhooks/:id/redeliver", r.handleRedeliverWebhook)
 }
 
 // handleProcessFFMPEG handles video processing requests
 // @Summary Process video with FFMPEG
 // @Description Submit a video processing job using FFMPEG. The command should use placeholders like {{in1}} for input files and {{out1}} for output files.
 // @Description These placeholders will be replaced with actual file paths during processing.
+// @Description Jobs are queued and processed in submission order by a fixed number of workers.
+// @Description If webhook_url is set, the job's status is POSTed to it on PROCESSING, SUCCESS, FAILED and CANCELLED (or only the listed webhook_events).
+// @Description Each request carries an X-Webhook-Signature header: sha256= followed by the hex HMAC-SHA256 of the body, keyed with the webhook_secret returned at login.
+// @Description Instead of input_files, output_files and ffmpeg_command, the command can be given structured as global_options, inputs and outputs,
+// @Description each input and output with its own options. The arguments are then assembled in that order, without any string splitting.
+// @Description The command is checked against the command policy: every -i value must be an input_files placeholder and every output an output_files placeholder,
+// @Description and denied options, filters and formats, absolute paths and protocol URLs are rejected with a ValidationError listing each violation.
+// @Description Instead of ffmpeg_command, preset can name one of your presets or a global preset, with preset_variables setting its variables.
+// @Description The preset's command, with the variables filled in, is then checked and run as if it had been given as ffmpeg_command.
+// @Description Instead of a single command, steps can run several commands (or presets) on input_files in one job and one directory. A step reads
+// @Description the outputs of another step as {{step_id.key}}, so steps run after the steps they read from. Only the step outputs listed in upload,
+// @Description by default those no other step reads, are uploaded, keyed step_id.key in the job's output_files. The job's steps report each step's status, timing and log.
+// @Description destinations delivers outputs, by key, to a bucket under a prefix instead of your storage, using one of your storage credentials
+// @Description or, without credentials, the server's own for the buckets listed in STORAGE_DESTINATION_BUCKETS.
 // @Tags FFMPEG
 // @Accept json
 // @Produce json
@@ -84,13 +123,20 @@ func (r *FFMPEGRoutes) handleProcessFFMPEG(c *fiber.Ctx) error {
 	}
 
 	// Convert DTO to domain model
-	domainReq := domain.FFMPEGRequest{
-		InputFiles:    req.InputFiles,
-		OutputFiles:   req.OutputFiles,
-		FFmpegCommand: req.FFmpegCommand,
-	}
+	domainReq := toFFMPEGRequestDomain(req)
 
 	resp, err := r.ffmpegService.ProcessVideo(c.Context(), domainReq, user.ID)
+	var policyErr *service.CommandPolicyError
+	if errors.As(err, &policyErr) || errors.Is(err, service.ErrInvalidRequest) {
+		logger.Error("invalid processing request", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
 	if err != nil {
 		logger.Error("failed to process video", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
@@ -104,8 +150,9 @@ func (r *FFMPEGRoutes) handleProcessFFMPEG(c *fiber.Ctx) error {
 
 	// Convert domain response to DTO
 	dtoResp := dto.FFMPEGResponse{
-		UUID:   resp.UUID,
-		Status: resp.Status,
+		UUID:          resp.UUID,
+		Status:        resp.Status,
+		QueuePosition: resp.QueuePosition,
 	}
 
 	return c.Status(fiber.StatusAccepted).JSON(response.Response{
@@ -114,20 +161,24 @@ func (r *FFMPEGRoutes) handleProcessFFMPEG(c *fiber.Ctx) error {
 	})
 }
 
-// handleGetProgress handles job progress requests
-// @Summary Get job progress
-// @Description Get the current status and progress of a video processing job. Returns details about output files when the job is completed.
+// handleSubmitABR handles adaptive bitrate ladder requests
+// @Summary Package a video as an adaptive bitrate ladder
+// @Description Queue an ABR job that encodes input_url into each rendition and packages them as HLS with a master playlist and/or DASH.
+// @Description The input is probed first: renditions above its resolution are skipped, and if all of them are, the smallest is encoded at the input's height.
+// @Description The job's output_files are keyed hls and dash, with manifest_url set to the master playlist or manifest, and its renditions report
+// @Description whether each rendition was encoded, its resolution, bit rate, duration and HLS media playlist.
+// @Description destinations can deliver the formats, keyed hls and dash, to other buckets like those of POST /ffmpeg.
 // @Tags FFMPEG
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
-// @Param uuid path string true "Job UUID returned from the process endpoint"
-// @Success 200 {object} response.Response{data=dto.JobStatus} "Job status retrieved successfully"
-// @Failure 400 {object} response.Response{error=response.APIError} "Invalid UUID format"
+// @Param request body dto.ABRRequest true "Input and ladder"
+// @Success 202 {object} response.Response{data=dto.FFMPEGResponse} "Job accepted for processing"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid request or validation error"
 // @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
-// @Failure 404 {object} response.Response{error=response.APIError} "Job not found"
-// @Router /ffmpeg/progress/{uuid} [get]
-func (r *FFMPEGRoutes) handleGetProgress(c *fiber.Ctx) error {
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /ffmpeg/abr [post]
+func (r *FFMPEGRoutes) handleSubmitABR(c *fiber.Ctx) error {
 	user := c.Locals("user").(*domain.User)
 	if user == nil {
 		logger.Error("user not found in context")
@@ -140,49 +191,1107 @@ func (r *FFMPEGRoutes) handleGetProgress(c *fiber.Ctx) error {
 		})
 	}
 
-	uuid := c.Params("uuid")
-	if uuid == "" {
-		logger.Error("missing uuid parameter")
+	var req dto.ABRRequest
+	if err := c.BodyParser(&req); err != nil {
+		logger.Error("invalid request body", "error", err)
 		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
 			Success: false,
 			Error: &response.APIError{
 				Type:    "BadRequest",
-				Message: "Missing UUID parameter",
+				Message: "Invalid request body",
 			},
 		})
 	}
 
-	status, err := r.ffmpegService.GetJobStatus(c.Context(), uuid, user.ID)
-	logger.Debug(status, "<<")
+	if err := validation.Validate(req); err != nil {
+		logger.Error("validation failed", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
+
+	ladder := &domain.ABRLadder{
+		Formats:         req.Formats,
+		SegmentDuration: req.SegmentDuration,
+	}
+	for _, rendition := range req.Renditions {
+		ladder.Renditions = append(ladder.Renditions, domain.Rendition{
+			Name:         rendition.Name,
+			Width:        rendition.Width,
+			Height:       rendition.Height,
+			VideoBitrate: rendition.VideoBitrate,
+			MaxBitrate:   rendition.MaxBitrate,
+			AudioBitrate: rendition.AudioBitrate,
+			Codec:        rendition.Codec,
+		})
+	}
+	domainReq := domain.FFMPEGRequest{
+		InputFiles:    map[string]string{"input": req.InputURL},
+		ABR:           ladder,
+		Destinations:  toDestinationsDomain(req.Destinations),
+		WebhookURL:    req.WebhookURL,
+		WebhookEvents: req.WebhookEvents,
+	}
+
+	resp, err := r.ffmpegService.SubmitABR(c.Context(), domainReq, user.ID)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		logger.Error("invalid ABR request", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
 	if err != nil {
-		logger.Error("failed to get job status", "error", err, "uuid", uuid)
+		logger.Error("failed to submit ABR job", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to submit ABR job",
+			},
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(response.Response{
+		Success: true,
+		Data: dto.FFMPEGResponse{
+			UUID:          resp.UUID,
+			Status:        resp.Status,
+			QueuePosition: resp.QueuePosition,
+		},
+	})
+}
+
+// handleSubmitStoryboard handles storyboard requests
+// @Summary Generate thumbnail sprite sheets and a WebVTT storyboard
+// @Description Queue a storyboard job that takes a thumbnail of input_url every interval seconds, tiles them columns by rows into JPEG or WebP sprite sheets,
+// @Description and writes a WebVTT file whose cues map each interval to the region of its thumbnail, as sheet_url#xywh=x,y,w,h.
+// @Description The job's output_files are keyed sprites, listing every sheet in files, and vtt. Both report the layout in storyboard.
+// @Description destinations can deliver the sprites and vtt outputs to other buckets like those of POST /ffmpeg.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body dto.StoryboardRequest true "Input and layout"
+// @Success 202 {object} response.Response{data=dto.FFMPEGResponse} "Job accepted for processing"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid request or validation error"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /ffmpeg/storyboard [post]
+func (r *FFMPEGRoutes) handleSubmitStoryboard(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	var req dto.StoryboardRequest
+	if err := c.BodyParser(&req); err != nil {
+		logger.Error("invalid request body", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Invalid request body",
+			},
+		})
+	}
+
+	if err := validation.Validate(req); err != nil {
+		logger.Error("validation failed", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
+
+	domainReq := domain.FFMPEGRequest{
+		InputFiles: map[string]string{"input": req.InputURL},
+		Storyboard: &domain.StoryboardSpec{
+			Interval: req.Interval,
+			Width:    req.Width,
+			Height:   req.Height,
+			Columns:  req.Columns,
+			Rows:     req.Rows,
+			Format:   req.Format,
+		},
+		Destinations:  toDestinationsDomain(req.Destinations),
+		WebhookURL:    req.WebhookURL,
+		WebhookEvents: req.WebhookEvents,
+	}
+
+	resp, err := r.ffmpegService.SubmitStoryboard(c.Context(), domainReq, user.ID)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		logger.Error("invalid storyboard request", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
+	if err != nil {
+		logger.Error("failed to submit storyboard job", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to submit storyboard job",
+			},
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(response.Response{
+		Success: true,
+		Data: dto.FFMPEGResponse{
+			UUID:          resp.UUID,
+			Status:        resp.Status,
+			QueuePosition: resp.QueuePosition,
+		},
+	})
+}
+
+// handleSubmitBatch handles batch processing requests
+// @Summary Submit a batch of jobs
+// @Description Submit many processing jobs at once, either as requests, each like the body of POST /ffmpeg, or as a template with input_sets.
+// @Description Every input set replaces the template's input_files (and optionally output_files) of the same keys; inputs the template leaves empty must be set.
+// @Description All requests are validated before any job is created, and the jobs are then created in one transaction, so either every job is queued or none is.
+// @Description Up to BATCH_MAX_JOBS jobs can be submitted at once. The jobs are returned in request order and can be listed with GET /ffmpeg/jobs?batch_id=.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body dto.BatchRequest true "Batch of FFMPEG processing requests"
+// @Success 202 {object} response.Response{data=dto.BatchResponse} "Jobs accepted for processing"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid request or validation error, listing every invalid request"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /ffmpeg/batch [post]
+func (r *FFMPEGRoutes) handleSubmitBatch(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	var req dto.BatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		logger.Error("invalid request body", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Invalid request body",
+			},
+		})
+	}
+
+	if err := validation.Validate(req); err != nil {
+		logger.Error("validation failed", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
+
+	// Convert DTO to domain model
+	var batch domain.BatchRequest
+	for _, request := range req.Requests {
+		batch.Requests = append(batch.Requests, toFFMPEGRequestDomain(request))
+	}
+	if req.Template != nil {
+		template := toFFMPEGRequestDomain(*req.Template)
+		batch.Template = &template
+	}
+	for _, set := range req.InputSets {
+		batch.InputSets = append(batch.InputSets, domain.BatchInputSet{
+			InputFiles:  set.InputFiles,
+			OutputFiles: set.OutputFiles,
+		})
+	}
+
+	result, err := r.ffmpegService.SubmitBatch(c.Context(), batch, user.ID)
+	var batchErr *service.BatchError
+	if errors.As(err, &batchErr) || errors.Is(err, service.ErrInvalidRequest) {
+		logger.Error("invalid batch request", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
+	if err != nil {
+		logger.Error("failed to submit batch", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to submit batch",
+			},
+		})
+	}
+
+	// Convert domain response to DTO
+	dtoResp := dto.BatchResponse{
+		BatchID: result.BatchID,
+		Jobs:    make([]dto.FFMPEGResponse, 0, len(result.Jobs)),
+	}
+	for _, job := range result.Jobs {
+		dtoResp.Jobs = append(dtoResp.Jobs, dto.FFMPEGResponse{
+			UUID:          job.UUID,
+			Status:        job.Status,
+			QueuePosition: job.QueuePosition,
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(response.Response{
+		Success: true,
+		Data:    dtoResp,
+	})
+}
+
+// handleGetBatchStatus handles batch status requests
+// @Summary Get batch status
+// @Description Get the aggregate status of the jobs of a batch: the number of jobs by status and their average progress, where finished jobs count as 100.
+// @Description The batch is QUEUED until a job starts and PROCESSING until every job has finished. It then is SUCCESS if every job succeeded,
+// @Description PARTIAL_SUCCESS if some did and FAILED if none did.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Batch ID returned from the batch endpoint"
+// @Success 200 {object} response.Response{data=dto.BatchStatus} "Batch status retrieved successfully"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Batch not found"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /ffmpeg/batches/{id} [get]
+func (r *FFMPEGRoutes) handleGetBatchStatus(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	batchID := c.Params("id")
+	status, err := r.ffmpegService.GetBatchStatus(c.Context(), batchID, user.ID)
+	if errors.Is(err, service.ErrBatchNotFound) {
 		return c.Status(fiber.StatusNotFound).JSON(response.Response{
 			Success: false,
 			Error: &response.APIError{
 				Type:    "NotFound",
-				Message: "Job not found",
+				Message: "Batch not found",
 			},
 		})
 	}
-
-	// Convert domain model to DTO
-	dtoStatus := dto.JobStatus{
-		UUID:        status.UUID,
-		Status:      status.Status,
-		Result:      status.Result,
-		Progress:    status.Progress,
-		Error:       status.Error,
-		CreatedAt:   status.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt:   status.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		OutputFiles: status.OutputFiles,
+	if err != nil {
+		logger.Error("failed to get batch status", "error", err, "batch_id", batchID)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to get batch status",
+			},
+		})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response.Response{
 		Success: true,
-		Data:    dtoStatus,
+		Data: dto.BatchStatus{
+			BatchID:  status.BatchID,
+			Status:   status.Status,
+			Total:    status.Total,
+			Counts:   status.Counts,
+			Progress: status.Progress,
+		},
 	})
 }
 
+// handleProbe handles media probe requests
+// @Summary Probe a media file
+// @Description Describe a media file with ffprobe and return its normalized format and streams. The url is an http(s) URL or,
+// @Description with MinIO storage, the key of one of your objects. Files up to PROBE_SYNC_MAX_SIZE_MB are probed right away and the
+// @Description response has probe set. Larger files, and files whose size is unknown, are probed by a queued job: the response has
+// @Description job set, and the probe is reported as input_probes.input of the job once it succeeded.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body dto.ProbeRequest true "File to probe"
+// @Success 200 {object} response.Response{data=dto.ProbeResponse} "File probed"
+// @Success 202 {object} response.Response{data=dto.ProbeResponse} "Probe job accepted for processing"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid request, or the file is not accessible"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 422 {object} response.Response{error=response.APIError} "The file could not be probed"
+// @Router /ffmpeg/probe [post]
+func (r *FFMPEGRoutes) handleProbe(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	var req dto.ProbeRequest
+	if err := c.BodyParser(&req); err != nil {
+		logger.Error("invalid request body", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Invalid request body",
+			},
+		})
+	}
+
+	if err := validation.Validate(req); err != nil {
+		logger.Error("validation failed", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
+
+	result, err := r.ffmpegService.Probe(c.Context(), req.URL, user.ID)
+	if err != nil {
+		logger.Error("failed to probe file", "error", err, "url", req.URL)
+		switch {
+		case errors.Is(err, service.ErrInvalidProbeURL), errors.Is(err, service.ErrFileNotAccessible):
+			return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "BadRequest",
+					Message: err.Error(),
+				},
+			})
+		default:
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "UnprocessableEntity",
+					Message: "Failed to probe file",
+				},
+			})
+		}
+	}
+
+	dtoResp := dto.ProbeResponse{
+		Size:  result.Size,
+		Probe: result.Probe,
+	}
+	status := fiber.StatusOK
+	if result.Job != nil {
+		dtoResp.Job = &dto.FFMPEGResponse{
+			UUID:          result.Job.UUID,
+			Status:        result.Job.Status,
+			QueuePosition: result.Job.QueuePosition,
+		}
+		status = fiber.StatusAccepted
+	}
+
+	return c.Status(status).JSON(response.Response{
+		Success: true,
+		Data:    dtoResp,
+	})
+}
+
+// handleGetProgress handles job progress requests
+// @Summary Get job progress
+// @Description Get the current status and progress of a video processing job. Returns details about output files when the job is completed.
+// @Description Queued jobs also report their position in the queue.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param uuid path string true "Job UUID returned from the process endpoint"
+// @Success 200 {object} response.Response{data=dto.JobStatus} "Job status retrieved successfully"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid UUID format"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Job not found"
+// @Router /ffmpeg/progress/{uuid} [get]
+func (r *FFMPEGRoutes) handleGetProgress(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	uuid := c.Params("uuid")
+	if uuid == "" {
+		logger.Error("missing uuid parameter")
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Missing UUID parameter",
+			},
+		})
+	}
+
+	status, err := r.ffmpegService.GetJobStatus(c.Context(), uuid, user.ID)
+	logger.Debug(status, "<<")
+	if err != nil {
+		logger.Error("failed to get job status", "error", err, "uuid", uuid)
+		return c.Status(fiber.StatusNotFound).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "NotFound",
+				Message: "Job not found",
+			},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    toJobStatusDTO(status),
+	})
+}
+
+// handleStreamProgress handles job progress stream requests
+// @Summary Stream job progress
+// @Description Stream the progress of a job as Server-Sent Events. The stream starts with the current state of the job,
+// @Description followed by "progress" events with the FFMPEG time, fps, bitrate and speed while the job runs and "status"
+// @Description events when its status changes. It ends with a "complete" event carrying the output files once the job
+// @Description succeeded, failed or was cancelled. Every event's data is a JSON encoded dto.ProgressEvent.
+// @Tags FFMPEG
+// @Produce text/event-stream
+// @Security ApiKeyAuth
+// @Param uuid path string true "Job UUID returned from the process endpoint"
+// @Success 200 {object} dto.ProgressEvent "Stream of progress events"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid UUID format"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Job not found"
+// @Router /ffmpeg/progress/{uuid}/stream [get]
+func (r *FFMPEGRoutes) handleStreamProgress(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	uuid := c.Params("uuid")
+	if uuid == "" {
+		logger.Error("missing uuid parameter")
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Missing UUID parameter",
+			},
+		})
+	}
+
+	// The stream outlives the request handler, so it gets its own context
+	ctx, cancel := context.WithCancel(context.Background())
+	job, events, unsubscribe, err := r.ffmpegService.SubscribeProgress(ctx, uuid, user.ID)
+	if err != nil {
+		cancel()
+		logger.Error("failed to subscribe to job progress", "error", err, "uuid", uuid)
+		return c.Status(fiber.StatusNotFound).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "NotFound",
+				Message: "Job not found",
+			},
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	userID := user.ID
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer unsubscribe()
+
+		ticker := time.NewTicker(progressStreamPollInterval)
+		defer ticker.Stop()
+
+		last := domain.ProgressEvent{
+			JobUUID:     job.UUID,
+			Status:      job.Status,
+			Progress:    job.Progress,
+			FFmpeg:      job.FFmpegProgress,
+			OutputFiles: job.OutputFiles,
+		}
+		if err := writeProgressEvent(w, progressEventName(last, ""), last); err != nil {
+			return
+		}
+
+		for !domain.IsTerminalJobStatus(last.Status) {
+			var event domain.ProgressEvent
+			select {
+			case event = <-events:
+			case <-ticker.C:
+				current, err := r.ffmpegService.GetJobStatus(ctx, uuid, userID)
+				if err != nil || (current.Status == last.Status && current.Progress == last.Progress) {
+					// Nothing new, keep the connection alive
+					if _, err := w.WriteString(": keep-alive\n\n"); err != nil {
+						return
+					}
+					if err := w.Flush(); err != nil {
+						return
+					}
+					continue
+				}
+				event = domain.ProgressEvent{
+					JobUUID:     current.UUID,
+					Status:      current.Status,
+					Progress:    current.Progress,
+					FFmpeg:      current.FFmpegProgress,
+					OutputFiles: current.OutputFiles,
+				}
+			}
+
+			name := progressEventName(event, last.Status)
+			last = event
+			if err := writeProgressEvent(w, name, event); err != nil {
+				return
+			}
+		}
+	}))
+
+	return nil
+}
+
+// progressEventName returns the SSE event name of a progress event given the
+// status of the previous event
+func progressEventName(event domain.ProgressEvent, previousStatus string) string {
+	switch {
+	case domain.IsTerminalJobStatus(event.Status):
+		return "complete"
+	case event.Status != previousStatus:
+		return "status"
+	default:
+		return "progress"
+	}
+}
+
+// writeProgressEvent writes a progress event to an SSE stream and flushes it.
+// An error means the client has gone away.
+func writeProgressEvent(w *bufio.Writer, name string, event domain.ProgressEvent) error {
+	progress := dto.ProgressEvent{
+		UUID:        event.JobUUID,
+		Status:      event.Status,
+		Progress:    event.Progress,
+		FFmpeg:      event.FFmpeg,
+		OutputFiles: event.OutputFiles,
+	}
+	if event.FFmpeg != nil {
+		progress.Time = event.FFmpeg.OutTime
+		progress.FPS = event.FFmpeg.FPS
+		progress.Bitrate = event.FFmpeg.Bitrate
+		progress.Speed = event.FFmpeg.Speed
+	}
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// handleListJobs handles job listing requests
+// @Summary List jobs
+// @Description List the jobs of the authenticated user by creation time, newest first by default. Results are paginated with an opaque
+// @Description cursor: pass the next_cursor of a page as the cursor parameter to get the following page. As creation times don't change,
+// @Description pages neither skip nor repeat jobs updated in the meantime; use updated_after to find the jobs changed since a time.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param status query string false "Comma-separated list of statuses to include"
+// @Param created_after query string false "Only jobs created at or after this RFC 3339 time"
+// @Param created_before query string false "Only jobs created before this RFC 3339 time"
+// @Param updated_after query string false "Only jobs last updated at or after this RFC 3339 time"
+// @Param output_type query string false "Only jobs with an output file of this type" Enums(image, video, audio, unknown)
+// @Param batch_id query string false "Only jobs of this batch"
+// @Param order query string false "Creation time order" Enums(asc, desc) default(desc)
+// @Param limit query int false "Maximum number of jobs to return (max 200)" default(50)
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Success 200 {object} response.Response{data=dto.JobList} "Jobs retrieved successfully"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid query parameters"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /ffmpeg/jobs [get]
+func (r *FFMPEGRoutes) handleListJobs(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	var query dto.JobListQuery
+	if err := c.QueryParser(&query); err != nil {
+		logger.Error("invalid query parameters", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Invalid query parameters",
+			},
+		})
+	}
+
+	if err := validation.Validate(query); err != nil {
+		logger.Error("validation failed", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
+
+	// Convert DTO to domain filter
+	var err error
+	filter := domain.JobFilter{
+		UserID:     user.ID,
+		OutputType: query.OutputType,
+		BatchID:    query.BatchID,
+		Descending: query.Order != "asc",
+		Limit:      query.Limit,
+	}
+	if query.Status != "" {
+		filter.Statuses = strings.Split(strings.ToUpper(query.Status), ",")
+	}
+	if filter.CreatedAfter, err = parseTimeQuery(query.CreatedAfter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: map[string]string{"created_after": "should be an RFC 3339 time"},
+			},
+		})
+	}
+	if filter.CreatedBefore, err = parseTimeQuery(query.CreatedBefore); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: map[string]string{"created_before": "should be an RFC 3339 time"},
+			},
+		})
+	}
+
+	if filter.UpdatedAfter, err = parseTimeQuery(query.UpdatedAfter); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: map[string]string{"updated_after": "should be an RFC 3339 time"},
+			},
+		})
+	}
+
+	list, err := r.ffmpegService.ListJobs(c.Context(), filter, query.Cursor)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "BadRequest",
+					Message: "Invalid cursor",
+				},
+			})
+		}
+		logger.Error("failed to list jobs", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to list jobs",
+			},
+		})
+	}
+
+	// Convert domain models to DTO
+	dtoList := dto.JobList{
+		Jobs:       make([]dto.JobStatus, 0, len(list.Jobs)),
+		NextCursor: list.NextCursor,
+	}
+	for i := range list.Jobs {
+		dtoList.Jobs = append(dtoList.Jobs, toJobStatusDTO(&list.Jobs[i]))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    dtoList,
+	})
+}
+
+// handleCancelJob handles job cancellation requests
+// @Summary Cancel a job
+// @Description Cancel a queued or running job. A running FFMPEG process is interrupted and killed if it does not stop within the grace period.
+// @Description In-flight downloads and uploads are aborted and the job ends in the CANCELLED status.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param uuid path string true "Job UUID returned from the process endpoint"
+// @Success 200 {object} response.Response{data=dto.JobStatus} "Job cancelled"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Job not found"
+// @Failure 409 {object} response.Response{error=response.APIError} "Job has already finished"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /ffmpeg/{uuid} [delete]
+func (r *FFMPEGRoutes) handleCancelJob(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	uuid := c.Params("uuid")
+	status, err := r.ffmpegService.CancelJob(c.Context(), uuid, user.ID)
+	if err != nil {
+		logger.Error("failed to cancel job", "error", err, "uuid", uuid)
+		switch {
+		case errors.Is(err, service.ErrJobNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "NotFound",
+					Message: "Job not found",
+				},
+			})
+		case errors.Is(err, service.ErrJobFinished):
+			return c.Status(fiber.StatusConflict).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "Conflict",
+					Message: "Job has already finished with status " + status.Status,
+				},
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "InternalServerError",
+					Message: "Failed to cancel job",
+				},
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    toJobStatusDTO(status),
+	})
+}
+
+// handleListWebhookDeliveries handles webhook delivery log requests
+// @Summary List webhook deliveries
+// @Description List every webhook delivery attempt made for a job, oldest first. Retries of one delivery share the same delivery_uuid.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param uuid path string true "Job UUID"
+// @Success 200 {object} response.Response{data=[]dto.WebhookDelivery} "Deliveries retrieved successfully"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Job not found"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /ffmpeg/{uuid}/webhooks [get]
+func (r *FFMPEGRoutes) handleListWebhookDeliveries(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	uuid := c.Params("uuid")
+	deliveries, err := r.webhookService.ListDeliveries(c.Context(), uuid, user.ID)
+	if err != nil {
+		if errors.Is(err, service.ErrJobNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "NotFound",
+					Message: "Job not found",
+				},
+			})
+		}
+		logger.Error("failed to list webhook deliveries", "error", err, "uuid", uuid)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to list webhook deliveries",
+			},
+		})
+	}
+
+	// Convert domain models to DTO
+	dtoDeliveries := make([]dto.WebhookDelivery, 0, len(deliveries))
+	for i := range deliveries {
+		dtoDeliveries = append(dtoDeliveries, toWebhookDeliveryDTO(&deliveries[i]))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    dtoDeliveries,
+	})
+}
+
+// handleRedeliverWebhook handles webhook redelivery requests
+// @Summary Redeliver a webhook
+// @Description Send the payload of an earlier delivery attempt again. The redelivery is scheduled as a new delivery, retried like any
+// @Description other, and returned with its scheduled_at; list the job's deliveries to see its outcome.
+// @Tags FFMPEG
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param uuid path string true "Job UUID"
+// @Param id path int true "Delivery attempt ID"
+// @Success 202 {object} response.Response{data=dto.WebhookDelivery} "Redelivery scheduled"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid delivery ID"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Job or delivery not found"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /ffmpeg/{uuid}/webhooks/{id}/redeliver [post]
+func (r *FFMPEGRoutes) handleRedeliverWebhook(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	deliveryID, err := c.ParamsInt("id")
+	if err != nil || deliveryID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Invalid delivery ID",
+			},
+		})
+	}
+
+	uuid := c.Params("uuid")
+	delivery, err := r.webhookService.Redeliver(c.Context(), uuid, uint(deliveryID), user.ID)
+	if err != nil {
+		if errors.Is(err, service.ErrJobNotFound) || errors.Is(err, service.ErrDeliveryNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "NotFound",
+					Message: err.Error(),
+				},
+			})
+		}
+		logger.Error("failed to redeliver webhook", "error", err, "uuid", uuid)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to redeliver webhook",
+			},
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(response.Response{
+		Success: true,
+		Data:    toWebhookDeliveryDTO(delivery),
+	})
+}
+
+// toWebhookDeliveryDTO converts a domain webhook delivery to its DTO
+func toWebhookDeliveryDTO(delivery *domain.WebhookDelivery) dto.WebhookDelivery {
+	var scheduledAt string
+	if delivery.ScheduledAt != nil {
+		scheduledAt = delivery.ScheduledAt.Format("2006-01-02T15:04:05Z07:00")
+	}
+	return dto.WebhookDelivery{
+		ID:           delivery.ID,
+		DeliveryUUID: delivery.DeliveryUUID,
+		Event:        delivery.Event,
+		URL:          delivery.URL,
+		Payload:      delivery.Payload,
+		Attempt:      delivery.Attempt,
+		StatusCode:   delivery.StatusCode,
+		Success:      delivery.Success,
+		Error:        delivery.Error,
+		DurationMs:   delivery.DurationMs,
+		ScheduledAt:  scheduledAt,
+		CreatedAt:    delivery.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+// toDestinationsDomain converts the output destinations of a request DTO to
+// the domain model
+func toDestinationsDomain(destinations map[string]dto.OutputDestination) map[string]domain.OutputDestination {
+	if len(destinations) == 0 {
+		return nil
+	}
+	domainDestinations := make(map[string]domain.OutputDestination, len(destinations))
+	for key, dest := range destinations {
+		domainDestinations[key] = domain.OutputDestination{
+			Bucket:      dest.Bucket,
+			Prefix:      dest.Prefix,
+			Credentials: dest.Credentials,
+		}
+	}
+	return domainDestinations
+}
+
+// toFFMPEGRequestDomain converts a processing request DTO to the domain model
+func toFFMPEGRequestDomain(req dto.FFMPEGRequest) domain.FFMPEGRequest {
+	domainReq := domain.FFMPEGRequest{
+		InputFiles:      req.InputFiles,
+		OutputFiles:     req.OutputFiles,
+		FFmpegCommand:   req.FFmpegCommand,
+		GlobalOptions:   req.GlobalOptions,
+		Preset:          req.Preset,
+		PresetVariables: req.PresetVariables,
+		Upload:          req.Upload,
+		Destinations:    toDestinationsDomain(req.Destinations),
+		WebhookURL:      req.WebhookURL,
+		WebhookEvents:   req.WebhookEvents,
+		InputModes:      req.InputModes,
+	}
+	for _, step := range req.Steps {
+		domainReq.Steps = append(domainReq.Steps, domain.PipelineStep{
+			ID:              step.ID,
+			FFmpegCommand:   step.FFmpegCommand,
+			Preset:          step.Preset,
+			PresetVariables: step.PresetVariables,
+			OutputFiles:     step.OutputFiles,
+		})
+	}
+	for _, input := range req.Inputs {
+		domainReq.Inputs = append(domainReq.Inputs, domain.FFMPEGInput{
+			Key:     input.Key,
+			URL:     input.URL,
+			Options: input.Options,
+		})
+	}
+	for _, output := range req.Outputs {
+		domainReq.Outputs = append(domainReq.Outputs, domain.FFMPEGOutput{
+			Key:      output.Key,
+			Filename: output.Filename,
+			Options:  output.Options,
+		})
+	}
+	return domainReq
+}
+
+// toJobStatusDTO converts a domain job status to its DTO
+func toJobStatusDTO(status *domain.JobStatus) dto.JobStatus {
+	return dto.JobStatus{
+		UUID:             status.UUID,
+		Type:             status.Type,
+		BatchID:          status.BatchID,
+		Status:           status.Status,
+		Result:           status.Result,
+		Progress:         status.Progress,
+		FFmpegProgress:   status.FFmpegProgress,
+		InputProbes:      status.InputProbes,
+		ExpectedDuration: status.ExpectedDuration,
+		Steps:            status.Steps,
+		Renditions:       status.Renditions,
+		QueuePosition:    status.QueuePosition,
+		Error:            status.Error,
+		CreatedAt:        status.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		UpdatedAt:        status.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		OutputFiles:      status.OutputFiles,
+	}
+}
+
+// parseTimeQuery parses an optional RFC 3339 query parameter
+func parseTimeQuery(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
 // authMiddleware authenticates requests
 func (r *FFMPEGRoutes) authMiddleware(c *fiber.Ctx) error {
 	token := c.Get("X-API-Token")
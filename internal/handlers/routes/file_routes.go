@@ -0,0 +1,171 @@
+package routes
+
+import (
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/dto"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/response"
+	"ffmpeg-api/internal/service"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FileRoutes handles the routes of the output files of jobs
+type FileRoutes struct {
+	ffmpegService service.FFMPEGService
+	authService   service.AuthService
+}
+
+// NewFileRoutes creates a new FileRoutes instance
+func NewFileRoutes(ffmpegService service.FFMPEGService, authService service.AuthService) *FileRoutes {
+	return &FileRoutes{
+		ffmpegService: ffmpegService,
+		authService:   authService,
+	}
+}
+
+// Register registers all file routes
+func (r *FileRoutes) Register(router fiber.Router) {
+	files := router.Group("/api/v1/files")
+	files.Use(r.authMiddleware)
+	files.Get("/:file_id", r.handleGetFile)
+
+	// Players can't send API tokens, playlist URLs are signed instead
+	router.Get("/api/v1/playlists/:user_id/:file_id/*", r.handleGetPlaylist)
+}
+
+// handleGetFile handles output file requests
+// @Summary Get an output file
+// @Description Get an output file of one of your jobs by the file_id of its output_files entry, with its storage_url, manifest_url
+// @Description and the storage_url of each of its files issued anew. If storage is private (STORAGE_PRIVATE), the URLs in a job's status
+// @Description are presigned and expire after STORAGE_URL_EXPIRY_MINUTES; this returns fresh ones and when they expire.
+// @Description Outputs delivered to a destination bucket keep the URLs they were uploaded with. The URLs of HLS playlists, DASH manifests
+// @Description and storyboards in private storage are those of GET /playlists/{user_id}/{file_id}/{path}, which refers to their files
+// @Description by URLs issued anew as well.
+// @Tags Files
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param file_id path string true "File ID"
+// @Success 200 {object} response.Response{data=dto.File} "File retrieved successfully"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "File not found"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /files/{file_id} [get]
+func (r *FileRoutes) handleGetFile(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	file, err := r.ffmpegService.GetFile(c.Context(), c.Params("file_id"), user.ID)
+	if err != nil {
+		if errors.Is(err, service.ErrFileNotFound) {
+			return objectNotFound(c)
+		}
+		logger.Error("failed to get file", "error", err, "file_id", c.Params("file_id"))
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to get file",
+			},
+		})
+	}
+
+	dtoFile := dto.File{
+		JobUUID:            file.JobUUID,
+		Key:                file.Key,
+		OutputFileMetadata: file.OutputFileMetadata,
+	}
+	if file.ExpiresAt != nil {
+		dtoFile.ExpiresAt = file.ExpiresAt.Format("2006-01-02T15:04:05Z07:00")
+	}
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    dtoFile,
+	})
+}
+
+// handleGetPlaylist handles served playlist requests
+// @Summary Get a playlist of private storage
+// @Description Get an HLS playlist, DASH manifest or storyboard of an output in private storage (STORAGE_PRIVATE) with the files it
+// @Description refers to replaced by URLs issued anew, so a player can follow them. The URL is handed out by GET /files/{file_id} as
+// @Description a storage_url or manifest_url, carries the expires and signature instead of an API token, and works until it expires.
+// @Tags Files
+// @Produce plain
+// @Param user_id path int true "User ID"
+// @Param file_id path string true "File ID"
+// @Param path path string true "Path of the file in the job's directory, e.g. hls/master.m3u8"
+// @Param expires query int true "Unix time the URL expires at"
+// @Param signature query string true "Signature of the URL"
+// @Success 200 {string} string "Playlist"
+// @Failure 403 {object} response.Response{error=response.APIError} "URL expired or not signed"
+// @Failure 404 {object} response.Response{error=response.APIError} "File not found"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /playlists/{user_id}/{file_id}/{path} [get]
+func (r *FileRoutes) handleGetPlaylist(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("user_id")
+	filePath, pathErr := url.PathUnescape(c.Params("*"))
+	if err != nil || userID <= 0 || pathErr != nil {
+		return objectNotFound(c)
+	}
+
+	data, err := r.ffmpegService.GetPlaylist(c.Context(), uint(userID), c.Params("file_id"), filePath, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrFileNotFound):
+			return objectNotFound(c)
+		case errors.Is(err, service.ErrInvalidSignature):
+			return c.Status(fiber.StatusForbidden).JSON(response.Response{
+				Success: false,
+				Error: &response.APIError{
+					Type:    "Forbidden",
+					Message: "URL has expired or is not signed",
+				},
+			})
+		}
+		logger.Error("failed to get playlist", "error", err, "file_id", c.Params("file_id"))
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to get playlist",
+			},
+		})
+	}
+
+	// The URLs it refers to expire, so it must not be cached
+	c.Set(fiber.HeaderContentType, service.ContentType(filePath))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(fiber.StatusOK).Send(data)
+}
+
+// authMiddleware authenticates requests
+func (r *FileRoutes) authMiddleware(c *fiber.Ctx) error {
+	token := c.Get("X-API-Token")
+	if token == "" {
+		logger.Warn("missing API token")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Missing API token",
+			},
+		})
+	}
+
+	user, err := r.authService.ValidateToken(c.Context(), token)
+	if err != nil {
+		logger.Error("invalid API token", "error", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Invalid API token",
+			},
+		})
+	}
+
+	c.Locals("user", user)
+	return c.Next()
+}
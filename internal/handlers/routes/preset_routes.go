@@ -0,0 +1,396 @@
+package routes
+
+import (
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/dto"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/response"
+	"ffmpeg-api/internal/service"
+	"ffmpeg-api/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PresetRoutes handles all preset related routes
+type PresetRoutes struct {
+	presetService service.PresetService
+	authService   service.AuthService
+}
+
+// NewPresetRoutes creates a new PresetRoutes instance
+func NewPresetRoutes(presetService service.PresetService, authService service.AuthService) *PresetRoutes {
+	return &PresetRoutes{
+		presetService: presetService,
+		authService:   authService,
+	}
+}
+
+// Register registers all preset routes
+func (r *PresetRoutes) Register(router fiber.Router) {
+	presets := router.Group("/api/v1/presets")
+	presets.Use(r.authMiddleware)
+	presets.Get("/", r.handleListPresets)
+	presets.Post("/", r.handleCreatePreset)
+	presets.Get("/:id", r.handleGetPreset)
+	presets.Put("/:id", r.handleUpdatePreset)
+	presets.Delete("/:id", r.handleDeletePreset)
+}
+
+// handleListPresets handles preset list requests
+// @Summary List presets
+// @Description List your own presets followed by the global presets, each ordered by name.
+// @Tags Presets
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} response.Response{data=[]dto.Preset} "Presets retrieved successfully"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /presets [get]
+func (r *PresetRoutes) handleListPresets(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	presets, err := r.presetService.ListPresets(c.Context(), user)
+	if err != nil {
+		logger.Error("failed to list presets", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to list presets",
+			},
+		})
+	}
+
+	dtoPresets := make([]dto.Preset, 0, len(presets))
+	for i := range presets {
+		dtoPresets = append(dtoPresets, toPresetDTO(&presets[i]))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    dtoPresets,
+	})
+}
+
+// handleCreatePreset handles preset creation requests
+// @Summary Create a preset
+// @Description Create a named FFMPEG command template. Besides file placeholders such as {{in1}} and {{out1}}, the command may contain
+// @Description {{name}} placeholders for its variables, which are typed (string, integer, number or boolean) and may have a default,
+// @Description bounds and a list of allowed values. Every variable must be used by the command.
+// @Description Jobs use a preset by setting preset and preset_variables instead of ffmpeg_command; your own preset wins over a global one of the same name.
+// @Description Global presets are visible to every user and can only be created by the users listed in ADMIN_USERNAMES.
+// @Tags Presets
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body dto.PresetRequest true "Preset details"
+// @Success 201 {object} response.Response{data=dto.Preset} "Preset created"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid request or validation error"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 403 {object} response.Response{error=response.APIError} "Only admins can create global presets"
+// @Failure 409 {object} response.Response{error=response.APIError} "A preset with this name already exists"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /presets [post]
+func (r *PresetRoutes) handleCreatePreset(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	req, errResp := parsePresetRequest(c)
+	if errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+
+	preset := toPresetDomain(req)
+	if err := r.presetService.CreatePreset(c.Context(), &preset, req.Global, user); err != nil {
+		logger.Error("failed to create preset", "error", err)
+		return presetError(c, err, "Failed to create preset")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response.Response{
+		Success: true,
+		Data:    toPresetDTO(&preset),
+	})
+}
+
+// handleGetPreset handles preset requests
+// @Summary Get a preset
+// @Description Get one of your presets or a global preset.
+// @Tags Presets
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Preset ID"
+// @Success 200 {object} response.Response{data=dto.Preset} "Preset retrieved successfully"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Preset not found"
+// @Router /presets/{id} [get]
+func (r *PresetRoutes) handleGetPreset(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return presetError(c, service.ErrPresetNotFound, "")
+	}
+
+	preset, err := r.presetService.GetPreset(c.Context(), uint(id), user)
+	if err != nil {
+		return presetError(c, err, "Failed to get preset")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    toPresetDTO(preset),
+	})
+}
+
+// handleUpdatePreset handles preset update requests
+// @Summary Update a preset
+// @Description Replace the name, description, command and variables of one of your presets. Global presets can only be updated by admins.
+// @Description Jobs that were already submitted keep the command they were submitted with.
+// @Tags Presets
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Preset ID"
+// @Param request body dto.PresetRequest true "Preset details"
+// @Success 200 {object} response.Response{data=dto.Preset} "Preset updated"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid request or validation error"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 403 {object} response.Response{error=response.APIError} "Only admins can update global presets"
+// @Failure 404 {object} response.Response{error=response.APIError} "Preset not found"
+// @Failure 409 {object} response.Response{error=response.APIError} "A preset with this name already exists"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /presets/{id} [put]
+func (r *PresetRoutes) handleUpdatePreset(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return presetError(c, service.ErrPresetNotFound, "")
+	}
+
+	req, errResp := parsePresetRequest(c)
+	if errResp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errResp)
+	}
+
+	preset, err := r.presetService.UpdatePreset(c.Context(), uint(id), toPresetDomain(req), user)
+	if err != nil {
+		logger.Error("failed to update preset", "error", err, "id", id)
+		return presetError(c, err, "Failed to update preset")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    toPresetDTO(preset),
+	})
+}
+
+// handleDeletePreset handles preset deletion requests
+// @Summary Delete a preset
+// @Description Delete one of your presets. Global presets can only be deleted by admins.
+// @Tags Presets
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Preset ID"
+// @Success 204 "Preset deleted"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 403 {object} response.Response{error=response.APIError} "Only admins can delete global presets"
+// @Failure 404 {object} response.Response{error=response.APIError} "Preset not found"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /presets/{id} [delete]
+func (r *PresetRoutes) handleDeletePreset(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return presetError(c, service.ErrPresetNotFound, "")
+	}
+
+	if err := r.presetService.DeletePreset(c.Context(), uint(id), user); err != nil {
+		logger.Error("failed to delete preset", "error", err, "id", id)
+		return presetError(c, err, "Failed to delete preset")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// parsePresetRequest parses and validates the body of a preset request
+func parsePresetRequest(c *fiber.Ctx) (dto.PresetRequest, *response.Response) {
+	var req dto.PresetRequest
+	if err := c.BodyParser(&req); err != nil {
+		logger.Error("invalid request body", "error", err)
+		return req, &response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Invalid request body",
+			},
+		}
+	}
+
+	if err := validation.Validate(req); err != nil {
+		logger.Error("validation failed", "error", err)
+		return req, &response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		}
+	}
+	return req, nil
+}
+
+// presetError writes the response for an error of the preset service
+func presetError(c *fiber.Ctx, err error, message string) error {
+	status, errType := fiber.StatusInternalServerError, "InternalServerError"
+	switch {
+	case errors.Is(err, service.ErrPresetNotFound):
+		status, errType, message = fiber.StatusNotFound, "NotFound", "Preset not found"
+	case errors.Is(err, service.ErrPresetForbidden):
+		status, errType, message = fiber.StatusForbidden, "Forbidden", err.Error()
+	case errors.Is(err, service.ErrPresetExists):
+		status, errType, message = fiber.StatusConflict, "Conflict", err.Error()
+	case errors.Is(err, service.ErrInvalidPreset):
+		status, errType, message = fiber.StatusBadRequest, "ValidationError", err.Error()
+	}
+
+	return c.Status(status).JSON(response.Response{
+		Success: false,
+		Error: &response.APIError{
+			Type:    errType,
+			Message: message,
+		},
+	})
+}
+
+// toPresetDomain converts a preset request to the domain model
+func toPresetDomain(req dto.PresetRequest) domain.Preset {
+	preset := domain.Preset{
+		Name:        req.Name,
+		Description: req.Description,
+		Command:     req.Command,
+		Variables:   domain.PresetVariables{},
+	}
+	for _, variable := range req.Variables {
+		preset.Variables = append(preset.Variables, domain.PresetVariable{
+			Name:        variable.Name,
+			Type:        variable.Type,
+			Default:     variable.Default,
+			Description: variable.Description,
+			Min:         variable.Min,
+			Max:         variable.Max,
+			Enum:        variable.Enum,
+		})
+	}
+	return preset
+}
+
+// toPresetDTO converts a domain preset to its DTO
+func toPresetDTO(preset *domain.Preset) dto.Preset {
+	dtoPreset := dto.Preset{
+		ID:          preset.ID,
+		Name:        preset.Name,
+		Description: preset.Description,
+		Command:     preset.Command,
+		Variables:   []dto.PresetVariable{},
+		Global:      preset.IsGlobal(),
+		CreatedAt:   preset.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		UpdatedAt:   preset.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+	for _, variable := range preset.Variables {
+		dtoPreset.Variables = append(dtoPreset.Variables, dto.PresetVariable{
+			Name:        variable.Name,
+			Type:        variable.Type,
+			Default:     variable.Default,
+			Description: variable.Description,
+			Min:         variable.Min,
+			Max:         variable.Max,
+			Enum:        variable.Enum,
+		})
+	}
+	return dtoPreset
+}
+
+// authMiddleware authenticates requests
+func (r *PresetRoutes) authMiddleware(c *fiber.Ctx) error {
+	token := c.Get("X-API-Token")
+	if token == "" {
+		logger.Warn("missing API token")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Missing API token",
+			},
+		})
+	}
+
+	user, err := r.authService.ValidateToken(c.Context(), token)
+	if err != nil {
+		logger.Error("invalid API token", "error", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Invalid API token",
+			},
+		})
+	}
+
+	c.Locals("user", user)
+	return c.Next()
+}
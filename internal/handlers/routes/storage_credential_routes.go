@@ -0,0 +1,258 @@
+package routes
+
+import (
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/dto"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/response"
+	"ffmpeg-api/internal/service"
+	"ffmpeg-api/internal/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StorageCredentialRoutes handles all storage credential related routes
+type StorageCredentialRoutes struct {
+	credentialService service.StorageCredentialService
+	authService       service.AuthService
+}
+
+// NewStorageCredentialRoutes creates a new StorageCredentialRoutes instance
+func NewStorageCredentialRoutes(credentialService service.StorageCredentialService, authService service.AuthService) *StorageCredentialRoutes {
+	return &StorageCredentialRoutes{
+		credentialService: credentialService,
+		authService:       authService,
+	}
+}
+
+// Register registers all storage credential routes
+func (r *StorageCredentialRoutes) Register(router fiber.Router) {
+	credentials := router.Group("/api/v1/storage-credentials")
+	credentials.Use(r.authMiddleware)
+	credentials.Get("/", r.handleListCredentials)
+	credentials.Post("/", r.handleCreateCredential)
+	credentials.Get("/:name", r.handleGetCredential)
+	credentials.Delete("/:name", r.handleDeleteCredential)
+}
+
+// handleListCredentials handles storage credential list requests
+// @Summary List storage credentials
+// @Description List your storage credentials ordered by name. Secret keys and session tokens are never returned.
+// @Tags Storage Credentials
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} response.Response{data=[]dto.StorageCredential} "Storage credentials retrieved successfully"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /storage-credentials [get]
+func (r *StorageCredentialRoutes) handleListCredentials(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	credentials, err := r.credentialService.ListCredentials(c.Context(), user.ID)
+	if err != nil {
+		logger.Error("failed to list storage credentials", "error", err)
+		return credentialError(c, err, "Failed to list storage credentials")
+	}
+
+	dtoCredentials := make([]dto.StorageCredential, 0, len(credentials))
+	for i := range credentials {
+		dtoCredentials = append(dtoCredentials, toStorageCredentialDTO(&credentials[i]))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    dtoCredentials,
+	})
+}
+
+// handleCreateCredential handles storage credential creation requests
+// @Summary Create a storage credential
+// @Description Store the credentials of an AWS S3, Google Cloud Storage (with HMAC keys) or other S3 compatible object store under a name.
+// @Description Jobs deliver outputs to your own buckets by naming the credential in their destinations, such as
+// @Description "destinations": {"out1": {"bucket": "my-videos", "prefix": "transcoded/", "credentials": "my-aws"}}.
+// @Description The secret key and session token are stored encrypted and never returned. With a role_arn, the role is assumed with the access key through STS.
+// @Description Requires STORAGE_CREDENTIALS_KEY to be configured.
+// @Tags Storage Credentials
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body dto.StorageCredentialRequest true "Storage credential details"
+// @Success 201 {object} response.Response{data=dto.StorageCredential} "Storage credential created"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid request or validation error"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 409 {object} response.Response{error=response.APIError} "A storage credential with this name already exists"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Failure 503 {object} response.Response{error=response.APIError} "Storage credentials are disabled"
+// @Router /storage-credentials [post]
+func (r *StorageCredentialRoutes) handleCreateCredential(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	var req dto.StorageCredentialRequest
+	if err := c.BodyParser(&req); err != nil {
+		logger.Error("invalid request body", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: "Invalid request body",
+			},
+		})
+	}
+
+	if err := validation.Validate(req); err != nil {
+		logger.Error("validation failed", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "ValidationError",
+				Message: err.Error(),
+			},
+		})
+	}
+
+	credential := domain.StorageCredential{
+		UserID:       user.ID,
+		Name:         req.Name,
+		Provider:     req.Provider,
+		Endpoint:     req.Endpoint,
+		Region:       req.Region,
+		UseSSL:       req.UseSSL == nil || *req.UseSSL,
+		BucketLookup: req.BucketLookup,
+		PublicURL:    req.PublicURL,
+		AccessKey:    req.AccessKey,
+		SecretKey:    req.SecretKey,
+		SessionToken: req.SessionToken,
+		RoleARN:      req.RoleARN,
+	}
+	if err := r.credentialService.CreateCredential(c.Context(), &credential); err != nil {
+		logger.Error("failed to create storage credential", "error", err)
+		return credentialError(c, err, "Failed to create storage credential")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response.Response{
+		Success: true,
+		Data:    toStorageCredentialDTO(&credential),
+	})
+}
+
+// handleGetCredential handles storage credential requests
+// @Summary Get a storage credential
+// @Description Get one of your storage credentials by name, without its secret key and session token.
+// @Tags Storage Credentials
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param name path string true "Storage credential name"
+// @Success 200 {object} response.Response{data=dto.StorageCredential} "Storage credential retrieved successfully"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Storage credential not found"
+// @Router /storage-credentials/{name} [get]
+func (r *StorageCredentialRoutes) handleGetCredential(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	credential, err := r.credentialService.GetCredential(c.Context(), c.Params("name"), user.ID)
+	if err != nil {
+		return credentialError(c, err, "Failed to get storage credential")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    toStorageCredentialDTO(credential),
+	})
+}
+
+// handleDeleteCredential handles storage credential deletion requests
+// @Summary Delete a storage credential
+// @Description Delete one of your storage credentials. Queued and running jobs delivering outputs with it fail when they upload them.
+// @Tags Storage Credentials
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param name path string true "Storage credential name"
+// @Success 204 "Storage credential deleted"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Storage credential not found"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /storage-credentials/{name} [delete]
+func (r *StorageCredentialRoutes) handleDeleteCredential(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	if err := r.credentialService.DeleteCredential(c.Context(), c.Params("name"), user.ID); err != nil {
+		logger.Error("failed to delete storage credential", "error", err)
+		return credentialError(c, err, "Failed to delete storage credential")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// credentialError writes the response for an error of the storage credential
+// service
+func credentialError(c *fiber.Ctx, err error, message string) error {
+	status, errType := fiber.StatusInternalServerError, "InternalServerError"
+	switch {
+	case errors.Is(err, service.ErrCredentialNotFound):
+		status, errType, message = fiber.StatusNotFound, "NotFound", "Storage credential not found"
+	case errors.Is(err, service.ErrCredentialExists):
+		status, errType, message = fiber.StatusConflict, "Conflict", err.Error()
+	case errors.Is(err, service.ErrInvalidCredential):
+		status, errType, message = fiber.StatusBadRequest, "ValidationError", err.Error()
+	case errors.Is(err, service.ErrCredentialsDisabled):
+		status, errType, message = fiber.StatusServiceUnavailable, "ServiceUnavailable", err.Error()
+	}
+
+	return c.Status(status).JSON(response.Response{
+		Success: false,
+		Error: &response.APIError{
+			Type:    errType,
+			Message: message,
+		},
+	})
+}
+
+// toStorageCredentialDTO converts a domain storage credential to its DTO
+func toStorageCredentialDTO(credential *domain.StorageCredential) dto.StorageCredential {
+	return dto.StorageCredential{
+		Name:         credential.Name,
+		Provider:     credential.Provider,
+		Endpoint:     credential.Endpoint,
+		Region:       credential.Region,
+		UseSSL:       credential.UseSSL,
+		BucketLookup: credential.BucketLookup,
+		PublicURL:    credential.PublicURL,
+		AccessKey:    credential.AccessKey,
+		RoleARN:      credential.RoleARN,
+		CreatedAt:    credential.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+// authMiddleware authenticates requests
+func (r *StorageCredentialRoutes) authMiddleware(c *fiber.Ctx) error {
+	token := c.Get("X-API-Token")
+	if token == "" {
+		logger.Warn("missing API token")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Missing API token",
+			},
+		})
+	}
+
+	user, err := r.authService.ValidateToken(c.Context(), token)
+	if err != nil {
+		logger.Error("invalid API token", "error", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Invalid API token",
+			},
+		})
+	}
+
+	c.Locals("user", user)
+	return c.Next()
+}
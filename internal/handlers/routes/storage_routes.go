@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"ffmpeg-api/internal/response"
+	"ffmpeg-api/internal/service"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StorageRoutes serves the objects of storage drivers that keep them on this
+// server, such as the filesystem driver
+type StorageRoutes struct {
+	storageService service.StorageService
+}
+
+// NewStorageRoutes creates a new StorageRoutes instance
+func NewStorageRoutes(storageService service.StorageService) *StorageRoutes {
+	return &StorageRoutes{
+		storageService: storageService,
+	}
+}
+
+// Register registers the storage routes, if the storage driver's objects are
+// served by this server
+func (r *StorageRoutes) Register(router fiber.Router) {
+	if _, ok := r.storageService.(service.ServedStorage); !ok {
+		return
+	}
+	router.Get("/api/v1/storage/*", r.handleDownload)
+}
+
+// handleDownload handles object downloads
+// @Summary Download a stored file
+// @Description Download an object of the filesystem storage driver by its key, such as an output file of a job.
+// @Description Like a public bucket, objects can be downloaded without a token by anyone who has their URL. If storage is private
+// @Description (STORAGE_PRIVATE), the URL must also carry the expires and signature of a URL handed out by the API, such as by GET /files/{file_id},
+// @Description and works until it expires. Range requests are supported.
+// @Tags Storage
+// @Produce octet-stream
+// @Param key path string true "Object key, e.g. user_1/<job>/output.mp4"
+// @Param expires query int false "Unix time the URL expires at, for private storage"
+// @Param signature query string false "Signature of the URL, for private storage"
+// @Success 200 {file} file "Object"
+// @Success 206 {file} file "Requested range of the object"
+// @Failure 403 {object} response.Response{error=response.APIError} "URL expired or not signed"
+// @Failure 404 {object} response.Response{error=response.APIError} "Object not found"
+// @Router /storage/{key} [get]
+func (r *StorageRoutes) handleDownload(c *fiber.Ctx) error {
+	served := r.storageService.(service.ServedStorage)
+
+	// Multipart uploads in progress are not objects yet
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil || strings.HasSuffix(key, ".partial") {
+		return objectNotFound(c)
+	}
+	if !served.CheckSignature(key, c.Query("expires"), c.Query("signature")) {
+		return c.Status(fiber.StatusForbidden).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Forbidden",
+				Message: "URL has expired or is not signed",
+			},
+		})
+	}
+	path, err := served.ObjectPath(key)
+	if err != nil {
+		return objectNotFound(c)
+	}
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return objectNotFound(c)
+	}
+	if err := c.SendFile(path); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, service.ContentType(key))
+	c.Set(fiber.HeaderContentDisposition, service.ContentDisposition(filepath.Base(path)))
+	return nil
+}
+
+// objectNotFound responds that an object doesn't exist
+func objectNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(response.Response{
+		Success: false,
+		Error: &response.APIError{
+			Type:    "NotFound",
+			Message: "File not found",
+		},
+	})
+}
@@ -0,0 +1,315 @@
+package routes
+
+import (
+	"encoding/base64"
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/response"
+	"ffmpeg-api/internal/service"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// tusVersion is the version of the tus protocol served
+	tusVersion = "1.0.0"
+	// tusExtensions are the tus protocol extensions served
+	tusExtensions = "creation,expiration,checksum,termination"
+	// tusContentType is the content type of PATCH request bodies
+	tusContentType = "application/offset+octet-stream"
+	// statusChecksumMismatch is the tus status for a chunk that doesn't match
+	// its checksum
+	statusChecksumMismatch = 460
+)
+
+// TusRoutes handles resumable uploads over the tus protocol, see
+// https://tus.io/protocols/resumable-upload
+type TusRoutes struct {
+	uploadService service.UploadService
+	authService   service.AuthService
+}
+
+// NewTusRoutes creates a new TusRoutes instance
+func NewTusRoutes(uploadService service.UploadService, authService service.AuthService) *TusRoutes {
+	return &TusRoutes{
+		uploadService: uploadService,
+		authService:   authService,
+	}
+}
+
+// Register registers all tus routes
+func (r *TusRoutes) Register(router fiber.Router) {
+	tus := router.Group("/api/v1/tus")
+	tus.Use(r.tusMiddleware)
+	tus.Options("/", r.handleOptions)
+	tus.Options("/:id", r.handleOptions)
+	tus.Use(r.authMiddleware)
+	tus.Post("/", r.handleCreate)
+	tus.Head("/:id", r.handleHead)
+	tus.Patch("/:id", r.handlePatch)
+	tus.Delete("/:id", r.handleDelete)
+}
+
+// handleOptions handles tus capability discovery
+// @Summary Get the tus capabilities
+// @Description Get the tus version, extensions, maximum size and checksum algorithms the server supports in the Tus-* headers.
+// @Tags Uploads
+// @Success 204 "Capabilities in the Tus-Version, Tus-Extension, Tus-Max-Size and Tus-Checksum-Algorithm headers"
+// @Router /tus [options]
+func (r *TusRoutes) handleOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Max-Size", strconv.FormatInt(r.uploadService.MaxSize(), 10))
+	c.Set("Tus-Checksum-Algorithm", strings.Join(service.ChecksumAlgorithms, ","))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleCreate handles the creation of resumable uploads
+// @Summary Create a resumable upload
+// @Description Start a tus upload of Upload-Length bytes, then send them with PATCH requests to the URL in the Location header.
+// @Description The filename and filetype keys of Upload-Metadata name the file. Incomplete uploads expire UPLOAD_EXPIRY_HOURS
+// @Description after their last chunk, and uploads count against UPLOAD_QUOTA_MB. Use the returned ref, upload://<id>, as a job input
+// @Description once the upload is complete.
+// @Tags Uploads
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Tus-Resumable header string true "tus version" default(1.0.0)
+// @Param Upload-Length header int true "Size of the file in bytes"
+// @Param Upload-Metadata header string false "Comma-separated keys and base64 values, e.g. filename ZmlsZS5tcDQ="
+// @Success 201 {object} response.Response{data=dto.Upload} "Upload created, its URL is in the Location header"
+// @Failure 400 {object} response.Response{error=response.APIError} "Missing or invalid Upload-Length or Upload-Metadata"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 412 {object} response.Response{error=response.APIError} "Unsupported tus version"
+// @Failure 413 {object} response.Response{error=response.APIError} "File larger than UPLOAD_MAX_SIZE_MB or what is left of UPLOAD_QUOTA_MB"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /tus [post]
+func (r *TusRoutes) handleCreate(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	if c.Get("Upload-Defer-Length") != "" {
+		return tusError(c, fiber.StatusBadRequest, "BadRequest", "Upload-Defer-Length is not supported, send Upload-Length")
+	}
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return tusError(c, fiber.StatusBadRequest, "BadRequest", "Upload-Length must be a number of bytes")
+	}
+	metadata := c.Get("Upload-Metadata")
+	values, err := parseUploadMetadata(metadata)
+	if err != nil {
+		return tusError(c, fiber.StatusBadRequest, "BadRequest", err.Error())
+	}
+	fileName := values["filename"]
+	if fileName == "" {
+		fileName = values["name"]
+	}
+	contentType := values["filetype"]
+	if contentType == "" {
+		contentType = values["type"]
+	}
+
+	upload, err := r.uploadService.CreateResumableUpload(c.Context(), length, fileName, contentType, metadata, user.ID)
+	if err != nil {
+		return uploadError(c, err)
+	}
+
+	c.Location(c.BaseURL() + "/api/v1/tus/" + upload.UUID)
+	setUploadHeaders(c, upload)
+	return c.Status(fiber.StatusCreated).JSON(response.Response{
+		Success: true,
+		Data:    toUploadDTO(upload),
+	})
+}
+
+// handleHead handles resumable upload offset requests
+// @Summary Get the offset of a resumable upload
+// @Description Get how many bytes of a tus upload were received in the Upload-Offset header, to resume it from there.
+// @Tags Uploads
+// @Security ApiKeyAuth
+// @Param Tus-Resumable header string true "tus version" default(1.0.0)
+// @Param id path string true "Upload ID"
+// @Success 200 "Offset and length in the Upload-Offset and Upload-Length headers"
+// @Failure 401 "Missing or invalid API token"
+// @Failure 404 "Upload not found"
+// @Failure 410 "Upload expired"
+// @Failure 412 "Unsupported tus version"
+// @Router /tus/{id} [head]
+func (r *TusRoutes) handleHead(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	upload, err := r.uploadService.GetResumableUpload(c.Context(), c.Params("id"), user.ID)
+	if err != nil {
+		return uploadError(c, err)
+	}
+
+	setUploadHeaders(c, upload)
+	if upload.Metadata != "" {
+		c.Set("Upload-Metadata", upload.Metadata)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// handlePatch handles resumable upload chunks
+// @Summary Send a chunk of a resumable upload
+// @Description Append the body to a tus upload at Upload-Offset, which must be the upload's offset. With an Upload-Checksum the
+// @Description chunk is only kept if it matches; without one, as much of an interrupted chunk as was received is kept.
+// @Tags Uploads
+// @Accept application/offset+octet-stream
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Tus-Resumable header string true "tus version" default(1.0.0)
+// @Param Upload-Offset header int true "Offset of the chunk in bytes"
+// @Param Upload-Checksum header string false "Algorithm and base64 digest of the chunk, e.g. sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0="
+// @Param id path string true "Upload ID"
+// @Param chunk body string true "Bytes of the file"
+// @Success 204 "Chunk received, the new offset is in the Upload-Offset header"
+// @Failure 400 {object} response.Response{error=response.APIError} "Invalid Upload-Offset or Upload-Checksum, or a chunk past the upload's length"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Upload not found"
+// @Failure 409 {object} response.Response{error=response.APIError} "Upload-Offset is not the upload's offset, or the upload is complete"
+// @Failure 410 {object} response.Response{error=response.APIError} "Upload expired"
+// @Failure 412 {object} response.Response{error=response.APIError} "Unsupported tus version"
+// @Failure 415 {object} response.Response{error=response.APIError} "Content-Type is not application/offset+octet-stream"
+// @Failure 423 {object} response.Response{error=response.APIError} "Another chunk is being written to the upload"
+// @Failure 460 {object} response.Response{error=response.APIError} "Chunk does not match Upload-Checksum"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /tus/{id} [patch]
+func (r *TusRoutes) handlePatch(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	if c.Get(fiber.HeaderContentType) != tusContentType {
+		return tusError(c, fiber.StatusUnsupportedMediaType, "UnsupportedMediaType", "Content-Type must be "+tusContentType)
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return tusError(c, fiber.StatusBadRequest, "BadRequest", "Upload-Offset must be a number of bytes")
+	}
+
+	upload, err := r.uploadService.WriteChunk(c.Context(), c.Params("id"), offset, requestBody(c), c.Get("Upload-Checksum"), user.ID)
+	if err != nil {
+		return uploadError(c, err)
+	}
+
+	setUploadHeaders(c, upload)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleDelete handles resumable upload termination
+// @Summary Terminate a resumable upload
+// @Description Discard an incomplete tus upload and the bytes it received.
+// @Tags Uploads
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Tus-Resumable header string true "tus version" default(1.0.0)
+// @Param id path string true "Upload ID"
+// @Success 204 "Upload terminated"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Upload not found"
+// @Failure 409 {object} response.Response{error=response.APIError} "Upload is complete"
+// @Failure 412 {object} response.Response{error=response.APIError} "Unsupported tus version"
+// @Failure 423 {object} response.Response{error=response.APIError} "A chunk is being written to the upload"
+// @Router /tus/{id} [delete]
+func (r *TusRoutes) handleDelete(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+
+	if err := r.uploadService.TerminateUpload(c.Context(), c.Params("id"), user.ID); err != nil {
+		return uploadError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// setUploadHeaders sets the headers describing the state of an upload
+func setUploadHeaders(c *fiber.Ctx, upload *domain.Upload) {
+	c.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(upload.SizeBytes, 10))
+	if upload.ExpiresAt != nil {
+		c.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// parseUploadMetadata parses an Upload-Metadata header, comma-separated keys
+// each followed by a space and a base64 value unless the value is empty
+func parseUploadMetadata(header string) (map[string]string, error) {
+	values := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return values, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata has an empty key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("Upload-Metadata value of " + key + " is not base64")
+		}
+		values[key] = string(value)
+	}
+	return values, nil
+}
+
+// uploadError responds with the status for an upload service error
+func uploadError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		return tusError(c, fiber.StatusNotFound, "NotFound", "Upload not found")
+	case errors.Is(err, service.ErrUploadExpired):
+		return tusError(c, fiber.StatusGone, "Gone", err.Error())
+	case errors.Is(err, service.ErrUploadOffsetMismatch), errors.Is(err, service.ErrUploadComplete):
+		return tusError(c, fiber.StatusConflict, "Conflict", err.Error())
+	case errors.Is(err, service.ErrUploadLocked):
+		return tusError(c, fiber.StatusLocked, "Locked", err.Error())
+	case errors.Is(err, service.ErrChecksumMismatch):
+		return tusError(c, statusChecksumMismatch, "ChecksumMismatch", err.Error())
+	case errors.Is(err, service.ErrUploadTooLarge), errors.Is(err, service.ErrUploadQuotaExceeded):
+		return tusError(c, fiber.StatusRequestEntityTooLarge, "PayloadTooLarge", err.Error())
+	case errors.Is(err, service.ErrUnsupportedChecksum), errors.Is(err, service.ErrInvalidRequest):
+		return tusError(c, fiber.StatusBadRequest, "BadRequest", err.Error())
+	}
+	logger.Error("resumable upload failed", "upload", c.Params("id"), "error", err)
+	return tusError(c, fiber.StatusInternalServerError, "InternalServerError", "Failed to process upload")
+}
+
+// tusError responds with an error
+func tusError(c *fiber.Ctx, status int, errorType string, message string) error {
+	return c.Status(status).JSON(response.Response{
+		Success: false,
+		Error: &response.APIError{
+			Type:    errorType,
+			Message: message,
+		},
+	})
+}
+
+// tusMiddleware sets the tus version on responses and rejects requests for
+// other versions. OPTIONS requests need no version.
+func (r *TusRoutes) tusMiddleware(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	if c.Method() != fiber.MethodOptions && c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return tusError(c, fiber.StatusPreconditionFailed, "PreconditionFailed", "Tus-Resumable must be "+tusVersion)
+	}
+	return c.Next()
+}
+
+// authMiddleware authenticates requests
+func (r *TusRoutes) authMiddleware(c *fiber.Ctx) error {
+	token := c.Get("X-API-Token")
+	if token == "" {
+		logger.Warn("missing API token")
+		return tusError(c, fiber.StatusUnauthorized, "Unauthorized", "Missing API token")
+	}
+
+	user, err := r.authService.ValidateToken(c.Context(), token)
+	if err != nil {
+		logger.Error("invalid API token", "error", err)
+		return tusError(c, fiber.StatusUnauthorized, "Unauthorized", "Invalid API token")
+	}
+
+	c.Locals("user", user)
+	return c.Next()
+}
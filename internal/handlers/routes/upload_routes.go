@@ -0,0 +1,237 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/dto"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/response"
+	"ffmpeg-api/internal/service"
+	"io"
+	"mime"
+	"mime/multipart"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadFormField is the multipart form field holding an uploaded file
+const uploadFormField = "file"
+
+// UploadRoutes handles all upload related routes
+type UploadRoutes struct {
+	uploadService service.UploadService
+	authService   service.AuthService
+}
+
+// NewUploadRoutes creates a new UploadRoutes instance
+func NewUploadRoutes(uploadService service.UploadService, authService service.AuthService) *UploadRoutes {
+	return &UploadRoutes{
+		uploadService: uploadService,
+		authService:   authService,
+	}
+}
+
+// Register registers all upload routes
+func (r *UploadRoutes) Register(router fiber.Router) {
+	uploads := router.Group("/api/v1/uploads")
+	uploads.Use(r.authMiddleware)
+	uploads.Post("/", r.handleCreateUpload)
+	uploads.Get("/:id", r.handleGetUpload)
+}
+
+// handleCreateUpload handles file uploads
+// @Summary Upload an input file
+// @Description Stream a file, sent as the file field of a multipart/form-data body, into storage under user_<id>/input/.
+// @Description The file is not buffered in memory and may be up to UPLOAD_MAX_SIZE_MB. Use the returned ref, upload://<id>,
+// @Description as an input file of a job, an ABR or storyboard input_url or a probe url; only you can use your uploads.
+// @Tags Uploads
+// @Accept multipart/form-data
+// @Produce json
+// @Security ApiKeyAuth
+// @Param file formData file true "File to upload"
+// @Success 201 {object} response.Response{data=dto.Upload} "File uploaded"
+// @Failure 400 {object} response.Response{error=response.APIError} "Not a multipart body with a file field"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 413 {object} response.Response{error=response.APIError} "File larger than UPLOAD_MAX_SIZE_MB or what is left of UPLOAD_QUOTA_MB"
+// @Failure 500 {object} response.Response{error=response.APIError} "Internal server error"
+// @Router /uploads [post]
+func (r *UploadRoutes) handleCreateUpload(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	part, err := uploadPart(c)
+	if err != nil {
+		logger.Error("invalid upload body", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "BadRequest",
+				Message: err.Error(),
+			},
+		})
+	}
+	defer part.Close()
+
+	upload, err := r.uploadService.CreateUpload(c.Context(), part, part.FileName(), part.Header.Get("Content-Type"), user.ID)
+	if errors.Is(err, service.ErrUploadTooLarge) || errors.Is(err, service.ErrUploadQuotaExceeded) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "PayloadTooLarge",
+				Message: err.Error(),
+			},
+		})
+	}
+	if err != nil {
+		logger.Error("failed to upload file", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "InternalServerError",
+				Message: "Failed to upload file",
+			},
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response.Response{
+		Success: true,
+		Data:    toUploadDTO(upload),
+	})
+}
+
+// handleGetUpload handles upload requests
+// @Summary Get an upload
+// @Description Get one of your uploaded input files.
+// @Tags Uploads
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Upload ID"
+// @Success 200 {object} response.Response{data=dto.Upload} "Upload retrieved successfully"
+// @Failure 401 {object} response.Response{error=response.APIError} "Missing or invalid API token"
+// @Failure 404 {object} response.Response{error=response.APIError} "Upload not found"
+// @Router /uploads/{id} [get]
+func (r *UploadRoutes) handleGetUpload(c *fiber.Ctx) error {
+	user := c.Locals("user").(*domain.User)
+	if user == nil {
+		logger.Error("user not found in context")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Unauthorized",
+			},
+		})
+	}
+
+	upload, err := r.uploadService.GetUpload(c.Context(), c.Params("id"), user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "NotFound",
+				Message: "Upload not found",
+			},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.Response{
+		Success: true,
+		Data:    toUploadDTO(upload),
+	})
+}
+
+// uploadPart returns the file part of a multipart upload body, read from the
+// body stream so the file is never held in memory as a whole. Parts before
+// the file are skipped.
+func uploadPart(c *fiber.Ctx) (*multipart.Part, error) {
+	mediaType, params, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	if err != nil || mediaType != fiber.MIMEMultipartForm || params["boundary"] == "" {
+		return nil, errors.New("the body must be multipart/form-data")
+	}
+
+	reader := multipart.NewReader(requestBody(c), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("the body has no " + uploadFormField + " field")
+		}
+		if err != nil {
+			return nil, errors.New("the body is not valid multipart/form-data")
+		}
+		if part.FormName() == uploadFormField && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// requestBody returns the body of a request as a stream, or as a reader of the
+// body in memory when it was small enough to be read at once
+func requestBody(c *fiber.Ctx) io.Reader {
+	if body := c.Context().RequestBodyStream(); body != nil {
+		return body
+	}
+	return bytes.NewReader(c.Body())
+}
+
+// toUploadDTO converts a domain upload to its DTO
+func toUploadDTO(upload *domain.Upload) dto.Upload {
+	var expiresAt string
+	if upload.ExpiresAt != nil {
+		expiresAt = upload.ExpiresAt.Format("2006-01-02T15:04:05Z07:00")
+	}
+	return dto.Upload{
+		ID:          upload.UUID,
+		Ref:         "upload://" + upload.UUID,
+		FileName:    upload.FileName,
+		ContentType: upload.ContentType,
+		ObjectKey:   upload.ObjectKey,
+		Status:      upload.Status,
+		SizeBytes:   upload.SizeBytes,
+		Offset:      upload.Offset,
+		SHA256:      upload.SHA256,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   upload.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+// authMiddleware authenticates requests
+func (r *UploadRoutes) authMiddleware(c *fiber.Ctx) error {
+	token := c.Get("X-API-Token")
+	if token == "" {
+		logger.Warn("missing API token")
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Missing API token",
+			},
+		})
+	}
+
+	user, err := r.authService.ValidateToken(c.Context(), token)
+	if err != nil {
+		logger.Error("invalid API token", "error", err)
+		return c.Status(fiber.StatusUnauthorized).JSON(response.Response{
+			Success: false,
+			Error: &response.APIError{
+				Type:    "Unauthorized",
+				Message: "Invalid API token",
+			},
+		})
+	}
+
+	c.Locals("user", user)
+	return c.Next()
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"ffmpeg-api/internal/domain"
+	"time"
 )
 
 // UserRepository defines the interface for user-related database operations
@@ -14,10 +15,98 @@ type UserRepository interface {
 	FindByAPIToken(ctx context.Context, token string) (*domain.User, error)
 	IncrementUsage(ctx context.Context, userID uint) error
 	IncrementBytesProcessed(ctx context.Context, userID uint, bytes int64) error
+	SetWebhookSecret(ctx context.Context, userID uint, secret string) error
 }
 
 type JobRepository interface {
 	BaseRepositoryInterface[domain.JobStatus]
 	FindByUUID(ctx context.Context, uuid string) (*domain.JobStatus, error)
 	FindByUserID(ctx context.Context, userID uint) ([]domain.JobStatus, error)
+	// FindByFileID returns the job of userID with an output of the given file ID
+	FindByFileID(ctx context.Context, fileID string, userID uint) (*domain.JobStatus, error)
+	// List returns up to filter.Limit jobs matching filter, starting after filter.After
+	List(ctx context.Context, filter domain.JobFilter) ([]domain.JobStatus, error)
+	// ClaimNextQueued atomically moves the oldest QUEUED job to PROCESSING on
+	// behalf of node and returns it. It returns nil without an error when the
+	// queue is empty.
+	ClaimNextQueued(ctx context.Context, node string) (*domain.JobStatus, error)
+	// FindProcessingByNode returns the PROCESSING jobs claimed by node, including
+	// jobs that were never claimed by any node.
+	FindProcessingByNode(ctx context.Context, node string) ([]domain.JobStatus, error)
+	// FindStale returns the PROCESSING jobs whose node last sent a heartbeat
+	// for them before t, or last updated them before t if it never did
+	FindStale(ctx context.Context, t time.Time) ([]domain.JobStatus, error)
+	// Heartbeat records that node still processes a job and reports whether
+	// the job is still PROCESSING on node
+	Heartbeat(ctx context.Context, id uint, node string) (bool, error)
+	// UpdateClaimed saves a job only while it is PROCESSING on node, so a job
+	// cancelled or taken over in the meantime isn't overwritten, and reports
+	// whether it did.
+	UpdateClaimed(ctx context.Context, job *domain.JobStatus, node string) (bool, error)
+	// CompareAndSwapStatus sets the status of a job only if it currently is
+	// from, and reports whether it did.
+	CompareAndSwapStatus(ctx context.Context, id uint, from, to string) (bool, error)
+	// QueuePosition returns the 1-based position of a QUEUED job in the queue.
+	QueuePosition(ctx context.Context, job *domain.JobStatus) (int, error)
+	// CreateBatch creates all jobs in one transaction, in the given order
+	CreateBatch(ctx context.Context, jobs []*domain.JobStatus) error
+	// CountByBatch returns the number of jobs of a user's batch by status
+	CountByBatch(ctx context.Context, batchID string, userID uint) ([]domain.JobStatusCount, error)
+}
+
+type WebhookDeliveryRepository interface {
+	BaseRepositoryInterface[domain.WebhookDelivery]
+	FindByJobUUID(ctx context.Context, jobUUID string) ([]domain.WebhookDelivery, error)
+	// FindDue returns up to limit delivery attempts scheduled for t or
+	// earlier that aren't claimed, or whose claim expired before t
+	FindDue(ctx context.Context, t time.Time, limit int) ([]domain.WebhookDelivery, error)
+	// Claim takes a scheduled delivery attempt to make it until the claim
+	// expires at until, marking it as interrupted until its outcome is
+	// stored, and reports whether no other worker holds it. An attempt whose
+	// claim expired, such as one of a node that crashed, can be taken again.
+	Claim(ctx context.Context, id uint, until time.Time, interrupted string) (bool, error)
+}
+
+type PresetRepository interface {
+	BaseRepositoryInterface[domain.Preset]
+	// FindByName returns the preset of userID with the given name, or the
+	// global preset of that name if the user has none
+	FindByName(ctx context.Context, userID uint, name string) (*domain.Preset, error)
+	// FindVisible returns the presets of userID and the global presets,
+	// ordered by name with the user's own presets first
+	FindVisible(ctx context.Context, userID uint) ([]domain.Preset, error)
+}
+
+type UploadRepository interface {
+	BaseRepositoryInterface[domain.Upload]
+	FindByUUID(ctx context.Context, uuid string) (*domain.Upload, error)
+	// FindExpired returns the incomplete uploads that expired before t
+	FindExpired(ctx context.Context, t time.Time) ([]domain.Upload, error)
+	// SumBytes returns the bytes a user's uploads take up, counting
+	// incomplete uploads at the length they were declared with
+	SumBytes(ctx context.Context, userID uint) (int64, error)
+	// CreateWithinQuota creates an upload unless the user's uploads would
+	// then take up more than quota bytes, reporting whether it did. Creations
+	// for the same user are serialized, so they can't exceed it together.
+	CreateWithinQuota(ctx context.Context, upload *domain.Upload, quota int64) (bool, error)
+	// Lock claims an incomplete upload at the given offset with token until
+	// the given time, reporting false if its offset differs or another
+	// unexpired claim holds it
+	Lock(ctx context.Context, id uint, offset int64, token string, until time.Time) (bool, error)
+	// ExtendLock extends the claim of token on an upload, reporting false if
+	// it no longer holds it
+	ExtendLock(ctx context.Context, id uint, token string, until time.Time) (bool, error)
+	// Unlock releases the claim of token on an upload
+	Unlock(ctx context.Context, id uint, token string) error
+	// UpdateLocked saves an upload only while token holds its claim,
+	// reporting whether it did
+	UpdateLocked(ctx context.Context, upload *domain.Upload, token string) (bool, error)
+}
+
+type StorageCredentialRepository interface {
+	BaseRepositoryInterface[domain.StorageCredential]
+	// FindByName returns the storage credential of userID with the given name
+	FindByName(ctx context.Context, userID uint, name string) (*domain.StorageCredential, error)
+	// FindByUser returns the storage credentials of userID ordered by name
+	FindByUser(ctx context.Context, userID uint) ([]domain.StorageCredential, error)
 }
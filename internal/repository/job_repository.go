@@ -5,6 +5,12 @@ import (
 	"ffmpeg-api/internal/database"
 	"ffmpeg-api/internal/domain"
 	"ffmpeg-api/internal/logger"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type GormJobRepository struct {
@@ -50,6 +56,21 @@ func (r *GormJobRepository) FindByUUID(ctx context.Context, uuid string) (*domai
 	return &job, nil
 }
 
+func (r *GormJobRepository) FindByFileID(ctx context.Context, fileID string, userID uint) (*domain.JobStatus, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var job domain.JobStatus
+	err = db.WithContext(ctx).
+		Where("id = (?)", db.Model(&domain.JobOutputFile{}).Select("job_id").Where("file_id = ? AND user_id = ?", fileID, userID)).
+		First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
 func (r *GormJobRepository) FindByUserID(ctx context.Context, userID uint) ([]domain.JobStatus, error) {
 	db, err := r.GetGormDB()
 	if err != nil {
@@ -62,12 +83,103 @@ func (r *GormJobRepository) FindByUserID(ctx context.Context, userID uint) ([]do
 	return jobs, nil
 }
 
+func (r *GormJobRepository) List(ctx context.Context, filter domain.JobFilter) ([]domain.JobStatus, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	query := db.WithContext(ctx).Where("user_id = ?", filter.UserID)
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at < ?", *filter.CreatedBefore)
+	}
+	if filter.UpdatedAfter != nil {
+		query = query.Where("updated_at >= ?", *filter.UpdatedAfter)
+	}
+	if filter.OutputType != "" {
+		query = query.Where("output_file_types LIKE ?", "%,"+filter.OutputType+",%")
+	}
+	if filter.BatchID != "" {
+		query = query.Where("batch_id = ?", filter.BatchID)
+	}
+
+	// Keyset pagination: continue strictly after the last (created_at, id) seen
+	if filter.After != nil {
+		query = query.Where(
+			fmt.Sprintf("(created_at %[1]s ? OR (created_at = ? AND id %[1]s ?))", comparison),
+			filter.After.CreatedAt, filter.After.CreatedAt, filter.After.ID,
+		)
+	}
+
+	var jobs []domain.JobStatus
+	if err := query.
+		Order(fmt.Sprintf("created_at %[1]s, id %[1]s", direction)).
+		Limit(filter.Limit).
+		Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
 func (r *GormJobRepository) Update(ctx context.Context, job *domain.JobStatus) error {
 	db, err := r.GetGormDB()
 	if err != nil {
 		return err
 	}
-	return db.WithContext(ctx).Save(job).Error
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(job).Error; err != nil {
+			return err
+		}
+		return indexOutputFiles(tx, job)
+	})
+}
+
+func (r *GormJobRepository) UpdateClaimed(ctx context.Context, job *domain.JobStatus, node string) (bool, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return false, err
+	}
+	var saved bool
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Select all columns so zero values are written too, like Save, but the
+		// heartbeat, which is only written by Heartbeat
+		result := tx.Model(job).
+			Where("status = ? AND worker_node = ?", domain.JobStatusProcessing, node).
+			Select("*").Omit("heartbeat_at").Updates(job)
+		if result.Error != nil || result.RowsAffected != 1 {
+			return result.Error
+		}
+		saved = true
+		return indexOutputFiles(tx, job)
+	})
+	return saved, err
+}
+
+// indexOutputFiles records the file IDs of a job's outputs, which are only
+// set once, so FindByFileID finds the job without reading the JSON of every
+// job's output files
+func indexOutputFiles(tx *gorm.DB, job *domain.JobStatus) error {
+	if len(job.OutputFiles) == 0 {
+		return nil
+	}
+	files := make([]domain.JobOutputFile, 0, len(job.OutputFiles))
+	for _, metadata := range job.OutputFiles {
+		files = append(files, domain.JobOutputFile{FileID: metadata.FileID, JobID: job.ID, UserID: job.UserID})
+	}
+	// Sorted, so concurrent saves lock the rows in the same order
+	sort.Slice(files, func(i, j int) bool { return files[i].FileID < files[j].FileID })
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&files).Error
 }
 
 func (r *GormJobRepository) Delete(ctx context.Context, id uint) error {
@@ -75,5 +187,141 @@ func (r *GormJobRepository) Delete(ctx context.Context, id uint) error {
 	if err != nil {
 		return err
 	}
-	return db.WithContext(ctx).Delete(&domain.JobStatus{}, id).Error
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_id = ?", id).Delete(&domain.JobOutputFile{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&domain.JobStatus{}, id).Error
+	})
+}
+
+func (r *GormJobRepository) ClaimNextQueued(ctx context.Context, node string) (*domain.JobStatus, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		// Find with a limit instead of First, an empty queue is not an error worth logging
+		var jobs []domain.JobStatus
+		if err := db.WithContext(ctx).Where("status = ?", domain.JobStatusQueued).Order("id ASC").Limit(1).Find(&jobs).Error; err != nil {
+			return nil, err
+		}
+		if len(jobs) == 0 {
+			return nil, nil
+		}
+		job := jobs[0]
+
+		// Only one worker wins the status swap, even across nodes
+		now := time.Now()
+		result := db.WithContext(ctx).Model(&domain.JobStatus{}).
+			Where("id = ? AND status = ?", job.ID, domain.JobStatusQueued).
+			Updates(map[string]interface{}{"status": domain.JobStatusProcessing, "worker_node": node, "heartbeat_at": now, "updated_at": now})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			job.Status = domain.JobStatusProcessing
+			job.WorkerNode = node
+			job.HeartbeatAt = &now
+			job.UpdatedAt = now
+			return &job, nil
+		}
+	}
+}
+
+func (r *GormJobRepository) FindProcessingByNode(ctx context.Context, node string) ([]domain.JobStatus, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []domain.JobStatus
+	if err := db.WithContext(ctx).
+		Where("status = ? AND worker_node IN ?", domain.JobStatusProcessing, []string{node, ""}).
+		Order("id ASC").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *GormJobRepository) FindStale(ctx context.Context, t time.Time) ([]domain.JobStatus, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []domain.JobStatus
+	if err := db.WithContext(ctx).
+		Where("status = ? AND COALESCE(heartbeat_at, updated_at) < ?", domain.JobStatusProcessing, t).
+		Order("id ASC").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *GormJobRepository) Heartbeat(ctx context.Context, id uint, node string) (bool, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return false, err
+	}
+	// UpdateColumn leaves updated_at alone, heartbeats aren't changes of the job
+	result := db.WithContext(ctx).Model(&domain.JobStatus{}).
+		Where("id = ? AND status = ? AND worker_node = ?", id, domain.JobStatusProcessing, node).
+		UpdateColumn("heartbeat_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormJobRepository) CompareAndSwapStatus(ctx context.Context, id uint, from, to string) (bool, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return false, err
+	}
+	result := db.WithContext(ctx).Model(&domain.JobStatus{}).
+		Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{"status": to, "updated_at": time.Now()})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormJobRepository) QueuePosition(ctx context.Context, job *domain.JobStatus) (int, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return 0, err
+	}
+	var ahead int64
+	if err := db.WithContext(ctx).Model(&domain.JobStatus{}).
+		Where("status = ? AND id < ?", domain.JobStatusQueued, job.ID).
+		Count(&ahead).Error; err != nil {
+		return 0, err
+	}
+	return int(ahead) + 1, nil
+}
+
+func (r *GormJobRepository) CreateBatch(ctx context.Context, jobs []*domain.JobStatus) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(jobs, 100).Error
+	})
+}
+
+func (r *GormJobRepository) CountByBatch(ctx context.Context, batchID string, userID uint) ([]domain.JobStatusCount, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var counts []domain.JobStatusCount
+	if err := db.WithContext(ctx).Model(&domain.JobStatus{}).
+		Select("status, COUNT(*) AS count, SUM(progress) AS progress_sum").
+		Where("batch_id = ? AND user_id = ?", batchID, userID).
+		Group("status").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	return counts, nil
 }
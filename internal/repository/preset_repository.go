@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"ffmpeg-api/internal/database"
+	"ffmpeg-api/internal/domain"
+)
+
+type GormPresetRepository struct {
+	BaseRepository
+}
+
+// NewGormPresetRepository creates a new GormPresetRepository
+func NewGormPresetRepository(db database.Database) PresetRepository {
+	return &GormPresetRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+func (r *GormPresetRepository) Create(ctx context.Context, preset *domain.Preset) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Create(preset).Error
+}
+
+func (r *GormPresetRepository) FindByID(ctx context.Context, id uint) (*domain.Preset, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var preset domain.Preset
+	if err := db.WithContext(ctx).First(&preset, id).Error; err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+func (r *GormPresetRepository) FindByName(ctx context.Context, userID uint, name string) (*domain.Preset, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var preset domain.Preset
+	err = db.WithContext(ctx).
+		Where("name = ? AND user_id IN ?", name, []uint{userID, 0}).
+		Order("user_id DESC").
+		First(&preset).Error
+	if err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+func (r *GormPresetRepository) FindVisible(ctx context.Context, userID uint) ([]domain.Preset, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var presets []domain.Preset
+	err = db.WithContext(ctx).
+		Where("user_id IN ?", []uint{userID, 0}).
+		Order("user_id DESC, name ASC").
+		Find(&presets).Error
+	if err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+func (r *GormPresetRepository) Update(ctx context.Context, preset *domain.Preset) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Save(preset).Error
+}
+
+func (r *GormPresetRepository) Delete(ctx context.Context, id uint) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Delete(&domain.Preset{}, id).Error
+}
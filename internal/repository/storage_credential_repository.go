@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"ffmpeg-api/internal/database"
+	"ffmpeg-api/internal/domain"
+)
+
+type GormStorageCredentialRepository struct {
+	BaseRepository
+}
+
+// NewGormStorageCredentialRepository creates a new GormStorageCredentialRepository
+func NewGormStorageCredentialRepository(db database.Database) StorageCredentialRepository {
+	return &GormStorageCredentialRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+func (r *GormStorageCredentialRepository) Create(ctx context.Context, credential *domain.StorageCredential) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Create(credential).Error
+}
+
+func (r *GormStorageCredentialRepository) FindByID(ctx context.Context, id uint) (*domain.StorageCredential, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var credential domain.StorageCredential
+	if err := db.WithContext(ctx).First(&credential, id).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func (r *GormStorageCredentialRepository) FindByName(ctx context.Context, userID uint, name string) (*domain.StorageCredential, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var credential domain.StorageCredential
+	if err := db.WithContext(ctx).Where("user_id = ? AND name = ?", userID, name).First(&credential).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func (r *GormStorageCredentialRepository) FindByUser(ctx context.Context, userID uint) ([]domain.StorageCredential, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var credentials []domain.StorageCredential
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).Order("name ASC").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (r *GormStorageCredentialRepository) Update(ctx context.Context, credential *domain.StorageCredential) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Save(credential).Error
+}
+
+func (r *GormStorageCredentialRepository) Delete(ctx context.Context, id uint) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Delete(&domain.StorageCredential{}, id).Error
+}
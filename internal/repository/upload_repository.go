@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"ffmpeg-api/internal/database"
+	"ffmpeg-api/internal/domain"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type GormUploadRepository struct {
+	BaseRepository
+}
+
+// NewGormUploadRepository creates a new GormUploadRepository
+func NewGormUploadRepository(db database.Database) UploadRepository {
+	return &GormUploadRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+func (r *GormUploadRepository) Create(ctx context.Context, upload *domain.Upload) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Create(upload).Error
+}
+
+func (r *GormUploadRepository) FindByID(ctx context.Context, id uint) (*domain.Upload, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var upload domain.Upload
+	if err := db.WithContext(ctx).First(&upload, id).Error; err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r *GormUploadRepository) FindByUUID(ctx context.Context, uuid string) (*domain.Upload, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var upload domain.Upload
+	if err := db.WithContext(ctx).Where("uuid = ?", uuid).First(&upload).Error; err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r *GormUploadRepository) FindExpired(ctx context.Context, t time.Time) ([]domain.Upload, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var uploads []domain.Upload
+	err = db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", domain.UploadStatusInProgress, t).
+		Find(&uploads).Error
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+func (r *GormUploadRepository) SumBytes(ctx context.Context, userID uint) (int64, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	err = db.WithContext(ctx).Model(&domain.Upload{}).
+		Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size_bytes), 0)").
+		Scan(&total).Error
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func (r *GormUploadRepository) CreateWithinQuota(ctx context.Context, upload *domain.Upload, quota int64) (bool, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return false, err
+	}
+	created := false
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Writing the user's row locks it until the transaction ends
+		if err := tx.Model(&domain.User{}).Where("id = ?", upload.UserID).UpdateColumn("id", gorm.Expr("id")).Error; err != nil {
+			return err
+		}
+		var used int64
+		if err := tx.Model(&domain.Upload{}).
+			Where("user_id = ?", upload.UserID).
+			Select("COALESCE(SUM(size_bytes), 0)").
+			Scan(&used).Error; err != nil {
+			return err
+		}
+		if used+upload.SizeBytes > quota {
+			return nil
+		}
+		created = true
+		return tx.Create(upload).Error
+	})
+	return created && err == nil, err
+}
+
+func (r *GormUploadRepository) Lock(ctx context.Context, id uint, offset int64, token string, until time.Time) (bool, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return false, err
+	}
+	// offset is a keyword, which a map condition quotes
+	result := db.WithContext(ctx).Model(&domain.Upload{}).
+		Where("id = ? AND status = ?", id, domain.UploadStatusInProgress).
+		Where(map[string]interface{}{"offset": offset}).
+		Where("locked_until IS NULL OR locked_until < ?", time.Now()).
+		UpdateColumns(map[string]interface{}{"lock_token": token, "locked_until": until})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormUploadRepository) ExtendLock(ctx context.Context, id uint, token string, until time.Time) (bool, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return false, err
+	}
+	result := db.WithContext(ctx).Model(&domain.Upload{}).
+		Where("id = ? AND lock_token = ?", id, token).
+		UpdateColumn("locked_until", until)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormUploadRepository) Unlock(ctx context.Context, id uint, token string) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Model(&domain.Upload{}).
+		Where("id = ? AND lock_token = ?", id, token).
+		UpdateColumns(map[string]interface{}{"lock_token": "", "locked_until": nil}).Error
+}
+
+func (r *GormUploadRepository) UpdateLocked(ctx context.Context, upload *domain.Upload, token string) (bool, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return false, err
+	}
+	// Select all columns so zero values are written too, like Save, but the
+	// claim, which is only written by Lock, ExtendLock and Unlock
+	result := db.WithContext(ctx).Model(upload).
+		Where("lock_token = ?", token).
+		Select("*").Omit("lock_token", "locked_until").Updates(upload)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormUploadRepository) Update(ctx context.Context, upload *domain.Upload) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Save(upload).Error
+}
+
+func (r *GormUploadRepository) Delete(ctx context.Context, id uint) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Delete(&domain.Upload{}, id).Error
+}
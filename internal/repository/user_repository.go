@@ -85,7 +85,7 @@ func (r *GormUserRepository) FindByUsernameWithPassword(ctx context.Context, use
 		return nil, err
 	}
 	var user domain.User
-	if err := db.WithContext(ctx).Where("username = ?", username).Select("username, email, password, api_token").First(&user).Error; err != nil {
+	if err := db.WithContext(ctx).Where("username = ?", username).Select("id, username, email, password, api_token, webhook_secret").First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -120,3 +120,12 @@ func (r *GormUserRepository) IncrementBytesProcessed(ctx context.Context, userID
 	return db.WithContext(ctx).Model(&domain.User{}).Where("id = ?", userID).
 		UpdateColumn("bytes_processed", gorm.Expr("bytes_processed + ?", bytes)).Error
 }
+
+func (r *GormUserRepository) SetWebhookSecret(ctx context.Context, userID uint, secret string) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Model(&domain.User{}).Where("id = ?", userID).
+		UpdateColumn("webhook_secret", secret).Error
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"ffmpeg-api/internal/database"
+	"ffmpeg-api/internal/domain"
+	"time"
+)
+
+type GormWebhookDeliveryRepository struct {
+	BaseRepository
+}
+
+// NewGormWebhookDeliveryRepository creates a new GormWebhookDeliveryRepository
+func NewGormWebhookDeliveryRepository(db database.Database) WebhookDeliveryRepository {
+	return &GormWebhookDeliveryRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+func (r *GormWebhookDeliveryRepository) Create(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Create(delivery).Error
+}
+
+func (r *GormWebhookDeliveryRepository) FindByID(ctx context.Context, id uint) (*domain.WebhookDelivery, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var delivery domain.WebhookDelivery
+	if err := db.WithContext(ctx).First(&delivery, id).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (r *GormWebhookDeliveryRepository) FindByJobUUID(ctx context.Context, jobUUID string) ([]domain.WebhookDelivery, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []domain.WebhookDelivery
+	if err := db.WithContext(ctx).Where("job_uuid = ?", jobUUID).Order("id ASC").Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r *GormWebhookDeliveryRepository) FindDue(ctx context.Context, t time.Time, limit int) ([]domain.WebhookDelivery, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []domain.WebhookDelivery
+	if err := db.WithContext(ctx).
+		Where("scheduled_at IS NOT NULL AND scheduled_at <= ? AND (claimed_until IS NULL OR claimed_until < ?)", t, t).
+		Order("scheduled_at ASC, id ASC").Limit(limit).
+		Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r *GormWebhookDeliveryRepository) Claim(ctx context.Context, id uint, until time.Time, interrupted string) (bool, error) {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return false, err
+	}
+	result := db.WithContext(ctx).Model(&domain.WebhookDelivery{}).
+		Where("id = ? AND scheduled_at IS NOT NULL AND (claimed_until IS NULL OR claimed_until < ?)", id, time.Now()).
+		Updates(map[string]interface{}{"claimed_until": until, "error": interrupted})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *GormWebhookDeliveryRepository) Update(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Save(delivery).Error
+}
+
+func (r *GormWebhookDeliveryRepository) Delete(ctx context.Context, id uint) error {
+	db, err := r.GetGormDB()
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Delete(&domain.WebhookDelivery{}, id).Error
+}
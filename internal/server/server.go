@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	_ "ffmpeg-api/docs"
 	"ffmpeg-api/internal/config"
 	"ffmpeg-api/internal/database"
@@ -20,9 +21,12 @@ import (
 )
 
 type Server struct {
-	app    *fiber.App
-	config *config.Config
-	db     database.Database
+	app            *fiber.App
+	config         *config.Config
+	db             database.Database
+	ffmpegService  service.FFMPEGService
+	uploadService  service.UploadService
+	webhookService service.WebhookService
 }
 
 func NewServer(cfg *config.Config) (*Server, error) {
@@ -33,34 +37,51 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	}
 
 	// Run migrations
-	if err := db.AutoMigrate(&domain.User{}, &domain.JobStatus{}); err != nil {
+	if err := db.AutoMigrate(&domain.User{}, &domain.JobStatus{}, &domain.WebhookDelivery{}, &domain.Preset{}, &domain.Upload{}, &domain.StorageCredential{}, &domain.JobOutputFile{}); err != nil {
 		return nil, fmt.Errorf("failed to run database migrations: %w", err)
 	}
 
 	// Create repositories
 	userRepo := repository.NewGormUserRepository(db)
 	jobRepo := repository.NewGormJobRepository(db)
+	webhookDeliveryRepo := repository.NewGormWebhookDeliveryRepository(db)
+	presetRepo := repository.NewGormPresetRepository(db)
+	uploadRepo := repository.NewGormUploadRepository(db)
+	storageCredentialRepo := repository.NewGormStorageCredentialRepository(db)
 
 	// Create storage service based on configuration
-	storageService, err := initStorageService(cfg)
+	logger.Info("using storage driver", "provider", cfg.Storage.Provider)
+	storageService, err := service.NewStorageService(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize storage service: %w", err)
 	}
 
 	// Create services
 	authService := service.NewAuthService(userRepo, cfg)
-	ffmpegService := service.NewFFMPEGService(jobRepo, userRepo, storageService, cfg)
+	webhookService := service.NewWebhookService(webhookDeliveryRepo, jobRepo, userRepo, cfg)
+	presetService := service.NewPresetService(presetRepo, cfg)
+	uploadService := service.NewUploadService(uploadRepo, storageService, cfg)
+	storageCredentialService := service.NewStorageCredentialService(storageCredentialRepo, cfg)
+	ffmpegService := service.NewFFMPEGService(jobRepo, userRepo, presetRepo, uploadRepo, storageService, webhookService, storageCredentialService, cfg)
+
+	// Deal with jobs interrupted by the last shutdown before workers start
+	if err := ffmpegService.RecoverJobs(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to recover jobs: %w", err)
+	}
 
 	// Create Fiber app
 	app := handlers.NewFiberApp()
 
 	// Add middlewares
 	app.Use(recover.New())
-	app.Use(cors.New())
+	// Browser tus clients need to read the upload headers
+	app.Use(cors.New(cors.Config{
+		ExposeHeaders: "Location,Tus-Resumable,Tus-Version,Tus-Extension,Tus-Max-Size,Tus-Checksum-Algorithm,Upload-Offset,Upload-Length,Upload-Metadata,Upload-Expires",
+	}))
 	app.Use(fiberLogger.New())
 
 	// Create handlers
-	handler := handlers.NewHandler(authService, ffmpegService)
+	handler := handlers.NewHandler(authService, ffmpegService, webhookService, presetService, uploadService, storageService, storageCredentialService)
 
 	// Swagger documentation
 	app.Get("/swagger/*", swagger.New(swagger.Config{
@@ -71,9 +92,12 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	handler.RegisterRoutes(app)
 
 	return &Server{
-		app:    app,
-		config: cfg,
-		db:     db,
+		app:            app,
+		config:         cfg,
+		db:             db,
+		ffmpegService:  ffmpegService,
+		uploadService:  uploadService,
+		webhookService: webhookService,
 	}, nil
 }
 
@@ -81,6 +105,15 @@ func (s *Server) Start() error {
 	// Create temp directories
 	createTempDirectories(s.config)
 
+	// Start processing queued jobs
+	s.ffmpegService.Start(context.Background())
+
+	// Start delivering scheduled webhooks
+	s.webhookService.Start(context.Background())
+
+	// Start deleting expired resumable uploads
+	s.uploadService.Start(context.Background())
+
 	// Start server
 	addr := fmt.Sprintf(":%s", s.config.Server.Port)
 	logger.Info("server starting", "address", addr)
@@ -91,20 +124,12 @@ func (s *Server) Close() error {
 	return s.db.Close()
 }
 
-func initStorageService(cfg *config.Config) (service.StorageService, error) {
-	if cfg.Storage.Provider == "minio" {
-		logger.Info("using MinIO storage service")
-		return service.NewMinioStorageService(cfg)
-	}
-	logger.Info("using local storage service")
-	return service.NewLocalStorageService(cfg), nil
-}
-
 func createTempDirectories(cfg *config.Config) {
 	dirs := []string{
 		cfg.FFMPEG.TempDirectory,
 		cfg.Storage.TempDirectory,
 		fmt.Sprintf("%s/uploads", cfg.Storage.TempDirectory),
+		fmt.Sprintf("%s/resumable", cfg.Storage.TempDirectory),
 	}
 
 	for _, dir := range dirs {
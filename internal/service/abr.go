@@ -0,0 +1,403 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// abrInputKey is the input key of the single file of an ABR job
+	abrInputKey = "input"
+	// maxRenditions is the most renditions an ABR ladder may have
+	maxRenditions = 10
+)
+
+// renditionNameRegexp matches the names of renditions, which become part of
+// the output file names
+var renditionNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// abrEncoders maps the codecs of renditions to FFmpeg encoders
+var abrEncoders = map[string]string{
+	"h264": "libx264",
+	"hevc": "libx265",
+}
+
+// SubmitABR queues an ABR job packaging the request's single input file into
+// the renditions and formats of req.ABR. Defaults are filled in for the
+// formats (HLS), segment duration (6 seconds), codecs (h264) and audio
+// bitrates (128 kbit/s).
+func (s *FFMPEGServiceImpl) SubmitABR(ctx context.Context, req domain.FFMPEGRequest, userID uint) (*domain.FFMPEGResponse, error) {
+	if req.ABR == nil || !isInputReference(req.InputFiles[abrInputKey]) {
+		return nil, fmt.Errorf("%w: an ABR job needs an http(s) input URL or upload and a ladder", ErrInvalidRequest)
+	}
+	if err := s.checkUploadRefs(ctx, req.InputFiles, userID); err != nil {
+		return nil, err
+	}
+	if err := s.webhookService.CheckURL(req.WebhookURL); err != nil {
+		return nil, fmt.Errorf("%w: webhook_url: %v", ErrInvalidRequest, err)
+	}
+	ladder := *req.ABR
+	ladder.Renditions = slices.Clone(ladder.Renditions)
+	if err := normalizeLadder(&ladder); err != nil {
+		return nil, err
+	}
+	req.ABR = &ladder
+	if err := s.checkDestinations(ctx, req.Destinations, ladder.Formats, userID); err != nil {
+		return nil, err
+	}
+
+	job := &domain.JobStatus{
+		UUID:            uuid.New().String(),
+		Type:            domain.JobTypeABR,
+		Status:          domain.JobStatusQueued,
+		UserID:          userID,
+		OriginalRequest: &req,
+		OutputFileTypes: ",video,",
+	}
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	position, err := s.jobRepo.QueuePosition(ctx, job)
+	if err != nil {
+		logger.Error("failed to get queue position", "uuid", job.UUID, "error", err)
+	}
+
+	s.queue.Notify()
+
+	return &domain.FFMPEGResponse{
+		UUID:          job.UUID,
+		Status:        domain.JobStatusQueued,
+		QueuePosition: position,
+	}, nil
+}
+
+// normalizeLadder validates an ABR ladder and fills in its defaults
+func normalizeLadder(ladder *domain.ABRLadder) error {
+	if len(ladder.Formats) == 0 {
+		ladder.Formats = []string{domain.ABRFormatHLS}
+	}
+	for i, format := range ladder.Formats {
+		if format != domain.ABRFormatHLS && format != domain.ABRFormatDASH {
+			return fmt.Errorf("%w: formats[%d]: %q must be hls or dash", ErrInvalidRequest, i, format)
+		}
+		if slices.Index(ladder.Formats, format) != i {
+			return fmt.Errorf("%w: formats[%d]: %q is given more than once", ErrInvalidRequest, i, format)
+		}
+	}
+
+	if ladder.SegmentDuration == 0 {
+		ladder.SegmentDuration = 6
+	}
+	if ladder.SegmentDuration < 1 || ladder.SegmentDuration > 60 {
+		return fmt.Errorf("%w: segment_duration must be between 1 and 60 seconds", ErrInvalidRequest)
+	}
+
+	if len(ladder.Renditions) == 0 || len(ladder.Renditions) > maxRenditions {
+		return fmt.Errorf("%w: a ladder has between 1 and %d renditions", ErrInvalidRequest, maxRenditions)
+	}
+	names := make(map[string]bool, len(ladder.Renditions))
+	for i := range ladder.Renditions {
+		r := &ladder.Renditions[i]
+		switch {
+		case !renditionNameRegexp.MatchString(r.Name):
+			return fmt.Errorf("%w: renditions[%d]: name %q may only contain letters, digits, '_' and '-'", ErrInvalidRequest, i, r.Name)
+		case names[r.Name]:
+			return fmt.Errorf("%w: renditions[%d]: name %q is used more than once", ErrInvalidRequest, i, r.Name)
+		case r.Height <= 0 || r.Height%2 != 0 || r.Width < 0 || r.Width%2 != 0:
+			return fmt.Errorf("%w: renditions[%d]: width and height must be even, and height positive", ErrInvalidRequest, i)
+		case r.VideoBitrate <= 0:
+			return fmt.Errorf("%w: renditions[%d]: video_bitrate must be positive", ErrInvalidRequest, i)
+		case r.AudioBitrate < 0:
+			return fmt.Errorf("%w: renditions[%d]: audio_bitrate must be positive", ErrInvalidRequest, i)
+		case r.MaxBitrate != 0 && r.MaxBitrate < r.VideoBitrate:
+			return fmt.Errorf("%w: renditions[%d]: max_bitrate must be at least video_bitrate", ErrInvalidRequest, i)
+		}
+		names[r.Name] = true
+
+		if r.Codec == "" {
+			r.Codec = "h264"
+		}
+		if abrEncoders[r.Codec] == "" {
+			return fmt.Errorf("%w: renditions[%d]: codec %q must be h264 or hevc", ErrInvalidRequest, i, r.Codec)
+		}
+		if r.AudioBitrate == 0 {
+			r.AudioBitrate = 128
+		}
+	}
+	return nil
+}
+
+// selectRenditions returns the renditions of a ladder that fit the source's
+// resolution, along with the results of all of them. If the source is smaller
+// than every rendition, the smallest one is encoded at the source's height.
+func selectRenditions(renditions []domain.Rendition, sourceWidth, sourceHeight int) ([]domain.Rendition, domain.RenditionResults) {
+	var selected []domain.Rendition
+	results := make(domain.RenditionResults, len(renditions))
+	for i, r := range renditions {
+		results[i] = domain.RenditionResult{Name: r.Name, Status: domain.RenditionEncoded, Codec: r.Codec}
+		if r.Height > sourceHeight || r.Width > sourceWidth {
+			results[i].Status = domain.RenditionSkipped
+			results[i].Reason = fmt.Sprintf("above the source resolution %dx%d", sourceWidth, sourceHeight)
+			continue
+		}
+		selected = append(selected, r)
+	}
+
+	if len(selected) == 0 {
+		smallest := 0
+		for i, r := range renditions {
+			if r.Height < renditions[smallest].Height {
+				smallest = i
+			}
+		}
+		r := renditions[smallest]
+		r.Width, r.Height = 0, max(sourceHeight/2*2, 2)
+		selected = append(selected, r)
+		results[smallest].Status = domain.RenditionEncoded
+		results[smallest].Reason = fmt.Sprintf("every rendition is above the source resolution %dx%d, encoded at the source's height", sourceWidth, sourceHeight)
+	}
+	return selected, results
+}
+
+// abrArgs returns the FFmpeg arguments encoding the renditions of an ABR job
+// from one input into one format, written to the format's directory. Key
+// frames are forced on segment boundaries so every rendition switches
+// cleanly. HLS variants each get their own audio, DASH shares one audio
+// representation at the highest audio bitrate. With segmentList, DASH
+// manifests list every segment instead of referring to them by templates, so
+// they can be referred to by signed URLs.
+func abrArgs(format, inputPath string, renditions []domain.Rendition, hasAudio bool, segmentDuration int, segmentList bool, tempDir string) []string {
+	labels := make([]string, len(renditions))
+	for i := range renditions {
+		labels[i] = fmt.Sprintf("[s%d]", i)
+	}
+	graph := "[0:v:0]split=" + fmt.Sprint(len(renditions)) + strings.Join(labels, "")
+	for i, r := range renditions {
+		width := r.Width
+		if width == 0 {
+			width = -2
+		}
+		graph += fmt.Sprintf(";[s%d]scale=w=%d:h=%d[v%d]", i, width, r.Height, i)
+	}
+
+	args := []string{"-i", inputPath, "-filter_complex", graph}
+	audioBitrate := 0
+	var streamMap []string
+	for i, r := range renditions {
+		maxBitrate := r.MaxBitrate
+		if maxBitrate == 0 {
+			maxBitrate = r.VideoBitrate
+		}
+		args = append(args,
+			"-map", fmt.Sprintf("[v%d]", i),
+			fmt.Sprintf("-c:v:%d", i), abrEncoders[r.Codec],
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", maxBitrate),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", 2*maxBitrate),
+		)
+		audioBitrate = max(audioBitrate, r.AudioBitrate)
+
+		entry := fmt.Sprintf("v:%d", i)
+		if hasAudio && format == domain.ABRFormatHLS {
+			args = append(args,
+				"-map", "0:a:0",
+				fmt.Sprintf("-c:a:%d", i), "aac",
+				fmt.Sprintf("-b:a:%d", i), fmt.Sprintf("%dk", r.AudioBitrate),
+			)
+			entry += fmt.Sprintf(",a:%d", i)
+		}
+		streamMap = append(streamMap, entry+",name:"+r.Name)
+	}
+	args = append(args, "-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentDuration))
+
+	dir := filepath.Join(tempDir, format)
+	switch format {
+	case domain.ABRFormatDASH:
+		adaptationSets := "id=0,streams=v"
+		if hasAudio {
+			args = append(args, "-map", "0:a:0", "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", audioBitrate))
+			adaptationSets += " id=1,streams=a"
+		}
+		useTemplate := "1"
+		if segmentList {
+			useTemplate = "0"
+		}
+		return append(args,
+			"-f", "dash",
+			"-seg_duration", fmt.Sprint(segmentDuration),
+			"-use_template", useTemplate,
+			"-use_timeline", "1",
+			"-adaptation_sets", adaptationSets,
+			"-init_seg_name", "init-$RepresentationID$.m4s",
+			"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+			absolutePath(filepath.Join(dir, "manifest.mpd")),
+		)
+	default:
+		return append(args,
+			"-f", "hls",
+			"-hls_time", fmt.Sprint(segmentDuration),
+			"-hls_playlist_type", "vod",
+			"-hls_flags", "independent_segments",
+			"-hls_segment_filename", absolutePath(filepath.Join(dir, "%v_%03d.ts")),
+			"-master_pl_name", "master.m3u8",
+			"-var_stream_map", strings.Join(streamMap, " "),
+			absolutePath(filepath.Join(dir, "%v.m3u8")),
+		)
+	}
+}
+
+// processABRJob downloads and probes the input of an ABR job, encodes the
+// renditions that fit the source into each format, and uploads the formats'
+// directories as the job's outputs, keyed by format
+func (s *FFMPEGServiceImpl) processABRJob(ctx, jobCtx context.Context, job *domain.JobStatus) {
+	startTime := time.Now()
+	req := *job.OriginalRequest
+	ladder := *req.ABR
+
+	tempDir := filepath.Join(s.config.FFMPEG.TempDirectory, job.UUID)
+	for _, format := range ladder.Formats {
+		if err := os.MkdirAll(filepath.Join(tempDir, format), 0755); err != nil {
+			s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to create temp directory: %v", err))
+			return
+		}
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Download and probe the input (25% of progress)
+	input, err := s.downloadVideoInput(jobCtx, req.InputFiles[abrInputKey], job.UserID)
+	if input != nil {
+		defer s.storageService.DeleteFile(ctx, input.Path)
+	}
+	if err != nil {
+		s.failJob(ctx, jobCtx, job, err.Error())
+		return
+	}
+	job.InputProbes = domain.MediaProbesMap{abrInputKey: *input.Probe}
+
+	// Renditions are compared with the source as it is displayed
+	renditions, results := selectRenditions(ladder.Renditions, input.Width, input.Height)
+	job.Renditions = results
+	job.ExpectedDuration = probeDuration(input.Probe)
+	job.Progress = 25
+	s.saveProgress(ctx, job)
+
+	// Encode every format (25-75% of progress)
+	ffmpegStartTime := time.Now()
+	for i, format := range ladder.Formats {
+		args := abrArgs(format, absolutePath(input.Path), renditions, input.HasAudio, ladder.SegmentDuration, s.config.Storage.Private, tempDir)
+		from, to := 25+50*i/len(ladder.Formats), 25+50*(i+1)/len(ladder.Formats)
+		if _, err := s.runFFmpeg(ctx, jobCtx, job, tempDir, args, nil, from, to); err != nil {
+			s.failJob(ctx, jobCtx, job, fmt.Sprintf("%s: %v", format, err))
+			return
+		}
+	}
+	job.FFmpegCommandRunSeconds = time.Since(ffmpegStartTime).Seconds()
+
+	// Describe the renditions while the playlists still refer to local files
+	s.probeRenditions(jobCtx, job, tempDir, ladder.Formats)
+
+	// Upload the formats' directories (75-99% of progress)
+	job.Progress = 75
+	s.saveProgress(ctx, job)
+
+	outputFiles := make(map[string]string, len(ladder.Formats))
+	for _, format := range ladder.Formats {
+		outputFiles[format] = format + "/"
+	}
+	totalOutputSize, err := s.uploadOutputs(ctx, jobCtx, job, tempDir, outputFiles)
+	if err != nil {
+		s.failJob(ctx, jobCtx, job, err.Error())
+		return
+	}
+	if hls, ok := job.OutputFiles[domain.ABRFormatHLS]; ok {
+		for i := range job.Renditions {
+			for _, file := range hls.Files {
+				if file.Path == "hls/"+job.Renditions[i].Name+".m3u8" {
+					job.Renditions[i].PlaylistURL = file.StorageURL
+				}
+			}
+		}
+	}
+
+	job.Status = domain.JobStatusSuccess
+	job.Progress = 100
+	job.TotalProcessingSeconds = time.Since(startTime).Seconds()
+	job.Result = "Successfully packaged renditions"
+	if !s.saveJob(ctx, job) {
+		return
+	}
+	s.notifyStatus(ctx, job)
+
+	go s.userRepo.IncrementUsage(ctx, job.UserID)
+	go s.userRepo.IncrementBytesProcessed(ctx, job.UserID, input.Size+totalOutputSize)
+}
+
+// probeRenditions fills in the resolution, bit rate and duration of the
+// encoded renditions of an ABR job, from their HLS media playlists or else
+// from the video representations of the DASH manifest, which are in ladder
+// order
+func (s *FFMPEGServiceImpl) probeRenditions(ctx context.Context, job *domain.JobStatus, tempDir string, formats []string) {
+	var manifestVideo []domain.MediaStream
+	var manifest *domain.MediaProbe
+	if !slices.Contains(formats, domain.ABRFormatHLS) {
+		probe, err := probeMedia(ctx, s.config.FFMPEG.ProbePath, filepath.Join(tempDir, domain.ABRFormatDASH, "manifest.mpd"), localInputProtocols)
+		if err != nil {
+			logger.Warn("failed to probe DASH manifest", "uuid", job.UUID, "error", err)
+			return
+		}
+		manifest = probe
+		for _, stream := range probe.Streams {
+			if stream.CodecType == "video" {
+				manifestVideo = append(manifestVideo, stream)
+			}
+		}
+	}
+
+	encoded := 0
+	for i := range job.Renditions {
+		result := &job.Renditions[i]
+		if result.Status != domain.RenditionEncoded {
+			continue
+		}
+		encoded++
+
+		probe := manifest
+		var video *domain.MediaStream
+		if manifest == nil {
+			var err error
+			probe, err = probeMedia(ctx, s.config.FFMPEG.ProbePath, filepath.Join(tempDir, domain.ABRFormatHLS, result.Name+".m3u8"), localInputProtocols)
+			if err != nil {
+				logger.Warn("failed to probe rendition", "uuid", job.UUID, "rendition", result.Name, "error", err)
+				continue
+			}
+			for j, stream := range probe.Streams {
+				if stream.CodecType == "video" {
+					video = &probe.Streams[j]
+					break
+				}
+			}
+		} else if encoded <= len(manifestVideo) {
+			video = &manifestVideo[encoded-1]
+		}
+
+		result.Duration = probeDuration(probe)
+		result.BitRate = probe.Format.BitRate
+		if video != nil {
+			result.Width, result.Height = video.Width, video.Height
+			if video.BitRate > 0 {
+				result.BitRate = video.BitRate
+			}
+		}
+	}
+}
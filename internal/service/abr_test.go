@@ -0,0 +1,115 @@
+package service
+
+import (
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLadder(t *testing.T) {
+	ladder := domain.ABRLadder{
+		Renditions: []domain.Rendition{
+			{Name: "360p", Height: 360, VideoBitrate: 800},
+			{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 3000, AudioBitrate: 96, Codec: "hevc"},
+		},
+	}
+	if err := normalizeLadder(&ladder); err != nil {
+		t.Fatalf("normalizeLadder failed: %v", err)
+	}
+
+	if !slices.Equal(ladder.Formats, []string{domain.ABRFormatHLS}) || ladder.SegmentDuration != 6 {
+		t.Errorf("formats, segment_duration = %v, %d, want the defaults [hls], 6", ladder.Formats, ladder.SegmentDuration)
+	}
+	want := []domain.Rendition{
+		{Name: "360p", Height: 360, VideoBitrate: 800, AudioBitrate: 128, Codec: "h264"},
+		{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 3000, AudioBitrate: 96, Codec: "hevc"},
+	}
+	if !slices.Equal(ladder.Renditions, want) {
+		t.Errorf("renditions = %+v, want %+v", ladder.Renditions, want)
+	}
+}
+
+func TestNormalizeLadderInvalid(t *testing.T) {
+	rendition := domain.Rendition{Name: "720p", Height: 720, VideoBitrate: 3000}
+	with := func(change func(r *domain.Rendition)) []domain.Rendition {
+		r := rendition
+		change(&r)
+		return []domain.Rendition{r}
+	}
+
+	tests := []struct {
+		name   string
+		ladder domain.ABRLadder
+		want   string
+	}{
+		{"unknown format", domain.ABRLadder{Formats: []string{"smooth"}, Renditions: []domain.Rendition{rendition}}, `"smooth" must be hls or dash`},
+		{"duplicate format", domain.ABRLadder{Formats: []string{"hls", "hls"}, Renditions: []domain.Rendition{rendition}}, "more than once"},
+		{"segment duration", domain.ABRLadder{SegmentDuration: 61, Renditions: []domain.Rendition{rendition}}, "segment_duration"},
+		{"no renditions", domain.ABRLadder{}, "between 1 and 10 renditions"},
+		{"too many renditions", domain.ABRLadder{Renditions: make([]domain.Rendition, 11)}, "between 1 and 10 renditions"},
+		{"invalid name", domain.ABRLadder{Renditions: with(func(r *domain.Rendition) { r.Name = "../720p" })}, "may only contain"},
+		{"duplicate name", domain.ABRLadder{Renditions: []domain.Rendition{rendition, rendition}}, "used more than once"},
+		{"odd height", domain.ABRLadder{Renditions: with(func(r *domain.Rendition) { r.Height = 721 })}, "must be even"},
+		{"odd width", domain.ABRLadder{Renditions: with(func(r *domain.Rendition) { r.Width = 1281 })}, "must be even"},
+		{"no video bitrate", domain.ABRLadder{Renditions: with(func(r *domain.Rendition) { r.VideoBitrate = 0 })}, "video_bitrate must be positive"},
+		{"negative audio bitrate", domain.ABRLadder{Renditions: with(func(r *domain.Rendition) { r.AudioBitrate = -1 })}, "audio_bitrate must be positive"},
+		{"max bitrate below video bitrate", domain.ABRLadder{Renditions: with(func(r *domain.Rendition) { r.MaxBitrate = 2000 })}, "max_bitrate"},
+		{"unknown codec", domain.ABRLadder{Renditions: with(func(r *domain.Rendition) { r.Codec = "av1" })}, `codec "av1"`},
+	}
+	for _, tt := range tests {
+		err := normalizeLadder(&tt.ladder)
+		if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: normalizeLadder() = %v, want ErrInvalidRequest containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSelectRenditions(t *testing.T) {
+	ladder := []domain.Rendition{
+		{Name: "1080p", Height: 1080},
+		{Name: "360p", Height: 360},
+		{Name: "720p", Width: 1280, Height: 720},
+	}
+	tests := []struct {
+		name          string
+		width, height int
+		want          []domain.Rendition
+		statuses      []string
+	}{
+		{
+			name: "every rendition fits", width: 1920, height: 1080,
+			want:     ladder,
+			statuses: []string{domain.RenditionEncoded, domain.RenditionEncoded, domain.RenditionEncoded},
+		},
+		{
+			name: "renditions above the source are skipped", width: 1280, height: 720,
+			want:     ladder[1:],
+			statuses: []string{domain.RenditionSkipped, domain.RenditionEncoded, domain.RenditionEncoded},
+		},
+		{
+			name: "width above the source", width: 1000, height: 720,
+			want:     ladder[1:2],
+			statuses: []string{domain.RenditionSkipped, domain.RenditionEncoded, domain.RenditionSkipped},
+		},
+		{
+			name: "smallest rendition at the source height", width: 426, height: 241,
+			want:     []domain.Rendition{{Name: "360p", Height: 240}},
+			statuses: []string{domain.RenditionSkipped, domain.RenditionEncoded, domain.RenditionSkipped},
+		},
+	}
+	for _, tt := range tests {
+		selected, results := selectRenditions(ladder, tt.width, tt.height)
+		if !slices.Equal(selected, tt.want) {
+			t.Errorf("%s: selected = %+v, want %+v", tt.name, selected, tt.want)
+		}
+		var statuses []string
+		for _, result := range results {
+			statuses = append(statuses, result.Status)
+		}
+		if !slices.Equal(statuses, tt.statuses) {
+			t.Errorf("%s: statuses = %v, want %v", tt.name, statuses, tt.statuses)
+		}
+	}
+}
@@ -29,11 +29,16 @@ func NewAuthService(userRepo repository.UserRepository, config *config.Config) A
 // Register registers a new user
 func (s *AuthServiceImpl) Register(ctx context.Context, req domain.RegisterRequest) (*domain.AuthResponse, error) {
 	// Generate API token
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
+	apiToken, err := generateSecret()
+	if err != nil {
 		return nil, fmt.Errorf("failed to generate API token: %w", err)
 	}
-	apiToken := base64.URLEncoding.EncodeToString(tokenBytes)
+
+	// Generate the key used to sign webhooks
+	webhookSecret, err := generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
 
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
@@ -43,10 +48,11 @@ func (s *AuthServiceImpl) Register(ctx context.Context, req domain.RegisterReque
 
 	// Create user
 	user := &domain.User{
-		Username: req.Username,
-		Email:    req.Email,
-		APIToken: apiToken,
-		Password: string(hashedPassword),
+		Username:      req.Username,
+		Email:         req.Email,
+		APIToken:      apiToken,
+		WebhookSecret: webhookSecret,
+		Password:      string(hashedPassword),
 	}
 
 	if err := s.userRepo.Create(ctx, user); err != nil {
@@ -54,7 +60,8 @@ func (s *AuthServiceImpl) Register(ctx context.Context, req domain.RegisterReque
 	}
 
 	return &domain.AuthResponse{
-		APIToken: apiToken,
+		APIToken:      apiToken,
+		WebhookSecret: webhookSecret,
 	}, nil
 }
 
@@ -71,8 +78,21 @@ func (s *AuthServiceImpl) Login(ctx context.Context, req domain.LoginRequest) (*
 		return nil, fmt.Errorf("invalid username or password")
 	}
 
+	// Users registered before webhooks existed get their secret on first login
+	if user.WebhookSecret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		if err := s.userRepo.SetWebhookSecret(ctx, user.ID, secret); err != nil {
+			return nil, fmt.Errorf("failed to save webhook secret: %w", err)
+		}
+		user.WebhookSecret = secret
+	}
+
 	return &domain.AuthResponse{
-		APIToken: user.APIToken,
+		APIToken:      user.APIToken,
+		WebhookSecret: user.WebhookSecret,
 	}, nil
 }
 
@@ -84,3 +104,12 @@ func (s *AuthServiceImpl) ValidateToken(ctx context.Context, token string) (*dom
 	}
 	return user, nil
 }
+
+// generateSecret returns a random URL-safe token
+func generateSecret() (string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(secretBytes), nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// BatchError lists every invalid request of a batch
+type BatchError struct {
+	Errors []string
+}
+
+func (e *BatchError) Error() string {
+	return "invalid batch: " + strings.Join(e.Errors, "; ")
+}
+
+// SubmitBatch validates every request of a batch up front and creates all of
+// its jobs in one transaction. If any request is invalid, no job is created
+// and a *BatchError lists the problems of each invalid request.
+func (s *FFMPEGServiceImpl) SubmitBatch(ctx context.Context, batch domain.BatchRequest, userID uint) (*domain.BatchResult, error) {
+	reqs := batch.Requests
+	if batch.Template != nil {
+		var err error
+		if reqs, err = expandBatchTemplate(*batch.Template, batch.InputSets); err != nil {
+			return nil, err
+		}
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("%w: a batch needs at least one request", ErrInvalidRequest)
+	}
+	if len(reqs) > s.config.FFMPEG.BatchMaxJobs {
+		return nil, fmt.Errorf("%w: a batch may have at most %d requests", ErrInvalidRequest, s.config.FFMPEG.BatchMaxJobs)
+	}
+
+	batchID := uuid.New().String()
+	jobs := make([]*domain.JobStatus, 0, len(reqs))
+	var problems []string
+	for i, req := range reqs {
+		job, err := s.newJob(ctx, req, userID)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("requests[%d]: %v", i, err))
+			continue
+		}
+		job.BatchID = batchID
+		jobs = append(jobs, job)
+	}
+	if len(problems) > 0 {
+		return nil, &BatchError{Errors: problems}
+	}
+
+	if err := s.jobRepo.CreateBatch(ctx, jobs); err != nil {
+		return nil, fmt.Errorf("failed to create jobs: %w", err)
+	}
+
+	// Jobs are queued in the order they were created, so only the position of
+	// the first one has to be looked up
+	position, err := s.jobRepo.QueuePosition(ctx, jobs[0])
+	if err != nil {
+		logger.Error("failed to get queue position", "batch_id", batchID, "error", err)
+	}
+
+	s.queue.Notify()
+
+	result := &domain.BatchResult{BatchID: batchID, Jobs: make([]domain.FFMPEGResponse, len(jobs))}
+	for i, job := range jobs {
+		result.Jobs[i] = domain.FFMPEGResponse{UUID: job.UUID, Status: job.Status}
+		if position > 0 {
+			result.Jobs[i].QueuePosition = position + i
+		}
+	}
+	return result, nil
+}
+
+// GetBatchStatus returns the aggregate state of the jobs of a batch. Finished
+// jobs count as complete towards the batch's progress.
+func (s *FFMPEGServiceImpl) GetBatchStatus(ctx context.Context, batchID string, userID uint) (*domain.BatchStatus, error) {
+	counts, err := s.jobRepo.CountByBatch(ctx, batchID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count batch jobs: %w", err)
+	}
+	if len(counts) == 0 {
+		return nil, ErrBatchNotFound
+	}
+
+	status := &domain.BatchStatus{BatchID: batchID, Counts: make(map[string]int, len(counts))}
+	progress, finished := 0, 0
+	for _, count := range counts {
+		status.Total += count.Count
+		status.Counts[count.Status] = count.Count
+		if domain.IsTerminalJobStatus(count.Status) {
+			finished += count.Count
+			progress += 100 * count.Count
+		} else {
+			progress += count.ProgressSum
+		}
+	}
+	status.Progress = progress / status.Total
+
+	succeeded := status.Counts[domain.JobStatusSuccess]
+	switch {
+	case status.Counts[domain.JobStatusQueued] == status.Total:
+		status.Status = domain.BatchStatusQueued
+	case finished < status.Total:
+		status.Status = domain.BatchStatusProcessing
+	case succeeded == status.Total:
+		status.Status = domain.BatchStatusSuccess
+	case succeeded > 0:
+		status.Status = domain.BatchStatusPartialSuccess
+	default:
+		status.Status = domain.BatchStatusFailed
+	}
+	return status, nil
+}
+
+// expandBatchTemplate returns a request for every input set: a copy of the
+// template with the input files, and optionally the output files, of the set
+// replaced by key. Sets may only use keys the template has, and every input
+// the template leaves empty must be set.
+func expandBatchTemplate(template domain.FFMPEGRequest, sets []domain.BatchInputSet) ([]domain.FFMPEGRequest, error) {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy template: %w", err)
+	}
+
+	reqs := make([]domain.FFMPEGRequest, 0, len(sets))
+	var problems []string
+	for i, set := range sets {
+		var req domain.FFMPEGRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, fmt.Errorf("failed to copy template: %w", err)
+		}
+		if err := applyInputSet(&req, set); err != nil {
+			problems = append(problems, fmt.Sprintf("input_sets[%d]: %v", i, err))
+			continue
+		}
+		reqs = append(reqs, req)
+	}
+	if len(problems) > 0 {
+		return nil, &BatchError{Errors: problems}
+	}
+	return reqs, nil
+}
+
+// applyInputSet replaces the files of a request with those of an input set
+func applyInputSet(req *domain.FFMPEGRequest, set domain.BatchInputSet) error {
+	for _, key := range sortedKeys(set.InputFiles) {
+		url := set.InputFiles[key]
+		if len(req.Inputs) > 0 {
+			i := structuredFileIndex(key, "in", len(req.Inputs), func(i int) string { return req.Inputs[i].Key })
+			if i < 0 {
+				return fmt.Errorf("the template has no input %q", key)
+			}
+			req.Inputs[i].URL = url
+			continue
+		}
+		if _, ok := req.InputFiles[key]; !ok {
+			return fmt.Errorf("the template has no input %q", key)
+		}
+		req.InputFiles[key] = url
+	}
+
+	if len(set.OutputFiles) > 0 && len(req.Steps) > 0 {
+		return fmt.Errorf("output files can't be set for a template with steps")
+	}
+	for _, key := range sortedKeys(set.OutputFiles) {
+		filename := set.OutputFiles[key]
+		if len(req.Outputs) > 0 {
+			i := structuredFileIndex(key, "out", len(req.Outputs), func(i int) string { return req.Outputs[i].Key })
+			if i < 0 {
+				return fmt.Errorf("the template has no output %q", key)
+			}
+			req.Outputs[i].Filename = filename
+			continue
+		}
+		if _, ok := req.OutputFiles[key]; !ok {
+			return fmt.Errorf("the template has no output %q", key)
+		}
+		req.OutputFiles[key] = filename
+	}
+
+	for _, key := range sortedKeys(req.InputFiles) {
+		if req.InputFiles[key] == "" {
+			return fmt.Errorf("input %q is not set", key)
+		}
+	}
+	for i, input := range req.Inputs {
+		if input.URL == "" {
+			return fmt.Errorf("inputs[%d] is not set", i)
+		}
+	}
+	return nil
+}
+
+// structuredFileIndex returns the index of the structured input or output
+// with the given key, where keys default to prefix1, prefix2, ... by position,
+// or -1 if there is none
+func structuredFileIndex(key, prefix string, n int, keyAt func(int) string) int {
+	for i := 0; i < n; i++ {
+		k := keyAt(i)
+		if k == "" {
+			k = fmt.Sprintf("%s%d", prefix, i+1)
+		}
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
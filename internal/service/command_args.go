@@ -0,0 +1,149 @@
+package service
+
+import (
+	"ffmpeg-api/internal/domain"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// fileKeyRegexp matches the keys of structured inputs and outputs
+var fileKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// normalizeRequest validates the files of a request. A structured request
+// gets default keys for its inputs and outputs, and the InputFiles and
+// OutputFiles maps the rest of the job processing works with. The OutputFiles
+// of a multi-step request are the step outputs it uploads.
+func normalizeRequest(req *domain.FFMPEGRequest) error {
+	if len(req.Steps) > 0 {
+		if err := normalizePipeline(req); err != nil {
+			return err
+		}
+	}
+
+	if len(req.Inputs) > 0 {
+		req.InputFiles = make(map[string]string, len(req.Inputs))
+		for i := range req.Inputs {
+			input := &req.Inputs[i]
+			if input.Key == "" {
+				input.Key = fmt.Sprintf("in%d", i+1)
+			}
+			if err := checkFileKey(input.Key, req.InputFiles); err != nil {
+				return fmt.Errorf("%w: inputs[%d]: %v", ErrInvalidRequest, i, err)
+			}
+			req.InputFiles[input.Key] = input.URL
+		}
+
+		req.OutputFiles = make(map[string]string, len(req.Outputs))
+		for i := range req.Outputs {
+			output := &req.Outputs[i]
+			if output.Key == "" {
+				output.Key = fmt.Sprintf("out%d", i+1)
+			}
+			if err := checkFileKey(output.Key, req.OutputFiles); err != nil {
+				return fmt.Errorf("%w: outputs[%d]: %v", ErrInvalidRequest, i, err)
+			}
+			req.OutputFiles[output.Key] = output.Filename
+		}
+	}
+
+	if len(req.InputFiles) == 0 {
+		return fmt.Errorf("%w: at least one input file is required", ErrInvalidRequest)
+	}
+	if len(req.OutputFiles) == 0 {
+		return fmt.Errorf("%w: at least one output file is required", ErrInvalidRequest)
+	}
+	return nil
+}
+
+// checkFileKey checks a structured input or output key, which must be usable
+// as a placeholder and unique
+func checkFileKey(key string, seen map[string]string) error {
+	if !fileKeyRegexp.MatchString(key) {
+		return fmt.Errorf("key %q may only contain letters, digits, '_', '.' and '-'", key)
+	}
+	if _, ok := seen[key]; ok {
+		return fmt.Errorf("key %q is used more than once", key)
+	}
+	return nil
+}
+
+// commandTemplate returns the arguments of a request's FFmpeg command with the
+// file placeholders still in place. A structured request is assembled as the
+// global options, then every input's options followed by -i and its
+// placeholder, then every output's options followed by its placeholder.
+func commandTemplate(req domain.FFMPEGRequest) []string {
+	if len(req.Inputs) == 0 {
+		return splitCommand(req.FFmpegCommand)
+	}
+
+	args := slices.Clone(req.GlobalOptions)
+	for _, input := range req.Inputs {
+		args = append(args, input.Options...)
+		args = append(args, "-i", "{{"+input.Key+"}}")
+	}
+	for _, output := range req.Outputs {
+		args = append(args, output.Options...)
+		args = append(args, "{{"+output.Key+"}}")
+	}
+	return args
+}
+
+// splitCommand splits a command string into arguments the way a POSIX shell
+// does, without expansions:
+//   - unquoted whitespace separates arguments
+//   - single quotes keep everything up to the next single quote literally
+//   - double quotes keep everything up to the next unescaped double quote,
+//     where a backslash only escapes '"', '\', '$' and '`'
+//   - outside quotes, a backslash escapes the next character
+//
+// Quoted and unquoted parts next to each other form one argument, so
+// 'a'"b"c is "abc", and "" is an empty argument.
+func splitCommand(command string) []string {
+	var args []string
+	var current strings.Builder
+	inArg := false
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			inArg = true
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				// Unterminated quote, take the rest literally
+				current.WriteString(command[i+1:])
+				i = len(command)
+				break
+			}
+			current.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			inArg = true
+			for i++; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("\"\\$`", command[i+1]) >= 0 {
+					i++
+				}
+				current.WriteByte(command[i])
+			}
+		case c == '\\' && i+1 < len(command):
+			inArg = true
+			i++
+			current.WriteByte(command[i])
+		default:
+			inArg = true
+			current.WriteByte(c)
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeRequest(t *testing.T) {
+	req := domain.FFMPEGRequest{
+		Inputs: []domain.FFMPEGInput{
+			{URL: "https://example.com/a.mp4"},
+			{Key: "logo", URL: "https://example.com/logo.png"},
+		},
+		Outputs: []domain.FFMPEGOutput{
+			{Filename: "out.mp4"},
+			{Key: "thumb", Filename: "thumb.jpg"},
+		},
+	}
+	if err := normalizeRequest(&req); err != nil {
+		t.Fatalf("normalizeRequest failed: %v", err)
+	}
+
+	if req.Inputs[0].Key != "in1" || req.Outputs[0].Key != "out1" {
+		t.Errorf("default keys = %q, %q, want in1, out1", req.Inputs[0].Key, req.Outputs[0].Key)
+	}
+	wantInputs := map[string]string{"in1": "https://example.com/a.mp4", "logo": "https://example.com/logo.png"}
+	if !maps.Equal(req.InputFiles, wantInputs) {
+		t.Errorf("InputFiles = %v, want %v", req.InputFiles, wantInputs)
+	}
+	wantOutputs := map[string]string{"out1": "out.mp4", "thumb": "thumb.jpg"}
+	if !maps.Equal(req.OutputFiles, wantOutputs) {
+		t.Errorf("OutputFiles = %v, want %v", req.OutputFiles, wantOutputs)
+	}
+}
+
+func TestNormalizeRequestInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		req  domain.FFMPEGRequest
+	}{
+		{"no inputs", domain.FFMPEGRequest{
+			FFmpegCommand: "-i {{in1}} {{out1}}",
+			OutputFiles:   map[string]string{"out1": "out.mp4"},
+		}},
+		{"no outputs", domain.FFMPEGRequest{
+			FFmpegCommand: "-i {{in1}} {{out1}}",
+			InputFiles:    map[string]string{"in1": "https://example.com/a.mp4"},
+		}},
+		{"structured without outputs", domain.FFMPEGRequest{
+			Inputs: []domain.FFMPEGInput{{URL: "https://example.com/a.mp4"}},
+		}},
+		{"invalid input key", domain.FFMPEGRequest{
+			Inputs:  []domain.FFMPEGInput{{Key: "in}}", URL: "https://example.com/a.mp4"}},
+			Outputs: []domain.FFMPEGOutput{{Filename: "out.mp4"}},
+		}},
+		{"invalid output key", domain.FFMPEGRequest{
+			Inputs:  []domain.FFMPEGInput{{URL: "https://example.com/a.mp4"}},
+			Outputs: []domain.FFMPEGOutput{{Key: "out 1", Filename: "out.mp4"}},
+		}},
+		{"duplicate input key", domain.FFMPEGRequest{
+			Inputs: []domain.FFMPEGInput{
+				{Key: "in2", URL: "https://example.com/a.mp4"},
+				{URL: "https://example.com/b.mp4"},
+			},
+			Outputs: []domain.FFMPEGOutput{{Filename: "out.mp4"}},
+		}},
+		{"duplicate output key", domain.FFMPEGRequest{
+			Inputs: []domain.FFMPEGInput{{URL: "https://example.com/a.mp4"}},
+			Outputs: []domain.FFMPEGOutput{
+				{Key: "video", Filename: "a.mp4"},
+				{Key: "video", Filename: "b.mp4"},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		if err := normalizeRequest(&tt.req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: normalizeRequest() = %v, want ErrInvalidRequest", tt.name, err)
+		}
+	}
+}
+
+func TestCommandTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		req  domain.FFMPEGRequest
+		want []string
+	}{
+		{
+			name: "command string",
+			req:  domain.FFMPEGRequest{FFmpegCommand: `-i {{in1}} -vf 'scale=640:-2' {{out1}}`},
+			want: []string{"-i", "{{in1}}", "-vf", "scale=640:-2", "{{out1}}"},
+		},
+		{
+			name: "structured",
+			req: domain.FFMPEGRequest{
+				GlobalOptions: []string{"-y", "-loglevel", "error"},
+				Inputs: []domain.FFMPEGInput{
+					{Key: "in1", Options: []string{"-ss", "5"}},
+					{Key: "logo"},
+				},
+				Outputs: []domain.FFMPEGOutput{
+					{Key: "out1", Options: []string{"-filter_complex", "[0:v][1:v] overlay=10:10", "-c:v", "libx264"}},
+					{Key: "thumb", Options: []string{"-frames:v", "1"}},
+				},
+			},
+			want: []string{
+				"-y", "-loglevel", "error",
+				"-ss", "5", "-i", "{{in1}}",
+				"-i", "{{logo}}",
+				"-filter_complex", "[0:v][1:v] overlay=10:10", "-c:v", "libx264", "{{out1}}",
+				"-frames:v", "1", "{{thumb}}",
+			},
+		},
+		{
+			name: "structured without options",
+			req: domain.FFMPEGRequest{
+				Inputs:  []domain.FFMPEGInput{{Key: "in1"}},
+				Outputs: []domain.FFMPEGOutput{{Key: "out1"}},
+			},
+			want: []string{"-i", "{{in1}}", "{{out1}}"},
+		},
+	}
+	for _, tt := range tests {
+		if got := commandTemplate(tt.req); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: commandTemplate() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommandTemplateKeepsGlobalOptions(t *testing.T) {
+	req := domain.FFMPEGRequest{
+		GlobalOptions: make([]string, 1, 4),
+		Inputs:        []domain.FFMPEGInput{{Key: "in1"}},
+		Outputs:       []domain.FFMPEGOutput{{Key: "out1"}},
+	}
+	req.GlobalOptions[0] = "-y"
+	commandTemplate(req)
+	if spare := req.GlobalOptions[:cap(req.GlobalOptions)]; spare[1] != "" {
+		t.Errorf("commandTemplate wrote into the request's GlobalOptions: %q", spare)
+	}
+}
@@ -0,0 +1,455 @@
+package service
+
+import (
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/domain"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderRegexp matches a file placeholder such as {{in1}}
+var placeholderRegexp = regexp.MustCompile(`\{\{([^{}]+)\}\}`)
+
+// fileProtocols are the FFmpeg protocols rejected as a prefix of option and
+// filter values, so they can't be used to open other files or connections
+var fileProtocols = []string{
+	"async", "cache", "concat", "concatf", "crypto", "data", "fd", "file", "ftp",
+	"gopher", "hls", "http", "https", "pipe", "rtmp", "rtp", "rtsp", "sftp",
+	"srt", "subfile", "tcp", "tee", "tls", "udp", "unix",
+}
+
+// unquote removes the quotes and backslash escapes FFmpeg strips from filter
+// graphs, so checks see names and values the way FFmpeg reads them. Escaped
+// quotes and backslashes are dropped too, which only makes checks stricter.
+var unquote = strings.NewReplacer(`\`, "", "'", "", `"`, "").Replace
+
+// CommandPolicyError lists every way a command violates the command policy
+type CommandPolicyError struct {
+	Violations []string
+}
+
+func (e *CommandPolicyError) Error() string {
+	return "ffmpeg_command is not allowed: " + strings.Join(e.Violations, "; ")
+}
+
+// CommandPolicy decides which user supplied FFmpeg commands may run. Inputs
+// and outputs must be file placeholders, options and filters are checked
+// against configurable allow and deny lists, and FFmpeg is restricted to the
+// whitelisted protocols when opening inputs.
+type CommandPolicy struct {
+	allowedOptions      map[string]bool
+	deniedOptions       map[string]bool
+	deniedFilters       map[string]bool
+	deniedFilterOptions map[string]bool
+	deniedFormats       map[string]bool
+	protocolWhitelist   string
+}
+
+// NewCommandPolicy creates a new CommandPolicy
+func NewCommandPolicy(config config.CommandPolicyConfig) *CommandPolicy {
+	return &CommandPolicy{
+		allowedOptions:      toSet(config.AllowedOptions),
+		deniedOptions:       toSet(config.DeniedOptions),
+		deniedFilters:       toSet(config.DeniedFilters),
+		deniedFilterOptions: toSet(config.DeniedFilterOptions),
+		deniedFormats:       toSet(config.DeniedFormats),
+		protocolWhitelist:   config.ProtocolWhitelist,
+	}
+}
+
+// Check validates the command of a request, or every step command of a
+// multi-step request, and returns a *CommandPolicyError describing every
+// violation, or nil if the commands may run.
+func (p *CommandPolicy) Check(req domain.FFMPEGRequest) error {
+	var violations []string
+	if len(req.Steps) > 0 {
+		for _, step := range req.Steps {
+			for _, violation := range p.checkCommand(stepRequest(req, step)) {
+				violations = append(violations, fmt.Sprintf("step %s: %s", step.ID, violation))
+			}
+		}
+	} else {
+		violations = p.checkCommand(req)
+	}
+
+	if len(violations) > 0 {
+		return &CommandPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// checkCommand returns the violations of the command of a single step request
+func (p *CommandPolicy) checkCommand(req domain.FFMPEGRequest) []string {
+	var violations []string
+
+	// Output files are written into the job's directory, so they must be paths inside it
+	for _, key := range sortedKeys(req.OutputFiles) {
+		name := req.OutputFiles[key]
+		if reason := checkOutputName(name); reason != "" {
+			violations = append(violations, fmt.Sprintf("output_files.%s: %q %s", key, name, reason))
+		}
+	}
+
+	args := parseCommandArgs(commandTemplate(req))
+	if len(args) == 0 {
+		violations = append(violations, "the command is empty")
+	}
+
+	usedOutputs := make(map[string]bool)
+	for _, arg := range args {
+		switch arg.kind {
+		case commandArgInput:
+			if key, ok := placeholderKey(arg.value); !ok || req.InputFiles[key] == "" {
+				violations = append(violations, fmt.Sprintf("input %q must be an input file placeholder such as {{in1}}", arg.value))
+			}
+		case commandArgOutput:
+			key, ok := placeholderKey(arg.value)
+			if !ok || req.OutputFiles[key] == "" {
+				violations = append(violations, fmt.Sprintf("output %q must be an output file placeholder such as {{out1}}", arg.value))
+				continue
+			}
+			if isCollectionOutput(req.OutputFiles[key]) {
+				violations = append(violations, fmt.Sprintf("output {{%s}} designates several files, which FFmpeg writes through options such as -hls_segment_filename", key))
+				continue
+			}
+			usedOutputs[key] = true
+		case commandArgOption:
+			violations = append(violations, p.checkOption(arg)...)
+		}
+	}
+
+	for _, key := range sortedKeys(req.OutputFiles) {
+		if !usedOutputs[key] && !isCollectionOutput(req.OutputFiles[key]) {
+			violations = append(violations, fmt.Sprintf("output file {{%s}} is not used as an output", key))
+		}
+	}
+	return violations
+}
+
+// Restrict returns the arguments with the protocol whitelist set on every
+// input, or the whitelist protocols has for an input the server streams to
+// FFMPEG rather than downloads. args must have passed Check.
+func (p *CommandPolicy) Restrict(args []string, protocols map[string]string) []string {
+	if p.protocolWhitelist == "" {
+		return args
+	}
+
+	restricted := make([]string, 0, len(args))
+	for _, arg := range parseCommandArgs(args) {
+		if arg.kind == commandArgInput {
+			whitelist, ok := protocols[arg.value]
+			if !ok {
+				whitelist = p.protocolWhitelist
+			}
+			restricted = append(restricted, "-protocol_whitelist", whitelist)
+		}
+		restricted = append(restricted, arg.argv()...)
+	}
+	return restricted
+}
+
+// checkOption returns the violations of a single option
+func (p *CommandPolicy) checkOption(arg commandArg) []string {
+	name := optionName(arg.option)
+	if strings.Contains(arg.option, "=") {
+		return []string{fmt.Sprintf("option %s: options take their value as the next argument, not after '='", arg.option)}
+	}
+	if strings.HasPrefix(arg.option, "-/") {
+		// "-/option file" makes FFmpeg read the value from a file
+		return []string{fmt.Sprintf("option %s may not load its value from a file", arg.option)}
+	}
+	if len(p.allowedOptions) > 0 && !p.allowedOptions[name] {
+		return []string{fmt.Sprintf("option %s is not in the list of allowed options", arg.option)}
+	}
+	if p.deniedOptions[name] {
+		return []string{fmt.Sprintf("option %s is not allowed", arg.option)}
+	}
+	if !arg.hasValue {
+		if !flagOptions[name] {
+			return []string{fmt.Sprintf("option %s is missing its value", arg.option)}
+		}
+		return nil
+	}
+
+	if placeholderRegexp.MatchString(arg.value) {
+		return []string{fmt.Sprintf("option %s: file placeholders can only be used as an input after -i or as an output file", arg.option)}
+	}
+	if name == "-f" && p.deniedFormats[arg.value] {
+		return []string{fmt.Sprintf("format %q is not allowed", arg.value)}
+	}
+	if filterOptions[name] {
+		return p.checkFilterGraph(arg.option, arg.value)
+	}
+	for _, value := range parameterValues(arg.value) {
+		if reason := unsafeValue(value); reason != "" {
+			return []string{fmt.Sprintf("option %s: %s", arg.option, reason)}
+		}
+	}
+	return nil
+}
+
+// parameterValues returns an option value along with every part of it that
+// follows a ':', ',' or '=', so the values of key=value lists such as the one
+// of -x264-params, which may name files too, are checked as well
+func parameterValues(value string) []string {
+	values := []string{value}
+	for i, r := range value {
+		if r == ':' || r == ',' || r == '=' {
+			values = append(values, value[i+1:])
+		}
+	}
+	return values
+}
+
+// checkFilterGraph returns the violations of a filter graph given to option
+func (p *CommandPolicy) checkFilterGraph(option, graph string) []string {
+	var violations []string
+	for _, filter := range parseFilterGraph(graph) {
+		if p.deniedFilters[filter.name] {
+			violations = append(violations, fmt.Sprintf("option %s: filter %q is not allowed", option, filter.name))
+			continue
+		}
+		for _, opt := range filter.options {
+			if opt.key != "" && p.deniedFilterOptions[opt.key] {
+				violations = append(violations, fmt.Sprintf("option %s: option %q of filter %q is not allowed", option, opt.key, filter.name))
+				continue
+			}
+			if reason := unsafeValue(opt.value); reason != "" {
+				violations = append(violations, fmt.Sprintf("option %s: filter %q: %s", option, filter.name, reason))
+			}
+		}
+	}
+	return violations
+}
+
+// unsafeValue returns why a value may refer to a file outside of the job's
+// directory, or "" if it doesn't
+func unsafeValue(value string) string {
+	value = unquote(value)
+	lower := strings.ToLower(value)
+
+	if strings.HasPrefix(value, "/") || strings.HasPrefix(value, "~") {
+		return fmt.Sprintf("absolute path %q is not allowed", value)
+	}
+	if value == ".." || strings.HasPrefix(value, "../") || strings.Contains(value, "/../") || strings.HasSuffix(value, "/..") {
+		return fmt.Sprintf("path %q may not leave the job directory", value)
+	}
+	for _, protocol := range fileProtocols {
+		if strings.HasPrefix(lower, protocol+":") {
+			return fmt.Sprintf("protocol %q is not allowed", protocol)
+		}
+	}
+	return ""
+}
+
+// commandArgKind tells what an argument of an FFmpeg command is
+type commandArgKind int
+
+const (
+	commandArgOption commandArgKind = iota
+	commandArgInput
+	commandArgOutput
+)
+
+// commandArg is an option with its value, an input or an output of an FFmpeg
+// command
+type commandArg struct {
+	kind     commandArgKind
+	option   string // the option as written, e.g. "-c:v"
+	value    string // the option value, input or output file
+	hasValue bool
+}
+
+// argv returns the command line arguments of the argument
+func (a commandArg) argv() []string {
+	switch {
+	case a.kind == commandArgInput:
+		return []string{"-i", a.value}
+	case a.kind == commandArgOutput:
+		return []string{a.value}
+	case a.hasValue:
+		return []string{a.option, a.value}
+	default:
+		return []string{a.option}
+	}
+}
+
+// parseCommandArgs groups the arguments of an FFmpeg command into options with
+// their values, inputs and outputs. Options are assumed to take a value unless
+// they are known flags.
+func parseCommandArgs(args []string) []commandArg {
+	parsed := make([]commandArg, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-i":
+			input := commandArg{kind: commandArgInput}
+			if i+1 < len(args) {
+				input.value = args[i+1]
+				i++
+			}
+			parsed = append(parsed, input)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			option := commandArg{kind: commandArgOption, option: arg}
+			if !flagOptions[optionName(arg)] && i+1 < len(args) {
+				option.value = args[i+1]
+				option.hasValue = true
+				i++
+			}
+			parsed = append(parsed, option)
+		default:
+			parsed = append(parsed, commandArg{kind: commandArgOutput, value: arg})
+		}
+	}
+	return parsed
+}
+
+// substitutePlaceholders replaces the input and output placeholders of a
+// command with the paths of their files
+func substitutePlaceholders(args []string, inputPaths, outputPaths map[string]string) []string {
+	substituted := make([]string, 0, len(args))
+	for _, arg := range parseCommandArgs(args) {
+		if key, ok := placeholderKey(arg.value); ok {
+			switch arg.kind {
+			case commandArgInput:
+				arg.value = inputPaths[key]
+			case commandArgOutput:
+				arg.value = outputPaths[key]
+			}
+		}
+		substituted = append(substituted, arg.argv()...)
+	}
+	return substituted
+}
+
+// optionName returns an option without its stream specifier, e.g. "-c" for
+// "-c:v:0"
+func optionName(option string) string {
+	name, _, _ := strings.Cut(option, ":")
+	return name
+}
+
+// placeholderKey returns the key of an argument that is exactly a placeholder
+func placeholderKey(arg string) (string, bool) {
+	match := placeholderRegexp.FindStringSubmatch(arg)
+	if match == nil || match[0] != arg {
+		return "", false
+	}
+	return match[1], true
+}
+
+// filterSpec is a filter of a filter graph with its options
+type filterSpec struct {
+	name    string
+	options []filterOption
+}
+
+// filterOption is an option of a filter. key is empty for positional options.
+type filterOption struct {
+	key   string
+	value string
+}
+
+// parseFilterGraph splits a filter graph such as
+// "[0:v]scale=w=640:h=-2,fps=30[v];[1:a]atempo=2" into its filters. Quoted
+// and escaped separators are not split on.
+func parseFilterGraph(graph string) []filterSpec {
+	var filters []filterSpec
+	for _, part := range splitUnquoted(graph, ",;") {
+		part = strings.TrimSpace(part)
+		// Drop the input pads, e.g. "[0:v]"
+		for strings.HasPrefix(part, "[") {
+			end := strings.Index(part, "]")
+			if end < 0 {
+				break
+			}
+			part = strings.TrimSpace(part[end+1:])
+		}
+		// Drop the output pads
+		if end := indexUnquoted(part, '['); end >= 0 {
+			part = part[:end]
+		}
+		if part == "" {
+			continue
+		}
+
+		name, args, _ := strings.Cut(part, "=")
+		name, _, _ = strings.Cut(unquote(strings.TrimSpace(name)), "@")
+		filter := filterSpec{name: name}
+		if args != "" {
+			for _, opt := range splitUnquoted(args, ":") {
+				if key, value, ok := strings.Cut(opt, "="); ok {
+					filter.options = append(filter.options, filterOption{key: strings.TrimSpace(key), value: value})
+				} else {
+					filter.options = append(filter.options, filterOption{value: opt})
+				}
+			}
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// splitUnquoted splits s on any of the separators that are neither quoted
+// with ' nor escaped with a backslash
+func splitUnquoted(s, separators string) []string {
+	var parts []string
+	var current strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			current.WriteByte(c)
+			current.WriteByte(s[i+1])
+			i++
+		case c == '\'':
+			quoted = !quoted
+			current.WriteByte(c)
+		case !quoted && strings.IndexByte(separators, c) >= 0:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	return append(parts, current.String())
+}
+
+// indexUnquoted returns the index of the first unquoted, unescaped c in s, or
+// -1 if there is none
+func indexUnquoted(s string, c byte) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '\'':
+			quoted = !quoted
+		case !quoted && s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// toSet returns the items of a list as a set
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// sortedKeys returns the keys of a map in order, for stable error messages
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
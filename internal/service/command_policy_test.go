@@ -0,0 +1,232 @@
+package service
+
+import (
+	"errors"
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/domain"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testPolicyConfig is the default command policy configuration
+var testPolicyConfig = config.CommandPolicyConfig{
+	DeniedOptions: []string{
+		"-filter_script", "-filter_complex_script", "-dump_attachment", "-attach", "-vstats", "-vstats_file",
+		"-passlogfile", "-report", "-progress", "-protocol_whitelist", "-protocol_blacklist", "-safe", "-sdp_file",
+		"-fpre", "-pre", "-vpre", "-apre", "-spre", "-stats_enc_pre", "-stats_enc_post", "-stats_mux_pre",
+		"-hls_key_info_file", "-key_info_file",
+	},
+	DeniedFilters: []string{
+		"movie", "amovie", "sendcmd", "asendcmd", "zmq", "azmq", "subtitles", "ass", "ladspa", "lv2", "frei0r", "frei0r_src",
+	},
+	DeniedFilterOptions: []string{"textfile", "fontfile", "file", "filename"},
+	DeniedFormats:       []string{"lavfi"},
+	ProtocolWhitelist:   "file",
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"-i {{in1}} {{out1}}", []string{"-i", "{{in1}}", "{{out1}}"}},
+		{"  -y\t-i  {{in1}}\n{{out1}} ", []string{"-y", "-i", "{{in1}}", "{{out1}}"}},
+		{`-vf 'scale=640:-2, fps=30' {{out1}}`, []string{"-vf", "scale=640:-2, fps=30", "{{out1}}"}},
+		{`-metadata "title=A \"quoted\" \$title" {{out1}}`, []string{"-metadata", `title=A "quoted" $title`, "{{out1}}"}},
+		{`-metadata "a\b" {{out1}}`, []string{"-metadata", `a\b`, "{{out1}}"}},
+		{`-metadata title=a\ b {{out1}}`, []string{"-metadata", "title=a b", "{{out1}}"}},
+		{`'a'"b"c`, []string{"abc"}},
+		{`-metadata "" {{out1}}`, []string{"-metadata", "", "{{out1}}"}},
+		{`-vf 'unterminated`, []string{"-vf", "unterminated"}},
+		{`'it'\''s'`, []string{"it's"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := splitCommand(tt.command); !slices.Equal(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestParseCommandArgs(t *testing.T) {
+	args := []string{"-y", "-ss", "5", "-i", "{{in1}}", "-c:v", "libx264", "-an", "{{out1}}", "-i"}
+	want := []commandArg{
+		{kind: commandArgOption, option: "-y"},
+		{kind: commandArgOption, option: "-ss", value: "5", hasValue: true},
+		{kind: commandArgInput, value: "{{in1}}"},
+		{kind: commandArgOption, option: "-c:v", value: "libx264", hasValue: true},
+		{kind: commandArgOption, option: "-an"},
+		{kind: commandArgOutput, value: "{{out1}}"},
+		{kind: commandArgInput},
+	}
+	if got := parseCommandArgs(args); !slices.Equal(got, want) {
+		t.Errorf("parseCommandArgs(%q) = %+v, want %+v", args, got, want)
+	}
+}
+
+func TestCommandPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		outputs map[string]string // defaults to out1: out.mp4
+		want    string            // a violation the check must report, "" if the command is allowed
+	}{
+		{"plain transcode", "-i {{in1}} -c:v libx264 -crf 23 {{out1}}", nil, ""},
+		{"quoted filter graph", `-i {{in1}} -vf "scale=w=640:h=-2,fps=30" {{out1}}`, nil, ""},
+		{"stream specifiers", "-i {{in1}} -map 0:v:0 -c:a:0 aac -metadata:s:a:0 language=eng {{out1}}", nil, ""},
+		{"flags without values", "-y -hide_banner -i {{in1}} -an -sn {{out1}}", nil, ""},
+		{"filter with instance name", `-i {{in1}} -filter_complex "[0:v]scale@s=640:-2[v]" -map "[v]" {{out1}}`, nil, ""},
+		{"empty command", "", nil, "the command is empty"},
+
+		{"input is a path", "-i /etc/passwd {{out1}}", nil, `input "/etc/passwd" must be an input file placeholder`},
+		{"input of another job", "-i {{in2}} {{out1}}", nil, `input "{{in2}}" must be an input file placeholder`},
+		{"input is a URL", "-i http://169.254.169.254/ {{out1}}", nil, "must be an input file placeholder"},
+		{"output is a path", "-i {{in1}} /tmp/out.mp4", nil, `output "/tmp/out.mp4" must be an output file placeholder`},
+		{"unused output", "-i {{in1}} -f null -", map[string]string{"out1": "out.mp4"}, "output file {{out1}} is not used as an output"},
+		{"output name leaves the directory", "-i {{in1}} {{out1}}", map[string]string{"out1": "../out.mp4"}, "may not leave the job directory"},
+		{"absolute output name", "-i {{in1}} {{out1}}", map[string]string{"out1": "/tmp/out.mp4"}, "must be a relative path"},
+		{"placeholder in an option value", "-i {{in1}} -vf {{in1}} {{out1}}", nil, "file placeholders can only be used"},
+
+		{"-progress", "-progress progress.txt -i {{in1}} {{out1}}", nil, "option -progress is not allowed"},
+		{"-progress with a stream specifier", "-progress:0 progress.txt -i {{in1}} {{out1}}", nil, "option -progress:0 is not allowed"},
+		{"-protocol_whitelist", "-protocol_whitelist file,http -i {{in1}} {{out1}}", nil, "option -protocol_whitelist is not allowed"},
+		{"-filter_complex_script", "-filter_complex_script graph.txt -i {{in1}} {{out1}}", nil, "option -filter_complex_script is not allowed"},
+		{"option value from a file", "-i {{in1}} -/vf graph.txt {{out1}}", nil, "may not load its value from a file"},
+		{"-opt=value", "-progress=/tmp/progress -i {{in1}} {{out1}}", nil, "option -progress=/tmp/progress"},
+		{"-opt=value of a filter option", "-i {{in1}} -vf=movie=/etc/passwd {{out1}}", nil, "option -vf=movie=/etc/passwd"},
+		{"option without a value", "-i {{in1}} {{out1}} -c:v", nil, "option -c:v is missing its value"},
+
+		{"-f lavfi", "-f lavfi -i {{in1}} {{out1}}", nil, `format "lavfi" is not allowed`},
+		{"-f:v lavfi", "-f:v lavfi -i {{in1}} {{out1}}", nil, `format "lavfi" is not allowed`},
+		{"-f mp4", "-i {{in1}} -f mp4 {{out1}}", nil, ""},
+
+		{"movie source", `-i {{in1}} -vf "movie=/etc/passwd[m];[in][m]overlay" {{out1}}`, nil, `filter "movie" is not allowed`},
+		{"amovie source", `-i {{in1}} -filter_complex "amovie=secret.wav[a]" {{out1}}`, nil, `filter "amovie" is not allowed`},
+		{"movie after input pads", `-i {{in1}} -lavfi "[0:v]null[v];movie=x.mp4[m]" {{out1}}`, nil, `filter "movie" is not allowed`},
+		{"movie with an instance name", `-i {{in1}} -vf "movie@m=x.mp4" {{out1}}`, nil, `filter "movie" is not allowed`},
+		{"escaped movie", `-i {{in1}} -vf "mov\\ie=x.mp4" {{out1}}`, nil, `filter "movie" is not allowed`},
+		{"quoted movie", `-i {{in1}} -vf "'mo'vie=x.mp4" {{out1}}`, nil, `filter "movie" is not allowed`},
+		{"movie in -filter:v", `-i {{in1}} -filter:v "movie=x.mp4" {{out1}}`, nil, `filter "movie" is not allowed`},
+		{"movie in -af", `-i {{in1}} -af "amovie=x.wav" {{out1}}`, nil, `filter "amovie" is not allowed`},
+		{"denied filter option", `-i {{in1}} -vf "drawtext=textfile=/etc/passwd" {{out1}}`, nil, `option "textfile" of filter "drawtext" is not allowed`},
+		{"absolute path in a filter", `-i {{in1}} -vf "lut3d=/etc/lut.cube" {{out1}}`, nil, `absolute path "/etc/lut.cube" is not allowed`},
+		{"quoted parent directory in a filter", `-i {{in1}} -vf "lut3d='.'./lut.cube" {{out1}}`, nil, "may not leave the job directory"},
+		{"separator inside quotes", `-i {{in1}} -vf "drawtext=text='a,movie=b'" {{out1}}`, nil, ""},
+
+		{"absolute path in an option", "-i {{in1}} -attach_x /etc/passwd {{out1}}", nil, `absolute path "/etc/passwd" is not allowed`},
+		{"parent directory in an option", "-i {{in1}} -hls_segment_filename ../seg_%03d.ts {{out1}}", nil, "may not leave the job directory"},
+		{"protocol in an option", "-i {{in1}} -hls_base_url concat:a|b {{out1}}", nil, `protocol "concat" is not allowed`},
+		{"path in an encoder parameter", "-i {{in1}} -x264-params stats=/etc/cron.d/x:pass=1 {{out1}}", nil, `absolute path "/etc/cron.d/x`},
+		{"parent directory in an encoder parameter", "-i {{in1}} -x265-params pass=1:stats=../x.log {{out1}}", nil, "may not leave the job directory"},
+		{"path in -x264opts", "-i {{in1}} -x264opts pass=1,stats=/tmp/x.log {{out1}}", nil, `absolute path "/tmp/x.log" is not allowed`},
+		{"protocol in an encoder parameter", "-i {{in1}} -x264-params stats=file:x.log {{out1}}", nil, `protocol "file" is not allowed`},
+		{"encoder parameters", "-i {{in1}} -x264-params keyint=60:min-keyint=60:scenecut=0 {{out1}}", nil, ""},
+		{"uppercase protocol", "-i {{in1}} -metadata FILE:x {{out1}}", nil, `protocol "file" is not allowed`},
+	}
+
+	policy := NewCommandPolicy(testPolicyConfig)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs := tt.outputs
+			if outputs == nil {
+				outputs = map[string]string{"out1": "out.mp4"}
+			}
+			err := policy.Check(domain.FFMPEGRequest{
+				InputFiles:    map[string]string{"in1": "https://example.com/in.mp4"},
+				OutputFiles:   outputs,
+				FFmpegCommand: tt.command,
+			})
+
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Check(%q) = %v, want no violations", tt.command, err)
+				}
+				return
+			}
+			var policyErr *CommandPolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Check(%q) = %v, want a *CommandPolicyError", tt.command, err)
+			}
+			if !slices.ContainsFunc(policyErr.Violations, func(v string) bool { return strings.Contains(v, tt.want) }) {
+				t.Errorf("Check(%q) violations = %q, want one containing %q", tt.command, policyErr.Violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandPolicyCheckAllowedOptions(t *testing.T) {
+	cfg := testPolicyConfig
+	cfg.AllowedOptions = []string{"-c", "-crf"}
+	policy := NewCommandPolicy(cfg)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"-i {{in1}} -c:v libx264 -crf 23 {{out1}}", ""},
+		{"-i {{in1}} -vf scale=640:-2 {{out1}}", "option -vf is not in the list of allowed options"},
+	}
+	for _, tt := range tests {
+		err := policy.Check(domain.FFMPEGRequest{
+			InputFiles:    map[string]string{"in1": "https://example.com/in.mp4"},
+			OutputFiles:   map[string]string{"out1": "out.mp4"},
+			FFmpegCommand: tt.command,
+		})
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("Check(%q) = %v, want no violations", tt.command, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("Check(%q) = %v, want a violation containing %q", tt.command, err, tt.want)
+		}
+	}
+}
+
+func TestCommandPolicyCheckSteps(t *testing.T) {
+	policy := NewCommandPolicy(testPolicyConfig)
+	err := policy.Check(domain.FFMPEGRequest{
+		InputFiles: map[string]string{"in1": "https://example.com/in.mp4"},
+		Steps: []domain.PipelineStep{
+			{ID: "scale", OutputFiles: map[string]string{"out": "scaled.mp4"}, FFmpegCommand: "-i {{in1}} -vf scale=640:-2 {{out}}"},
+			{ID: "thumb", OutputFiles: map[string]string{"out": "thumb.jpg"}, FFmpegCommand: "-i {{scale.out}} -f lavfi {{out}}"},
+		},
+	})
+	var policyErr *CommandPolicyError
+	if !errors.As(err, &policyErr) || !slices.Equal(policyErr.Violations, []string{`step thumb: format "lavfi" is not allowed`}) {
+		t.Errorf("Check() = %v, want only the lavfi format of step thumb rejected", err)
+	}
+}
+
+func TestCommandPolicyRestrict(t *testing.T) {
+	policy := NewCommandPolicy(testPolicyConfig)
+	tests := []struct {
+		name      string
+		args      []string
+		protocols map[string]string
+		want      []string
+	}{
+		{
+			name: "configured whitelist on every input",
+			args: []string{"-y", "-i", "/tmp/job/in1.mp4", "-i", "/tmp/job/in2.wav", "-c", "copy", "/tmp/job/out.mp4"},
+			want: []string{
+				"-y", "-protocol_whitelist", "file", "-i", "/tmp/job/in1.mp4",
+				"-protocol_whitelist", "file", "-i", "/tmp/job/in2.wav", "-c", "copy", "/tmp/job/out.mp4",
+			},
+		},
+		{
+			name:      "per-input override",
+			args:      []string{"-i", "https://bucket.example.com/in1.mp4?X-Amz-Signature=x", "-i", "pipe:4", "-i", "/tmp/job/in3.mp4", "/tmp/job/out.mp4"},
+			protocols: map[string]string{"https://bucket.example.com/in1.mp4?X-Amz-Signature=x": urlInputProtocols, "pipe:4": pipeInputProtocols},
+			want: []string{
+				"-protocol_whitelist", "http,https,tcp,tls", "-i", "https://bucket.example.com/in1.mp4?X-Amz-Signature=x",
+				"-protocol_whitelist", "pipe", "-i", "pipe:4",
+				"-protocol_whitelist", "file", "-i", "/tmp/job/in3.mp4", "/tmp/job/out.mp4",
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := policy.Restrict(tt.args, tt.protocols); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Restrict() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
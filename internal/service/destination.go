@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Destination is a bucket job outputs are delivered to instead of the user's
+// storage, as resolved by StorageCredentialService.ResolveDestination
+type Destination struct {
+	Bucket string
+	Prefix string // ends with '/' unless empty
+
+	client  *minio.Client // nil to access the bucket with the storage's own credentials
+	baseURL string        // URL the keys of the bucket's objects are appended to
+}
+
+// upload uploads the file at localPath to the destination as objectKey under
+// its prefix using client and returns the object's URL
+func (d *Destination) upload(ctx context.Context, client *minio.Client, baseURL string, localPath string, objectKey string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	key := d.Prefix + objectKey
+	logger.Debug("uploading to destination", "bucket", d.Bucket, "object", key, "size", info.Size())
+	_, err = client.PutObject(ctx, d.Bucket, key, file, info.Size(), minio.PutObjectOptions{
+		ContentType:        ContentType(localPath),
+		ContentDisposition: ContentDisposition(filepath.Base(localPath)),
+	})
+	if err != nil {
+		logger.Error("failed to upload file to destination", "bucket", d.Bucket, "error", err)
+		return "", fmt.Errorf("failed to upload file to bucket %s: %w", d.Bucket, err)
+	}
+
+	logger.Info("file uploaded successfully", "bucket", d.Bucket, "object", key)
+	return fmt.Sprintf("%s/%s", baseURL, key), nil
+}
+
+// uploadWithCredentials uploads to a destination accessed with a storage
+// credential, for storages that can't reach buckets with their own
+func uploadWithCredentials(ctx context.Context, localPath string, objectKey string, dest *Destination) (string, error) {
+	if dest.client == nil {
+		return "", fmt.Errorf("the configured storage can't deliver to bucket %s without storage credentials", dest.Bucket)
+	}
+	return dest.upload(ctx, dest.client, dest.baseURL, localPath, objectKey)
+}
+
+func (s *S3StorageService) UploadFileTo(ctx context.Context, localPath string, objectKey string, dest *Destination) (string, error) {
+	if dest.client == nil {
+		return dest.upload(ctx, s.client, s3BucketURL(s.client, dest.Bucket, s.lookup), localPath, objectKey)
+	}
+	return dest.upload(ctx, dest.client, dest.baseURL, localPath, objectKey)
+}
+
+func (s *LocalStorageService) UploadFileTo(ctx context.Context, localPath string, objectKey string, dest *Destination) (string, error) {
+	return uploadWithCredentials(ctx, localPath, objectKey, dest)
+}
+
+func (s *MemoryStorageService) UploadFileTo(ctx context.Context, localPath string, objectKey string, dest *Destination) (string, error) {
+	return uploadWithCredentials(ctx, localPath, objectKey, dest)
+}
@@ -0,0 +1,64 @@
+package service
+
+import "errors"
+
+var (
+	// ErrJobNotFound is returned when a job does not exist or belongs to another user
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished is returned when a job has already reached a terminal status
+	ErrJobFinished = errors.New("job has already finished")
+	// ErrInvalidRequest is returned when a processing request is malformed
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrDeliveryNotFound is returned when a webhook delivery does not exist for a job
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	// ErrInvalidProbeURL is returned when a probe URL is neither an http(s) URL
+	// nor a storage object of the requesting user
+	ErrInvalidProbeURL = errors.New("url must be an http(s) URL or one of your storage object keys")
+	// ErrFileNotFound is returned when an output file does not exist or belongs to another user
+	ErrFileNotFound = errors.New("file not found")
+	// ErrInvalidSignature is returned when a signed URL has expired or its signature doesn't match
+	ErrInvalidSignature = errors.New("URL has expired or is not signed")
+	// ErrFileNotAccessible is returned when a file to probe cannot be reached
+	ErrFileNotAccessible = errors.New("file is not accessible")
+	// ErrNoStreamURL is returned when the storage has no URL FFMPEG could read
+	// an object from
+	ErrNoStreamURL = errors.New("the configured storage has no URLs to stream objects from")
+	// ErrBatchNotFound is returned when a batch has no jobs of the requesting user
+	ErrBatchNotFound = errors.New("batch not found")
+	// ErrPresetNotFound is returned when a preset does not exist or is not visible to the user
+	ErrPresetNotFound = errors.New("preset not found")
+	// ErrPresetExists is returned when the user already has a preset of the same name
+	ErrPresetExists = errors.New("a preset with this name already exists")
+	// ErrPresetForbidden is returned when a user changes a preset they may only use
+	ErrPresetForbidden = errors.New("only admins can manage global presets")
+	// ErrInvalidPreset is returned when a preset definition is malformed
+	ErrInvalidPreset = errors.New("invalid preset")
+	// ErrUploadNotFound is returned when an upload does not exist or belongs to another user
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadTooLarge is returned when an uploaded file exceeds the configured maximum size
+	ErrUploadTooLarge = errors.New("uploaded file is too large")
+	// ErrUploadQuotaExceeded is returned when an upload would exceed the user's upload quota
+	ErrUploadQuotaExceeded = errors.New("upload quota exceeded")
+	// ErrUploadExpired is returned when an incomplete resumable upload has expired
+	ErrUploadExpired = errors.New("upload has expired")
+	// ErrUploadComplete is returned when an upload that is already complete is written or terminated
+	ErrUploadComplete = errors.New("upload is already complete")
+	// ErrUploadOffsetMismatch is returned when a chunk does not continue an upload where it left off
+	ErrUploadOffsetMismatch = errors.New("offset does not match the upload's offset")
+	// ErrUploadLocked is returned when a chunk is written to an upload that is being written to
+	ErrUploadLocked = errors.New("upload is being written to by another request")
+	// ErrChecksumMismatch is returned when a chunk does not match the checksum sent with it
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrCredentialNotFound is returned when a storage credential does not exist or belongs to another user
+	ErrCredentialNotFound = errors.New("storage credential not found")
+	// ErrCredentialExists is returned when the user already has a storage credential of the same name
+	ErrCredentialExists = errors.New("a storage credential with this name already exists")
+	// ErrInvalidCredential is returned when a storage credential is malformed
+	ErrInvalidCredential = errors.New("invalid storage credential")
+	// ErrCredentialsDisabled is returned when storage credentials are used without an encryption key configured
+	ErrCredentialsDisabled = errors.New("storage credentials are disabled, STORAGE_CREDENTIALS_KEY is not set")
+	// ErrUnsupportedChecksum is returned when a chunk's checksum uses an unsupported algorithm
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+)
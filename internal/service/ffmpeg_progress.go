@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bufio"
+	"ffmpeg-api/internal/domain"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// progressFD is the file descriptor FFMPEG writes its -progress output to. It
+// is the first entry of exec.Cmd.ExtraFiles, after stdin, stdout and stderr.
+const progressFD = 3
+
+// progressArgs are prepended to every FFMPEG command. They turn the human
+// readable stats on stderr off and write machine readable key=value progress
+// blocks to progressFD instead.
+var progressArgs = []string{"-nostats", "-progress", "pipe:" + strconv.Itoa(progressFD)}
+
+// readFFmpegProgress reads the -progress output of FFMPEG and calls report
+// with the accumulated values at the end of every block, until r is closed.
+//
+// A block is a series of key=value lines ending with "progress=continue" or,
+// for the last one, "progress=end". Values FFMPEG does not know yet are
+// written as "N/A" and leave the field unchanged.
+func readFFmpegProgress(r io.Reader, report func(domain.FFmpegProgress)) {
+	var progress domain.FFmpegProgress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || value == "N/A" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "frame":
+			progress.Frame = parseProgressInt(value, progress.Frame)
+		case "fps":
+			if fps, err := strconv.ParseFloat(value, 64); err == nil {
+				progress.FPS = fps
+			}
+		case "bitrate":
+			progress.Bitrate = value
+		case "total_size":
+			progress.TotalSize = parseProgressInt(value, progress.TotalSize)
+		case "out_time_us":
+			progress.OutTimeUs = parseProgressInt(value, progress.OutTimeUs)
+		case "out_time":
+			progress.OutTime = value
+		case "speed":
+			progress.Speed = value
+		case "dup_frames":
+			progress.DupFrames = parseProgressInt(value, progress.DupFrames)
+		case "drop_frames":
+			progress.DropFrames = parseProgressInt(value, progress.DropFrames)
+		case "progress":
+			progress.Done = value == "end"
+			report(progress)
+		}
+	}
+}
+
+// parseProgressInt parses an integer progress value, keeping current when the
+// value is malformed
+func parseProgressInt(value string, current int64) int64 {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return current
+	}
+	return n
+}
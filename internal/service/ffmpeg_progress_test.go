@@ -0,0 +1,64 @@
+package service
+
+import (
+	"ffmpeg-api/internal/domain"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadFFmpegProgress(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []domain.FFmpegProgress
+	}{
+		{
+			name: "blocks",
+			output: "frame=25\nfps=24.5\nbitrate=1205.3kbits/s\ntotal_size=4096\nout_time_us=1000000\nout_time=00:00:01.000000\n" +
+				"dup_frames=1\ndrop_frames=2\nspeed=1.5x\nprogress=continue\n" +
+				"frame=50\nout_time_us=2000000\nprogress=end\n",
+			want: []domain.FFmpegProgress{
+				{Frame: 25, FPS: 24.5, Bitrate: "1205.3kbits/s", TotalSize: 4096, OutTimeUs: 1000000, OutTime: "00:00:01.000000", Speed: "1.5x", DupFrames: 1, DropFrames: 2},
+				{Frame: 50, FPS: 24.5, Bitrate: "1205.3kbits/s", TotalSize: 4096, OutTimeUs: 2000000, OutTime: "00:00:01.000000", Speed: "1.5x", DupFrames: 1, DropFrames: 2, Done: true},
+			},
+		},
+		{
+			name: "values not known yet",
+			output: "frame=0\nfps=0.00\nbitrate=N/A\ntotal_size=N/A\nout_time_us=N/A\nout_time=N/A\nspeed=N/A\nprogress=continue\n" +
+				"frame=12\nbitrate=N/A\nout_time_us=500000\nspeed=0.5x\nprogress=continue\n",
+			want: []domain.FFmpegProgress{
+				{},
+				{Frame: 12, OutTimeUs: 500000, Speed: "0.5x"},
+			},
+		},
+		{
+			name:   "value unknown again keeps the last one",
+			output: "speed=2x\ntotal_size=100\nprogress=continue\nspeed=N/A\ntotal_size=N/A\nprogress=end\n",
+			want: []domain.FFmpegProgress{
+				{TotalSize: 100, Speed: "2x"},
+				{TotalSize: 100, Speed: "2x", Done: true},
+			},
+		},
+		{
+			name:   "malformed values and lines",
+			output: "frame=12\nframe=twelve\nfps=fast\nstream_0_0_q=28.0\nnot a key value\n\nout_time_us=42\nprogress=continue\n",
+			want: []domain.FFmpegProgress{
+				{Frame: 12, OutTimeUs: 42},
+			},
+		},
+		{
+			name:   "no complete block",
+			output: "frame=12\nout_time_us=42\n",
+		},
+	}
+	for _, tt := range tests {
+		var got []domain.FFmpegProgress
+		readFFmpegProgress(strings.NewReader(tt.output), func(p domain.FFmpegProgress) {
+			got = append(got, p)
+		})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: readFFmpegProgress() reported %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"ffmpeg-api/internal/config"
 	"ffmpeg-api/internal/domain"
 	"ffmpeg-api/internal/logger"
@@ -11,11 +13,16 @@ import (
 	_ "image/gif"  // Register GIF format
 	_ "image/jpeg" // Register JPEG format
 	_ "image/png"  // Register PNG format
+	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"bufio"
@@ -25,48 +32,298 @@ import (
 
 // FFMPEGServiceImpl implements FFMPEGService
 type FFMPEGServiceImpl struct {
-	jobRepo        repository.JobRepository
-	userRepo       repository.UserRepository
-	storageService StorageService
-	config         *config.Config
+	jobRepo           repository.JobRepository
+	userRepo          repository.UserRepository
+	presetRepo        repository.PresetRepository
+	uploadRepo        repository.UploadRepository
+	storageService    StorageService
+	webhookService    WebhookService
+	credentialService StorageCredentialService
+	config            *config.Config
+	queue             *JobQueue
+	progress          *ProgressBroker
+	policy            *CommandPolicy
+
+	runningMu sync.Mutex
+	running   map[string]*runningJob
+}
+
+const (
+	defaultJobListLimit = 50
+	maxJobListLimit     = 200
+)
+
+// runningJob is a job being processed by a worker on this node
+type runningJob struct {
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 // NewFFMPEGService creates a new FFMPEGService
 func NewFFMPEGService(
 	jobRepo repository.JobRepository,
 	userRepo repository.UserRepository,
+	presetRepo repository.PresetRepository,
+	uploadRepo repository.UploadRepository,
 	storageService StorageService,
+	webhookService WebhookService,
+	credentialService StorageCredentialService,
 	config *config.Config,
 ) FFMPEGService {
-	return &FFMPEGServiceImpl{
-		jobRepo:        jobRepo,
-		userRepo:       userRepo,
-		storageService: storageService,
-		config:         config,
+	s := &FFMPEGServiceImpl{
+		jobRepo:           jobRepo,
+		userRepo:          userRepo,
+		presetRepo:        presetRepo,
+		uploadRepo:        uploadRepo,
+		storageService:    storageService,
+		webhookService:    webhookService,
+		credentialService: credentialService,
+		config:            config,
+		running:           make(map[string]*runningJob),
+		progress:          NewProgressBroker(),
+		policy:            NewCommandPolicy(config.Policy),
 	}
+	s.queue = NewJobQueue(jobRepo, config.FFMPEG.NodeID, config.FFMPEG.Workers, config.FFMPEG.QueuePollInterval, s.processJob)
+	return s
 }
 
-func (s *FFMPEGServiceImpl) ProcessVideo(ctx context.Context, req domain.FFMPEGRequest, userID uint) (*domain.FFMPEGResponse, error) {
-	jobUUID := uuid.New().String()
+// Start launches the worker pool that processes queued jobs, and takes over
+// the jobs of nodes that stop sending heartbeats for them
+func (s *FFMPEGServiceImpl) Start(ctx context.Context) {
+	s.queue.Start(ctx)
+	go s.watchLeases(ctx)
+}
+
+// RecoverJobs handles jobs this node left in PROCESSING when it last stopped,
+// and jobs of other nodes that stopped sending heartbeats for them, e.g.
+// because the node's ID changed with its host name when it restarted.
+// Depending on the configured recovery mode they are either queued again from
+// their original request or marked as failed.
+func (s *FFMPEGServiceImpl) RecoverJobs(ctx context.Context) error {
+	jobs, err := s.jobRepo.FindProcessingByNode(ctx, s.config.FFMPEG.NodeID)
+	if err != nil {
+		return fmt.Errorf("failed to find interrupted jobs: %w", err)
+	}
+	stale, err := s.jobRepo.FindStale(ctx, time.Now().Add(-s.config.FFMPEG.LeaseTimeout))
+	if err != nil {
+		return fmt.Errorf("failed to find interrupted jobs: %w", err)
+	}
+	for _, job := range stale {
+		if job.WorkerNode != s.config.FFMPEG.NodeID && job.WorkerNode != "" {
+			jobs = append(jobs, job)
+		}
+	}
+
+	for i := range jobs {
+		if err := s.recoverJob(ctx, &jobs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverJob requeues or fails an interrupted job, unless its node updated
+// it in the meantime
+func (s *FFMPEGServiceImpl) recoverJob(ctx context.Context, job *domain.JobStatus) error {
+	node := job.WorkerNode
+	if node == s.config.FFMPEG.NodeID {
+		// Whatever the previous run left behind is incomplete
+		if err := os.RemoveAll(filepath.Join(s.config.FFMPEG.TempDirectory, job.UUID)); err != nil {
+			logger.Error("failed to remove temp directory", "uuid", job.UUID, "error", err)
+		}
+	}
+
+	if s.config.FFMPEG.RecoveryMode != "requeue" || job.OriginalRequest == nil {
+		logger.Warn("failing interrupted job", "uuid", job.UUID, "node", node)
+		job.Status = domain.JobStatusFailed
+		job.Result = "job was interrupted by a server restart"
+		job.TotalProcessingSeconds = time.Since(job.CreatedAt).Seconds()
+		if saved, err := s.jobRepo.UpdateClaimed(ctx, job, node); err != nil {
+			return fmt.Errorf("failed to fail job %s: %w", job.UUID, err)
+		} else if saved {
+			s.notifyStatus(ctx, job)
+		}
+		return nil
+	}
+
+	logger.Info("requeueing interrupted job", "uuid", job.UUID, "node", node)
+	job.Status = domain.JobStatusQueued
+	job.Progress = 0
+	job.Result = ""
+	job.WorkerNode = ""
+	job.OutputFiles = nil
+	job.InputProbes = nil
+	job.ExpectedDuration = 0
+	job.FFmpegProgress = nil
+	job.Steps = nil
+	job.Renditions = nil
+	job.FFmpegCommandRunSeconds = 0
+	job.TotalProcessingSeconds = 0
+	if _, err := s.jobRepo.UpdateClaimed(ctx, job, node); err != nil {
+		return fmt.Errorf("failed to requeue job %s: %w", job.UUID, err)
+	}
+	return nil
+}
+
+// watchLeases takes over the jobs of nodes that stopped sending heartbeats
+// for them until ctx is cancelled
+func (s *FFMPEGServiceImpl) watchLeases(ctx context.Context) {
+	ticker := time.NewTicker(s.config.FFMPEG.LeaseTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		jobs, err := s.jobRepo.FindStale(ctx, time.Now().Add(-s.config.FFMPEG.LeaseTimeout))
+		if err != nil {
+			logger.Error("failed to find stale jobs", "error", err)
+			continue
+		}
+		for i := range jobs {
+			s.runningMu.Lock()
+			running := s.running[jobs[i].UUID] != nil
+			s.runningMu.Unlock()
+			if running {
+				// This node's heartbeats failed; it hands the job over once
+				// they find it taken over
+				continue
+			}
+			if err := s.recoverJob(ctx, &jobs[i]); err != nil {
+				logger.Error("failed to recover stale job", "uuid", jobs[i].UUID, "error", err)
+			}
+		}
+		if len(jobs) > 0 {
+			s.queue.Notify()
+		}
+	}
+}
 
-	job := &domain.JobStatus{
-		UUID:   jobUUID,
-		Status: "pending",
-		UserID: userID,
+// sendHeartbeats records that this node still processes a job until jobCtx is
+// done, and cancels the job once it no longer does
+func (s *FFMPEGServiceImpl) sendHeartbeats(ctx, jobCtx context.Context, job *domain.JobStatus, cancel context.CancelFunc) {
+	ticker := time.NewTicker(s.config.FFMPEG.LeaseTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-jobCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		alive, err := s.jobRepo.Heartbeat(ctx, job.ID, s.config.FFMPEG.NodeID)
+		if err != nil {
+			logger.Error("failed to send job heartbeat", "uuid", job.UUID, "error", err)
+			continue
+		}
+		if !alive {
+			logger.Info("job is no longer processing on this node, stopping it", "uuid", job.UUID)
+			cancel()
+			return
+		}
+	}
+}
+
+func (s *FFMPEGServiceImpl) ProcessVideo(ctx context.Context, req domain.FFMPEGRequest, userID uint) (*domain.FFMPEGResponse, error) {
+	job, err := s.newJob(ctx, req, userID)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := s.jobRepo.Create(ctx, job); err != nil {
 		return nil, fmt.Errorf("failed to create job: %w", err)
 	}
 
-	go s.processFFMPEGJob(context.Background(), job, req)
+	position, err := s.jobRepo.QueuePosition(ctx, job)
+	if err != nil {
+		logger.Error("failed to get queue position", "uuid", job.UUID, "error", err)
+	}
+
+	s.queue.Notify()
 
 	return &domain.FFMPEGResponse{
-		UUID:   jobUUID,
-		Status: "pending",
+		UUID:          job.UUID,
+		Status:        domain.JobStatusQueued,
+		QueuePosition: position,
 	}, nil
 }
 
+// newJob validates a processing request and returns the queued job for it,
+// without storing it
+func (s *FFMPEGServiceImpl) newJob(ctx context.Context, req domain.FFMPEGRequest, userID uint) (*domain.JobStatus, error) {
+	if err := s.applyPreset(ctx, &req, userID); err != nil {
+		return nil, err
+	}
+	if err := normalizeRequest(&req); err != nil {
+		return nil, err
+	}
+	if err := s.checkUploadRefs(ctx, req.InputFiles, userID); err != nil {
+		return nil, err
+	}
+	if err := s.webhookService.CheckURL(req.WebhookURL); err != nil {
+		return nil, fmt.Errorf("%w: webhook_url: %v", ErrInvalidRequest, err)
+	}
+	if err := checkInputModes(req); err != nil {
+		return nil, err
+	}
+	if err := s.checkDestinations(ctx, req.Destinations, sortedKeys(req.OutputFiles), userID); err != nil {
+		return nil, err
+	}
+	if err := s.policy.Check(req); err != nil {
+		return nil, err
+	}
+
+	return &domain.JobStatus{
+		UUID:            uuid.New().String(),
+		Type:            domain.JobTypeFFmpeg,
+		Status:          domain.JobStatusQueued,
+		UserID:          userID,
+		OriginalRequest: &req,
+		OutputFileTypes: outputFileTypes(req.OutputFiles),
+	}, nil
+}
+
+// applyPreset sets the command of a request, or of each of its steps, that
+// references a preset to the preset's expanded command. The variable values
+// used are kept in the request, so the job's original request shows exactly
+// what ran.
+func (s *FFMPEGServiceImpl) applyPreset(ctx context.Context, req *domain.FFMPEGRequest, userID uint) error {
+	if req.Preset != "" {
+		command, values, err := s.expandPreset(ctx, req.Preset, req.PresetVariables, userID)
+		if err != nil {
+			return err
+		}
+		req.FFmpegCommand = command
+		req.PresetVariables = values
+	}
+
+	for i := range req.Steps {
+		step := &req.Steps[i]
+		if step.Preset == "" {
+			continue
+		}
+		command, values, err := s.expandPreset(ctx, step.Preset, step.PresetVariables, userID)
+		if err != nil {
+			return fmt.Errorf("step %s: %w", step.ID, err)
+		}
+		step.FFmpegCommand = command
+		step.PresetVariables = values
+	}
+	return nil
+}
+
+// expandPreset looks up a preset of the user, or a global one, by name and
+// expands its command with the given variable values
+func (s *FFMPEGServiceImpl) expandPreset(ctx context.Context, name string, values map[string]interface{}, userID uint) (string, map[string]interface{}, error) {
+	preset, err := s.presetRepo.FindByName(ctx, userID, name)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: preset %q not found", ErrInvalidRequest, name)
+	}
+	return expandPreset(preset, values)
+}
+
 func (s *FFMPEGServiceImpl) GetJobStatus(ctx context.Context, uuid string, userID uint) (*domain.JobStatus, error) {
 	job, err := s.jobRepo.FindByUUID(ctx, uuid)
 	if err != nil {
@@ -77,277 +334,620 @@ func (s *FFMPEGServiceImpl) GetJobStatus(ctx context.Context, uuid string, userI
 		return nil, fmt.Errorf("unauthorized access to job")
 	}
 
+	if job.Status == domain.JobStatusQueued {
+		position, err := s.jobRepo.QueuePosition(ctx, job)
+		if err != nil {
+			logger.Error("failed to get queue position", "uuid", uuid, "error", err)
+		}
+		job.QueuePosition = position
+	}
+
 	return job, nil
 }
 
-func (s *FFMPEGServiceImpl) processFFMPEGJob(ctx context.Context, job *domain.JobStatus, req domain.FFMPEGRequest) {
-	startTime := time.Now()
-	job.Status = "PROCESSING"
+// SubscribeProgress subscribes to the live progress events of a job and
+// returns the job's current state along with the subscription. The returned
+// function must be called to unsubscribe.
+func (s *FFMPEGServiceImpl) SubscribeProgress(ctx context.Context, uuid string, userID uint) (*domain.JobStatus, <-chan domain.ProgressEvent, func(), error) {
+	// Subscribe before reading the job so no transition is missed in between
+	events, unsubscribe := s.progress.Subscribe(uuid)
+
+	job, err := s.GetJobStatus(ctx, uuid, userID)
+	if err != nil {
+		unsubscribe()
+		return nil, nil, nil, ErrJobNotFound
+	}
+
+	return job, events, unsubscribe, nil
+}
+
+// ListJobs lists a user's jobs. cursor is the NextCursor of the previous page,
+// or empty for the first page.
+func (s *FFMPEGServiceImpl) ListJobs(ctx context.Context, filter domain.JobFilter, cursor string) (*domain.JobList, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultJobListLimit
+	}
+	if filter.Limit > maxJobListLimit {
+		filter.Limit = maxJobListLimit
+	}
+
+	if cursor != "" {
+		after, err := decodeJobCursor(cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		filter.After = after
+	}
+
+	// Fetch one extra job to know whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	jobs, err := s.jobRepo.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	list := &domain.JobList{Jobs: jobs}
+	if len(jobs) > limit {
+		list.Jobs = jobs[:limit]
+		last := list.Jobs[limit-1]
+		list.NextCursor = encodeJobCursor(domain.JobCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	return list, nil
+}
+
+// CancelJob cancels a queued or running job. A running FFmpeg process is asked
+// to stop and killed if it does not exit within the configured grace period.
+func (s *FFMPEGServiceImpl) CancelJob(ctx context.Context, uuid string, userID uint) (*domain.JobStatus, error) {
+	job, err := s.GetJobStatus(ctx, uuid, userID)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	if job.Status == domain.JobStatusQueued {
+		cancelled, err := s.jobRepo.CompareAndSwapStatus(ctx, job.ID, domain.JobStatusQueued, domain.JobStatusCancelled)
+		if err != nil {
+			return nil, fmt.Errorf("failed to cancel job: %w", err)
+		}
+		if cancelled {
+			job.QueuePosition = 0
+			s.updateJobStatus(ctx, job, domain.JobStatusCancelled, "job cancelled before processing started")
+			return job, nil
+		}
+		// A worker claimed the job in the meantime
+		job.Status = domain.JobStatusProcessing
+	}
+
+	if job.Status != domain.JobStatusProcessing {
+		return job, ErrJobFinished
+	}
+
+	s.runningMu.Lock()
+	running := s.running[uuid]
+	s.runningMu.Unlock()
+
+	if running == nil {
+		// The job runs on another node, whose worker stops FFmpeg once its
+		// writes find the job cancelled, or no worker runs it any more, e.g.
+		// it was orphaned by a restart of this node
+		if job.WorkerNode != "" && job.WorkerNode != s.config.FFMPEG.NodeID {
+			logger.Info("cancelling job running on another node", "uuid", uuid, "node", job.WorkerNode)
+		}
+		cancelled, err := s.jobRepo.CompareAndSwapStatus(ctx, job.ID, domain.JobStatusProcessing, domain.JobStatusCancelled)
+		if err != nil {
+			return nil, fmt.Errorf("failed to cancel job: %w", err)
+		}
+		if !cancelled {
+			return s.jobRepo.FindByUUID(ctx, uuid)
+		}
+		s.updateJobStatus(ctx, job, domain.JobStatusCancelled, "job cancelled")
+		return job, nil
+	}
+
+	running.cancel()
+	select {
+	case <-running.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return s.jobRepo.FindByUUID(ctx, uuid)
+}
+
+// processJob runs a job claimed from the queue with the processor for its type
+func (s *FFMPEGServiceImpl) processJob(ctx context.Context, job *domain.JobStatus) {
+	if job.OriginalRequest == nil {
+		s.finishJob(ctx, job, domain.JobStatusFailed, "job has no request to process")
+		return
+	}
+
+	// Register the job so it can be cancelled while it runs. Database writes
+	// keep using ctx so the final status is recorded after a cancellation.
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer s.trackJob(job.UUID, cancel)()
+	go s.sendHeartbeats(ctx, jobCtx, job, cancel)
+
+	// Saving fails if the job was cancelled between being claimed and registered
 	job.Progress = 0 // Initialize progress
-	job.OriginalRequest = &req
-	if err := s.jobRepo.Update(ctx, job); err != nil {
-		s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("failed to update job status: %v", err))
+	if !s.saveJob(ctx, job) {
+		return
+	}
+	s.notifyStatus(ctx, job)
+
+	switch job.Type {
+	case domain.JobTypeProbe:
+		s.processProbeJob(ctx, jobCtx, job)
+	case domain.JobTypeABR:
+		s.processABRJob(ctx, jobCtx, job)
+	case domain.JobTypeStoryboard:
+		s.processStoryboardJob(ctx, jobCtx, job)
+	default:
+		s.processFFMPEGJob(ctx, jobCtx, job)
+	}
+}
+
+func (s *FFMPEGServiceImpl) processFFMPEGJob(ctx, jobCtx context.Context, job *domain.JobStatus) {
+	startTime := time.Now()
+	req := *job.OriginalRequest
+
+	// Jobs queued before the policy changed are checked again
+	if err := s.policy.Check(req); err != nil {
+		s.finishJob(ctx, job, domain.JobStatusFailed, err.Error())
 		return
 	}
 
 	// Create temporary directory for this job
 	tempDir := filepath.Join(s.config.FFMPEG.TempDirectory, job.UUID)
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("failed to create temp directory: %v", err))
+		s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to create temp directory: %v", err))
 		return
 	}
 	defer os.RemoveAll(tempDir)
 
-	// Download all input files (25% of progress)
+	// FFmpeg doesn't create the directories of outputs in subdirectories
+	outputNames := slices.Collect(maps.Values(req.OutputFiles))
+	for _, step := range req.Steps {
+		outputNames = append(outputNames, slices.Collect(maps.Values(step.OutputFiles))...)
+	}
+	for _, name := range outputNames {
+		if err := os.MkdirAll(filepath.Join(tempDir, filepath.FromSlash(outputDirectory(name))), 0755); err != nil {
+			s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to create output directory: %v", err))
+			return
+		}
+	}
+
+	// Download all input files (25% of progress), except those FFmpeg reads
+	// as they arrive
 	inputPaths := make(map[string]string)
+	job.InputProbes = make(domain.MediaProbesMap)
+	streams := &streamedInputs{protocols: make(map[string]string)}
+	defer streams.Close()
 	var totalInputSize int64
 	totalFiles := len(req.InputFiles)
 	fileNum := 0
-	for key, url := range req.InputFiles {
-		if !strings.HasPrefix(url, "http") {
-			s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("Invalid URL for input file %s", key))
+	for key, input := range req.InputFiles {
+		url, err := s.resolveInput(jobCtx, input, job.UserID)
+		if err != nil {
+			s.failJob(ctx, jobCtx, job, fmt.Sprintf("Invalid input file %s: %v", key, err))
 			return
 		}
 
-		inputPath, err := s.storageService.DownloadFile(ctx, url)
+		inputPath, size, err := s.streamInput(jobCtx, req, key, url, streams)
 		if err != nil {
-			s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("failed to download input file %s: %v", key, err))
+			s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to stream input file %s: %v", key, err))
 			return
 		}
-		defer s.storageService.DeleteFile(ctx, inputPath)
+		if inputPath != "" {
+			logger.Info("streaming input file", "uuid", job.UUID, "key", key, "protocols", streams.protocols[inputPath])
+		} else {
+			if inputPath, err = s.storageService.DownloadFile(jobCtx, url); err != nil {
+				s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to download input file %s: %v", key, err))
+				return
+			}
+			defer s.storageService.DeleteFile(ctx, inputPath)
 
-		inputFileInfo, err := os.Stat(inputPath)
-		if err != nil {
-			s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("failed to get input file size for %s: %v", key, err))
-			return
+			inputFileInfo, err := os.Stat(inputPath)
+			if err != nil {
+				s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to get input file size for %s: %v", key, err))
+				return
+			}
+			size = inputFileInfo.Size()
+			inputPath = absolutePath(inputPath)
 		}
-		totalInputSize += inputFileInfo.Size()
+		totalInputSize += max(size, 0)
 		inputPaths[key] = inputPath
 
+		// Probing is best effort, the duration only makes the progress more
+		// accurate. Piped inputs can only be read once, by FFmpeg.
+		protocols, streamed := streams.protocols[inputPath]
+		if !streamed {
+			protocols = localInputProtocols
+		}
+		if protocols != pipeInputProtocols {
+			probe, err := probeMedia(jobCtx, s.config.FFMPEG.ProbePath, inputPath, protocols)
+			if err != nil {
+				logger.Warn("failed to probe input file", "uuid", job.UUID, "key", key, "error", err)
+			} else {
+				job.InputProbes[key] = *probe
+			}
+		}
+
 		// Update progress for download phase (0-25%)
 		fileNum++
 		job.Progress = int(float64(fileNum) / float64(totalFiles) * 25)
-		if err := s.jobRepo.Update(ctx, job); err != nil {
-			logger.Error("failed to update job progress", "error", err)
-		}
+		s.saveProgress(ctx, job)
 	}
 
-	// Prepare output paths
+	// Prepare output paths. FFmpeg runs in the job's directory, so all paths
+	// it gets are absolute.
 	outputPaths := make(map[string]string)
 	for key, filename := range req.OutputFiles {
-		outputPaths[key] = filepath.Join(tempDir, filename)
+		outputPaths[key] = absolutePath(filepath.Join(tempDir, filepath.FromSlash(filename)))
 	}
 
-	// Process command template
-	command := req.FFmpegCommand
-	for key, path := range inputPaths {
-		placeholder := fmt.Sprintf("{{%s}}", key)
-		logger.Debug(command, "Command<<")
-		logger.Debug(placeholder, "Placeholder<<")
-		logger.Debug(path, "Path<<")
-		command = strings.ReplaceAll(command, placeholder, path)
-		logger.Debug(command, "Command2<<")
-		logger.Debug(path, "Path2<<")
+	inputDurations := make(map[string]float64)
+	for key, probe := range job.InputProbes {
+		inputDurations[inputPaths[key]] = probeDuration(&probe)
 	}
-	for key, path := range outputPaths {
-		placeholder := fmt.Sprintf("{{%s}}", key)
-		command = strings.ReplaceAll(command, placeholder, path)
-		logger.Debug(command, "Command3<<")
-		// println(path, "Path<<")
+
+	// Execute FFmpeg command (25-75% of progress)
+	if len(req.Steps) > 0 {
+		if err := s.runPipeline(ctx, jobCtx, job, tempDir, inputPaths, inputDurations, streams); err != nil {
+			s.failJob(ctx, jobCtx, job, err.Error())
+			return
+		}
+	} else {
+		// Process command template. The command policy only allows placeholders
+		// as whole arguments, so they are substituted argument by argument.
+		args := substitutePlaceholders(commandTemplate(req), inputPaths, outputPaths)
+		if len(args) == 0 {
+			s.failJob(ctx, jobCtx, job, "invalid FFmpeg command")
+			return
+		}
+		job.ExpectedDuration = expectedOutputDuration(args, inputDurations)
+
+		job.Progress = 25
+		s.saveProgress(ctx, job)
+
+		ffmpegStartTime := time.Now()
+		if _, err := s.runFFmpeg(ctx, jobCtx, job, tempDir, args, streams, 25, 75); err != nil {
+			s.failJob(ctx, jobCtx, job, err.Error())
+			return
+		}
+		job.FFmpegCommandRunSeconds = time.Since(ffmpegStartTime).Seconds()
 	}
 
-	// Split command into args
-	args := splitCommand(command)
-	fmt.Println(args, "<<<<")
+	// Upload output files and gather metadata (75-99% of progress)
+	job.Progress = 75
+	s.saveProgress(ctx, job)
 
-	if len(args) == 0 {
-		s.updateJobStatus(ctx, job, "FAILED", "invalid FFmpeg command")
+	totalOutputSize, err := s.uploadOutputs(ctx, jobCtx, job, tempDir, req.OutputFiles)
+	if err != nil {
+		s.failJob(ctx, jobCtx, job, err.Error())
 		return
 	}
 
-	// Execute FFmpeg command (25-75% of progress)
-	job.Progress = 25
-	if err := s.jobRepo.Update(ctx, job); err != nil {
-		logger.Error("failed to update job progress", "error", err)
+	// Update job status to completed and set progress to 100%
+	job.Status = domain.JobStatusSuccess
+	job.Progress = 100
+	job.TotalProcessingSeconds = time.Since(startTime).Seconds()
+	job.Result = "Successfully processed files"
+	if !s.saveJob(ctx, job) {
+		return
 	}
+	s.notifyStatus(ctx, job)
 
-	ffmpegStartTime := time.Now()
-	cmd := exec.CommandContext(ctx, s.config.FFMPEG.BinaryPath, args...)
+	// Update user usage statistics
+	go s.userRepo.IncrementUsage(ctx, job.UserID)
+	go s.userRepo.IncrementBytesProcessed(ctx, job.UserID, totalInputSize+totalOutputSize)
+}
 
-	// Capture stderr to parse progress
-	stderr, err := cmd.StderrPipe()
+// runFFmpeg runs FFmpeg with the given arguments in the job's directory and
+// reports its progress as job progress between from and to. streams are the
+// inputs among args it reads over HTTP(S) or from pipes, fed while it runs.
+// It returns the last lines FFmpeg logged.
+func (s *FFMPEGServiceImpl) runFFmpeg(ctx, jobCtx context.Context, job *domain.JobStatus, dir string, args []string, streams *streamedInputs, from, to int) ([]string, error) {
+	binaryPath := s.config.FFMPEG.BinaryPath
+	if filepath.Base(binaryPath) != binaryPath {
+		// A relative path would be resolved from the job's directory
+		binaryPath = absolutePath(binaryPath)
+	}
+	var protocols map[string]string
+	if streams != nil {
+		protocols = streams.protocols
+	}
+	cmd := exec.CommandContext(jobCtx, binaryPath, slices.Concat(progressArgs, s.policy.Restrict(args, protocols))...)
+	cmd.Dir = dir
+	// On cancellation ask FFmpeg to stop gracefully, and kill it once the grace period is over
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = s.config.FFMPEG.KillGracePeriod
+
+	// FFmpeg reports its progress on an extra pipe
+	progressReader, progressWriter, err := os.Pipe()
 	if err != nil {
-		s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("failed to create stderr pipe: %v", err))
-		return
+		return nil, fmt.Errorf("failed to create progress pipe: %v", err)
 	}
+	defer progressReader.Close()
 
-	if err := cmd.Start(); err != nil {
-		s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("failed to start FFmpeg: %v", err))
-		return
+	// Piped inputs follow on pipe:4 onwards
+	pipeReadEnds, pipeWriteEnds, err := streams.openPipes()
+	if err != nil {
+		progressWriter.Close()
+		return nil, err
 	}
+	cmd.ExtraFiles = append([]*os.File{progressWriter}, pipeReadEnds...)
 
-	// Start a goroutine to read stderr and update progress
-	service := s // Capture service instance for goroutine
+	// Capture stderr to find the input duration and keep the end of the log
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		closeFiles(cmd.ExtraFiles)
+		closeFiles(pipeWriteEnds)
+		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+
+	err = cmd.Start()
+	// Only FFmpeg may hold the write end, so the reader sees EOF once it
+	// exits, and the read ends of the input pipes, so writing to them fails
+	closeFiles(cmd.ExtraFiles)
+	if err != nil {
+		closeFiles(pipeWriteEnds)
+		return nil, fmt.Errorf("failed to start FFmpeg: %v", err)
+	}
+	fed := streams.feed(pipeWriteEnds)
+
+	// Without an expected duration from the probes, the longest input FFmpeg
+	// logs is taken as the length of the output. FFmpeg logs the inputs before
+	// writing any progress, but the stderr reader runs concurrently, so the
+	// duration is guarded by a mutex.
+	var durationMu sync.Mutex
+	duration := job.ExpectedDuration
+	var logLines []string
+	stderrDone := make(chan struct{})
 	go func() {
+		defer close(stderrDone)
 		scanner := bufio.NewScanner(stderr)
-		var duration float64
-
-		// First, try to find the duration
 		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.Contains(line, "Duration:") {
-				// Parse duration in format "Duration: 00:00:00.00"
-				parts := strings.Split(line, "Duration: ")
-				if len(parts) > 1 {
-					timeStr := strings.Split(parts[1], ",")[0]
-					h, m, s := 0, 0, 0.0
-					fmt.Sscanf(timeStr, "%d:%d:%f", &h, &m, &s)
-					duration = float64(h*3600) + float64(m*60) + s
-					break
-				}
+			logLines = appendLogLine(logLines, scanner.Text())
+			if job.ExpectedDuration > 0 {
+				continue
+			}
+			if match := durationRegexp.FindStringSubmatch(scanner.Text()); match != nil {
+				durationMu.Lock()
+				duration = max(duration, parseTimestamp(match[1]))
+				durationMu.Unlock()
 			}
 		}
+	}()
 
-		// Now process the time updates
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.Contains(line, "time=") {
-				// Parse time in format "time=00:00:00.00"
-				parts := strings.Split(line, "time=")
-				if len(parts) > 1 {
-					timeStr := strings.Split(parts[1], " ")[0]
-					h, m, s := 0, 0, 0.0
-					fmt.Sscanf(timeStr, "%d:%d:%f", &h, &m, &s)
-					currentTime := float64(h*3600) + float64(m*60) + s
-
-					if duration > 0 {
-						// Calculate progress within the FFMPEG phase (25-75%)
-						ffmpegProgress := (currentTime / duration) * 50
-						job.Progress = 25 + int(ffmpegProgress)
-						if err := service.jobRepo.Update(ctx, job); err != nil {
-							logger.Error("failed to update job progress", "error", err)
-						}
-					}
-				}
+	// Read the progress blocks and publish each of them. The job is stored
+	// at most once per PROGRESS_UPDATE_INTERVAL, and once FFmpeg is done.
+	service := s // Capture service instance for goroutine
+	var lastSaved time.Time
+	unsaved := false
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		readFFmpegProgress(progressReader, func(progress domain.FFmpegProgress) {
+			durationMu.Lock()
+			total := duration
+			durationMu.Unlock()
+
+			if total > 0 {
+				// Calculate progress within the FFMPEG phase
+				ffmpegProgress := min(float64(progress.OutTimeUs)/1e6/total, 1) * float64(to-from)
+				job.Progress = from + int(ffmpegProgress)
 			}
-		}
+			job.FFmpegProgress = &progress
+			unsaved = time.Since(lastSaved) < service.config.FFMPEG.ProgressUpdateInterval
+			if !unsaved {
+				service.saveJob(ctx, job)
+				lastSaved = time.Now()
+			}
+
+			service.progress.Publish(domain.ProgressEvent{
+				JobUUID:  job.UUID,
+				Status:   job.Status,
+				Progress: job.Progress,
+				FFmpeg:   &progress,
+			})
+		})
 	}()
 
-	if err := cmd.Wait(); err != nil {
-		s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("FFmpeg processing failed: %v", err))
-		return
+	// All of stderr and the progress output must be read before waiting for the process
+	<-stderrDone
+	<-progressDone
+	if unsaved {
+		s.saveJob(ctx, job)
 	}
-	ffmpegEndTime := time.Now()
-	job.FFmpegCommandRunSeconds = ffmpegEndTime.Sub(ffmpegStartTime).Seconds()
+	err = cmd.Wait()
+	feedErr := fed()
+	if err != nil {
+		return logLines, fmt.Errorf("FFmpeg processing failed: %v", err)
+	}
+	if feedErr != nil {
+		// FFmpeg took the input for having ended
+		return logLines, fmt.Errorf("failed to stream input file: %v", feedErr)
+	}
+	return logLines, nil
+}
+
+func (s *FFMPEGServiceImpl) updateJobStatus(ctx context.Context, job *domain.JobStatus, status, result string) {
+	job.Status = status
+	job.Result = result
+	job.UpdatedAt = time.Now()
+	job.TotalProcessingSeconds = time.Since(job.CreatedAt).Seconds()
 
-	// Upload output files and gather metadata (75-99% of progress)
-	job.Progress = 75
 	if err := s.jobRepo.Update(ctx, job); err != nil {
-		logger.Error("failed to update job progress", "error", err)
+		fmt.Printf("failed to update job status: %v\n", err)
 	}
+	s.notifyStatus(ctx, job)
+}
 
-	var totalOutputSize int64
-	job.OutputFiles = make(map[string]domain.OutputFileMetadata)
-
-	totalOutputFiles := len(outputPaths)
-	currentOutputFile := 0
+// saveJob stores the state of a job a worker of this node is processing and
+// reports whether it did. A job that was cancelled or taken over by another
+// node in the meantime isn't overwritten; its processing is stopped instead.
+func (s *FFMPEGServiceImpl) saveJob(ctx context.Context, job *domain.JobStatus) bool {
+	saved, err := s.jobRepo.UpdateClaimed(ctx, job, s.config.FFMPEG.NodeID)
+	if err != nil {
+		logger.Error("failed to update job", "uuid", job.UUID, "error", err)
+		return false
+	}
+	if !saved {
+		logger.Info("job is no longer processing on this node, stopping it", "uuid", job.UUID)
+		s.stopJob(job.UUID)
+	}
+	return saved
+}
 
-	for key, outputPath := range outputPaths {
-		outputFileInfo, err := os.Stat(outputPath)
-		if err != nil {
-			s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("failed to get output file size for %s: %v", key, err))
-			return
-		}
-		totalOutputSize += outputFileInfo.Size()
+// finishJob sets the terminal status of a job a worker of this node is
+// processing, unless it was cancelled or taken over in the meantime
+func (s *FFMPEGServiceImpl) finishJob(ctx context.Context, job *domain.JobStatus, status, result string) {
+	job.Status = status
+	job.Result = result
+	job.TotalProcessingSeconds = time.Since(job.CreatedAt).Seconds()
+	if s.saveJob(ctx, job) {
+		s.notifyStatus(ctx, job)
+	}
+}
 
-		s3URL, err := s.storageService.UploadFile(ctx, outputPath, filepath.Base(outputPath), job.UserID)
-		if err != nil {
-			s.updateJobStatus(ctx, job, "FAILED", fmt.Sprintf("failed to upload output file %s: %v", key, err))
-			return
-		}
+// saveProgress stores the job's progress and publishes it to live subscribers
+func (s *FFMPEGServiceImpl) saveProgress(ctx context.Context, job *domain.JobStatus) {
+	if !s.saveJob(ctx, job) {
+		return
+	}
+	s.progress.Publish(domain.ProgressEvent{
+		JobUUID:  job.UUID,
+		Status:   job.Status,
+		Progress: job.Progress,
+	})
+}
 
-		logger.Debug(s3URL, "S3 URL<<")
+// notifyStatus tells webhook and live subscribers about the job's new status
+func (s *FFMPEGServiceImpl) notifyStatus(ctx context.Context, job *domain.JobStatus) {
+	s.webhookService.Notify(ctx, job)
+	s.progress.Publish(domain.ProgressEvent{
+		JobUUID:     job.UUID,
+		Status:      job.Status,
+		Progress:    job.Progress,
+		OutputFiles: job.OutputFiles,
+	})
+}
 
-		// Get file metadata
-		metadata := domain.OutputFileMetadata{
-			FileID:     uuid.New().String(),
-			SizeMBytes: float64(outputFileInfo.Size()) / 1024 / 1024,
-			StorageURL: s3URL,
-		}
+// failJob marks the job as failed, or as cancelled when the failure was caused
+// by cancelling jobCtx.
+func (s *FFMPEGServiceImpl) failJob(ctx, jobCtx context.Context, job *domain.JobStatus, result string) {
+	if jobCtx.Err() != nil {
+		s.finishJob(ctx, job, domain.JobStatusCancelled, "job cancelled")
+		return
+	}
+	s.finishJob(ctx, job, domain.JobStatusFailed, result)
+}
 
-		// Get file format from extension
-		ext := strings.TrimPrefix(filepath.Ext(outputPath), ".")
-		metadata.FileFormat = ext
+// trackJob registers a running job and returns the function that unregisters it
+func (s *FFMPEGServiceImpl) trackJob(uuid string, cancel context.CancelFunc) func() {
+	entry := &runningJob{cancel: cancel, done: make(chan struct{})}
+	s.runningMu.Lock()
+	s.running[uuid] = entry
+	s.runningMu.Unlock()
+
+	return func() {
+		cancel()
+		s.runningMu.Lock()
+		delete(s.running, uuid)
+		s.runningMu.Unlock()
+		close(entry.done)
+	}
+}
 
-		// Set file type based on format
-		switch ext {
-		case "jpg", "jpeg", "png", "gif", "webp":
-			metadata.FileType = "image"
-			// Get image dimensions
-			if f, err := os.Open(outputPath); err == nil {
-				defer f.Close()
-				if img, _, err := image.DecodeConfig(f); err == nil {
-					metadata.Width = img.Width
-					metadata.Height = img.Height
-				}
-			}
-		case "mp4", "webm", "mov", "avi":
-			metadata.FileType = "video"
-			// For video files, we could use ffprobe here if needed
-		default:
-			metadata.FileType = "unknown"
-		}
+// stopJob cancels a job if a worker of this node runs it
+func (s *FFMPEGServiceImpl) stopJob(uuid string) {
+	s.runningMu.Lock()
+	running := s.running[uuid]
+	s.runningMu.Unlock()
+	if running != nil {
+		running.cancel()
+	}
+}
 
-		job.OutputFiles[key] = metadata
+// durationRegexp matches the duration FFmpeg logs for each input. Inputs of
+// unknown length are logged as "Duration: N/A" and don't match.
+var durationRegexp = regexp.MustCompile(`Duration: (\d+:\d+:\d+(?:\.\d+)?)`)
 
-		currentOutputFile++
-		// Update progress for upload phase (75-99%)
-		job.Progress = 75 + int(float64(currentOutputFile)/float64(totalOutputFiles)*24)
-		// add files to job
+// parseTimestamp parses an FFmpeg "HH:MM:SS.ss" timestamp into seconds
+func parseTimestamp(value string) float64 {
+	h, m, s := 0, 0, 0.0
+	fmt.Sscanf(value, "%d:%d:%f", &h, &m, &s)
+	return float64(h*3600) + float64(m*60) + s
+}
 
-		if err := s.jobRepo.Update(ctx, job); err != nil {
-			logger.Error("failed to update job progress", "error", err)
-		}
+// absolutePath returns path made absolute, or path itself if that fails
+func absolutePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
 	}
+	return path
+}
 
-	// Update job status to completed and set progress to 100%
-	job.Status = "SUCCESS"
-	job.Progress = 100
-	job.TotalProcessingSeconds = time.Since(startTime).Seconds()
-	job.Result = "Successfully processed files"
-	if err := s.jobRepo.Update(ctx, job); err != nil {
-		fmt.Printf("failed to update final job status: %v\n", err)
-		return
+// imageDimensions decodes the header of an image file and returns its width
+// and height, or zeros if it can't be decoded
+func imageDimensions(path string) (int, int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
 	}
+	defer f.Close()
 
-	// Update user usage statistics
-	go s.userRepo.IncrementUsage(ctx, job.UserID)
-	go s.userRepo.IncrementBytesProcessed(ctx, job.UserID, totalInputSize+totalOutputSize)
+	img, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	return img.Width, img.Height
 }
 
-func (s *FFMPEGServiceImpl) updateJobStatus(ctx context.Context, job *domain.JobStatus, status, result string) {
-	job.Status = status
-	job.Result = result
-	job.UpdatedAt = time.Now()
-	job.TotalProcessingSeconds = time.Since(job.CreatedAt).Seconds()
+// fileSHA256 returns the hex encoded SHA-256 checksum of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
 
-	if err := s.jobRepo.Update(ctx, job); err != nil {
-		fmt.Printf("failed to update job status: %v\n", err)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileTypeForFormat returns the file type for a file format (extension)
+func fileTypeForFormat(format string) string {
+	switch strings.ToLower(format) {
+	case "jpg", "jpeg", "png", "gif", "webp":
+		return "image"
+	case "mp4", "webm", "mov", "avi":
+		return "video"
+	case "mp3", "aac", "m4a", "wav", "flac", "opus", "ogg":
+		return "audio"
+	default:
+		return "unknown"
 	}
 }
 
-// splitCommand splits a command string into arguments, respecting quotes
-func splitCommand(command string) []string {
-	r := regexp.MustCompile(`[^\s"']+|"([^"]*)"|'([^']*)'`)
-	matches := r.FindAllString(command, -1)
-	args := make([]string, 0, len(matches))
-	for _, match := range matches {
-		// Remove surrounding quotes if present
-		if (strings.HasPrefix(match, "\"") && strings.HasSuffix(match, "\"")) ||
-			(strings.HasPrefix(match, "'") && strings.HasSuffix(match, "'")) {
-			match = match[1 : len(match)-1]
+// outputFileTypes returns the comma-delimited file types of the requested
+// output files, wrapped in commas so a single type can be matched with LIKE.
+func outputFileTypes(outputFiles map[string]string) string {
+	seen := make(map[string]bool)
+	types := make([]string, 0, len(outputFiles))
+	for _, filename := range outputFiles {
+		fileType := fileTypeForFormat(strings.TrimPrefix(filepath.Ext(filename), "."))
+		if !seen[fileType] {
+			seen[fileType] = true
+			types = append(types, fileType)
 		}
-		args = append(args, match)
 	}
-	return args
+	sort.Strings(types)
+	return "," + strings.Join(types, ",") + ","
 }
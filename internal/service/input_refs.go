@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/domain"
+	"fmt"
+	"strings"
+)
+
+// isInputReference reports whether an input file is given in a form a job can
+// download: an http(s) URL or a reference to an upload
+func isInputReference(input string) bool {
+	return isExternalURL(input) || strings.HasPrefix(input, uploadRefPrefix)
+}
+
+// resolveInput returns the URL or storage object key the storage service
+// downloads an input file from. Uploads are only resolved for their owner.
+func (s *FFMPEGServiceImpl) resolveInput(ctx context.Context, input string, userID uint) (string, error) {
+	id, ok := strings.CutPrefix(input, uploadRefPrefix)
+	if !ok {
+		if !isExternalURL(input) {
+			return "", fmt.Errorf("must be an http(s) URL or an %s<id> reference", uploadRefPrefix)
+		}
+		return input, nil
+	}
+
+	upload, err := s.uploadRepo.FindByUUID(ctx, id)
+	if err != nil || upload.UserID != userID {
+		return "", fmt.Errorf("upload %s not found", id)
+	}
+	if upload.Status != domain.UploadStatusComplete {
+		return "", fmt.Errorf("upload %s is not complete", id)
+	}
+	return upload.ObjectKey, nil
+}
+
+// checkUploadRefs checks that the uploads the input files refer to exist and
+// belong to the user, so a job doesn't fail on them once it runs
+func (s *FFMPEGServiceImpl) checkUploadRefs(ctx context.Context, inputFiles map[string]string, userID uint) error {
+	for _, key := range sortedKeys(inputFiles) {
+		if !strings.HasPrefix(inputFiles[key], uploadRefPrefix) {
+			continue
+		}
+		if _, err := s.resolveInput(ctx, inputFiles[key], userID); err != nil {
+			return fmt.Errorf("%w: input %s: %v", ErrInvalidRequest, key, err)
+		}
+	}
+	return nil
+}
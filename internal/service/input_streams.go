@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Protocols FFMPEG may open streamed inputs with instead of the configured
+// protocol whitelist, which is meant for downloaded files
+const (
+	urlInputProtocols  = "http,https,tcp,tls"
+	pipeInputProtocols = "pipe"
+)
+
+// firstPipeFD is the file descriptor of the first input piped to FFMPEG,
+// after its progress pipe
+const firstPipeFD = progressFD + 1
+
+// streamedInputs are the inputs of a job FFMPEG reads over HTTP(S) or from
+// pipes rather than from downloaded files. A nil *streamedInputs has none.
+type streamedInputs struct {
+	protocols map[string]string // protocol whitelist by input argument
+	pipes     []io.ReadCloser   // piped to FFMPEG on pipe:4 onwards
+}
+
+// checkInputModes checks that the input modes of a request are for its input
+// files and that inputs piped to FFMPEG are read once by a single command
+func checkInputModes(req domain.FFMPEGRequest) error {
+	for _, key := range sortedKeys(req.InputModes) {
+		if _, ok := req.InputFiles[key]; !ok {
+			return fmt.Errorf("%w: input_modes.%s: there is no such input file", ErrInvalidRequest, key)
+		}
+		switch req.InputModes[key] {
+		case domain.InputModeAuto, domain.InputModeDownload, domain.InputModeURL:
+		case domain.InputModePipe:
+			if !isPipeable(req, key) {
+				return fmt.Errorf("%w: input_modes.%s: only an input a single command reads once can be piped", ErrInvalidRequest, key)
+			}
+		default:
+			return fmt.Errorf("%w: input_modes.%s: mode must be auto, download, url or pipe", ErrInvalidRequest, key)
+		}
+	}
+	return nil
+}
+
+// isPipeable reports whether an input file can be piped to FFMPEG: a pipe
+// can only be read once, by one command
+func isPipeable(req domain.FFMPEGRequest, key string) bool {
+	if len(req.Steps) > 0 {
+		return false
+	}
+	uses := 0
+	for _, arg := range parseCommandArgs(commandTemplate(req)) {
+		if inputKey, ok := placeholderKey(arg.value); ok && arg.kind == commandArgInput && inputKey == key {
+			uses++
+		}
+	}
+	return uses == 1
+}
+
+// streamInput prepares an input file of a job to be streamed to FFMPEG as
+// its input mode says, source being what resolveInput returned for it. It
+// returns the argument FFMPEG reads the input from and its size, or -1 if
+// unknown, or "" if the input is to be downloaded. Inputs in auto mode are
+// streamed from FFMPEG_STREAM_INPUT_MIN_SIZE_MB on, over HTTP(S) if the
+// storage has URLs for them and otherwise piped if they can be.
+func (s *FFMPEGServiceImpl) streamInput(ctx context.Context, req domain.FFMPEGRequest, key string, source string, streams *streamedInputs) (string, int64, error) {
+	mode := req.InputModes[key]
+	explicit := mode == domain.InputModeURL || mode == domain.InputModePipe
+	if mode == domain.InputModeDownload || (!explicit && s.config.FFMPEG.StreamInputMinSize <= 0) {
+		return "", 0, nil
+	}
+	size, err := s.storageService.StatFile(ctx, source)
+	if !explicit && (err != nil || size < s.config.FFMPEG.StreamInputMinSize) {
+		// Inputs that can't be stat'ed are downloaded, which reports why
+		return "", 0, nil
+	}
+	if err != nil {
+		size = -1
+	}
+
+	if mode != domain.InputModePipe {
+		url, err := s.storageService.StreamURL(ctx, source)
+		switch {
+		case err == nil:
+			streams.protocols[url] = urlInputProtocols
+			return url, size, nil
+		case mode == domain.InputModeURL || !errors.Is(err, ErrNoStreamURL):
+			return "", 0, err
+		case !isPipeable(req, key):
+			return "", 0, nil
+		}
+	}
+
+	r, err := s.storageService.OpenFile(ctx, source)
+	if err != nil {
+		return "", 0, err
+	}
+	arg := "pipe:" + strconv.Itoa(firstPipeFD+len(streams.pipes))
+	streams.pipes = append(streams.pipes, r)
+	streams.protocols[arg] = pipeInputProtocols
+	return arg, size, nil
+}
+
+// openPipes creates a pipe for every piped input, returning the read ends to
+// pass to FFMPEG in order and the write ends to feed the inputs into
+func (s *streamedInputs) openPipes() (readEnds, writeEnds []*os.File, err error) {
+	if s == nil {
+		return nil, nil, nil
+	}
+	for range s.pipes {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closeFiles(readEnds)
+			closeFiles(writeEnds)
+			return nil, nil, fmt.Errorf("failed to create input pipe: %v", err)
+		}
+		readEnds = append(readEnds, r)
+		writeEnds = append(writeEnds, w)
+	}
+	return readEnds, writeEnds, nil
+}
+
+// feed copies the piped inputs into the write ends of their pipes, closing
+// each once its input ends or FFMPEG stops reading it. The returned function
+// is called once FFMPEG has exited: it closes the inputs and returns the
+// errors reading them before, which cut them short.
+func (s *streamedInputs) feed(writeEnds []*os.File) func() error {
+	if s == nil {
+		return func() error { return nil }
+	}
+	var exited atomic.Bool
+	var wg sync.WaitGroup
+	sources := make([]*pipeSource, len(writeEnds))
+	for i, w := range writeEnds {
+		sources[i] = &pipeSource{r: s.pipes[i], exited: &exited}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer w.Close()
+			// Writing fails once FFMPEG stops reading, which it may
+			io.Copy(w, sources[i])
+		}()
+	}
+	return func() error {
+		exited.Store(true)
+		s.Close()
+		wg.Wait()
+		var errs []error
+		for _, source := range sources {
+			if source.err != nil {
+				errs = append(errs, source.err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// Close closes the piped inputs
+func (s *streamedInputs) Close() {
+	if s == nil {
+		return
+	}
+	for _, r := range s.pipes {
+		r.Close()
+	}
+}
+
+// pipeSource reads a piped input, remembering an error reading it while
+// FFMPEG is running
+type pipeSource struct {
+	r      io.Reader
+	exited *atomic.Bool
+	err    error
+}
+
+func (p *pipeSource) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF && !p.exited.Load() {
+		p.err = err
+	}
+	return n, err
+}
+
+// closeFiles closes files, ignoring errors
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"ffmpeg-api/internal/domain"
+	"io"
 )
 
 // AuthService defines the interface for authentication-related operations
@@ -14,13 +15,118 @@ type AuthService interface {
 
 // FFMPEGService defines the interface for FFMPEG processing operations
 type FFMPEGService interface {
+	Start(ctx context.Context)
+	RecoverJobs(ctx context.Context) error
 	ProcessVideo(ctx context.Context, req domain.FFMPEGRequest, userID uint) (*domain.FFMPEGResponse, error)
+	SubmitABR(ctx context.Context, req domain.FFMPEGRequest, userID uint) (*domain.FFMPEGResponse, error)
+	SubmitStoryboard(ctx context.Context, req domain.FFMPEGRequest, userID uint) (*domain.FFMPEGResponse, error)
+	SubmitBatch(ctx context.Context, batch domain.BatchRequest, userID uint) (*domain.BatchResult, error)
+	GetBatchStatus(ctx context.Context, batchID string, userID uint) (*domain.BatchStatus, error)
 	GetJobStatus(ctx context.Context, uuid string, userID uint) (*domain.JobStatus, error)
+	Probe(ctx context.Context, url string, userID uint) (*domain.ProbeResult, error)
+	SubscribeProgress(ctx context.Context, uuid string, userID uint) (*domain.JobStatus, <-chan domain.ProgressEvent, func(), error)
+	ListJobs(ctx context.Context, filter domain.JobFilter, cursor string) (*domain.JobList, error)
+	CancelJob(ctx context.Context, uuid string, userID uint) (*domain.JobStatus, error)
+	// GetFile returns an output file of the user's jobs by its file ID with
+	// its URLs issued anew
+	GetFile(ctx context.Context, fileID string, userID uint) (*domain.JobFile, error)
+	// GetPlaylist returns a playlist of an output in private storage by the
+	// parameters of its signed URL, referring to files by URLs issued anew
+	GetPlaylist(ctx context.Context, userID uint, fileID, filePath, expires, signature string) ([]byte, error)
+}
+
+// PresetService defines the interface for managing FFMPEG command presets
+type PresetService interface {
+	ListPresets(ctx context.Context, user *domain.User) ([]domain.Preset, error)
+	GetPreset(ctx context.Context, id uint, user *domain.User) (*domain.Preset, error)
+	CreatePreset(ctx context.Context, preset *domain.Preset, global bool, user *domain.User) error
+	UpdatePreset(ctx context.Context, id uint, update domain.Preset, user *domain.User) (*domain.Preset, error)
+	DeletePreset(ctx context.Context, id uint, user *domain.User) error
+}
+
+// UploadService defines the interface for uploading input files to storage
+type UploadService interface {
+	Start(ctx context.Context)
+	CreateUpload(ctx context.Context, r io.Reader, fileName string, contentType string, userID uint) (*domain.Upload, error)
+	GetUpload(ctx context.Context, uuid string, userID uint) (*domain.Upload, error)
+	CreateResumableUpload(ctx context.Context, length int64, fileName string, contentType string, metadata string, userID uint) (*domain.Upload, error)
+	GetResumableUpload(ctx context.Context, uuid string, userID uint) (*domain.Upload, error)
+	WriteChunk(ctx context.Context, uuid string, offset int64, r io.Reader, checksum string, userID uint) (*domain.Upload, error)
+	TerminateUpload(ctx context.Context, uuid string, userID uint) error
+	MaxSize() int64
+}
+
+// StorageCredentialService defines the interface for managing the storage
+// credentials jobs deliver outputs to other buckets with
+type StorageCredentialService interface {
+	ListCredentials(ctx context.Context, userID uint) ([]domain.StorageCredential, error)
+	GetCredential(ctx context.Context, name string, userID uint) (*domain.StorageCredential, error)
+	CreateCredential(ctx context.Context, credential *domain.StorageCredential) error
+	DeleteCredential(ctx context.Context, name string, userID uint) error
+	// ResolveDestination validates an output destination of the user and
+	// returns the bucket it delivers to, accessed with the named credential
+	ResolveDestination(ctx context.Context, dest domain.OutputDestination, userID uint) (*Destination, error)
+}
+
+// WebhookService defines the interface for notifying users about job status changes
+type WebhookService interface {
+	// Start launches the loop that makes scheduled delivery attempts
+	Start(ctx context.Context)
+	// CheckURL returns an error if webhooks can't be delivered to a URL
+	CheckURL(rawURL string) error
+	Notify(ctx context.Context, job *domain.JobStatus)
+	ListDeliveries(ctx context.Context, jobUUID string, userID uint) ([]domain.WebhookDelivery, error)
+	Redeliver(ctx context.Context, jobUUID string, deliveryID uint, userID uint) (*domain.WebhookDelivery, error)
 }
 
 // StorageService defines the interface for file storage operations
 type StorageService interface {
 	DownloadFile(ctx context.Context, url string) (string, error)
 	UploadFile(ctx context.Context, localPath string, objectKey string, userID uint) (string, error)
+	// UploadFileTo uploads a file as objectKey under the prefix of a
+	// destination bucket rather than to the user's storage and returns its URL
+	UploadFileTo(ctx context.Context, localPath string, objectKey string, dest *Destination) (string, error)
+	// UploadStream uploads what r yields under objectKey in the user's
+	// storage, like UploadFile, for data that isn't on disk. size is -1 if it
+	// is unknown.
+	UploadStream(ctx context.Context, r io.Reader, size int64, objectKey string, contentType string, userID uint) (string, error)
+	// CreateMultipartUpload starts writing an object of the user in parts,
+	// possibly over several requests, and returns the ID of the upload
+	CreateMultipartUpload(ctx context.Context, objectKey string, contentType string, userID uint) (string, error)
+	// UploadPart writes part number n, counted from 1, of a multipart upload.
+	// The part starts offset bytes into the object; every part but the last
+	// is at least 5 MB. Writing a part again replaces it.
+	UploadPart(ctx context.Context, objectKey string, uploadID string, n int, offset int64, r io.Reader, size int64, userID uint) error
+	// CompleteMultipartUpload assembles the parts written into the object and
+	// returns its URL
+	CompleteMultipartUpload(ctx context.Context, objectKey string, uploadID string, userID uint) (string, error)
+	// AbortMultipartUpload discards the parts of a multipart upload
+	AbortMultipartUpload(ctx context.Context, objectKey string, uploadID string, userID uint) error
 	DeleteFile(ctx context.Context, localPath string) error
+	// StatFile returns the size in bytes of a file DownloadFile would fetch,
+	// or -1 if the storage does not report it
+	StatFile(ctx context.Context, url string) (int64, error)
+	// OpenFile opens a file DownloadFile would fetch to read it as it arrives
+	OpenFile(ctx context.Context, url string) (io.ReadCloser, error)
+	// StreamURL returns an http(s) URL FFMPEG can read a file DownloadFile
+	// would fetch from, with range requests. URLs of objects are presigned
+	// for a day. It returns ErrNoStreamURL if the storage has no such URLs.
+	StreamURL(ctx context.Context, url string) (string, error)
+	// ObjectURL returns a URL of the user's object with the given key. If
+	// storage is private, the URL is presigned and expires.
+	ObjectURL(ctx context.Context, objectKey string, userID uint) (string, error)
+	// ObjectExists reports whether the user has an object with the given key
+	ObjectExists(ctx context.Context, objectKey string, userID uint) (bool, error)
+	// DeleteObject deletes the user's object with the given key, if it exists
+	DeleteObject(ctx context.Context, objectKey string, userID uint) error
+}
+
+// ServedStorage is implemented by storage services whose objects this server
+// serves for download at /api/v1/storage/<key>
+type ServedStorage interface {
+	// ObjectPath returns the local path of the object with the given key
+	ObjectPath(key string) (string, error)
+	// CheckSignature reports whether the expires and signature parameters of
+	// a URL allow downloading the object with the given key
+	CheckSignature(key, expires, signature string) bool
 }
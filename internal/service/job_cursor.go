@@ -0,0 +1,26 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"ffmpeg-api/internal/domain"
+)
+
+// encodeJobCursor encodes a cursor into an opaque, URL-safe string
+func encodeJobCursor(cursor domain.JobCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeJobCursor decodes a cursor produced by encodeJobCursor
+func decodeJobCursor(value string) (*domain.JobCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor domain.JobCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
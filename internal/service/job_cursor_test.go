@@ -0,0 +1,45 @@
+package service
+
+import (
+	"encoding/base64"
+	"ffmpeg-api/internal/domain"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobCursorRoundTrip(t *testing.T) {
+	cursors := []domain.JobCursor{
+		{CreatedAt: time.Date(2026, 10, 18, 4, 24, 43, 910906635, time.UTC), ID: 42},
+		{CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.FixedZone("CEST", 2*60*60)), ID: 1},
+		{},
+	}
+	for _, cursor := range cursors {
+		encoded := encodeJobCursor(cursor)
+		if strings.ContainsAny(encoded, "+/=") {
+			t.Errorf("encodeJobCursor(%+v) = %q, want a URL-safe string without padding", cursor, encoded)
+		}
+		decoded, err := decodeJobCursor(encoded)
+		if err != nil {
+			t.Fatalf("decodeJobCursor(%q) failed: %v", encoded, err)
+		}
+		if !decoded.CreatedAt.Equal(cursor.CreatedAt) || decoded.ID != cursor.ID {
+			t.Errorf("decodeJobCursor(encodeJobCursor(%+v)) = %+v", cursor, *decoded)
+		}
+	}
+}
+
+func TestDecodeJobCursorInvalid(t *testing.T) {
+	values := []string{
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte(`{"v":"2026-10-18T04:24:43Z","id":1}`)), // padded
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"v":"yesterday","id":1}`)),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"v":"2026-10-18T04:24:43Z","id":-1}`)),
+	}
+	for _, value := range values {
+		if cursor, err := decodeJobCursor(value); err == nil {
+			t.Errorf("decodeJobCursor(%q) = %+v, want an error", value, *cursor)
+		}
+	}
+}
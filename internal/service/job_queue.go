@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/repository"
+	"time"
+)
+
+// JobHandler processes a job that has been claimed from the queue
+type JobHandler func(ctx context.Context, job *domain.JobStatus)
+
+// JobQueue runs queued jobs on a fixed number of workers. Jobs are claimed
+// from the JobRepository in FIFO order, so queued work survives a restart and
+// several nodes can share the same database.
+type JobQueue struct {
+	jobRepo      repository.JobRepository
+	node         string
+	workers      int
+	pollInterval time.Duration
+	handler      JobHandler
+	wake         chan struct{}
+}
+
+// NewJobQueue creates a new JobQueue
+func NewJobQueue(jobRepo repository.JobRepository, node string, workers int, pollInterval time.Duration, handler JobHandler) *JobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	return &JobQueue{
+		jobRepo:      jobRepo,
+		node:         node,
+		workers:      workers,
+		pollInterval: pollInterval,
+		handler:      handler,
+		wake:         make(chan struct{}, workers),
+	}
+}
+
+// Start launches the workers. They stop when ctx is cancelled.
+func (q *JobQueue) Start(ctx context.Context) {
+	logger.Info("starting job queue", "node", q.node, "workers", q.workers)
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx, i)
+	}
+}
+
+// Notify wakes an idle worker so a freshly queued job starts without waiting
+// for the next poll.
+func (q *JobQueue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *JobQueue) work(ctx context.Context, id int) {
+	for {
+		job, err := q.jobRepo.ClaimNextQueued(ctx, q.node)
+		if err != nil {
+			logger.Error("failed to claim queued job", "worker", id, "error", err)
+		}
+		if job != nil {
+			logger.Debug("worker claimed job", "worker", id, "uuid", job.UUID)
+			q.handler(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"ffmpeg-api/internal/domain"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ffprobeOutput is the JSON written by ffprobe -show_format -show_streams.
+// ffprobe writes most numbers as strings, and omits values it doesn't know.
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		StartTime  string `json:"start_time"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		Index         int               `json:"index"`
+		CodecType     string            `json:"codec_type"`
+		CodecName     string            `json:"codec_name"`
+		Duration      string            `json:"duration"`
+		BitRate       string            `json:"bit_rate"`
+		Width         int               `json:"width"`
+		Height        int               `json:"height"`
+		AvgFrameRate  string            `json:"avg_frame_rate"`
+		RFrameRate    string            `json:"r_frame_rate"`
+		PixFmt        string            `json:"pix_fmt"`
+		SampleRate    string            `json:"sample_rate"`
+		Channels      int               `json:"channels"`
+		ChannelLayout string            `json:"channel_layout"`
+		Tags          map[string]string `json:"tags"`
+		SideDataList  []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// localInputProtocols is the protocol whitelist ffprobe opens local files
+// with, so playlists and other files referring to further files can't make it
+// read files of other jobs or fetch URLs
+const localInputProtocols = "file"
+
+// probeMedia runs ffprobe on a file, restricted to the protocols of the
+// protocols whitelist, and returns its normalized description
+func probeMedia(ctx context.Context, probePath, path, protocols string) (*domain.MediaProbe, error) {
+	cmd := exec.CommandContext(ctx, probePath,
+		"-v", "error",
+		"-protocol_whitelist", protocols,
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ffprobe failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var raw ffprobeOutput
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	probe := &domain.MediaProbe{
+		Format: domain.MediaFormat{
+			Name:      raw.Format.FormatName,
+			Duration:  parseProbeFloat(raw.Format.Duration),
+			StartTime: parseProbeFloat(raw.Format.StartTime),
+			Size:      parseProbeInt(raw.Format.Size),
+			BitRate:   parseProbeInt(raw.Format.BitRate),
+		},
+		Streams: make([]domain.MediaStream, 0, len(raw.Streams)),
+	}
+	for _, stream := range raw.Streams {
+		frameRate := parseFrameRate(stream.AvgFrameRate)
+		if frameRate == 0 {
+			frameRate = parseFrameRate(stream.RFrameRate)
+		}
+
+		// Newer FFmpeg versions report the display matrix rotation as side
+		// data, older ones as a "rotate" tag
+		rotation := int(parseProbeInt(stream.Tags["rotate"]))
+		for _, sideData := range stream.SideDataList {
+			if sideData.Rotation != 0 {
+				rotation = int(sideData.Rotation)
+			}
+		}
+
+		probe.Streams = append(probe.Streams, domain.MediaStream{
+			Index:         stream.Index,
+			CodecType:     stream.CodecType,
+			CodecName:     stream.CodecName,
+			Duration:      parseProbeFloat(stream.Duration),
+			BitRate:       parseProbeInt(stream.BitRate),
+			Width:         stream.Width,
+			Height:        stream.Height,
+			FrameRate:     frameRate,
+			PixFmt:        stream.PixFmt,
+			Rotation:      rotation,
+			SampleRate:    int(parseProbeInt(stream.SampleRate)),
+			Channels:      stream.Channels,
+			ChannelLayout: stream.ChannelLayout,
+		})
+	}
+
+	return probe, nil
+}
+
+// probeDuration returns the duration of a probed file in seconds, falling
+// back to its longest stream when the container doesn't know it
+func probeDuration(probe *domain.MediaProbe) float64 {
+	if probe.Format.Duration > 0 {
+		return probe.Format.Duration
+	}
+	var duration float64
+	for _, stream := range probe.Streams {
+		duration = max(duration, stream.Duration)
+	}
+	return duration
+}
+
+// applyProbe fills the output file metadata from its probe. Files whose type
+// is unknown from their extension get it from their streams.
+func applyProbe(metadata *domain.OutputFileMetadata, probe *domain.MediaProbe) {
+	metadata.Duration = probeDuration(probe)
+	metadata.Container = probe.Format.Name
+	metadata.BitRate = probe.Format.BitRate
+	metadata.Streams = probe.Streams
+
+	var hasVideo, hasAudio bool
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if !hasVideo {
+				metadata.Width = stream.Width
+				metadata.Height = stream.Height
+			}
+			hasVideo = true
+		case "audio":
+			hasAudio = true
+		}
+	}
+
+	if metadata.FileType == "unknown" {
+		switch {
+		case hasVideo:
+			metadata.FileType = "video"
+		case hasAudio:
+			metadata.FileType = "audio"
+		}
+	}
+}
+
+// parseFrameRate parses a frame rate written by ffprobe as a fraction, e.g.
+// "30000/1001", returning 0 when it is unknown ("0/0")
+func parseFrameRate(value string) float64 {
+	num, den, ok := strings.Cut(value, "/")
+	if !ok {
+		return parseProbeFloat(value)
+	}
+	d := parseProbeFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return math.Round(parseProbeFloat(num)/d*1000) / 1000
+}
+
+// parseProbeFloat parses a number written by ffprobe, returning 0 for missing
+// or unknown ("N/A") values
+func parseProbeFloat(value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// parseProbeInt parses an integer written by ffprobe, returning 0 for missing
+// or unknown ("N/A") values
+func parseProbeInt(value string) int64 {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// memoryURLPrefix starts the URLs of objects kept in memory
+const memoryURLPrefix = "memory://"
+
+// MemoryStorageService implements StorageService keeping objects in memory,
+// for tests. Objects are lost when the server stops and can't be downloaded
+// by their URLs, but jobs can use them as inputs by key.
+type MemoryStorageService struct {
+	config *config.Config
+
+	mu      sync.RWMutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte // parts of multipart uploads by upload ID
+}
+
+// NewMemoryStorageService creates a new MemoryStorageService
+func NewMemoryStorageService(config *config.Config) StorageService {
+	return &MemoryStorageService{
+		config:  config,
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+}
+
+func (s *MemoryStorageService) DownloadFile(ctx context.Context, url string) (string, error) {
+	logger.Debug("downloading file", "url", url)
+
+	tmpFile, err := os.CreateTemp(s.config.Storage.TempDirectory, "input-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tmpFile.Close()
+
+	// Anything but an external URL is the key of an object
+	var src io.Reader
+	if isExternalURL(url) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", fmt.Errorf("failed to download file: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			os.Remove(tmpFile.Name())
+			return "", fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+		}
+		src = resp.Body
+	} else {
+		object, err := s.object(url)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", err
+		}
+		src = bytes.NewReader(object)
+	}
+
+	if _, err := io.Copy(tmpFile, &contextReader{ctx: ctx, r: src}); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to save file: %w", err)
+	}
+	return tmpFile.Name(), nil
+}
+
+func (s *MemoryStorageService) UploadFile(ctx context.Context, localPath string, objectKey string, userID uint) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer file.Close()
+
+	return s.UploadStream(ctx, file, -1, objectKey, "", userID)
+}
+
+func (s *MemoryStorageService) UploadStream(ctx context.Context, r io.Reader, size int64, objectKey string, contentType string, userID uint) (string, error) {
+	data, err := io.ReadAll(&contextReader{ctx: ctx, r: r})
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	userObjectKey := fmt.Sprintf("user_%d/%s", userID, objectKey)
+	s.mu.Lock()
+	s.objects[userObjectKey] = data
+	s.mu.Unlock()
+	return memoryURLPrefix + userObjectKey, nil
+}
+
+func (s *MemoryStorageService) CreateMultipartUpload(ctx context.Context, objectKey string, contentType string, userID uint) (string, error) {
+	uploadID := uuid.New().String()
+	s.mu.Lock()
+	s.uploads[uploadID] = make(map[int][]byte)
+	s.mu.Unlock()
+	return uploadID, nil
+}
+
+func (s *MemoryStorageService) UploadPart(ctx context.Context, objectKey string, uploadID string, n int, offset int64, r io.Reader, size int64, userID uint) error {
+	data, err := io.ReadAll(&contextReader{ctx: ctx, r: r})
+	if err != nil {
+		return fmt.Errorf("failed to read part %d: %w", n, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts, ok := s.uploads[uploadID]
+	if !ok {
+		return fmt.Errorf("multipart upload %s not found", uploadID)
+	}
+	parts[n] = data
+	return nil
+}
+
+func (s *MemoryStorageService) CompleteMultipartUpload(ctx context.Context, objectKey string, uploadID string, userID uint) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts, ok := s.uploads[uploadID]
+	if !ok {
+		return "", fmt.Errorf("multipart upload %s not found", uploadID)
+	}
+
+	var object []byte
+	for _, n := range slices.Sorted(maps.Keys(parts)) {
+		object = append(object, parts[n]...)
+	}
+	userObjectKey := fmt.Sprintf("user_%d/%s", userID, objectKey)
+	s.objects[userObjectKey] = object
+	delete(s.uploads, uploadID)
+	return memoryURLPrefix + userObjectKey, nil
+}
+
+func (s *MemoryStorageService) AbortMultipartUpload(ctx context.Context, objectKey string, uploadID string, userID uint) error {
+	s.mu.Lock()
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStorageService) DeleteFile(ctx context.Context, localPath string) error {
+	if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (s *MemoryStorageService) StatFile(ctx context.Context, url string) (int64, error) {
+	if isExternalURL(url) {
+		return statURL(ctx, url)
+	}
+
+	object, err := s.object(url)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(object)), nil
+}
+
+func (s *MemoryStorageService) OpenFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	if isExternalURL(url) {
+		return openURL(ctx, url)
+	}
+	object, err := s.object(url)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(object)), nil
+}
+
+func (s *MemoryStorageService) StreamURL(ctx context.Context, url string) (string, error) {
+	if isExternalURL(url) {
+		return url, nil
+	}
+	return "", ErrNoStreamURL
+}
+
+func (s *MemoryStorageService) ObjectURL(ctx context.Context, objectKey string, userID uint) (string, error) {
+	return fmt.Sprintf("%suser_%d/%s", memoryURLPrefix, userID, objectKey), nil
+}
+
+func (s *MemoryStorageService) ObjectExists(ctx context.Context, objectKey string, userID uint) (bool, error) {
+	_, err := s.object(fmt.Sprintf("user_%d/%s", userID, objectKey))
+	return err == nil, nil
+}
+
+func (s *MemoryStorageService) DeleteObject(ctx context.Context, objectKey string, userID uint) error {
+	s.mu.Lock()
+	delete(s.objects, fmt.Sprintf("user_%d/%s", userID, objectKey))
+	s.mu.Unlock()
+	return nil
+}
+
+// object returns the object with the given key
+func (s *MemoryStorageService) object(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", key)
+	}
+	return object, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// keyPlaceholderRegexp matches the placeholders of object key templates
+var keyPlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// keyPlaceholders are the placeholders object key templates may use
+var keyPlaceholders = []string{"{job}", "{key}", "{file_id}", "{path}", "{filename}", "{ext}", "{sha256}"}
+
+// checkKeyTemplate returns an error if an object key template uses unknown
+// placeholders or doesn't keep the keys of output files apart: they must
+// differ between jobs ({job}, {file_id} or {sha256}) and between the files of
+// a job ({path}, {filename} or {sha256}). Deduplicating outputs needs keys
+// that only depend on the content of files.
+func checkKeyTemplate(template string, dedup bool) error {
+	for _, placeholder := range keyPlaceholderRegexp.FindAllString(template, -1) {
+		if !slices.Contains(keyPlaceholders, placeholder) {
+			return fmt.Errorf("STORAGE_KEY_TEMPLATE: unknown placeholder %s, use %s", placeholder, strings.Join(keyPlaceholders, ", "))
+		}
+	}
+	uses := func(placeholders ...string) bool {
+		return slices.ContainsFunc(placeholders, func(placeholder string) bool {
+			return strings.Contains(template, placeholder)
+		})
+	}
+	switch {
+	case strings.HasPrefix(template, "/") || strings.HasSuffix(template, "/"):
+		return errors.New("STORAGE_KEY_TEMPLATE must not start or end with '/'")
+	case !uses("{job}", "{file_id}", "{sha256}"):
+		return errors.New("STORAGE_KEY_TEMPLATE must contain {job}, {file_id} or {sha256} so jobs don't overwrite each other's outputs")
+	case !uses("{path}", "{filename}", "{sha256}"):
+		return errors.New("STORAGE_KEY_TEMPLATE must contain {path}, {filename} or {sha256} so the files of a job don't overwrite each other")
+	case dedup && (!uses("{sha256}") || uses("{job}", "{key}", "{file_id}")):
+		return errors.New("STORAGE_DEDUP needs a STORAGE_KEY_TEMPLATE made of {sha256} and the file name, such as sha256/{sha256}{ext}")
+	}
+	return nil
+}
+
+// expandKeyTemplate returns the object key of an output file of a job, file
+// being its path relative to the job's directory
+func expandKeyTemplate(template, jobUUID, outputKey, fileID, file, checksum string) string {
+	return strings.NewReplacer(
+		"{job}", jobUUID,
+		"{key}", outputKey,
+		"{file_id}", fileID,
+		"{path}", file,
+		"{filename}", path.Base(file),
+		"{ext}", path.Ext(file),
+		"{sha256}", checksum,
+	).Replace(template)
+}
+
+// contentTypes maps the extensions of media files to their content types,
+// for the formats Go's mime package doesn't know or gets wrong
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+	".mp3":  "audio/mpeg",
+	".aac":  "audio/aac",
+	".m4a":  "audio/mp4",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".opus": "audio/ogg",
+	".ogg":  "audio/ogg",
+	".vtt":  "text/vtt",
+	".srt":  "application/x-subrip",
+}
+
+// ContentType returns the content type of a file by the extension of its name
+func ContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := contentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// ContentDisposition returns the Content-Disposition of a file with the given
+// name, displayed inline and saved under the name
+func ContentDisposition(name string) string {
+	return mime.FormatMediaType("inline", map[string]string{"filename": name})
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckKeyTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		dedup    bool
+		want     string // part of the error, empty for none
+	}{
+		{"default", "{job}/{path}", false, ""},
+		{"file ID and file name", "outputs/{file_id}/{filename}", false, ""},
+		{"checksum alone", "{sha256}", false, ""},
+		{"output key and path", "{job}/{key}/{path}", false, ""},
+		{"unknown placeholder", "{job}/{name}", false, "unknown placeholder {name}"},
+		{"leading slash", "/{job}/{path}", false, "must not start or end with '/'"},
+		{"trailing slash", "{job}/{path}/", false, "must not start or end with '/'"},
+		{"nothing per job", "outputs/{path}", false, "{job}, {file_id} or {sha256}"},
+		{"output key is not per job", "{key}/{path}", false, "{job}, {file_id} or {sha256}"},
+		{"nothing per file", "{job}/{key}{ext}", false, "{path}, {filename} or {sha256}"},
+
+		{"dedup by checksum and extension", "sha256/{sha256}{ext}", true, ""},
+		{"dedup by checksum and file name", "{sha256}/{filename}", true, ""},
+		{"dedup by checksum and path", "{sha256}/{path}", true, ""},
+		{"dedup without checksum", "{file_id}/{filename}", true, "STORAGE_DEDUP needs"},
+		{"dedup with job", "{job}/{sha256}{ext}", true, "STORAGE_DEDUP needs"},
+		{"dedup with output key", "{key}/{sha256}{ext}", true, "STORAGE_DEDUP needs"},
+		{"dedup with file ID", "{sha256}/{file_id}{ext}", true, "STORAGE_DEDUP needs"},
+		{"dedup with a leading slash", "/{sha256}{ext}", true, "must not start or end with '/'"},
+	}
+	for _, tt := range tests {
+		err := checkKeyTemplate(tt.template, tt.dedup)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: checkKeyTemplate(%q, %v) = %v, want no error", tt.name, tt.template, tt.dedup, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: checkKeyTemplate(%q, %v) = %v, want an error containing %q", tt.name, tt.template, tt.dedup, err, tt.want)
+		}
+	}
+}
+
+func TestExpandKeyTemplate(t *testing.T) {
+	got := expandKeyTemplate("{job}/{key}/{file_id}/{path}|{filename}|{ext}|{sha256}", "job-1", "hls", "file-1", "360p/segment_001.ts", "abc")
+	want := "job-1/hls/file-1/360p/segment_001.ts|segment_001.ts|.ts|abc"
+	if got != want {
+		t.Errorf("expandKeyTemplate() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// flagOptions are the FFmpeg options that don't take a value. Every other
+// option is assumed to be followed by one.
+var flagOptions = map[string]bool{
+	"-y": true, "-n": true, "-nostdin": true, "-hide_banner": true,
+	"-stats": true, "-nostats": true, "-shortest": true, "-re": true,
+	"-copyts": true, "-start_at_zero": true, "-an": true, "-vn": true,
+	"-sn": true, "-dn": true, "-accurate_seek": true, "-noaccurate_seek": true,
+	"-autorotate": true, "-noautorotate": true, "-autoscale": true, "-noautoscale": true,
+	"-ignore_unknown": true, "-copy_unknown": true, "-xerror": true, "-benchmark": true,
+	"-benchmark_all": true, "-debug_ts": true, "-stdin": true, "-vstats": true, "-report": true,
+}
+
+// filterOptions are the options whose value is a filter graph
+var filterOptions = map[string]bool{
+	"-filter_complex": true, "-lavfi": true, "-filter": true,
+	"-vf": true, "-filter:v": true, "-af": true, "-filter:a": true,
+}
+
+var (
+	concatFilterRegexp = regexp.MustCompile(`(^|[\],;\s])concat(=|\s*[\[,;]|$)`)
+	setptsScaleRegexp  = regexp.MustCompile(`setpts=\s*(?:([0-9.]+)\s*\*\s*PTS|PTS\s*\*\s*([0-9.]+)|PTS\s*/\s*([0-9.]+))`)
+	atempoRegexp       = regexp.MustCompile(`atempo=\s*([0-9.]+)`)
+)
+
+// commandInput is an input of an FFmpeg command with the options given for it
+type commandInput struct {
+	path    string
+	options map[string]string
+}
+
+// expectedOutputDuration estimates how many seconds of output the FFmpeg
+// command writes to its first output, given the durations of its input files
+// by path. It honours -ss, -t and -to on inputs and on the output, adds the
+// inputs up when they are joined by the concat filter, and scales the result
+// by setpts and atempo speed changes. It returns 0 when no estimate can be
+// made.
+func expectedOutputDuration(args []string, inputDurations map[string]float64) float64 {
+	var inputs []commandInput
+	options := make(map[string]string)
+	var filters []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-i" && i+1 < len(args) {
+			inputs = append(inputs, commandInput{path: args[i+1], options: options})
+			options = make(map[string]string)
+			i++
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			// The first output file, the options collected since the last
+			// input belong to it
+			break
+		}
+		if flagOptions[arg] {
+			options[arg] = ""
+			continue
+		}
+		if i+1 < len(args) {
+			options[arg] = args[i+1]
+			if filterOptions[arg] {
+				filters = append(filters, args[i+1])
+			}
+			i++
+		}
+	}
+
+	// Length of every input after its own trimming options
+	durations := make([]float64, 0, len(inputs))
+	for _, input := range inputs {
+		duration := inputDurations[input.path]
+		if duration <= 0 {
+			continue
+		}
+		durations = append(durations, trimDuration(duration, input.options))
+	}
+	if len(durations) == 0 {
+		return 0
+	}
+
+	graph := strings.Join(filters, ";")
+	var duration float64
+	switch {
+	case concatFilterRegexp.MatchString(graph):
+		for _, d := range durations {
+			duration += d
+		}
+	case hasOption(options, "-shortest"):
+		duration = durations[0]
+		for _, d := range durations[1:] {
+			duration = min(duration, d)
+		}
+	default:
+		for _, d := range durations {
+			duration = max(duration, d)
+		}
+	}
+
+	duration *= speedFactor(graph)
+	return trimDuration(duration, options)
+}
+
+// trimDuration applies the -ss, -t and -to options to a duration. -t takes
+// precedence over -to, as it does in FFmpeg.
+func trimDuration(duration float64, options map[string]string) float64 {
+	start, _ := parseFFmpegTime(options["-ss"])
+	if t, ok := parseFFmpegTime(options["-t"]); ok {
+		return max(min(duration-start, t), 0)
+	}
+	if to, ok := parseFFmpegTime(options["-to"]); ok {
+		return max(min(duration, to)-start, 0)
+	}
+	return max(duration-start, 0)
+}
+
+// speedFactor returns how much a filter graph stretches the timeline, e.g. 0.5
+// for "setpts=0.5*PTS" or "atempo=2". Video speed changes win over audio ones.
+func speedFactor(graph string) float64 {
+	if match := setptsScaleRegexp.FindStringSubmatch(graph); match != nil {
+		if f, err := strconv.ParseFloat(match[1]+match[2], 64); err == nil && f > 0 {
+			return f
+		}
+		if f, err := strconv.ParseFloat(match[3], 64); err == nil && f > 0 {
+			return 1 / f
+		}
+	}
+
+	factor := 1.0
+	for _, match := range atempoRegexp.FindAllStringSubmatch(graph, -1) {
+		if tempo, err := strconv.ParseFloat(match[1], 64); err == nil && tempo > 0 {
+			factor /= tempo
+		}
+	}
+	return factor
+}
+
+// hasOption reports whether an option was given
+func hasOption(options map[string]string, name string) bool {
+	_, ok := options[name]
+	return ok
+}
+
+// parseFFmpegTime parses an FFmpeg time duration, either "[-][HH:]MM:SS[.m...]"
+// or "[-]S+[.m...][s|ms|us]", into seconds
+func parseFFmpegTime(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if strings.Contains(value, ":") {
+		negative := strings.HasPrefix(value, "-")
+		parts := strings.Split(strings.TrimPrefix(value, "-"), ":")
+		if len(parts) > 3 {
+			return 0, false
+		}
+		var seconds float64
+		for _, part := range parts {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return 0, false
+			}
+			seconds = seconds*60 + n
+		}
+		if negative {
+			seconds = -seconds
+		}
+		return seconds, true
+	}
+
+	unit := 1.0
+	switch {
+	case strings.HasSuffix(value, "ms"):
+		value, unit = strings.TrimSuffix(value, "ms"), 1e-3
+	case strings.HasSuffix(value, "us"):
+		value, unit = strings.TrimSuffix(value, "us"), 1e-6
+	case strings.HasSuffix(value, "s"):
+		value = strings.TrimSuffix(value, "s")
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n * unit, true
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExpectedOutputDuration(t *testing.T) {
+	durations := map[string]float64{"a.mp4": 60, "b.mp4": 30}
+	tests := []struct {
+		name    string
+		command string
+		want    float64
+	}{
+		{"single input", "-y -i a.mp4 -an out.mp4", 60},
+		{"unknown input", "-i c.mp4 out.mp4", 0},
+		{"input -ss", "-ss 10 -i a.mp4 out.mp4", 50},
+		{"input -ss and -t", "-ss 10 -t 20 -i a.mp4 out.mp4", 20},
+		{"input -ss and -to", "-ss 10 -to 30 -i a.mp4 out.mp4", 20},
+		{"-t past the end", "-ss 50 -t 20 -i a.mp4 out.mp4", 10},
+		{"-ss past the end", "-ss 90 -i a.mp4 out.mp4", 0},
+		{"-t wins over -to", "-ss 5 -t 10 -to 50 -i a.mp4 out.mp4", 10},
+		{"output -ss and -t", "-i a.mp4 -ss 00:00:10 -t 15 out.mp4", 15},
+		{"output -to past the end", "-i a.mp4 -to 00:01:30 out.mp4", 60},
+		{"input and output trims", "-ss 10 -i a.mp4 -ss 5 -to 30 out.mp4", 25},
+		{"options after the output", "-i a.mp4 out.mp4 -t 5", 60},
+		{"longest input", "-i a.mp4 -i b.mp4 -map 0:v -map 1:a out.mp4", 60},
+		{"-shortest", "-i a.mp4 -i b.mp4 -shortest out.mp4", 30},
+		{"concat filter", "-i a.mp4 -i b.mp4 -filter_complex [0:v][1:v]concat=n=2:v=1:a=0[v] -map [v] out.mp4", 90},
+		{"concat of trimmed inputs", "-ss 10 -i a.mp4 -t 10 -i b.mp4 -filter_complex concat=n=2 out.mp4", 60},
+		{"setpts speed up", "-i a.mp4 -vf setpts=0.5*PTS out.mp4", 30},
+		{"setpts divided", "-i a.mp4 -filter:v setpts=PTS/2 out.mp4", 30},
+		{"setpts slow down then -t", "-i a.mp4 -vf setpts=PTS*2 -t 100 out.mp4", 100},
+		{"atempo", "-i a.mp4 -af atempo=2 out.mp4", 30},
+		{"chained atempo", "-i a.mp4 -af atempo=2,atempo=2 out.mp4", 15},
+		{"setpts wins over atempo", "-i a.mp4 -filter_complex [0:v]setpts=2*PTS[v];[0:a]atempo=0.5[a] out.mp4", 120},
+	}
+	for _, tt := range tests {
+		got := expectedOutputDuration(strings.Fields(tt.command), durations)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: expectedOutputDuration(%q) = %v, want %v", tt.name, tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestParseFFmpegTime(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   float64
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"90", 90, true},
+		{" 1.5 ", 1.5, true},
+		{"01:30", 90, true},
+		{"01:00:30.5", 3630.5, true},
+		{"-00:10", -10, true},
+		{"-2.5", -2.5, true},
+		{"2s", 2, true},
+		{"500ms", 0.5, true},
+		{"250000us", 0.25, true},
+		{"1:02:03:04", 0, false},
+		{"1:xx", 0, false},
+		{"ten", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseFFmpegTime(tt.value)
+		if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("parseFFmpegTime(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// isCollectionOutput reports whether an output file name designates several
+// files: a directory, written with a trailing '/', or a glob pattern such as
+// "hls/*.ts"
+func isCollectionOutput(name string) bool {
+	return strings.HasSuffix(name, "/") || strings.ContainsAny(name, "*?[")
+}
+
+// checkOutputName returns why an output file name can't be used, or "" if it
+// can. Names are '/' separated paths inside the job's directory.
+func checkOutputName(name string) string {
+	trimmed := strings.TrimSuffix(name, "/")
+	switch {
+	case trimmed == "" || trimmed == ".":
+		return "must name a file or directory"
+	case strings.Contains(name, `\`):
+		return "must separate directories with '/'"
+	case strings.HasPrefix(name, "/"):
+		return "must be a relative path"
+	case slices.Contains(strings.Split(trimmed, "/"), ".."):
+		return "may not leave the job directory"
+	case path.Clean(trimmed) != trimmed:
+		return "must not contain empty or '.' path segments"
+	case strings.HasSuffix(name, "/") && strings.ContainsAny(name, "*?["):
+		return "can't be both a directory and a glob pattern"
+	}
+	if _, err := path.Match(trimmed, ""); err != nil {
+		return "is not a valid glob pattern"
+	}
+	return ""
+}
+
+// outputDirectory returns the directory an output is written to, relative to
+// the job's directory. FFmpeg doesn't create directories, so they are created
+// before it runs.
+func outputDirectory(name string) string {
+	if strings.HasSuffix(name, "/") {
+		return strings.TrimSuffix(name, "/")
+	}
+	dir := path.Dir(name)
+	for dir != "." && strings.ContainsAny(dir, "*?[") {
+		dir = path.Dir(dir)
+	}
+	return dir
+}
+
+// collectOutputFiles returns the regular files a directory or glob output
+// designates, as sorted paths relative to the job's directory
+func collectOutputFiles(dir, name string) ([]string, error) {
+	var files []string
+	addFile := func(p string, mode fs.FileMode) {
+		if mode.IsRegular() {
+			if rel, err := filepath.Rel(dir, p); err == nil {
+				files = append(files, filepath.ToSlash(rel))
+			}
+		}
+	}
+
+	if strings.HasSuffix(name, "/") {
+		err := filepath.WalkDir(filepath.Join(dir, filepath.FromSlash(name)), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			addFile(p, d.Type())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			if info, err := os.Lstat(match); err == nil {
+				addFile(match, info.Mode())
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %q", name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// GetFile returns the output file of the user's jobs with the given file ID
+// with the URLs of the files in the user's storage issued anew, presigned if
+// storage is private. The playlists of private storage are served by
+// GetPlaylist, which refers to their files by presigned URLs issued anew too.
+// Files delivered to a destination keep their URLs.
+func (s *FFMPEGServiceImpl) GetFile(ctx context.Context, fileID string, userID uint) (*domain.JobFile, error) {
+	if _, err := uuid.Parse(fileID); err != nil {
+		return nil, ErrFileNotFound
+	}
+	job, err := s.jobRepo.FindByFileID(ctx, fileID, userID)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	_, files := jobObjects(job)
+
+	for key, metadata := range job.OutputFiles {
+		if metadata.FileID != fileID {
+			continue
+		}
+		file := &domain.JobFile{JobUUID: job.UUID, Key: key, OutputFileMetadata: metadata}
+		if s.config.Storage.Private && metadata.ObjectKey != "" {
+			// The URLs expire a little after this
+			expiresAt := time.Now().Add(s.config.Storage.URLExpiry)
+			file.ExpiresAt = &expiresAt
+		}
+
+		if metadata.ObjectKey != "" {
+			if file.StorageURL, err = s.objectURL(ctx, job, metadata.ObjectKey, files); err != nil {
+				return nil, err
+			}
+			if file.ManifestURL != "" {
+				file.ManifestURL = file.StorageURL
+			}
+		}
+		file.Files = slices.Clone(metadata.Files)
+		for i := range file.Files {
+			if file.Files[i].ObjectKey == "" {
+				continue
+			}
+			if file.Files[i].StorageURL, err = s.objectURL(ctx, job, file.Files[i].ObjectKey, files); err != nil {
+				return nil, err
+			}
+		}
+		return file, nil
+	}
+	return nil, ErrFileNotFound
+}
+
+// checkDestinations checks that the destinations of a request are for the
+// given output keys and that the user may deliver to them
+func (s *FFMPEGServiceImpl) checkDestinations(ctx context.Context, destinations map[string]domain.OutputDestination, outputKeys []string, userID uint) error {
+	for _, key := range slices.Sorted(maps.Keys(destinations)) {
+		if !slices.Contains(outputKeys, key) {
+			return fmt.Errorf("%w: destination %s: no output has this key", ErrInvalidRequest, key)
+		}
+		if _, err := s.credentialService.ResolveDestination(ctx, destinations[key], userID); err != nil {
+			return fmt.Errorf("%w: destination %s: %v", ErrInvalidRequest, key, err)
+		}
+	}
+	return nil
+}
+
+// uploadOutputs uploads the files of the job's outputs and records their
+// metadata in job.OutputFiles, returning their total size. Outputs are probed
+// before HLS playlists, DASH manifests and WebVTT storyboards are rewritten to
+// refer to the uploaded files, which are uploaded first. Files are uploaded
+// under the object keys STORAGE_KEY_TEMPLATE expands to; a file of several
+// outputs is named after the first. Files of outputs with a destination are
+// uploaded to its bucket. The object keys of the others are recorded so their
+// URLs can be issued anew, as those of private storage expire, and with
+// STORAGE_DEDUP they aren't uploaded again if their object exists.
+func (s *FFMPEGServiceImpl) uploadOutputs(ctx, jobCtx context.Context, job *domain.JobStatus, tempDir string, outputFiles map[string]string) (int64, error) {
+	keys := sortedKeys(outputFiles)
+	files := make(map[string][]string, len(keys))
+	fileIDs := make(map[string]string, len(keys))
+	outputKeys := make(map[string]string) // the first output of each file
+	var uploads []string
+	for _, key := range keys {
+		fileIDs[key] = uuid.New().String()
+		name := outputFiles[key]
+		files[key] = []string{name}
+		if isCollectionOutput(name) {
+			collected, err := collectOutputFiles(tempDir, name)
+			if err != nil {
+				return 0, fmt.Errorf("failed to collect output files for %s: %v", key, err)
+			}
+			files[key] = collected
+		}
+		for _, file := range files[key] {
+			if _, ok := outputKeys[file]; !ok {
+				outputKeys[file] = key
+				uploads = append(uploads, file)
+			}
+		}
+	}
+
+	localPath := func(file string) string {
+		return filepath.Join(tempDir, filepath.FromSlash(file))
+	}
+
+	// A file of several outputs goes to the destination of the first
+	destinations := make(map[string]*Destination)
+	if job.OriginalRequest != nil {
+		for _, key := range slices.Sorted(maps.Keys(job.OriginalRequest.Destinations)) {
+			dest, err := s.credentialService.ResolveDestination(jobCtx, job.OriginalRequest.Destinations[key], job.UserID)
+			if err != nil {
+				return 0, fmt.Errorf("destination %s: %v", key, err)
+			}
+			for _, file := range files[key] {
+				if destinations[file] == nil {
+					destinations[file] = dest
+				}
+			}
+		}
+	}
+
+	// ffprobe reads the segments of a playlist, so outputs are probed while
+	// they still refer to the local files
+	probes := make(map[string]*domain.MediaProbe, len(keys))
+	for _, key := range keys {
+		file := files[key][0]
+		if isCollectionOutput(outputFiles[key]) {
+			if file = findManifest(tempDir, files[key]); file == "" {
+				continue
+			}
+		}
+		probe, err := probeMedia(jobCtx, s.config.FFMPEG.ProbePath, localPath(file), localInputProtocols)
+		if err != nil {
+			logger.Warn("failed to probe output file", "uuid", job.UUID, "key", key, "error", err)
+			continue
+		}
+		probes[key] = probe
+	}
+
+	// Playlists and storyboards are uploaded after the files they refer to,
+	// and master playlists after their media playlists
+	sort.SliceStable(uploads, func(i, j int) bool {
+		return playlistRank(localPath(uploads[i])) < playlistRank(localPath(uploads[j]))
+	})
+
+	urls := make(map[string]string, len(uploads))
+	sizes := make(map[string]int64, len(uploads))
+	checksums := make(map[string]string, len(uploads))
+	objectKeys := make(map[string]string, len(uploads)) // of the files uploaded to the user's storage
+	keyFiles := make(map[string]string, len(uploads))   // the file uploaded under each object key
+	var totalOutputSize int64
+	for i, file := range uploads {
+		if refersToFiles(file) {
+			if err := rewriteReferences(tempDir, file, urls); err != nil {
+				return 0, fmt.Errorf("failed to rewrite the references of %s: %v", file, err)
+			}
+		}
+
+		info, err := os.Stat(localPath(file))
+		if err != nil {
+			return 0, fmt.Errorf("failed to get output file size for %s: %v", file, err)
+		}
+		sizes[file] = info.Size()
+		totalOutputSize += info.Size()
+
+		checksums[file], err = fileSHA256(localPath(file))
+		if err != nil {
+			return 0, fmt.Errorf("failed to compute checksum of output file %s: %v", file, err)
+		}
+
+		key := outputKeys[file]
+		objectKey := expandKeyTemplate(s.config.Storage.KeyTemplate, job.UUID, key, fileIDs[key], file, checksums[file])
+		// Files with the same content may share a content-addressed object
+		if other, ok := keyFiles[objectKey]; ok && checksums[other] != checksums[file] {
+			return 0, fmt.Errorf("output files %s and %s would both be stored as %s, STORAGE_KEY_TEMPLATE needs {path} to keep them apart", other, file, objectKey)
+		}
+		keyFiles[objectKey] = file
+
+		if dest := destinations[file]; dest != nil {
+			urls[file], err = s.storageService.UploadFileTo(jobCtx, localPath(file), objectKey, dest)
+		} else {
+			urls[file], err = s.uploadOutputFile(jobCtx, localPath(file), objectKey, job.UserID)
+			objectKeys[file] = objectKey
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to upload output file %s: %v", file, err)
+		}
+
+		// Update progress for upload phase (75-99%)
+		job.Progress = 75 + int(float64(i+1)/float64(len(uploads))*24)
+		s.saveProgress(ctx, job)
+	}
+
+	job.OutputFiles = make(map[string]domain.OutputFileMetadata, len(keys))
+	for _, key := range keys {
+		metadata := domain.OutputFileMetadata{FileID: fileIDs[key]}
+
+		file := files[key][0]
+		if isCollectionOutput(outputFiles[key]) {
+			var size int64
+			for _, f := range files[key] {
+				size += sizes[f]
+				metadata.Files = append(metadata.Files, domain.OutputFile{
+					Path:       f,
+					StorageURL: urls[f],
+					ObjectKey:  objectKeys[f],
+					SizeBytes:  sizes[f],
+					SHA256:     checksums[f],
+				})
+			}
+			metadata.SizeMBytes = float64(size) / 1024 / 1024
+			if manifest := findManifest(tempDir, files[key]); manifest != "" {
+				file = manifest
+				metadata.StorageURL = urls[manifest]
+				metadata.ObjectKey = objectKeys[manifest]
+				metadata.SHA256 = checksums[manifest]
+			}
+		} else {
+			metadata.SizeMBytes = float64(sizes[file]) / 1024 / 1024
+			metadata.StorageURL = urls[file]
+			metadata.ObjectKey = objectKeys[file]
+			metadata.SHA256 = checksums[file]
+		}
+		if isPlaylist(file) {
+			metadata.ManifestURL = urls[file]
+		}
+
+		metadata.FileFormat = strings.TrimPrefix(path.Ext(file), ".")
+		metadata.FileType = fileTypeForFormat(metadata.FileFormat)
+		if probe := probes[key]; probe != nil {
+			applyProbe(&metadata, probe)
+		}
+		if metadata.FileType == "image" && metadata.Width == 0 {
+			// Fall back to decoding the image header
+			metadata.Width, metadata.Height = imageDimensions(localPath(file))
+		}
+
+		job.OutputFiles[key] = metadata
+	}
+	return totalOutputSize, nil
+}
+
+// uploadOutputFile uploads an output file to the user's storage and returns
+// its URL. With STORAGE_DEDUP, a file whose content-addressed object exists
+// is not uploaded again.
+func (s *FFMPEGServiceImpl) uploadOutputFile(ctx context.Context, localPath string, objectKey string, userID uint) (string, error) {
+	if s.config.Storage.Dedup {
+		exists, err := s.storageService.ObjectExists(ctx, objectKey, userID)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			logger.Debug("output file already stored", "object_key", objectKey, "user_id", userID)
+			return s.storageService.ObjectURL(ctx, objectKey, userID)
+		}
+	}
+	return s.storageService.UploadFile(ctx, localPath, objectKey, userID)
+}
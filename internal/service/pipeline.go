@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// stepIDRegexp matches the IDs of pipeline steps. They can't contain '.',
+// which separates the step from the output key in {{step.key}} references.
+var stepIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// stepLogLines is how many of the last lines FFmpeg logged are kept per step
+const stepLogLines = 20
+
+// normalizePipeline validates the steps of a multi-step request: unique IDs,
+// unique output file names across all steps, references to existing step
+// outputs and no cycles. The outputs to upload, by default every output no
+// other step reads, become the request's OutputFiles keyed by reference.
+func normalizePipeline(req *domain.FFMPEGRequest) error {
+	steps := make(map[string]*domain.PipelineStep, len(req.Steps))
+	filenames := make(map[string]string)
+	for i := range req.Steps {
+		step := &req.Steps[i]
+		if !stepIDRegexp.MatchString(step.ID) {
+			return fmt.Errorf("%w: steps[%d]: id %q may only contain letters, digits, '_' and '-'", ErrInvalidRequest, i, step.ID)
+		}
+		if steps[step.ID] != nil {
+			return fmt.Errorf("%w: steps[%d]: id %q is used more than once", ErrInvalidRequest, i, step.ID)
+		}
+		steps[step.ID] = step
+
+		if len(step.OutputFiles) == 0 {
+			return fmt.Errorf("%w: step %s: at least one output file is required", ErrInvalidRequest, step.ID)
+		}
+		for _, key := range sortedKeys(step.OutputFiles) {
+			ref := step.ID + "." + key
+			if other, ok := filenames[step.OutputFiles[key]]; ok {
+				return fmt.Errorf("%w: outputs %s and %s both write %q", ErrInvalidRequest, other, ref, step.OutputFiles[key])
+			}
+			filenames[step.OutputFiles[key]] = ref
+		}
+	}
+
+	for key := range req.InputFiles {
+		if id, _, ok := strings.Cut(key, "."); ok && steps[id] != nil {
+			return fmt.Errorf("%w: input key %q is ambiguous with the outputs of step %s", ErrInvalidRequest, key, id)
+		}
+	}
+
+	consumed := make(map[string]bool)
+	for _, step := range req.Steps {
+		for _, ref := range stepReferences(step, steps) {
+			id, key, _ := strings.Cut(ref, ".")
+			if id == step.ID {
+				return fmt.Errorf("%w: step %s can't read its own output {{%s}}", ErrInvalidRequest, step.ID, ref)
+			}
+			name, ok := steps[id].OutputFiles[key]
+			if !ok {
+				return fmt.Errorf("%w: step %s reads {{%s}}, but step %s has no output %q", ErrInvalidRequest, step.ID, ref, id, key)
+			}
+			if isCollectionOutput(name) {
+				return fmt.Errorf("%w: step %s reads {{%s}}, which designates several files", ErrInvalidRequest, step.ID, ref)
+			}
+			consumed[ref] = true
+		}
+	}
+	if _, err := pipelineOrder(req.Steps); err != nil {
+		return err
+	}
+
+	upload := req.Upload
+	if len(upload) == 0 {
+		for _, step := range req.Steps {
+			for _, key := range sortedKeys(step.OutputFiles) {
+				if ref := step.ID + "." + key; !consumed[ref] {
+					upload = append(upload, ref)
+				}
+			}
+		}
+	}
+
+	req.OutputFiles = make(map[string]string, len(upload))
+	for _, ref := range upload {
+		id, key, _ := strings.Cut(ref, ".")
+		step := steps[id]
+		if step == nil || step.OutputFiles[key] == "" {
+			return fmt.Errorf("%w: upload: %q is not a step output such as step1.out1", ErrInvalidRequest, ref)
+		}
+		req.OutputFiles[ref] = step.OutputFiles[key]
+	}
+	req.Upload = upload
+	return nil
+}
+
+// stepReferences returns the {{step.key}} placeholders of a step's command
+// that refer to outputs of the given steps, in the order they appear
+func stepReferences(step domain.PipelineStep, steps map[string]*domain.PipelineStep) []string {
+	var refs []string
+	for _, arg := range commandTemplate(domain.FFMPEGRequest{FFmpegCommand: step.FFmpegCommand}) {
+		for _, match := range placeholderRegexp.FindAllStringSubmatch(arg, -1) {
+			if id, _, ok := strings.Cut(match[1], "."); ok && steps[id] != nil && !slices.Contains(refs, match[1]) {
+				refs = append(refs, match[1])
+			}
+		}
+	}
+	return refs
+}
+
+// pipelineOrder returns the indexes of the steps in the order they run: every
+// step after the steps whose outputs it reads, and otherwise in the order
+// they were given.
+func pipelineOrder(steps []domain.PipelineStep) ([]int, error) {
+	byID := make(map[string]*domain.PipelineStep, len(steps))
+	for i := range steps {
+		byID[steps[i].ID] = &steps[i]
+	}
+
+	done := make(map[string]bool, len(steps))
+	order := make([]int, 0, len(steps))
+	for len(order) < len(steps) {
+		next := -1
+		for i, step := range steps {
+			if done[step.ID] {
+				continue
+			}
+			ready := true
+			for _, ref := range stepReferences(step, byID) {
+				id, _, _ := strings.Cut(ref, ".")
+				ready = ready && done[id]
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var waiting []string
+			for _, step := range steps {
+				if !done[step.ID] {
+					waiting = append(waiting, step.ID)
+				}
+			}
+			return nil, fmt.Errorf("%w: steps %s depend on each other in a cycle", ErrInvalidRequest, strings.Join(waiting, ", "))
+		}
+		done[steps[next].ID] = true
+		order = append(order, next)
+	}
+	return order, nil
+}
+
+// stepRequest returns the request a single step is checked and run as. Its
+// inputs are the job's input files plus the outputs of other steps it reads,
+// keyed by reference.
+func stepRequest(req domain.FFMPEGRequest, step domain.PipelineStep) domain.FFMPEGRequest {
+	steps := make(map[string]*domain.PipelineStep, len(req.Steps))
+	for i := range req.Steps {
+		steps[req.Steps[i].ID] = &req.Steps[i]
+	}
+
+	inputFiles := make(map[string]string, len(req.InputFiles))
+	for key, url := range req.InputFiles {
+		inputFiles[key] = url
+	}
+	for _, ref := range stepReferences(step, steps) {
+		id, key, _ := strings.Cut(ref, ".")
+		inputFiles[ref] = steps[id].OutputFiles[key]
+	}
+
+	return domain.FFMPEGRequest{
+		InputFiles:    inputFiles,
+		OutputFiles:   step.OutputFiles,
+		FFmpegCommand: step.FFmpegCommand,
+	}
+}
+
+// runPipeline runs the steps of a multi-step job one after another in the
+// job's directory, recording the state of each in job.Steps. The FFMPEG
+// phase of the job's progress is shared equally between the steps.
+// inputDurations holds the durations of the job's input files by path, and
+// gets those of the step outputs added as they are written. streams are the
+// input files read over HTTP(S), which can't be piped to several steps.
+func (s *FFMPEGServiceImpl) runPipeline(ctx, jobCtx context.Context, job *domain.JobStatus, tempDir string, inputPaths map[string]string, inputDurations map[string]float64, streams *streamedInputs) error {
+	req := *job.OriginalRequest
+	order, err := pipelineOrder(req.Steps)
+	if err != nil {
+		return err
+	}
+
+	job.Steps = make(domain.StepStatuses, len(order))
+	for i, index := range order {
+		job.Steps[i] = domain.StepStatus{ID: req.Steps[index].ID, Status: domain.JobStatusQueued}
+	}
+
+	for i, index := range order {
+		step := req.Steps[index]
+		status := &job.Steps[i]
+
+		// Inputs are either input files of the job or outputs of earlier steps
+		stepReq := stepRequest(req, step)
+		stepInputs := make(map[string]string, len(stepReq.InputFiles))
+		for key, filename := range stepReq.InputFiles {
+			if path, ok := inputPaths[key]; ok {
+				stepInputs[key] = path
+			} else {
+				stepInputs[key] = absolutePath(filepath.Join(tempDir, filepath.FromSlash(filename)))
+			}
+		}
+		stepOutputs := make(map[string]string, len(step.OutputFiles))
+		for key, filename := range step.OutputFiles {
+			if !isCollectionOutput(filename) {
+				stepOutputs[key] = absolutePath(filepath.Join(tempDir, filepath.FromSlash(filename)))
+			}
+		}
+
+		args := substitutePlaceholders(commandTemplate(stepReq), stepInputs, stepOutputs)
+		if len(args) == 0 {
+			status.Status = domain.JobStatusFailed
+			status.Error = "invalid FFmpeg command"
+			return fmt.Errorf("step %s: invalid FFmpeg command", step.ID)
+		}
+		status.ExpectedDuration = expectedOutputDuration(args, inputDurations)
+		job.ExpectedDuration = status.ExpectedDuration
+
+		startedAt := time.Now()
+		status.StartedAt = &startedAt
+		status.Status = domain.JobStatusProcessing
+		from, to := 25+50*i/len(order), 25+50*(i+1)/len(order)
+		job.Progress = from
+		s.saveProgress(ctx, job)
+
+		logLines, err := s.runFFmpeg(ctx, jobCtx, job, tempDir, args, streams, from, to)
+		finishedAt := time.Now()
+		status.FinishedAt = &finishedAt
+		status.RunSeconds = finishedAt.Sub(startedAt).Seconds()
+		status.Log = strings.Join(logLines, "\n")
+		job.FFmpegCommandRunSeconds += status.RunSeconds
+		if err != nil {
+			status.Status = domain.JobStatusFailed
+			if jobCtx.Err() != nil {
+				status.Status = domain.JobStatusCancelled
+			}
+			status.Error = err.Error()
+			return fmt.Errorf("step %s: %w", step.ID, err)
+		}
+		status.Status = domain.JobStatusSuccess
+
+		// The outputs' durations make the progress of later steps reading them accurate
+		for key, path := range stepOutputs {
+			probe, err := probeMedia(jobCtx, s.config.FFMPEG.ProbePath, path, localInputProtocols)
+			if err != nil {
+				logger.Warn("failed to probe step output", "uuid", job.UUID, "step", step.ID, "key", key, "error", err)
+				continue
+			}
+			inputDurations[path] = probeDuration(probe)
+		}
+	}
+	return nil
+}
+
+// appendLogLine adds a line to a log that keeps only its last stepLogLines lines
+func appendLogLine(lines []string, line string) []string {
+	if len(lines) == stepLogLines {
+		lines = slices.Delete(lines, 0, 1)
+	}
+	return append(lines, line)
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testStep returns a pipeline step writing a single out1.mp4 output
+func testStep(id, command string) domain.PipelineStep {
+	return domain.PipelineStep{ID: id, FFmpegCommand: command, OutputFiles: map[string]string{"out1": id + ".mp4"}}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []domain.PipelineStep
+		want  []int
+	}{
+		{
+			name: "independent steps keep their order",
+			steps: []domain.PipelineStep{
+				testStep("a", "-i {{in1}} {{out1}}"),
+				testStep("b", "-i {{in1}} {{out1}}"),
+			},
+			want: []int{0, 1},
+		},
+		{
+			name: "chain given in reverse",
+			steps: []domain.PipelineStep{
+				testStep("c", "-i {{b.out1}} {{out1}}"),
+				testStep("b", "-i {{a.out1}} {{out1}}"),
+				testStep("a", "-i {{in1}} {{out1}}"),
+			},
+			want: []int{2, 1, 0},
+		},
+		{
+			name: "step waits for all its inputs",
+			steps: []domain.PipelineStep{
+				testStep("merge", "-i {{a.out1}} -i {{b.out1}} {{out1}}"),
+				testStep("a", "-i {{in1}} {{out1}}"),
+				testStep("b", "-i {{in1}} {{out1}}"),
+			},
+			want: []int{1, 2, 0},
+		},
+		{
+			name: "reference inside an argument",
+			steps: []domain.PipelineStep{
+				testStep("burn", "-i {{in1}} -vf subtitles={{subs.out1}} {{out1}}"),
+				testStep("subs", "-i {{in2}} {{out1}}"),
+			},
+			want: []int{1, 0},
+		},
+	}
+	for _, tt := range tests {
+		got, err := pipelineOrder(tt.steps)
+		if err != nil {
+			t.Errorf("%s: pipelineOrder failed: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: pipelineOrder() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPipelineOrderCycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []domain.PipelineStep
+		waiting string
+	}{
+		{
+			name: "two steps",
+			steps: []domain.PipelineStep{
+				testStep("a", "-i {{b.out1}} {{out1}}"),
+				testStep("b", "-i {{a.out1}} {{out1}}"),
+			},
+			waiting: "steps a, b ",
+		},
+		{
+			name: "cycle after a runnable step",
+			steps: []domain.PipelineStep{
+				testStep("start", "-i {{in1}} {{out1}}"),
+				testStep("a", "-i {{start.out1}} -i {{c.out1}} {{out1}}"),
+				testStep("b", "-i {{a.out1}} {{out1}}"),
+				testStep("c", "-i {{b.out1}} {{out1}}"),
+			},
+			waiting: "steps a, b, c ",
+		},
+	}
+	for _, tt := range tests {
+		_, err := pipelineOrder(tt.steps)
+		if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tt.waiting) {
+			t.Errorf("%s: pipelineOrder() = %v, want a cycle error naming %q", tt.name, err, tt.waiting)
+		}
+	}
+}
+
+func TestNormalizePipeline(t *testing.T) {
+	req := domain.FFMPEGRequest{
+		InputFiles: map[string]string{"in1": "https://example.com/a.mp4"},
+		Steps: []domain.PipelineStep{
+			testStep("encode", "-i {{in1}} {{out1}}"),
+			{
+				ID:            "thumbs",
+				FFmpegCommand: "-i {{encode.out1}} -vf fps=1 {{out1}} {{out2}}",
+				OutputFiles:   map[string]string{"out1": "thumb.jpg", "out2": "thumbs/*.jpg"},
+			},
+		},
+	}
+	if err := normalizePipeline(&req); err != nil {
+		t.Fatalf("normalizePipeline failed: %v", err)
+	}
+	want := map[string]string{"thumbs.out1": "thumb.jpg", "thumbs.out2": "thumbs/*.jpg"}
+	if !maps.Equal(req.OutputFiles, want) {
+		t.Errorf("OutputFiles = %v, want the outputs no step reads: %v", req.OutputFiles, want)
+	}
+}
+
+func TestNormalizePipelineInvalid(t *testing.T) {
+	inputs := map[string]string{"in1": "https://example.com/a.mp4"}
+	tests := []struct {
+		name string
+		req  domain.FFMPEGRequest
+	}{
+		{"invalid id", domain.FFMPEGRequest{InputFiles: inputs, Steps: []domain.PipelineStep{
+			testStep("a.b", "-i {{in1}} {{out1}}"),
+		}}},
+		{"duplicate id", domain.FFMPEGRequest{InputFiles: inputs, Steps: []domain.PipelineStep{
+			testStep("a", "-i {{in1}} {{out1}}"),
+			{ID: "a", FFmpegCommand: "-i {{in1}} {{out1}}", OutputFiles: map[string]string{"out1": "other.mp4"}},
+		}}},
+		{"duplicate file name", domain.FFMPEGRequest{InputFiles: inputs, Steps: []domain.PipelineStep{
+			testStep("a", "-i {{in1}} {{out1}}"),
+			{ID: "b", FFmpegCommand: "-i {{in1}} {{out1}}", OutputFiles: map[string]string{"out1": "a.mp4"}},
+		}}},
+		{"own output", domain.FFMPEGRequest{InputFiles: inputs, Steps: []domain.PipelineStep{
+			testStep("a", "-i {{a.out1}} {{out1}}"),
+		}}},
+		{"missing output", domain.FFMPEGRequest{InputFiles: inputs, Steps: []domain.PipelineStep{
+			testStep("a", "-i {{in1}} {{out1}}"),
+			testStep("b", "-i {{a.out2}} {{out1}}"),
+		}}},
+		{"collection output", domain.FFMPEGRequest{InputFiles: inputs, Steps: []domain.PipelineStep{
+			{ID: "a", FFmpegCommand: "-i {{in1}} {{out1}}", OutputFiles: map[string]string{"out1": "frames/"}},
+			testStep("b", "-i {{a.out1}} {{out1}}"),
+		}}},
+		{"cycle", domain.FFMPEGRequest{InputFiles: inputs, Steps: []domain.PipelineStep{
+			testStep("a", "-i {{b.out1}} {{out1}}"),
+			testStep("b", "-i {{a.out1}} {{out1}}"),
+		}}},
+		{"ambiguous input key", domain.FFMPEGRequest{
+			InputFiles: map[string]string{"a.out1": "https://example.com/a.mp4"},
+			Steps:      []domain.PipelineStep{testStep("a", "-i {{a.out1}} {{out1}}")},
+		}},
+		{"unknown upload", domain.FFMPEGRequest{InputFiles: inputs, Upload: []string{"a.out2"}, Steps: []domain.PipelineStep{
+			testStep("a", "-i {{in1}} {{out1}}"),
+		}}},
+	}
+	for _, tt := range tests {
+		if err := normalizePipeline(&tt.req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: normalizePipeline() = %v, want ErrInvalidRequest", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// uriAttributeRegexp matches the URI attribute of an HLS tag such as
+	// #EXT-X-MAP or #EXT-X-MEDIA
+	uriAttributeRegexp = regexp.MustCompile(`URI="([^"]*)"`)
+	// mpdTagRegexp matches the opening tag of a DASH manifest
+	mpdTagRegexp = regexp.MustCompile(`<MPD[^>]*>`)
+	// dashSegmentTagRegexp matches the tags of a DASH segment list that refer
+	// to segments, and dashSegmentAttributeRegexp their attributes doing so
+	dashSegmentTagRegexp       = regexp.MustCompile(`<(?:Initialization|SegmentURL)\s[^>]*>`)
+	dashSegmentAttributeRegexp = regexp.MustCompile(`\b(sourceURL|media)="([^"]*)"`)
+)
+
+// isPlaylist reports whether a file is an HLS playlist or a DASH manifest
+func isPlaylist(file string) bool {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".m3u8", ".mpd":
+		return true
+	default:
+		return false
+	}
+}
+
+// isMasterPlaylist reports whether a file is an HLS master playlist, which
+// refers to other playlists
+func isMasterPlaylist(localPath string) bool {
+	if strings.ToLower(filepath.Ext(localPath)) != ".m3u8" {
+		return false
+	}
+	data, err := os.ReadFile(localPath)
+	return err == nil && bytes.Contains(data, []byte("#EXT-X-STREAM-INF"))
+}
+
+// refersToFiles reports whether an output file refers to other output files
+// by name: HLS playlists, DASH manifests and WebVTT storyboards
+func refersToFiles(file string) bool {
+	return isPlaylist(file) || strings.ToLower(path.Ext(file)) == ".vtt"
+}
+
+// playlistRank orders files for upload: media files, then the files
+// referring to them, then master playlists
+func playlistRank(localPath string) int {
+	switch {
+	case isMasterPlaylist(localPath):
+		return 2
+	case refersToFiles(localPath):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// findManifest returns the file of a directory or glob output that plays it:
+// an HLS master playlist if there is one, otherwise the least nested playlist
+// or manifest. It returns "" if the output has none.
+func findManifest(tempDir string, files []string) string {
+	best, bestRank := "", 0
+	for _, file := range files {
+		if !isPlaylist(file) {
+			continue
+		}
+		// Files are sorted, so the first of equally ranked files wins
+		rank := 100 - strings.Count(file, "/")
+		if isMasterPlaylist(filepath.Join(tempDir, filepath.FromSlash(file))) {
+			rank += 100
+		}
+		if rank > bestRank {
+			best, bestRank = file, rank
+		}
+	}
+	return best
+}
+
+// rewriteReferences points the references of an output file at the URLs of
+// the uploaded files, given by their paths relative to the job's directory.
+// The URIs of an HLS playlist, the segments of a DASH segment list and the
+// images of WebVTT storyboard cues are replaced, and a DASH manifest whose
+// segments are referred to by templates gets a BaseURL of its directory.
+func rewriteReferences(tempDir, file string, urls map[string]string) error {
+	localPath := filepath.Join(tempDir, filepath.FromSlash(file))
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+
+	ext := strings.ToLower(path.Ext(file))
+	if ext == ".mpd" && !dashSegmentTagRegexp.Match(data) {
+		if data, err = addBaseURL(data, file, urls); err != nil {
+			return err
+		}
+		return os.WriteFile(localPath, data, 0644)
+	}
+
+	var missing []string
+	data = replaceReferences(data, ext, func(uri string) string {
+		if strings.Contains(uri, "://") || strings.HasPrefix(uri, "data:") {
+			return uri
+		}
+		if fileURL, ok := resolvePlaylistURI(tempDir, file, uri, urls); ok {
+			return fileURL
+		}
+		missing = append(missing, uri)
+		return uri
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("it refers to files that are not among the output files: %s", strings.Join(missing, ", "))
+	}
+	return os.WriteFile(localPath, data, 0644)
+}
+
+// replaceReferences replaces each reference of an HLS playlist, DASH segment
+// list or WebVTT storyboard, as given by the file's extension, with what
+// replace returns for it
+func replaceReferences(data []byte, ext string, replace func(uri string) string) []byte {
+	if ext == ".mpd" {
+		// Attributes are XML escaped
+		return dashSegmentTagRegexp.ReplaceAllFunc(data, func(tag []byte) []byte {
+			return dashSegmentAttributeRegexp.ReplaceAllFunc(tag, func(attr []byte) []byte {
+				match := dashSegmentAttributeRegexp.FindSubmatch(attr)
+				return []byte(string(match[1]) + `="` + html.EscapeString(replace(html.UnescapeString(string(match[2])))) + `"`)
+			})
+		})
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case ext == ".vtt":
+			// Only the cue payloads of storyboards refer to files, as image#xywh=x,y,w,h
+			if image, fragment, ok := strings.Cut(trimmed, "#xywh="); ok {
+				lines[i] = replace(image) + "#xywh=" + fragment
+			}
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#"):
+			lines[i] = uriAttributeRegexp.ReplaceAllStringFunc(line, func(attr string) string {
+				return `URI="` + replace(uriAttributeRegexp.FindStringSubmatch(attr)[1]) + `"`
+			})
+		default:
+			lines[i] = replace(trimmed)
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// resolvePlaylistURI returns the URL of the uploaded file a playlist URI
+// refers to. FFmpeg writes URIs relative to the playlist, or as the segment
+// file name it was given, which is relative to the job's directory or
+// absolute.
+func resolvePlaylistURI(tempDir, file, uri string, urls map[string]string) (string, bool) {
+	var candidates []string
+	if filepath.IsAbs(uri) {
+		if rel, err := filepath.Rel(absolutePath(tempDir), uri); err == nil {
+			candidates = append(candidates, filepath.ToSlash(rel))
+		}
+	} else {
+		candidates = append(candidates, path.Join(path.Dir(file), uri), path.Clean(uri))
+	}
+	for _, candidate := range candidates {
+		if fileURL, ok := urls[candidate]; ok {
+			return fileURL, true
+		}
+	}
+	return "", false
+}
+
+// addBaseURL adds a BaseURL of the manifest's directory to a DASH manifest
+// that has none. The directory's URL is derived from that of a file uploaded
+// from the same directory; without one the manifest is left unchanged. Signed
+// URLs, such as those of private storage, have no directory URL.
+func addBaseURL(data []byte, file string, urls map[string]string) ([]byte, error) {
+	if bytes.Contains(data, []byte("<BaseURL")) {
+		return data, nil
+	}
+
+	var baseURL string
+	for other, fileURL := range urls {
+		u, err := url.Parse(fileURL)
+		if err != nil || path.Dir(other) != path.Dir(file) || !strings.HasSuffix(u.Path, "/"+path.Base(other)) {
+			continue
+		}
+		if u.RawQuery != "" {
+			return nil, errors.New("its segments are referred to by templates, which can't be signed URLs, write a segment list with -use_template 0 instead")
+		}
+		u.Path, u.RawPath, u.Fragment = strings.TrimSuffix(u.Path, path.Base(other)), "", ""
+		baseURL = u.String()
+		break
+	}
+	if baseURL == "" {
+		return data, nil
+	}
+
+	// BaseURL follows ProgramInformation, which comes first in an MPD
+	at := -1
+	if i := bytes.LastIndex(data, []byte("</ProgramInformation>")); i >= 0 {
+		at = i + len("</ProgramInformation>")
+	} else if loc := mpdTagRegexp.FindIndex(data); loc != nil {
+		at = loc[1]
+	}
+	if at < 0 {
+		return data, nil
+	}
+
+	element := "\n\t<BaseURL>" + html.EscapeString(baseURL) + "</BaseURL>"
+	return append(append(append([]byte{}, data[:at]...), element...), data[at:]...), nil
+}
+
+// referencedObjectKey returns which of the given object keys a URL of the
+// user's storage refers to, the longest if several match, or "" if none does
+func referencedObjectKey(objectURL string, objectKeys []string) string {
+	u, err := url.Parse(objectURL)
+	if err != nil {
+		return ""
+	}
+	var found string
+	for _, key := range objectKeys {
+		if strings.HasSuffix(u.Path, "/"+key) && len(key) > len(found) {
+			found = key
+		}
+	}
+	return found
+}
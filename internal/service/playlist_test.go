@@ -0,0 +1,253 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes a file of a job's directory for a test
+func writeTestFile(t *testing.T, dir, file, content string) {
+	t.Helper()
+	localPath := filepath.Join(dir, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(localPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewriteReferences(t *testing.T) {
+	dir := t.TempDir()
+	urls := map[string]string{
+		"hls/init.mp4":        "https://cdn.example.com/job/hls/init.mp4",
+		"hls/seg_000.m4s":     "https://cdn.example.com/job/hls/seg_000.m4s",
+		"hls/seg_001.m4s":     "https://cdn.example.com/job/hls/seg_001.m4s",
+		"hls/index.m3u8":      "https://cdn.example.com/job/hls/index.m3u8",
+		"hls/subs.m3u8":       "https://cdn.example.com/job/hls/subs.m3u8",
+		"sprites/sheet_1.jpg": "https://cdn.example.com/job/sprites/sheet_1.jpg",
+	}
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{
+			name: "media playlist",
+			file: "hls/index.m3u8",
+			content: "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4.0,\nseg_000.m4s\n" +
+				"#EXTINF:4.0,\n" + filepath.Join(absolutePath(dir), "hls", "seg_001.m4s") + "\n#EXT-X-ENDLIST\n",
+			want: "#EXTM3U\n#EXT-X-MAP:URI=\"https://cdn.example.com/job/hls/init.mp4\"\n#EXTINF:4.0,\nhttps://cdn.example.com/job/hls/seg_000.m4s\n" +
+				"#EXTINF:4.0,\nhttps://cdn.example.com/job/hls/seg_001.m4s\n#EXT-X-ENDLIST\n",
+		},
+		{
+			name:    "segment named relative to the job directory",
+			file:    "hls/index.m3u8",
+			content: "#EXTM3U\n#EXTINF:4.0,\nhls/seg_000.m4s\n",
+			want:    "#EXTM3U\n#EXTINF:4.0,\nhttps://cdn.example.com/job/hls/seg_000.m4s\n",
+		},
+		{
+			name: "master playlist",
+			file: "master.m3u8",
+			content: "#EXTM3U\n#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"en\",URI=\"hls/subs.m3u8\"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000,SUBTITLES=\"subs\"\n  hls/index.m3u8  \n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1600000\nhttps://other.example.com/high.m3u8\n",
+			want: "#EXTM3U\n#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"en\",URI=\"https://cdn.example.com/job/hls/subs.m3u8\"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000,SUBTITLES=\"subs\"\nhttps://cdn.example.com/job/hls/index.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1600000\nhttps://other.example.com/high.m3u8\n",
+		},
+		{
+			name:    "storyboard",
+			file:    "storyboard.vtt",
+			content: "WEBVTT\n\n00:00:00.000 --> 00:00:05.000\nsprites/sheet_1.jpg#xywh=0,0,160,90\n",
+			want:    "WEBVTT\n\n00:00:00.000 --> 00:00:05.000\nhttps://cdn.example.com/job/sprites/sheet_1.jpg#xywh=0,0,160,90\n",
+		},
+	}
+	for _, tt := range tests {
+		writeTestFile(t, dir, tt.file, tt.content)
+		if err := rewriteReferences(dir, tt.file, urls); err != nil {
+			t.Errorf("%s: rewriteReferences failed: %v", tt.name, err)
+			continue
+		}
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(tt.file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: rewriteReferences wrote\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteReferencesMissing(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "index.m3u8", "#EXTM3U\n#EXTINF:4.0,\nseg_000.ts\n#EXTINF:4.0,\nseg_001.ts\n")
+	err := rewriteReferences(dir, "index.m3u8", map[string]string{"seg_000.ts": "https://cdn.example.com/seg_000.ts"})
+	if err == nil || !strings.Contains(err.Error(), "seg_001.ts") || strings.Contains(err.Error(), "seg_000.ts") {
+		t.Errorf("rewriteReferences() = %v, want an error naming seg_001.ts", err)
+	}
+}
+
+func TestAddBaseURL(t *testing.T) {
+	urls := map[string]string{
+		"dash/manifest.mpd":      "https://cdn.example.com/job/dash/manifest.mpd",
+		"dash/init-stream0.m4s":  "https://cdn.example.com/job/dash/init-stream0.m4s",
+		"other/init-stream0.m4s": "https://cdn.example.com/job/other/init-stream0.m4s",
+	}
+	tests := []struct {
+		name string
+		file string
+		data string
+		urls map[string]string
+		want string
+	}{
+		{
+			name: "after ProgramInformation",
+			file: "dash/manifest.mpd",
+			data: "<MPD>\n\t<ProgramInformation>\n\t</ProgramInformation>\n\t<Period/>\n</MPD>",
+			urls: urls,
+			want: "<MPD>\n\t<ProgramInformation>\n\t</ProgramInformation>\n\t<BaseURL>https://cdn.example.com/job/dash/</BaseURL>\n\t<Period/>\n</MPD>",
+		},
+		{
+			name: "after the MPD tag",
+			file: "dash/manifest.mpd",
+			data: `<MPD type="static">` + "\n\t<Period/>\n</MPD>",
+			urls: urls,
+			want: `<MPD type="static">` + "\n\t<BaseURL>https://cdn.example.com/job/dash/</BaseURL>\n\t<Period/>\n</MPD>",
+		},
+		{
+			name: "escaped",
+			file: "manifest.mpd",
+			data: "<MPD>\n</MPD>",
+			urls: map[string]string{"seg.m4s": "https://cdn.example.com/a&b/seg.m4s"},
+			want: "<MPD>\n\t<BaseURL>https://cdn.example.com/a&amp;b/</BaseURL>\n</MPD>",
+		},
+		{
+			name: "has a BaseURL",
+			file: "dash/manifest.mpd",
+			data: "<MPD>\n\t<BaseURL>https://elsewhere.example.com/</BaseURL>\n</MPD>",
+			urls: urls,
+			want: "<MPD>\n\t<BaseURL>https://elsewhere.example.com/</BaseURL>\n</MPD>",
+		},
+		{
+			name: "no file uploaded from its directory",
+			file: "dash/manifest.mpd",
+			data: "<MPD>\n</MPD>",
+			urls: map[string]string{"other/init-stream0.m4s": urls["other/init-stream0.m4s"]},
+			want: "<MPD>\n</MPD>",
+		},
+		{
+			name: "URL not ending in the file name",
+			file: "manifest.mpd",
+			data: "<MPD>\n</MPD>",
+			urls: map[string]string{"seg.m4s": "https://cdn.example.com/sha256/0123abcd.m4s"},
+			want: "<MPD>\n</MPD>",
+		},
+	}
+	for _, tt := range tests {
+		got, err := addBaseURL([]byte(tt.data), tt.file, tt.urls)
+		if err != nil {
+			t.Errorf("%s: addBaseURL failed: %v", tt.name, err)
+		} else if string(got) != tt.want {
+			t.Errorf("%s: addBaseURL() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddBaseURLSigned(t *testing.T) {
+	urls := map[string]string{
+		"dash/init-0.m4s": "https://s3.example.com/bucket/user_1/job/dash/init-0.m4s?X-Amz-Expires=3600&X-Amz-Signature=abc",
+	}
+	if got, err := addBaseURL([]byte("<MPD>\n</MPD>"), "dash/manifest.mpd", urls); err == nil {
+		t.Errorf("addBaseURL() = %s, want an error for signed URLs", got)
+	}
+}
+
+func TestRewriteReferencesSegmentList(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "dash/manifest.mpd", `<MPD>
+	<Period>
+		<SegmentList timescale="1000" duration="4000">
+			<Initialization sourceURL="init-0.m4s" />
+			<SegmentURL media="chunk-0-00001.m4s" />
+		</SegmentList>
+		<SegmentTemplate media="unused-$Number$.m4s" />
+	</Period>
+</MPD>`)
+	urls := map[string]string{
+		"dash/init-0.m4s":        "https://s3.example.com/user_1/job/dash/init-0.m4s?X-Amz-Expires=3600&X-Amz-Signature=a",
+		"dash/chunk-0-00001.m4s": "https://s3.example.com/user_1/job/dash/chunk-0-00001.m4s?X-Amz-Expires=3600&X-Amz-Signature=b",
+	}
+	if err := rewriteReferences(dir, "dash/manifest.mpd", urls); err != nil {
+		t.Fatalf("rewriteReferences failed: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "dash", "manifest.mpd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<MPD>
+	<Period>
+		<SegmentList timescale="1000" duration="4000">
+			<Initialization sourceURL="https://s3.example.com/user_1/job/dash/init-0.m4s?X-Amz-Expires=3600&amp;X-Amz-Signature=a" />
+			<SegmentURL media="https://s3.example.com/user_1/job/dash/chunk-0-00001.m4s?X-Amz-Expires=3600&amp;X-Amz-Signature=b" />
+		</SegmentList>
+		<SegmentTemplate media="unused-$Number$.m4s" />
+	</Period>
+</MPD>`
+	if string(got) != want {
+		t.Errorf("rewriteReferences wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestReplaceReferencesResigns(t *testing.T) {
+	objectKeys := []string{"job/hls/index.m3u8", "job/hls/seg_000.ts", "job/dash/init-0.m4s", "hls/seg_000.ts"}
+	resign := func(uri string) string {
+		if key := referencedObjectKey(uri, objectKeys); key != "" {
+			return "https://s3.example.com/user_1/" + key + "?X-Amz-Signature=new"
+		}
+		return uri
+	}
+	tests := []struct {
+		ext  string
+		data string
+		want string
+	}{
+		{
+			ext:  ".m3u8",
+			data: "#EXTM3U\n#EXTINF:4.0,\nhttps://s3.example.com/user_1/job/hls/seg_000.ts?X-Amz-Signature=old\n#EXTINF:4.0,\nhttps://cdn.example.com/other.ts\n",
+			want: "#EXTM3U\n#EXTINF:4.0,\nhttps://s3.example.com/user_1/job/hls/seg_000.ts?X-Amz-Signature=new\n#EXTINF:4.0,\nhttps://cdn.example.com/other.ts\n",
+		},
+		{
+			ext:  ".mpd",
+			data: `<Initialization sourceURL="https://s3.example.com/user_1/job/dash/init-0.m4s?X-Amz-Expires=1&amp;X-Amz-Signature=old" />`,
+			want: `<Initialization sourceURL="https://s3.example.com/user_1/job/dash/init-0.m4s?X-Amz-Signature=new" />`,
+		},
+	}
+	for _, tt := range tests {
+		if got := string(replaceReferences([]byte(tt.data), tt.ext, resign)); got != tt.want {
+			t.Errorf("replaceReferences(%s) =\n%s\nwant\n%s", tt.ext, got, tt.want)
+		}
+	}
+}
+
+func TestReferencedObjectKey(t *testing.T) {
+	objectKeys := []string{"job/a b.ts", "seg.ts", "job/seg.ts"}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://s3.example.com/bucket/user_1/job/seg.ts?X-Amz-Signature=abc", "job/seg.ts"},
+		{"https://s3.example.com/bucket/user_1/seg.ts", "seg.ts"},
+		{"https://s3.example.com/bucket/user_1/job/a%20b.ts?X-Amz-Signature=abc", "job/a b.ts"},
+		{"https://s3.example.com/bucket/user_1/job/xseg.ts", ""},
+		{"https://s3.example.com/bucket/user_1/job/seg.ts.bak", ""},
+	}
+	for _, tt := range tests {
+		if got := referencedObjectKey(tt.url, objectKeys); got != tt.want {
+			t.Errorf("referencedObjectKey(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
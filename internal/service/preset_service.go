@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/repository"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// presetNameRegexp matches the names of presets
+	presetNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	// variableNameRegexp matches the names of preset variables
+	variableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// PresetServiceImpl implements PresetService
+type PresetServiceImpl struct {
+	presetRepo repository.PresetRepository
+	config     *config.Config
+}
+
+// NewPresetService creates a new PresetService
+func NewPresetService(presetRepo repository.PresetRepository, config *config.Config) PresetService {
+	return &PresetServiceImpl{
+		presetRepo: presetRepo,
+		config:     config,
+	}
+}
+
+// ListPresets returns the user's own presets followed by the global presets
+func (s *PresetServiceImpl) ListPresets(ctx context.Context, user *domain.User) ([]domain.Preset, error) {
+	presets, err := s.presetRepo.FindVisible(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list presets: %w", err)
+	}
+	return presets, nil
+}
+
+// GetPreset returns a preset of the user or a global preset
+func (s *PresetServiceImpl) GetPreset(ctx context.Context, id uint, user *domain.User) (*domain.Preset, error) {
+	preset, err := s.presetRepo.FindByID(ctx, id)
+	if err != nil || (!preset.IsGlobal() && preset.UserID != user.ID) {
+		return nil, ErrPresetNotFound
+	}
+	return preset, nil
+}
+
+// CreatePreset creates a preset owned by the user, or a global preset if
+// global is set and the user is an admin
+func (s *PresetServiceImpl) CreatePreset(ctx context.Context, preset *domain.Preset, global bool, user *domain.User) error {
+	preset.ID = 0
+	preset.UserID = user.ID
+	if global {
+		if !s.isAdmin(user) {
+			return ErrPresetForbidden
+		}
+		preset.UserID = 0
+	}
+
+	if err := validatePreset(preset); err != nil {
+		return err
+	}
+	if err := s.checkNameAvailable(ctx, preset); err != nil {
+		return err
+	}
+
+	if err := s.presetRepo.Create(ctx, preset); err != nil {
+		return fmt.Errorf("failed to create preset: %w", err)
+	}
+	return nil
+}
+
+// UpdatePreset replaces the name, description, command and variables of a
+// preset. Global presets can only be updated by admins.
+func (s *PresetServiceImpl) UpdatePreset(ctx context.Context, id uint, update domain.Preset, user *domain.User) (*domain.Preset, error) {
+	preset, err := s.editablePreset(ctx, id, user)
+	if err != nil {
+		return nil, err
+	}
+
+	preset.Name = update.Name
+	preset.Description = update.Description
+	preset.Command = update.Command
+	preset.Variables = update.Variables
+	if err := validatePreset(preset); err != nil {
+		return nil, err
+	}
+	if err := s.checkNameAvailable(ctx, preset); err != nil {
+		return nil, err
+	}
+
+	if err := s.presetRepo.Update(ctx, preset); err != nil {
+		return nil, fmt.Errorf("failed to update preset: %w", err)
+	}
+	return preset, nil
+}
+
+// DeletePreset deletes a preset. Global presets can only be deleted by admins.
+func (s *PresetServiceImpl) DeletePreset(ctx context.Context, id uint, user *domain.User) error {
+	preset, err := s.editablePreset(ctx, id, user)
+	if err != nil {
+		return err
+	}
+	if err := s.presetRepo.Delete(ctx, preset.ID); err != nil {
+		return fmt.Errorf("failed to delete preset: %w", err)
+	}
+	return nil
+}
+
+// editablePreset returns a preset the user may change
+func (s *PresetServiceImpl) editablePreset(ctx context.Context, id uint, user *domain.User) (*domain.Preset, error) {
+	preset, err := s.GetPreset(ctx, id, user)
+	if err != nil {
+		return nil, err
+	}
+	if preset.IsGlobal() && !s.isAdmin(user) {
+		return nil, ErrPresetForbidden
+	}
+	return preset, nil
+}
+
+// checkNameAvailable checks that no other preset of the same owner has the
+// preset's name
+func (s *PresetServiceImpl) checkNameAvailable(ctx context.Context, preset *domain.Preset) error {
+	existing, err := s.presetRepo.FindByName(ctx, preset.UserID, preset.Name)
+	if err == nil && existing.UserID == preset.UserID && existing.ID != preset.ID {
+		return ErrPresetExists
+	}
+	return nil
+}
+
+// isAdmin reports whether the user may manage global presets
+func (s *PresetServiceImpl) isAdmin(user *domain.User) bool {
+	return slices.Contains(s.config.Server.AdminUsernames, user.Username)
+}
+
+// validatePreset checks a preset definition: its name, that its command is
+// not empty, that its variables are well-formed with valid defaults and
+// bounds, and that each of them is used by the command.
+func validatePreset(preset *domain.Preset) error {
+	if !presetNameRegexp.MatchString(preset.Name) {
+		return fmt.Errorf("%w: name %q may only contain letters, digits, '_', '.' and '-'", ErrInvalidPreset, preset.Name)
+	}
+	if len(splitCommand(preset.Command)) == 0 {
+		return fmt.Errorf("%w: command must not be empty", ErrInvalidPreset)
+	}
+
+	used := make(map[string]bool)
+	for _, match := range placeholderRegexp.FindAllStringSubmatch(preset.Command, -1) {
+		used[match[1]] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, variable := range preset.Variables {
+		if !variableNameRegexp.MatchString(variable.Name) {
+			return fmt.Errorf("%w: variable name %q must start with a letter or '_' and contain only letters, digits and '_'", ErrInvalidPreset, variable.Name)
+		}
+		if seen[variable.Name] {
+			return fmt.Errorf("%w: variable %q is declared more than once", ErrInvalidPreset, variable.Name)
+		}
+		seen[variable.Name] = true
+		if !used[variable.Name] {
+			return fmt.Errorf("%w: variable %q is not used by the command", ErrInvalidPreset, variable.Name)
+		}
+
+		switch variable.Type {
+		case domain.PresetVariableString, domain.PresetVariableBoolean:
+			if variable.Min != nil || variable.Max != nil {
+				return fmt.Errorf("%w: variable %q: min and max only apply to integer and number variables", ErrInvalidPreset, variable.Name)
+			}
+		case domain.PresetVariableInteger, domain.PresetVariableNumber:
+			if variable.Min != nil && variable.Max != nil && *variable.Min > *variable.Max {
+				return fmt.Errorf("%w: variable %q: min is greater than max", ErrInvalidPreset, variable.Name)
+			}
+		default:
+			return fmt.Errorf("%w: variable %q: type must be one of string, integer, number, boolean", ErrInvalidPreset, variable.Name)
+		}
+
+		for _, value := range variable.Enum {
+			if _, err := formatVariableValue(variable, value); err != nil {
+				return fmt.Errorf("%w: variable %q: enum value: %v", ErrInvalidPreset, variable.Name, err)
+			}
+		}
+		if variable.Default != nil {
+			if _, err := formatVariableValue(variable, variable.Default); err != nil {
+				return fmt.Errorf("%w: variable %q: default: %v", ErrInvalidPreset, variable.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expandPreset returns the preset's command with its variables replaced by
+// the given values or their defaults, along with the values used. The
+// variables are replaced inside the split arguments, so a value never spans
+// more than one argument, and the result is quoted back into a command.
+func expandPreset(preset *domain.Preset, values map[string]interface{}) (string, map[string]interface{}, error) {
+	declared := make(map[string]bool, len(preset.Variables))
+	for _, variable := range preset.Variables {
+		declared[variable.Name] = true
+	}
+	for name := range values {
+		if !declared[name] {
+			return "", nil, fmt.Errorf("%w: preset %q has no variable %q", ErrInvalidRequest, preset.Name, name)
+		}
+	}
+
+	used := make(map[string]interface{}, len(preset.Variables))
+	replacements := make(map[string]string, len(preset.Variables))
+	for _, variable := range preset.Variables {
+		value, ok := values[variable.Name]
+		if !ok || value == nil {
+			value = variable.Default
+		}
+		if value == nil {
+			return "", nil, fmt.Errorf("%w: preset variable %q is required", ErrInvalidRequest, variable.Name)
+		}
+		formatted, err := formatVariableValue(variable, value)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: preset variable %q: %v", ErrInvalidRequest, variable.Name, err)
+		}
+		if len(variable.Enum) > 0 && !slices.ContainsFunc(variable.Enum, func(allowed interface{}) bool {
+			s, _ := formatVariableValue(variable, allowed)
+			return s == formatted
+		}) {
+			return "", nil, fmt.Errorf("%w: preset variable %q must be one of %v", ErrInvalidRequest, variable.Name, variable.Enum)
+		}
+		used[variable.Name] = value
+		replacements[variable.Name] = formatted
+	}
+
+	args := splitCommand(preset.Command)
+	for i, arg := range args {
+		args[i] = placeholderRegexp.ReplaceAllStringFunc(arg, func(placeholder string) string {
+			if value, ok := replacements[placeholder[2:len(placeholder)-2]]; ok {
+				return value
+			}
+			return placeholder
+		})
+	}
+	return joinCommand(args), used, nil
+}
+
+// formatVariableValue checks a JSON value against the type and bounds of a
+// variable and formats it as it is put into the command. Booleans become 1
+// or 0, which FFMPEG options and filter expressions both accept.
+func formatVariableValue(variable domain.PresetVariable, value interface{}) (string, error) {
+	switch variable.Type {
+	case domain.PresetVariableString:
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("expected a string, got %v", value)
+		}
+		if strings.Contains(s, "{{") || strings.Contains(s, "}}") {
+			return "", fmt.Errorf("must not contain placeholders")
+		}
+		return s, nil
+	case domain.PresetVariableBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return "", fmt.Errorf("expected a boolean, got %v", value)
+		}
+		if b {
+			return "1", nil
+		}
+		return "0", nil
+	case domain.PresetVariableInteger, domain.PresetVariableNumber:
+		n, ok := value.(float64)
+		if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+			return "", fmt.Errorf("expected a number, got %v", value)
+		}
+		if variable.Type == domain.PresetVariableInteger && n != math.Trunc(n) {
+			return "", fmt.Errorf("expected an integer, got %v", value)
+		}
+		if variable.Min != nil && n < *variable.Min {
+			return "", fmt.Errorf("must be at least %v", *variable.Min)
+		}
+		if variable.Max != nil && n > *variable.Max {
+			return "", fmt.Errorf("must be at most %v", *variable.Max)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unknown variable type %q", variable.Type)
+	}
+}
+
+// joinCommand quotes arguments into a command string that splitCommand
+// splits back into the same arguments
+func joinCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n\r'\"\\") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+var testPreset = &domain.Preset{
+	Name:    "scale",
+	Command: `-i {{in_1}} -vf "scale=-2:{{height}},drawtext=text={{title}}" -preset {{speed}} -g {{mute}} {{out_1}}`,
+	Variables: domain.PresetVariables{
+		{Name: "height", Type: domain.PresetVariableInteger, Default: float64(720), Min: float(144), Max: float(2160)},
+		{Name: "title", Type: domain.PresetVariableString},
+		{Name: "speed", Type: domain.PresetVariableString, Default: "slow", Enum: []interface{}{"fast", "slow"}},
+		{Name: "mute", Type: domain.PresetVariableBoolean, Default: false},
+	},
+}
+
+func TestExpandPreset(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		want     string
+		wantUsed map[string]interface{}
+	}{
+		{
+			name:     "defaults",
+			values:   map[string]interface{}{"title": "Hello"},
+			want:     "-i {{in_1}} -vf scale=-2:720,drawtext=text=Hello -preset slow -g 0 {{out_1}}",
+			wantUsed: map[string]interface{}{"height": float64(720), "title": "Hello", "speed": "slow", "mute": false},
+		},
+		{
+			name:     "values",
+			values:   map[string]interface{}{"height": float64(1080), "title": "Hi", "speed": "fast", "mute": true},
+			want:     "-i {{in_1}} -vf scale=-2:1080,drawtext=text=Hi -preset fast -g 1 {{out_1}}",
+			wantUsed: map[string]interface{}{"height": float64(1080), "title": "Hi", "speed": "fast", "mute": true},
+		},
+		{
+			name:     "null takes the default",
+			values:   map[string]interface{}{"title": "Hello", "height": nil},
+			want:     "-i {{in_1}} -vf scale=-2:720,drawtext=text=Hello -preset slow -g 0 {{out_1}}",
+			wantUsed: map[string]interface{}{"height": float64(720), "title": "Hello", "speed": "slow", "mute": false},
+		},
+		{
+			name:     "value with quotes and spaces stays in its argument",
+			values:   map[string]interface{}{"title": `it's "a" test; rm -rf /`},
+			want:     `-i {{in_1}} -vf 'scale=-2:720,drawtext=text=it'\''s "a" test; rm -rf /' -preset slow -g 0 {{out_1}}`,
+			wantUsed: map[string]interface{}{"height": float64(720), "title": `it's "a" test; rm -rf /`, "speed": "slow", "mute": false},
+		},
+	}
+	for _, tt := range tests {
+		got, used, err := expandPreset(testPreset, tt.values)
+		if err != nil {
+			t.Errorf("%s: expandPreset() failed: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expandPreset() = %s, want %s", tt.name, got, tt.want)
+		}
+		if !maps.Equal(used, tt.wantUsed) {
+			t.Errorf("%s: values used = %v, want %v", tt.name, used, tt.wantUsed)
+		}
+	}
+}
+
+func TestExpandPresetInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		want   string
+	}{
+		{"undeclared variable", map[string]interface{}{"title": "a", "crf": float64(23)}, `no variable "crf"`},
+		{"required variable missing", map[string]interface{}{}, `"title" is required`},
+		{"below min", map[string]interface{}{"title": "a", "height": float64(100)}, "at least 144"},
+		{"above max", map[string]interface{}{"title": "a", "height": float64(4320)}, "at most 2160"},
+		{"not an integer", map[string]interface{}{"title": "a", "height": 720.5}, "expected an integer"},
+		{"not in enum", map[string]interface{}{"title": "a", "speed": "medium"}, "must be one of"},
+		{"wrong type", map[string]interface{}{"title": float64(5)}, "expected a string"},
+		{"placeholder in value", map[string]interface{}{"title": "{{height}}"}, "placeholders"},
+	}
+	for _, tt := range tests {
+		_, _, err := expandPreset(testPreset, tt.values)
+		if !errors.Is(err, ErrInvalidRequest) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expandPreset() = %v, want ErrInvalidRequest containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestFormatVariableValue(t *testing.T) {
+	integer := domain.PresetVariable{Type: domain.PresetVariableInteger, Min: float(0), Max: float(51)}
+	number := domain.PresetVariable{Type: domain.PresetVariableNumber}
+	str := domain.PresetVariable{Type: domain.PresetVariableString}
+	boolean := domain.PresetVariable{Type: domain.PresetVariableBoolean}
+	tests := []struct {
+		name     string
+		variable domain.PresetVariable
+		value    interface{}
+		want     string
+		wantErr  bool
+	}{
+		{"integer", integer, float64(23), "23", false},
+		{"integer at min", integer, float64(0), "0", false},
+		{"integer at max", integer, float64(51), "51", false},
+		{"integer below min", integer, float64(-1), "", true},
+		{"integer above max", integer, float64(52), "", true},
+		{"integer with a fraction", integer, 2.5, "", true},
+		{"integer as string", integer, "23", "", true},
+		{"number", number, 1.25, "1.25", false},
+		{"large number not in exponent form", number, 1e7, "10000000", false},
+		{"small number not in exponent form", number, 1e-7, "0.0000001", false},
+		{"string", str, "libx264", "libx264", false},
+		{"empty string", str, "", "", false},
+		{"string with placeholder", str, "a}}b", "", true},
+		{"string as number", str, float64(1), "", true},
+		{"true", boolean, true, "1", false},
+		{"false", boolean, false, "0", false},
+		{"boolean as string", boolean, "true", "", true},
+		{"unknown type", domain.PresetVariable{Type: "list"}, "a", "", true},
+	}
+	for _, tt := range tests {
+		got, err := formatVariableValue(tt.variable, tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: formatVariableValue(%v) = %q, %v, want %q, error %v", tt.name, tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestJoinCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"-i", "in.mp4", "-c:v", "libx264"}, "-i in.mp4 -c:v libx264"},
+		{[]string{"-metadata", "title=a b"}, "-metadata 'title=a b'"},
+		{[]string{""}, "''"},
+		{[]string{"it's"}, `'it'\''s'`},
+		{[]string{`"quoted"`}, `'"quoted"'`},
+		{[]string{`back\slash`}, `'back\slash'`},
+		{[]string{"tab\there", "new\nline"}, "'tab\there' 'new\nline'"},
+		{[]string{"'"}, `''\'''`},
+		{[]string{"$HOME", "`id`"}, "$HOME `id`"},
+	}
+	for _, tt := range tests {
+		got := joinCommand(tt.args)
+		if got != tt.want {
+			t.Errorf("joinCommand(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+		if split := splitCommand(got); !slices.Equal(split, tt.args) {
+			t.Errorf("splitCommand(joinCommand(%q)) = %q, want the arguments back", tt.args, split)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// probeInputKey is the input key of the single file of a probe job
+const probeInputKey = "input"
+
+// Probe describes a media file with ffprobe. url is an http(s) URL, an
+// upload:// reference or an object key under the user's own prefix. Files up
+// to the configured size are probed right away; larger files, or files of
+// unknown size, are queued as a probe job.
+func (s *FFMPEGServiceImpl) Probe(ctx context.Context, url string, userID uint) (*domain.ProbeResult, error) {
+	if !isInputReference(url) && !strings.HasPrefix(url, fmt.Sprintf("user_%d/", userID)) {
+		return nil, ErrInvalidProbeURL
+	}
+	source := url
+	if strings.HasPrefix(url, uploadRefPrefix) {
+		var err error
+		if source, err = s.resolveInput(ctx, url, userID); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFileNotAccessible, err)
+		}
+	}
+
+	size, err := s.storageService.StatFile(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileNotAccessible, err)
+	}
+
+	if size < 0 || size > s.config.FFMPEG.ProbeSyncMaxSize {
+		resp, err := s.queueProbe(ctx, url, userID)
+		if err != nil {
+			return nil, err
+		}
+		return &domain.ProbeResult{Size: size, Job: resp}, nil
+	}
+
+	localPath, err := s.storageService.DownloadFile(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFileNotAccessible, err)
+	}
+	defer s.storageService.DeleteFile(ctx, localPath)
+
+	probe, err := probeMedia(ctx, s.config.FFMPEG.ProbePath, localPath, localInputProtocols)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.ProbeResult{Size: size, Probe: probe}, nil
+}
+
+// queueProbe creates a probe job for a file
+func (s *FFMPEGServiceImpl) queueProbe(ctx context.Context, url string, userID uint) (*domain.FFMPEGResponse, error) {
+	job := &domain.JobStatus{
+		UUID:   uuid.New().String(),
+		Type:   domain.JobTypeProbe,
+		Status: domain.JobStatusQueued,
+		UserID: userID,
+		OriginalRequest: &domain.FFMPEGRequest{
+			InputFiles: map[string]string{probeInputKey: url},
+		},
+	}
+
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	position, err := s.jobRepo.QueuePosition(ctx, job)
+	if err != nil {
+		logger.Error("failed to get queue position", "uuid", job.UUID, "error", err)
+	}
+
+	s.queue.Notify()
+
+	return &domain.FFMPEGResponse{
+		UUID:          job.UUID,
+		Status:        domain.JobStatusQueued,
+		QueuePosition: position,
+	}, nil
+}
+
+// processProbeJob downloads the file of a probe job and stores its probe in
+// the job's InputProbes
+func (s *FFMPEGServiceImpl) processProbeJob(ctx, jobCtx context.Context, job *domain.JobStatus) {
+	startTime := time.Now()
+	url := job.OriginalRequest.InputFiles[probeInputKey]
+	if strings.HasPrefix(url, uploadRefPrefix) {
+		var err error
+		if url, err = s.resolveInput(jobCtx, url, job.UserID); err != nil {
+			s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to download file: %v", err))
+			return
+		}
+	}
+
+	localPath, err := s.storageService.DownloadFile(jobCtx, url)
+	if err != nil {
+		s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to download file: %v", err))
+		return
+	}
+	defer s.storageService.DeleteFile(ctx, localPath)
+
+	job.Progress = 50
+	s.saveProgress(ctx, job)
+
+	probe, err := probeMedia(jobCtx, s.config.FFMPEG.ProbePath, localPath, localInputProtocols)
+	if err != nil {
+		s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to probe file: %v", err))
+		return
+	}
+
+	job.InputProbes = domain.MediaProbesMap{probeInputKey: *probe}
+	job.Status = domain.JobStatusSuccess
+	job.Progress = 100
+	job.TotalProcessingSeconds = time.Since(startTime).Seconds()
+	job.Result = "Successfully probed file"
+	if !s.saveJob(ctx, job) {
+		return
+	}
+	s.notifyStatus(ctx, job)
+}
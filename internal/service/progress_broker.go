@@ -0,0 +1,66 @@
+package service
+
+import (
+	"ffmpeg-api/internal/domain"
+	"sync"
+)
+
+// progressBufferSize is how many events a subscriber may fall behind before
+// the oldest pending events are dropped
+const progressBufferSize = 16
+
+// ProgressBroker fans out live progress events of running jobs to subscribers
+// in this process.
+type ProgressBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan domain.ProgressEvent]struct{}
+}
+
+// NewProgressBroker creates a new ProgressBroker
+func NewProgressBroker() *ProgressBroker {
+	return &ProgressBroker{
+		subscribers: make(map[string]map[chan domain.ProgressEvent]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving the events of a job and the function
+// that ends the subscription.
+func (b *ProgressBroker) Subscribe(jobUUID string) (<-chan domain.ProgressEvent, func()) {
+	ch := make(chan domain.ProgressEvent, progressBufferSize)
+
+	b.mu.Lock()
+	if b.subscribers[jobUUID] == nil {
+		b.subscribers[jobUUID] = make(map[chan domain.ProgressEvent]struct{})
+	}
+	b.subscribers[jobUUID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[jobUUID], ch)
+		if len(b.subscribers[jobUUID]) == 0 {
+			delete(b.subscribers, jobUUID)
+		}
+	}
+}
+
+// Publish sends an event to every subscriber of the job without blocking. A
+// subscriber that is not keeping up loses its oldest pending event, so the
+// latest state always gets through.
+func (b *ProgressBroker) Publish(event domain.ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.JobUUID] {
+		select {
+		case ch <- event:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- event
+		}
+	}
+}
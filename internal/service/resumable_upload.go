@@ -0,0 +1,475 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// uploadCleanupInterval is how often expired resumable uploads are deleted
+	uploadCleanupInterval = 10 * time.Minute
+	// uploadLockTimeout is how long the lock of a request writing to a
+	// resumable upload lasts if it isn't renewed, such as when its node dies
+	uploadLockTimeout = time.Minute
+)
+
+// ChecksumAlgorithms are the algorithms the checksums of chunks may use
+var ChecksumAlgorithms = []string{"md5", "sha1", "sha256"}
+
+// Start launches the deletion of expired resumable uploads
+func (s *UploadServiceImpl) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(uploadCleanupInterval)
+		defer ticker.Stop()
+		for {
+			s.deleteExpired(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// CreateResumableUpload starts an upload of length bytes, written with
+// WriteChunk in any number of requests. A file of 0 bytes is complete right
+// away. Incomplete uploads expire after the configured time without a chunk.
+func (s *UploadServiceImpl) CreateResumableUpload(ctx context.Context, length int64, fileName string, contentType string, metadata string, userID uint) (*domain.Upload, error) {
+	quotaLeft, err := s.quotaLeft(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize := min(s.config.Storage.UploadMaxSize, quotaLeft); length > maxSize {
+		return nil, s.sizeError(maxSize)
+	}
+
+	upload := &domain.Upload{
+		UUID:        uuid.New().String(),
+		UserID:      userID,
+		Status:      domain.UploadStatusInProgress,
+		FileName:    fileName,
+		ContentType: contentType,
+		SizeBytes:   length,
+		Metadata:    metadata,
+	}
+	objectKey := uploadObjectKey(upload)
+	upload.ObjectKey = fmt.Sprintf("user_%d/%s", userID, objectKey)
+
+	if length == 0 {
+		if _, err := s.storageService.UploadStream(ctx, bytes.NewReader(nil), 0, objectKey, contentType, userID); err != nil {
+			return nil, fmt.Errorf("failed to store upload: %w", err)
+		}
+		upload.Status = domain.UploadStatusComplete
+		upload.SHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+	} else {
+		upload.StorageUploadID, err = s.storageService.CreateMultipartUpload(ctx, objectKey, contentType, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start upload: %w", err)
+		}
+		upload.HashState, err = sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("failed to save checksum state: %w", err)
+		}
+		expiresAt := time.Now().Add(s.config.Storage.UploadExpiry)
+		upload.ExpiresAt = &expiresAt
+	}
+
+	// The quota is checked again as the upload is created, as other uploads
+	// may have been created since
+	if err := s.createUpload(ctx, upload); err != nil {
+		if length == 0 {
+			err = errors.Join(err, s.storageService.DeleteObject(ctx, objectKey, userID))
+		} else {
+			err = errors.Join(err, s.storageService.AbortMultipartUpload(ctx, objectKey, upload.StorageUploadID, userID))
+		}
+		return nil, err
+	}
+	return upload, nil
+}
+
+// MaxSize returns the largest file that may be uploaded, in bytes
+func (s *UploadServiceImpl) MaxSize() int64 {
+	return s.config.Storage.UploadMaxSize
+}
+
+// GetResumableUpload returns an upload of the user, failing for incomplete
+// uploads that expired
+func (s *UploadServiceImpl) GetResumableUpload(ctx context.Context, uuid string, userID uint) (*domain.Upload, error) {
+	upload, err := s.GetUpload(ctx, uuid, userID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status == domain.UploadStatusInProgress && upload.ExpiresAt != nil && upload.ExpiresAt.Before(time.Now()) {
+		return nil, ErrUploadExpired
+	}
+	return upload, nil
+}
+
+// WriteChunk appends a chunk to a resumable upload at offset, which must be
+// the upload's offset. With a checksum, given as "<algorithm> <base64
+// digest>", the chunk is only kept if it matches; without one, as much of an
+// interrupted chunk as was received is kept.
+//
+// The upload is locked in the database while the chunk is written, so chunks
+// can be sent to any node. Chunks are collected into parts of the configured
+// size, which are written to storage as they fill up; the bytes of the part
+// that isn't full yet are kept in storage as well. The upload's offset is
+// saved after every part, so a failure loses at most the chunk being written.
+// Once all bytes are received the object is completed.
+func (s *UploadServiceImpl) WriteChunk(ctx context.Context, uuid string, offset int64, r io.Reader, checksum string, userID uint) (*domain.Upload, error) {
+	upload, err := s.GetResumableUpload(ctx, uuid, userID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status == domain.UploadStatusComplete {
+		return nil, ErrUploadComplete
+	}
+	if offset != upload.Offset {
+		return nil, fmt.Errorf("%w: the upload is at %d", ErrUploadOffsetMismatch, upload.Offset)
+	}
+	verifier, digest, err := parseChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, token, release, err := s.lockUpload(ctx, upload)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Stage the chunk, reading one byte more than fits to notice if it's too
+	// long
+	chunk, err := os.CreateTemp(s.config.Storage.TempDirectory, "chunk-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to stage chunk: %w", err)
+	}
+	defer os.Remove(chunk.Name())
+	defer chunk.Close()
+
+	remaining := upload.SizeBytes - upload.Offset
+	dst := io.Writer(chunk)
+	if verifier != nil {
+		dst = io.MultiWriter(chunk, verifier)
+	}
+	n, err := io.Copy(dst, io.LimitReader(r, remaining+1))
+	switch {
+	case n > remaining:
+		return nil, fmt.Errorf("%w: the chunk goes past the upload's length of %d bytes", ErrInvalidRequest, upload.SizeBytes)
+	case err != nil && verifier != nil:
+		return nil, fmt.Errorf("failed to receive chunk: %w", err)
+	case err != nil:
+		logger.Warn("chunk interrupted, keeping the bytes received", "upload", upload.UUID, "bytes", n, "error", err)
+	case verifier != nil && !bytes.Equal(verifier.Sum(nil), digest):
+		return nil, ErrChecksumMismatch
+	}
+
+	if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read staged chunk: %w", err)
+	}
+	if err := s.appendChunk(ctx, upload, chunk, n, token); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// appendChunk adds n bytes of a staged chunk to the upload's current part,
+// writing every part that fills up to storage, and completes the upload once
+// all bytes are received. The upload is saved only while token holds its
+// lock.
+func (s *UploadServiceImpl) appendChunk(ctx context.Context, upload *domain.Upload, chunk io.Reader, n int64, token string) error {
+	partSize := s.config.Storage.UploadPartSize
+	objectKey := strings.TrimPrefix(upload.ObjectKey, fmt.Sprintf("user_%d/", upload.UserID))
+
+	sum := sha256.New()
+	if err := sum.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState); err != nil {
+		return fmt.Errorf("failed to restore checksum state: %w", err)
+	}
+
+	// The part is assembled in a local file, starting with the bytes received
+	// since the last part was written. Anything stored past them is left over
+	// from a chunk that failed.
+	part, err := os.CreateTemp(s.config.Storage.TempDirectory, "part-*")
+	if err != nil {
+		return fmt.Errorf("failed to stage part: %w", err)
+	}
+	defer os.Remove(part.Name())
+	defer part.Close()
+	partLen := upload.Offset - int64(upload.Parts)*partSize
+	if partLen > 0 {
+		stored, err := s.storageService.OpenFile(ctx, fmt.Sprintf("user_%d/%s", upload.UserID, partKey(objectKey)))
+		if err != nil {
+			return fmt.Errorf("failed to open the stored part: %w", err)
+		}
+		copied, err := io.CopyN(part, stored, partLen)
+		stored.Close()
+		if err == io.EOF {
+			return fmt.Errorf("the stored part of upload %s is missing %d bytes", upload.UUID, partLen-copied)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the stored part: %w", err)
+		}
+	}
+
+	for n > 0 {
+		copied, err := io.CopyN(io.MultiWriter(part, sum), chunk, min(partSize-partLen, n))
+		if err != nil {
+			return fmt.Errorf("failed to stage part: %w", err)
+		}
+		n -= copied
+		partLen += copied
+		received := int64(upload.Parts)*partSize + partLen
+
+		if partLen < partSize && received < upload.SizeBytes {
+			break
+		}
+
+		// Write the full or last part to storage
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read staged part: %w", err)
+		}
+		partOffset := int64(upload.Parts) * partSize
+		if err := s.storageService.UploadPart(ctx, objectKey, upload.StorageUploadID, upload.Parts+1, partOffset, part, partLen, upload.UserID); err != nil {
+			return err
+		}
+		upload.Parts++
+		upload.Offset = received
+		if upload.HashState, err = sum.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			return fmt.Errorf("failed to save checksum state: %w", err)
+		}
+		if received == upload.SizeBytes {
+			return s.completeUpload(ctx, upload, objectKey, sum, token)
+		}
+		if err := s.saveChunkProgress(ctx, upload, token); err != nil {
+			return err
+		}
+
+		if err := part.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate staged part: %w", err)
+		}
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek staged part: %w", err)
+		}
+		partLen = 0
+	}
+
+	// The part is stored before the offset that covers it is saved
+	if partLen > 0 {
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read staged part: %w", err)
+		}
+		if _, err := s.storageService.UploadStream(ctx, part, partLen, partKey(objectKey), "application/octet-stream", upload.UserID); err != nil {
+			return fmt.Errorf("failed to store part: %w", err)
+		}
+	}
+	upload.Offset = int64(upload.Parts)*partSize + partLen
+	if upload.HashState, err = sum.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return fmt.Errorf("failed to save checksum state: %w", err)
+	}
+	return s.saveChunkProgress(ctx, upload, token)
+}
+
+// saveChunkProgress saves the offset of an incomplete upload, which expires
+// later with every chunk, while token holds its lock
+func (s *UploadServiceImpl) saveChunkProgress(ctx context.Context, upload *domain.Upload, token string) error {
+	expiresAt := time.Now().Add(s.config.Storage.UploadExpiry)
+	upload.ExpiresAt = &expiresAt
+	return s.saveLocked(ctx, upload, token)
+}
+
+// saveLocked saves an upload while token holds its lock, failing with
+// ErrUploadLocked if it was lost
+func (s *UploadServiceImpl) saveLocked(ctx context.Context, upload *domain.Upload, token string) error {
+	saved, err := s.uploadRepo.UpdateLocked(ctx, upload, token)
+	if err != nil {
+		return fmt.Errorf("failed to update upload: %w", err)
+	}
+	if !saved {
+		return ErrUploadLocked
+	}
+	return nil
+}
+
+// completeUpload assembles the parts of an upload that received all its bytes
+func (s *UploadServiceImpl) completeUpload(ctx context.Context, upload *domain.Upload, objectKey string, sum hash.Hash, token string) error {
+	if _, err := s.storageService.CompleteMultipartUpload(ctx, objectKey, upload.StorageUploadID, upload.UserID); err != nil {
+		return err
+	}
+	if err := s.storageService.DeleteObject(ctx, partKey(objectKey), upload.UserID); err != nil {
+		logger.Warn("failed to delete the stored part of upload", "upload", upload.UUID, "error", err)
+	}
+
+	upload.Status = domain.UploadStatusComplete
+	upload.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	upload.ExpiresAt = nil
+	upload.StorageUploadID = ""
+	upload.HashState = nil
+	if err := s.saveLocked(ctx, upload, token); err != nil {
+		return err
+	}
+	logger.Info("resumable upload complete", "upload", upload.UUID, "size", upload.SizeBytes)
+	return nil
+}
+
+// TerminateUpload discards an incomplete upload and the parts it wrote
+func (s *UploadServiceImpl) TerminateUpload(ctx context.Context, uuid string, userID uint) error {
+	upload, err := s.GetUpload(ctx, uuid, userID)
+	if err != nil {
+		return err
+	}
+	if upload.Status == domain.UploadStatusComplete {
+		return ErrUploadComplete
+	}
+
+	ctx, _, release, err := s.lockUpload(ctx, upload)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.discardUpload(ctx, upload)
+}
+
+// discardUpload deletes an incomplete upload with its parts
+func (s *UploadServiceImpl) discardUpload(ctx context.Context, upload *domain.Upload) error {
+	objectKey := strings.TrimPrefix(upload.ObjectKey, fmt.Sprintf("user_%d/", upload.UserID))
+	if err := s.storageService.AbortMultipartUpload(ctx, objectKey, upload.StorageUploadID, upload.UserID); err != nil {
+		logger.Warn("failed to abort multipart upload", "upload", upload.UUID, "error", err)
+	}
+	if err := s.storageService.DeleteObject(ctx, partKey(objectKey), upload.UserID); err != nil {
+		logger.Warn("failed to delete the stored part of upload", "upload", upload.UUID, "error", err)
+	}
+	if err := s.uploadRepo.Delete(ctx, upload.ID); err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	return nil
+}
+
+// deleteExpired discards the incomplete uploads that expired
+func (s *UploadServiceImpl) deleteExpired(ctx context.Context) {
+	uploads, err := s.uploadRepo.FindExpired(ctx, time.Now())
+	if err != nil {
+		logger.Error("failed to find expired uploads", "error", err)
+		return
+	}
+	for i := range uploads {
+		upload := &uploads[i]
+		lockCtx, _, release, err := s.lockUpload(ctx, upload)
+		if err != nil {
+			continue
+		}
+		if err := s.discardUpload(lockCtx, upload); err != nil {
+			logger.Error("failed to delete expired upload", "upload", upload.UUID, "error", err)
+		} else {
+			logger.Info("deleted expired upload", "upload", upload.UUID)
+		}
+		release()
+	}
+}
+
+// partKey returns the key of the object the bytes of an upload's part that
+// isn't full yet are kept in, next to the upload's object. Upload file names
+// don't start with '.', so it can't be another upload's object.
+func partKey(objectKey string) string {
+	return path.Join(path.Dir(objectKey), ".part")
+}
+
+// lockUpload locks an incomplete upload at its offset for the calling
+// request, failing with ErrUploadLocked while a request on any node holds the
+// lock. The lock is renewed until release is called. It returns a context
+// that is cancelled if the lock is lost, and the lock's token.
+func (s *UploadServiceImpl) lockUpload(ctx context.Context, upload *domain.Upload) (lockCtx context.Context, token string, release func(), err error) {
+	token = uuid.New().String()
+	locked, err := s.uploadRepo.Lock(ctx, upload.ID, upload.Offset, token, time.Now().Add(uploadLockTimeout))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to lock upload: %w", err)
+	}
+	if !locked {
+		return nil, "", nil, ErrUploadLocked
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(uploadLockTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			held, err := s.uploadRepo.ExtendLock(lockCtx, upload.ID, token, time.Now().Add(uploadLockTimeout))
+			if err != nil {
+				logger.Error("failed to extend upload lock", "upload", upload.UUID, "error", err)
+				continue
+			}
+			if !held {
+				logger.Warn("upload lock lost, stopping the request", "upload", upload.UUID)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	release = func() {
+		close(done)
+		cancel()
+		if err := s.uploadRepo.Unlock(context.WithoutCancel(ctx), upload.ID, token); err != nil {
+			logger.Error("failed to unlock upload", "upload", upload.UUID, "error", err)
+		}
+	}
+	return lockCtx, token, release, nil
+}
+
+// parseChecksum parses a chunk checksum, "<algorithm> <base64 digest>", into
+// a hash to verify the chunk with and the expected digest. An empty checksum
+// returns a nil hash.
+func parseChecksum(checksum string) (hash.Hash, []byte, error) {
+	if checksum == "" {
+		return nil, nil, nil
+	}
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(checksum), " ")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: checksum must be \"<algorithm> <base64 digest>\"", ErrInvalidRequest)
+	}
+	digest, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: checksum digest is not base64", ErrInvalidRequest)
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return nil, nil, fmt.Errorf("%w: %q, use one of %s", ErrUnsupportedChecksum, algorithm, strings.Join(ChecksumAlgorithms, ", "))
+	}
+	if len(digest) != h.Size() {
+		return nil, nil, fmt.Errorf("%w: a %s digest has %d bytes", ErrInvalidRequest, algorithm, h.Size())
+	}
+	return h, digest, nil
+}
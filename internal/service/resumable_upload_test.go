@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// uploadRepositoryStub keeps a single upload in memory, with the claims of
+// the requests writing to it
+type uploadRepositoryStub struct {
+	repository.UploadRepository
+	upload domain.Upload
+}
+
+func (r *uploadRepositoryStub) Create(ctx context.Context, upload *domain.Upload) error {
+	upload.ID = 1
+	r.upload = *upload
+	return nil
+}
+
+func (r *uploadRepositoryStub) FindByUUID(ctx context.Context, uuid string) (*domain.Upload, error) {
+	if uuid != r.upload.UUID {
+		return nil, errors.New("record not found")
+	}
+	upload := r.upload
+	return &upload, nil
+}
+
+func (r *uploadRepositoryStub) Lock(ctx context.Context, id uint, offset int64, token string, until time.Time) (bool, error) {
+	if r.upload.Offset != offset || r.upload.LockToken != "" {
+		return false, nil
+	}
+	r.upload.LockToken = token
+	return true, nil
+}
+
+func (r *uploadRepositoryStub) ExtendLock(ctx context.Context, id uint, token string, until time.Time) (bool, error) {
+	return r.upload.LockToken == token, nil
+}
+
+func (r *uploadRepositoryStub) Unlock(ctx context.Context, id uint, token string) error {
+	if r.upload.LockToken == token {
+		r.upload.LockToken = ""
+	}
+	return nil
+}
+
+func (r *uploadRepositoryStub) UpdateLocked(ctx context.Context, upload *domain.Upload, token string) (bool, error) {
+	if r.upload.LockToken != token {
+		return false, nil
+	}
+	r.upload = *upload
+	r.upload.LockToken = token
+	return true, nil
+}
+
+// newResumableUpload creates a resumable upload of length bytes written to
+// memory storage in parts of 4 bytes
+func newResumableUpload(t *testing.T, length int64) (*UploadServiceImpl, *uploadRepositoryStub, *domain.Upload) {
+	t.Helper()
+	logger.InitLogger()
+	cfg := &config.Config{Storage: config.StorageConfig{
+		TempDirectory:  t.TempDir(),
+		UploadMaxSize:  1024,
+		UploadExpiry:   time.Hour,
+		UploadPartSize: 4,
+	}}
+	repo := &uploadRepositoryStub{}
+	s := NewUploadService(repo, NewMemoryStorageService(cfg), cfg).(*UploadServiceImpl)
+	upload, err := s.CreateResumableUpload(context.Background(), length, "clip.mp4", "video/mp4", "", 1)
+	if err != nil {
+		t.Fatalf("CreateResumableUpload failed: %v", err)
+	}
+	return s, repo, upload
+}
+
+func checksum(algorithm string, digest []byte) string {
+	return algorithm + " " + base64.StdEncoding.EncodeToString(digest)
+}
+
+func TestParseChecksum(t *testing.T) {
+	md5Sum := md5.Sum([]byte("chunk"))
+	sha256Sum := sha256.Sum256([]byte("chunk"))
+	tests := []struct {
+		name     string
+		checksum string
+		wantSize int // of the hash, 0 for none
+		wantErr  error
+	}{
+		{"no checksum", "", 0, nil},
+		{"md5", checksum("md5", md5Sum[:]), md5.Size, nil},
+		{"sha256", checksum("sha256", sha256Sum[:]), sha256.Size, nil},
+		{"surrounding spaces", "  " + checksum("sha256", sha256Sum[:]) + " ", sha256.Size, nil},
+		{"no digest", "sha256", 0, ErrInvalidRequest},
+		{"digest not base64", "sha256 not*base64", 0, ErrInvalidRequest},
+		{"digest of another algorithm", checksum("sha256", md5Sum[:]), 0, ErrInvalidRequest},
+		{"unsupported algorithm", checksum("crc32", []byte{1, 2, 3, 4}), 0, ErrUnsupportedChecksum},
+		{"algorithm in upper case", checksum("SHA256", sha256Sum[:]), 0, ErrUnsupportedChecksum},
+	}
+	for _, tt := range tests {
+		h, digest, err := parseChecksum(tt.checksum)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: parseChecksum() error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseChecksum() failed: %v", tt.name, err)
+			continue
+		}
+		if tt.wantSize == 0 {
+			if h != nil || digest != nil {
+				t.Errorf("%s: parseChecksum() = %v, %x, want no hash", tt.name, h, digest)
+			}
+			continue
+		}
+		if h == nil || h.Size() != tt.wantSize || len(digest) != tt.wantSize {
+			t.Errorf("%s: parseChecksum() returned a hash of %d bytes, want %d", tt.name, len(digest), tt.wantSize)
+		}
+	}
+}
+
+func TestWriteChunk(t *testing.T) {
+	ctx := context.Background()
+	s, _, upload := newResumableUpload(t, 10)
+	storage := s.storageService.(*MemoryStorageService)
+	partObject := "user_1/" + partKey(strings.TrimPrefix(upload.ObjectKey, "user_1/"))
+	sum := func(chunk string) string {
+		digest := sha256.Sum256([]byte(chunk))
+		return checksum("sha256", digest[:])
+	}
+
+	// Chunks fill 4 byte parts; the bytes of a part that isn't full yet are
+	// kept in storage until the next chunk completes it
+	steps := []struct {
+		name       string
+		chunk      string
+		checksum   string
+		wantErr    error
+		wantOffset int64
+		wantParts  int
+		wantPart   string // stored bytes of the part that isn't full yet
+	}{
+		{"partial part", "abc", "", nil, 3, 0, "abc"},
+		{"resumed partial part", "de", sum("de"), nil, 5, 1, "e"},
+		{"several parts", "fghi", "", nil, 9, 2, "i"},
+		{"checksum mismatch", "j", sum("x"), ErrChecksumMismatch, 9, 2, "i"},
+		{"overrun by one byte", "jk", "", ErrInvalidRequest, 9, 2, "i"},
+		{"last short part", "j", sum("j"), nil, 10, 3, ""},
+	}
+	for _, step := range steps {
+		got, err := s.WriteChunk(ctx, upload.UUID, upload.Offset, strings.NewReader(step.chunk), step.checksum, 1)
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: WriteChunk() error = %v, want %v", step.name, err, step.wantErr)
+		}
+		if err == nil {
+			upload = got
+		}
+		if upload.Offset != step.wantOffset || upload.Parts != step.wantParts {
+			t.Errorf("%s: offset, parts = %d, %d, want %d, %d", step.name, upload.Offset, upload.Parts, step.wantOffset, step.wantParts)
+		}
+		if part, err := storage.object(partObject); step.wantPart != "" && (err != nil || !strings.HasPrefix(string(part), step.wantPart)) {
+			t.Errorf("%s: stored part = %q, %v, want it to start with %q", step.name, part, err, step.wantPart)
+		}
+	}
+
+	if upload.Status != domain.UploadStatusComplete {
+		t.Fatalf("status = %s, want %s", upload.Status, domain.UploadStatusComplete)
+	}
+	object, err := storage.object(upload.ObjectKey)
+	if err != nil || string(object) != "abcdefghij" {
+		t.Errorf("object = %q, %v, want \"abcdefghij\"", object, err)
+	}
+	digest := sha256.Sum256([]byte("abcdefghij"))
+	if upload.SHA256 != hex.EncodeToString(digest[:]) {
+		t.Errorf("sha256 = %s, want %x", upload.SHA256, digest)
+	}
+	if _, err := storage.object(partObject); err == nil {
+		t.Errorf("the stored part was not deleted once the upload completed")
+	}
+}
+
+func TestAppendChunkLostLock(t *testing.T) {
+	ctx := context.Background()
+	s, repo, upload := newResumableUpload(t, 10)
+	repo.upload.LockToken = "another request"
+
+	err := s.appendChunk(ctx, upload, strings.NewReader("abcde"), 5, "lost")
+	if !errors.Is(err, ErrUploadLocked) {
+		t.Fatalf("appendChunk() error = %v, want %v", err, ErrUploadLocked)
+	}
+	if repo.upload.Offset != 0 {
+		t.Errorf("saved offset = %d after the lock was lost, want 0", repo.upload.Offset)
+	}
+}
@@ -0,0 +1,570 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3StorageService implements StorageService using a bucket of an S3
+// compatible object store, such as MinIO, AWS S3 or Google Cloud Storage
+type S3StorageService struct {
+	config  *config.Config
+	client  *minio.Client
+	lookup  minio.BucketLookupType // how the client addresses buckets
+	bucket  string
+	baseURL string // URL the keys of objects are appended to
+}
+
+// NewMinioStorageService creates a new S3StorageService for the MinIO server
+// of the configuration, creating its bucket if needed and making it public
+// unless storage is private
+func NewMinioStorageService(config *config.Config) (StorageService, error) {
+	// Initialize MinIO client
+	endpoint := fmt.Sprintf("%s:%s", config.Storage.MinioEndpoint, config.Storage.MinioPort)
+	logger.Info("initializing MinIO client", "endpoint", endpoint)
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.Storage.MinioAccessKey, config.Storage.MinioSecretKey, ""),
+		Secure: config.Storage.MinioUseSSL,
+		Region: config.Storage.MinioRegion,
+	})
+
+	if err != nil {
+		logger.Error("failed to create MinIO client", "error", err)
+		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
+	}
+
+	// Create bucket if it doesn't exist
+	exists, err := client.BucketExists(context.Background(), config.Storage.MinioBucketName)
+	if err != nil {
+		logger.Error("failed to check bucket existence",
+			"bucket", config.Storage.MinioBucketName,
+			"error", err)
+		return nil, fmt.Errorf("failed to check bucket existence: %w", err)
+	}
+
+	if !exists {
+		logger.Info("creating bucket", "bucket", config.Storage.MinioBucketName)
+		err = client.MakeBucket(context.Background(), config.Storage.MinioBucketName, minio.MakeBucketOptions{
+			Region: config.Storage.MinioRegion,
+		})
+		if err != nil {
+			logger.Error("failed to create bucket",
+				"bucket", config.Storage.MinioBucketName,
+				"error", err)
+			return nil, fmt.Errorf("failed to create bucket: %w", err)
+		}
+		logger.Info("bucket created successfully", "bucket", config.Storage.MinioBucketName)
+	}
+
+	// Objects are readable by anyone unless the bucket is private, when any
+	// policy a public bucket had is removed
+	policy := ""
+	if !config.Storage.Private {
+		policy = `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{
+				"Effect": "Allow",
+				"Principal": {"AWS": "*"},
+				"Action": ["s3:GetObject"],
+				"Resource": ["arn:aws:s3:::` + config.Storage.MinioBucketName + `/*"]
+			}
+		]
+	}`
+	}
+
+	err = client.SetBucketPolicy(context.Background(), config.Storage.MinioBucketName, policy)
+	if err != nil {
+		logger.Error("failed to set bucket policy", "error", err)
+		return nil, fmt.Errorf("failed to set bucket policy: %w", err)
+	}
+
+	logger.Info("MinIO storage service initialized successfully")
+	return &S3StorageService{
+		config:  config,
+		client:  client,
+		lookup:  minio.BucketLookupAuto,
+		bucket:  config.Storage.MinioBucketName,
+		baseURL: config.Storage.MinioBucketURL,
+	}, nil
+}
+
+// NewS3StorageService creates a new S3StorageService for an existing bucket
+// of any S3 compatible object store. The bucket is addressed as configured,
+// by virtual host or by path, and its access policy is left alone.
+func NewS3StorageService(config *config.Config) (StorageService, error) {
+	return newS3StorageService(config, "s3.amazonaws.com")
+}
+
+// NewGCSStorageService creates a new S3StorageService for a bucket of Google
+// Cloud Storage, used through its S3 compatible XML API with HMAC keys
+func NewGCSStorageService(config *config.Config) (StorageService, error) {
+	return newS3StorageService(config, "storage.googleapis.com")
+}
+
+// newS3StorageService creates a new S3StorageService from the S3 settings of
+// the configuration, using defaultEndpoint if they name none
+func newS3StorageService(config *config.Config, defaultEndpoint string) (StorageService, error) {
+	settings := s3Settings{
+		Endpoint:     config.Storage.S3Endpoint,
+		Region:       config.Storage.S3Region,
+		UseSSL:       config.Storage.S3UseSSL,
+		BucketLookup: config.Storage.S3BucketLookup,
+		AccessKey:    config.Storage.S3AccessKey,
+		SecretKey:    config.Storage.S3SecretKey,
+		SessionToken: config.Storage.S3SessionToken,
+		RoleARN:      config.Storage.S3RoleARN,
+		STSEndpoint:  config.Storage.S3STSEndpoint,
+	}
+	if settings.Endpoint == "" {
+		settings.Endpoint = defaultEndpoint
+	}
+
+	logger.Info("initializing S3 client", "endpoint", settings.Endpoint, "bucket", config.Storage.S3Bucket)
+	client, lookup, err := newS3Client(settings)
+	if err != nil {
+		logger.Error("failed to create S3 client", "error", err)
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	// The bucket and who may read it are managed outside of the API
+	exists, err := client.BucketExists(context.Background(), config.Storage.S3Bucket)
+	if err != nil {
+		logger.Error("failed to check bucket existence", "bucket", config.Storage.S3Bucket, "error", err)
+		return nil, fmt.Errorf("failed to check bucket existence: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", config.Storage.S3Bucket)
+	}
+
+	baseURL := config.Storage.S3PublicURL
+	if baseURL == "" {
+		baseURL = s3BucketURL(client, config.Storage.S3Bucket, lookup)
+	}
+
+	logger.Info("S3 storage service initialized successfully", "url", baseURL)
+	return &S3StorageService{
+		config:  config,
+		client:  client,
+		lookup:  lookup,
+		bucket:  config.Storage.S3Bucket,
+		baseURL: baseURL,
+	}, nil
+}
+
+// s3Settings are what a client of an S3 compatible object store is created
+// from, taken from the configuration or from a user's storage credential
+type s3Settings struct {
+	Endpoint     string
+	Region       string
+	UseSSL       bool
+	BucketLookup string // "auto", "virtual-host" or "path"
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	RoleARN      string // role assumed with STS using the access key, if set
+	STSEndpoint  string
+}
+
+// newS3Client creates a client of the object store settings describe and
+// returns it with the way it addresses buckets
+func newS3Client(settings s3Settings) (*minio.Client, minio.BucketLookupType, error) {
+	var lookup minio.BucketLookupType
+	switch settings.BucketLookup {
+	case "auto", "":
+		lookup = minio.BucketLookupAuto
+	case "virtual-host":
+		lookup = minio.BucketLookupDNS
+	case "path":
+		lookup = minio.BucketLookupPath
+	default:
+		return nil, lookup, fmt.Errorf("invalid bucket lookup %q, use auto, virtual-host or path", settings.BucketLookup)
+	}
+
+	creds, err := s3Credentials(settings)
+	if err != nil {
+		return nil, lookup, err
+	}
+
+	client, err := minio.New(settings.Endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       settings.UseSSL,
+		Region:       settings.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, lookup, err
+	}
+	return client, lookup, nil
+}
+
+// s3Credentials returns the credentials settings call for: temporary ones of
+// an STS role assumed with the access key, the access key itself, or, without
+// one, those of the AWS environment variables, credentials file or IAM role
+// of the machine
+func s3Credentials(settings s3Settings) (*credentials.Credentials, error) {
+	switch {
+	case settings.RoleARN != "":
+		creds, err := credentials.NewSTSAssumeRole(settings.STSEndpoint, credentials.STSAssumeRoleOptions{
+			AccessKey:       settings.AccessKey,
+			SecretKey:       settings.SecretKey,
+			SessionToken:    settings.SessionToken,
+			Location:        settings.Region,
+			RoleARN:         settings.RoleARN,
+			RoleSessionName: "ffmpeg-api",
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to assume role %s: %w", settings.RoleARN, err)
+		}
+		return creds, nil
+	case settings.AccessKey != "":
+		return credentials.NewStaticV4(settings.AccessKey, settings.SecretKey, settings.SessionToken), nil
+	case settings.SecretKey != "":
+		return nil, errors.New("secret key is set without access key")
+	}
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	}), nil
+}
+
+// s3BucketURL returns the URL of a bucket the client addresses with lookup,
+// https://<bucket>.<endpoint> by virtual host or https://<endpoint>/<bucket>
+// by path. Like the client, auto uses virtual hosts for AWS and Google.
+func s3BucketURL(client *minio.Client, bucket string, lookup minio.BucketLookupType) string {
+	endpoint := client.EndpointURL()
+	virtualHost := lookup == minio.BucketLookupDNS
+	if lookup == minio.BucketLookupAuto {
+		host := endpoint.Hostname()
+		virtualHost = !strings.Contains(bucket, ".") &&
+			(strings.HasSuffix(host, ".amazonaws.com") || host == "storage.googleapis.com")
+	}
+	if virtualHost {
+		return fmt.Sprintf("%s://%s.%s", endpoint.Scheme, bucket, endpoint.Host)
+	}
+	return fmt.Sprintf("%s://%s/%s", endpoint.Scheme, endpoint.Host, bucket)
+}
+
+func (s *S3StorageService) DownloadFile(ctx context.Context, url string) (string, error) {
+	logger.Debug("downloading file", "url", url)
+
+	// Create temporary file
+	tmpFile, err := os.CreateTemp(s.config.Storage.TempDirectory, "input-*")
+	if err != nil {
+		logger.Error("failed to create temp file", "error", err)
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer tmpFile.Close()
+
+	// For external URLs, download using HTTP
+	if isExternalURL(url) {
+		logger.Debug("downloading from external URL", "url", url)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			logger.Error("failed to download from external URL", "error", err)
+			return "", fmt.Errorf("failed to download file: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			os.Remove(tmpFile.Name())
+			logger.Error("failed to download file",
+				"status_code", resp.StatusCode,
+				"url", url)
+			return "", fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+		}
+
+		if _, err := io.Copy(tmpFile, resp.Body); err != nil {
+			os.Remove(tmpFile.Name())
+			logger.Error("failed to save downloaded file", "error", err)
+			return "", fmt.Errorf("failed to save file: %w", err)
+		}
+	} else {
+		// For S3 objects, get the object
+		logger.Debug("downloading from S3",
+			"bucket", s.bucket,
+			"object", url)
+		object, err := s.client.GetObject(ctx, s.bucket, url, minio.GetObjectOptions{})
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			logger.Error("failed to get object from S3", "error", err)
+			return "", fmt.Errorf("failed to get object from S3: %w", err)
+		}
+		defer object.Close()
+
+		if _, err := io.Copy(tmpFile, object); err != nil {
+			os.Remove(tmpFile.Name())
+			logger.Error("failed to save S3 object", "error", err)
+			return "", fmt.Errorf("failed to save file: %w", err)
+		}
+	}
+
+	logger.Debug("file downloaded successfully", "path", tmpFile.Name())
+	return tmpFile.Name(), nil
+}
+
+func (s *S3StorageService) UploadFile(ctx context.Context, localPath string, objectKey string, userID uint) (string, error) {
+	logger.Debug("uploading file",
+		"local_path", localPath,
+		"object_key", objectKey,
+		"user_id", userID)
+
+	// Open the local file
+	file, err := os.Open(localPath)
+	if err != nil {
+		logger.Error("failed to open file", "error", err)
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Get file info for content-type detection
+	fileInfo, err := file.Stat()
+	if err != nil {
+		logger.Error("failed to get file info", "error", err)
+		return "", fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	// Create user-specific object key
+	userObjectKey := fmt.Sprintf("user_%d/%s", userID, objectKey)
+
+	// Upload the file to S3
+	logger.Debug("uploading to S3",
+		"bucket", s.bucket,
+		"object", userObjectKey,
+		"size", fileInfo.Size())
+
+	_, err = s.client.PutObject(ctx, s.bucket, userObjectKey, file, fileInfo.Size(),
+		minio.PutObjectOptions{
+			ContentType:        ContentType(localPath),
+			ContentDisposition: ContentDisposition(filepath.Base(localPath)),
+			UserMetadata: map[string]string{
+				"x-amz-meta-filename": objectKey,
+				"x-amz-meta-userid":   fmt.Sprintf("%d", userID),
+			},
+			Expires: time.Now().Add(time.Hour),
+		})
+	if err != nil {
+		logger.Error("failed to upload file to S3", "error", err)
+		return "", fmt.Errorf("failed to upload file to S3: %w", err)
+	}
+
+	logger.Info("file uploaded successfully",
+		"bucket", s.bucket,
+		"object", userObjectKey)
+
+	return s.objectURL(ctx, userObjectKey)
+}
+
+func (s *S3StorageService) UploadStream(ctx context.Context, r io.Reader, size int64, objectKey string, contentType string, userID uint) (string, error) {
+	userObjectKey := fmt.Sprintf("user_%d/%s", userID, objectKey)
+	logger.Debug("streaming to S3",
+		"bucket", s.bucket,
+		"object", userObjectKey,
+		"size", size)
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// Streams of unknown size are uploaded in parts
+	_, err := s.client.PutObject(ctx, s.bucket, userObjectKey, r, size,
+		minio.PutObjectOptions{
+			ContentType: contentType,
+			UserMetadata: map[string]string{
+				"x-amz-meta-filename": objectKey,
+				"x-amz-meta-userid":   fmt.Sprintf("%d", userID),
+			},
+		})
+	if err != nil {
+		logger.Error("failed to stream file to S3", "error", err)
+		return "", fmt.Errorf("failed to upload file to S3: %w", err)
+	}
+
+	logger.Info("file uploaded successfully",
+		"bucket", s.bucket,
+		"object", userObjectKey)
+	return s.objectURL(ctx, userObjectKey)
+}
+
+func (s *S3StorageService) CreateMultipartUpload(ctx context.Context, objectKey string, contentType string, userID uint) (string, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	core := minio.Core{Client: s.client}
+	uploadID, err := core.NewMultipartUpload(ctx, s.bucket, fmt.Sprintf("user_%d/%s", userID, objectKey),
+		minio.PutObjectOptions{
+			ContentType: contentType,
+			UserMetadata: map[string]string{
+				"x-amz-meta-filename": objectKey,
+				"x-amz-meta-userid":   fmt.Sprintf("%d", userID),
+			},
+		})
+	if err != nil {
+		logger.Error("failed to start multipart upload in S3", "error", err)
+		return "", fmt.Errorf("failed to start multipart upload in S3: %w", err)
+	}
+	return uploadID, nil
+}
+
+func (s *S3StorageService) UploadPart(ctx context.Context, objectKey string, uploadID string, n int, offset int64, r io.Reader, size int64, userID uint) error {
+	core := minio.Core{Client: s.client}
+	_, err := core.PutObjectPart(ctx, s.bucket, fmt.Sprintf("user_%d/%s", userID, objectKey), uploadID, n, r, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		logger.Error("failed to upload part to S3", "part", n, "error", err)
+		return fmt.Errorf("failed to upload part %d to S3: %w", n, err)
+	}
+	return nil
+}
+
+func (s *S3StorageService) CompleteMultipartUpload(ctx context.Context, objectKey string, uploadID string, userID uint) (string, error) {
+	core := minio.Core{Client: s.client}
+	userObjectKey := fmt.Sprintf("user_%d/%s", userID, objectKey)
+
+	// The parts are listed rather than remembered, as they may have been
+	// written by other nodes
+	var parts []minio.CompletePart
+	marker := 0
+	for {
+		result, err := core.ListObjectParts(ctx, s.bucket, userObjectKey, uploadID, marker, 1000)
+		if err != nil {
+			return "", fmt.Errorf("failed to list parts in S3: %w", err)
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	if _, err := core.CompleteMultipartUpload(ctx, s.bucket, userObjectKey, uploadID, parts, minio.PutObjectOptions{}); err != nil {
+		logger.Error("failed to complete multipart upload in S3", "error", err)
+		return "", fmt.Errorf("failed to complete multipart upload in S3: %w", err)
+	}
+
+	logger.Info("file uploaded successfully",
+		"bucket", s.bucket,
+		"object", userObjectKey)
+	return s.objectURL(ctx, userObjectKey)
+}
+
+func (s *S3StorageService) AbortMultipartUpload(ctx context.Context, objectKey string, uploadID string, userID uint) error {
+	core := minio.Core{Client: s.client}
+	if err := core.AbortMultipartUpload(ctx, s.bucket, fmt.Sprintf("user_%d/%s", userID, objectKey), uploadID); err != nil {
+		return fmt.Errorf("failed to abort multipart upload in S3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3StorageService) DeleteFile(ctx context.Context, localPath string) error {
+	logger.Debug("deleting file", "path", localPath)
+	if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		logger.Error("failed to delete file", "error", err)
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (s *S3StorageService) StatFile(ctx context.Context, url string) (int64, error) {
+	if isExternalURL(url) {
+		return statURL(ctx, url)
+	}
+
+	info, err := s.client.StatObject(ctx, s.bucket, url, minio.StatObjectOptions{})
+	if err != nil {
+		logger.Error("failed to stat object in S3", "object", url, "error", err)
+		return 0, fmt.Errorf("failed to stat object in S3: %w", err)
+	}
+	return info.Size, nil
+}
+
+func (s *S3StorageService) OpenFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	if isExternalURL(url) {
+		return openURL(ctx, url)
+	}
+	object, err := s.client.GetObject(ctx, s.bucket, url, minio.GetObjectOptions{})
+	if err != nil {
+		logger.Error("failed to get object from S3", "error", err)
+		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	// GetObject doesn't request the object before it is read
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		logger.Error("failed to get object from S3", "object", url, "error", err)
+		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	return object, nil
+}
+
+func (s *S3StorageService) StreamURL(ctx context.Context, url string) (string, error) {
+	if isExternalURL(url) {
+		return url, nil
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, url, streamURLExpiry, nil)
+	if err != nil {
+		logger.Error("failed to presign URL", "object", url, "error", err)
+		return "", fmt.Errorf("failed to presign URL: %w", err)
+	}
+	return u.String(), nil
+}
+
+func (s *S3StorageService) ObjectURL(ctx context.Context, objectKey string, userID uint) (string, error) {
+	return s.objectURL(ctx, fmt.Sprintf("user_%d/%s", userID, objectKey))
+}
+
+func (s *S3StorageService) ObjectExists(ctx context.Context, objectKey string, userID uint) (bool, error) {
+	_, err := s.client.StatObject(ctx, s.bucket, fmt.Sprintf("user_%d/%s", userID, objectKey), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat object in S3: %w", err)
+	}
+	return true, nil
+}
+
+func (s *S3StorageService) DeleteObject(ctx context.Context, objectKey string, userID uint) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, fmt.Sprintf("user_%d/%s", userID, objectKey), minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete object from S3: %w", err)
+	}
+	return nil
+}
+
+// objectURL returns the URL of the object with the given key, presigned if
+// storage is private
+func (s *S3StorageService) objectURL(ctx context.Context, key string) (string, error) {
+	if !s.config.Storage.Private {
+		return fmt.Sprintf("%s/%s", s.baseURL, key), nil
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, s.config.Storage.URLExpiry, nil)
+	if err != nil {
+		logger.Error("failed to presign URL", "object", key, "error", err)
+		return "", fmt.Errorf("failed to presign URL: %w", err)
+	}
+	return u.String(), nil
+}
+
+// isExternalURL checks if the URL is external (starts with http:// or https://)
+func isExternalURL(url string) bool {
+	return len(url) > 7 && (url[:7] == "http://" || url[:8] == "https://")
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"ffmpeg-api/internal/domain"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// outputFile is a file of a job's output in the user's storage
+type outputFile struct {
+	fileID string // of the output
+	path   string // of the file in the job's directory
+}
+
+// jobObjects returns the object keys of the files of a job's outputs in the
+// user's storage, and the files of those referring to other files, such as
+// HLS playlists, by object key. A file of several outputs is served as a file
+// of the first.
+func jobObjects(job *domain.JobStatus) ([]string, map[string]outputFile) {
+	var objectKeys []string
+	files := make(map[string]outputFile)
+	add := func(objectKey string, file outputFile) {
+		if _, ok := files[objectKey]; !ok && refersToFiles(file.path) {
+			files[objectKey] = file
+		}
+		objectKeys = append(objectKeys, objectKey)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(job.OutputFiles)) {
+		metadata := job.OutputFiles[key]
+		for _, file := range metadata.Files {
+			if file.ObjectKey != "" {
+				add(file.ObjectKey, outputFile{fileID: metadata.FileID, path: file.Path})
+			}
+		}
+		if metadata.ObjectKey != "" && len(metadata.Files) == 0 {
+			add(metadata.ObjectKey, outputFile{fileID: metadata.FileID, path: outputFileName(job, key, metadata)})
+		}
+	}
+	return objectKeys, files
+}
+
+// outputFileName returns the name of the file of a single file output
+func outputFileName(job *domain.JobStatus, key string, metadata domain.OutputFileMetadata) string {
+	if job.OriginalRequest != nil && job.OriginalRequest.OutputFiles[key] != "" {
+		return job.OriginalRequest.OutputFiles[key]
+	}
+	return key + "." + metadata.FileFormat
+}
+
+// objectURL returns a URL of the object of a job's file issued anew. Files
+// referring to other files in private storage get a URL of GetPlaylist, which
+// refers to the other files by URLs issued as it serves them.
+func (s *FFMPEGServiceImpl) objectURL(ctx context.Context, job *domain.JobStatus, objectKey string, files map[string]outputFile) (string, error) {
+	if file, ok := files[objectKey]; ok && s.config.Storage.Private {
+		return s.playlistURL(job.UserID, file), nil
+	}
+	return s.storageService.ObjectURL(ctx, objectKey, job.UserID)
+}
+
+// playlistURL returns the URL GetPlaylist serves a file at, signed to expire
+// after STORAGE_URL_EXPIRY_MINUTES
+func (s *FFMPEGServiceImpl) playlistURL(userID uint, file outputFile) string {
+	segments := strings.Split(file.path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	expires := strconv.FormatInt(time.Now().Add(s.config.Storage.URLExpiry).Unix(), 10)
+	return fmt.Sprintf("%s/%d/%s/%s?expires=%s&signature=%s",
+		s.config.Storage.PlaylistBaseURL, userID, file.fileID, strings.Join(segments, "/"),
+		expires, hex.EncodeToString(s.playlistSignature(userID, file, expires)))
+}
+
+// playlistSignature returns the HMAC-SHA256 of a served file and the Unix
+// time its URL expires at
+func (s *FFMPEGServiceImpl) playlistSignature(userID uint, file outputFile, expires string) []byte {
+	mac := hmac.New(sha256.New, []byte(s.config.Storage.URLSigningKey))
+	fmt.Fprintf(mac, "%d/%s/%s\n%s", userID, file.fileID, file.path, expires)
+	return mac.Sum(nil)
+}
+
+// GetPlaylist returns an HLS playlist, DASH manifest or WebVTT storyboard of
+// a job's output in private storage, given by the parameters of a URL
+// playlistURL signed, with the files it refers to replaced by URLs issued
+// anew. The stored object is left as it is.
+func (s *FFMPEGServiceImpl) GetPlaylist(ctx context.Context, userID uint, fileID, filePath, expires, signature string) ([]byte, error) {
+	if !s.config.Storage.Private {
+		return nil, ErrFileNotFound
+	}
+	file := outputFile{fileID: fileID, path: filePath}
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return nil, ErrInvalidSignature
+	}
+	mac, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.playlistSignature(userID, file, expires)) {
+		return nil, ErrInvalidSignature
+	}
+
+	if _, err := uuid.Parse(fileID); err != nil {
+		return nil, ErrFileNotFound
+	}
+	job, err := s.jobRepo.FindByFileID(ctx, fileID, userID)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+	objectKeys, files := jobObjects(job)
+	objectKey := ""
+	for key, f := range files {
+		if f == file {
+			objectKey = key
+		}
+	}
+	if objectKey == "" {
+		return nil, ErrFileNotFound
+	}
+
+	object, err := s.storageService.OpenFile(ctx, fmt.Sprintf("user_%d/%s", userID, objectKey))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(object)
+	object.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var urlErr error
+	data = replaceReferences(data, strings.ToLower(path.Ext(filePath)), func(uri string) string {
+		key := referencedObjectKey(uri, objectKeys)
+		if key == "" || urlErr != nil {
+			return uri
+		}
+		fileURL, err := s.objectURL(ctx, job, key, files)
+		if err != nil {
+			urlErr = err
+			return uri
+		}
+		return fileURL
+	})
+	if urlErr != nil {
+		return nil, fmt.Errorf("failed to issue the URLs %s refers to: %w", filePath, urlErr)
+	}
+	return data, nil
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/repository"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+const (
+	maxCredentialNameLength = 64
+	maxPrefixLength         = 1024
+)
+
+// credentialNameRegexp matches the names of storage credentials
+var credentialNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// storageProviderEndpoints maps storage credential providers to the
+// endpoints used if a credential names none
+var storageProviderEndpoints = map[string]string{
+	domain.StorageProviderS3:  "s3.amazonaws.com",
+	domain.StorageProviderGCS: "storage.googleapis.com",
+}
+
+// StorageCredentialServiceImpl implements StorageCredentialService. Secret
+// keys and session tokens are encrypted with AES-256-GCM under a key derived
+// from STORAGE_CREDENTIALS_KEY; without one, credentials can't be created or
+// used.
+type StorageCredentialServiceImpl struct {
+	credentialRepo repository.StorageCredentialRepository
+	config         *config.Config
+	aead           cipher.AEAD // nil if no credentials key is configured
+}
+
+// NewStorageCredentialService creates a new StorageCredentialService
+func NewStorageCredentialService(credentialRepo repository.StorageCredentialRepository, config *config.Config) StorageCredentialService {
+	s := &StorageCredentialServiceImpl{
+		credentialRepo: credentialRepo,
+		config:         config,
+	}
+	if config.Storage.CredentialsKey != "" {
+		key := sha256.Sum256([]byte(config.Storage.CredentialsKey))
+		block, _ := aes.NewCipher(key[:]) // a 32 byte key is always valid
+		s.aead, _ = cipher.NewGCM(block)
+	}
+	return s
+}
+
+// ListCredentials returns the user's storage credentials ordered by name
+func (s *StorageCredentialServiceImpl) ListCredentials(ctx context.Context, userID uint) ([]domain.StorageCredential, error) {
+	credentials, err := s.credentialRepo.FindByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// GetCredential returns the user's storage credential with the given name
+func (s *StorageCredentialServiceImpl) GetCredential(ctx context.Context, name string, userID uint) (*domain.StorageCredential, error) {
+	credential, err := s.credentialRepo.FindByName(ctx, userID, name)
+	if err != nil {
+		return nil, ErrCredentialNotFound
+	}
+	return credential, nil
+}
+
+// CreateCredential validates a storage credential, fills in the defaults of
+// its provider and stores it with its secrets encrypted
+func (s *StorageCredentialServiceImpl) CreateCredential(ctx context.Context, credential *domain.StorageCredential) error {
+	if s.aead == nil {
+		return ErrCredentialsDisabled
+	}
+
+	credential.ID = 0
+	if err := normalizeCredential(credential); err != nil {
+		return err
+	}
+	if _, _, err := newS3Client(s.settings(credential)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+	if _, err := s.credentialRepo.FindByName(ctx, credential.UserID, credential.Name); err == nil {
+		return ErrCredentialExists
+	}
+
+	var err error
+	if credential.SecretKey, err = s.sealSecret(credential.SecretKey); err != nil {
+		return err
+	}
+	if credential.SessionToken, err = s.sealSecret(credential.SessionToken); err != nil {
+		return err
+	}
+	if err := s.credentialRepo.Create(ctx, credential); err != nil {
+		return fmt.Errorf("failed to create storage credential: %w", err)
+	}
+	return nil
+}
+
+// DeleteCredential deletes the user's storage credential with the given name.
+// Queued jobs delivering outputs with it fail when they finish.
+func (s *StorageCredentialServiceImpl) DeleteCredential(ctx context.Context, name string, userID uint) error {
+	credential, err := s.GetCredential(ctx, name, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.credentialRepo.Delete(ctx, credential.ID); err != nil {
+		return fmt.Errorf("failed to delete storage credential: %w", err)
+	}
+	return nil
+}
+
+// ResolveDestination checks the bucket and prefix of a destination and
+// returns it with a client of the named credential. Without one, the bucket
+// must be one the storage may deliver to with its own credentials.
+func (s *StorageCredentialServiceImpl) ResolveDestination(ctx context.Context, dest domain.OutputDestination, userID uint) (*Destination, error) {
+	if err := s3utils.CheckValidBucketNameStrict(dest.Bucket); err != nil {
+		return nil, fmt.Errorf("invalid bucket %q: %v", dest.Bucket, err)
+	}
+	prefix := dest.Prefix
+	switch {
+	case len(prefix) > maxPrefixLength:
+		return nil, fmt.Errorf("prefix may be at most %d bytes", maxPrefixLength)
+	case strings.HasPrefix(prefix, "/"):
+		return nil, errors.New("prefix must not start with '/'")
+	case slices.Contains(strings.Split(prefix, "/"), ".."):
+		return nil, errors.New("prefix must not contain '..'")
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	resolved := &Destination{Bucket: dest.Bucket, Prefix: prefix}
+
+	if dest.Credentials == "" {
+		if !slices.Contains(s.config.Storage.DestinationBuckets, dest.Bucket) {
+			return nil, fmt.Errorf("bucket %s needs storage credentials", dest.Bucket)
+		}
+		return resolved, nil
+	}
+
+	if s.aead == nil {
+		return nil, ErrCredentialsDisabled
+	}
+	credential, err := s.GetCredential(ctx, dest.Credentials, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, dest.Credentials)
+	}
+	settings := s.settings(credential)
+	if settings.SecretKey, err = s.openSecret(credential.SecretKey); err != nil {
+		return nil, err
+	}
+	if settings.SessionToken, err = s.openSecret(credential.SessionToken); err != nil {
+		return nil, err
+	}
+	client, lookup, err := newS3Client(settings)
+	if err != nil {
+		return nil, fmt.Errorf("storage credential %s: %v", credential.Name, err)
+	}
+
+	resolved.client = client
+	resolved.baseURL = strings.ReplaceAll(credential.PublicURL, "{bucket}", dest.Bucket)
+	if resolved.baseURL == "" {
+		resolved.baseURL = s3BucketURL(client, dest.Bucket, lookup)
+	}
+	return resolved, nil
+}
+
+// settings returns the client settings of a storage credential
+func (s *StorageCredentialServiceImpl) settings(credential *domain.StorageCredential) s3Settings {
+	return s3Settings{
+		Endpoint:     credential.Endpoint,
+		Region:       credential.Region,
+		UseSSL:       credential.UseSSL,
+		BucketLookup: credential.BucketLookup,
+		AccessKey:    credential.AccessKey,
+		SecretKey:    credential.SecretKey,
+		SessionToken: credential.SessionToken,
+		RoleARN:      credential.RoleARN,
+		STSEndpoint:  s.config.Storage.S3STSEndpoint,
+	}
+}
+
+// normalizeCredential validates a storage credential and fills in the
+// defaults of its provider (s3), endpoint, region (us-east-1) and bucket
+// lookup (auto)
+func normalizeCredential(credential *domain.StorageCredential) error {
+	if len(credential.Name) > maxCredentialNameLength || !credentialNameRegexp.MatchString(credential.Name) {
+		return fmt.Errorf("%w: name %q may only contain letters, digits, '_', '.' and '-'", ErrInvalidCredential, credential.Name)
+	}
+	if credential.Provider == "" {
+		credential.Provider = domain.StorageProviderS3
+	}
+	defaultEndpoint, ok := storageProviderEndpoints[credential.Provider]
+	if !ok {
+		return fmt.Errorf("%w: provider must be %s or %s", ErrInvalidCredential, domain.StorageProviderS3, domain.StorageProviderGCS)
+	}
+	if credential.Endpoint == "" {
+		credential.Endpoint = defaultEndpoint
+	}
+	if strings.Contains(credential.Endpoint, "/") {
+		return fmt.Errorf("%w: endpoint must be a host and optional port, without scheme or path", ErrInvalidCredential)
+	}
+	if credential.Region == "" {
+		credential.Region = "us-east-1"
+	}
+	if credential.BucketLookup == "" {
+		credential.BucketLookup = "auto"
+	}
+	if credential.AccessKey == "" || credential.SecretKey == "" {
+		return fmt.Errorf("%w: access key and secret key are required", ErrInvalidCredential)
+	}
+	credential.PublicURL = strings.TrimSuffix(credential.PublicURL, "/")
+	if credential.PublicURL != "" && !isExternalURL(credential.PublicURL) {
+		return fmt.Errorf("%w: public URL must be an http(s) URL", ErrInvalidCredential)
+	}
+	return nil
+}
+
+// sealSecret encrypts a secret, returning the nonce followed by the
+// ciphertext base64 encoded. Empty secrets stay empty.
+func (s *StorageCredentialServiceImpl) sealSecret(secret string) (string, error) {
+	if secret == "" {
+		return "", nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// openSecret decrypts a secret sealed by sealSecret
+func (s *StorageCredentialServiceImpl) openSecret(sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return "", errors.New("failed to decrypt storage credential: malformed secret")
+	}
+	secret, err := s.aead.Open(nil, data[:s.aead.NonceSize()], data[s.aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt storage credential, was STORAGE_CREDENTIALS_KEY changed?")
+	}
+	return string(secret), nil
+}
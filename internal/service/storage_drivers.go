@@ -0,0 +1,51 @@
+package service
+
+import (
+	"ffmpeg-api/internal/config"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// StorageDriver creates a StorageService from the configuration
+type StorageDriver func(config *config.Config) (StorageService, error)
+
+// storageDrivers holds the registered storage drivers by name
+var storageDrivers = map[string]StorageDriver{}
+
+func init() {
+	RegisterStorageDriver("local", func(config *config.Config) (StorageService, error) {
+		return NewLocalStorageService(config), nil
+	})
+	RegisterStorageDriver("filesystem", NewFilesystemStorageService)
+	RegisterStorageDriver("minio", NewMinioStorageService)
+	RegisterStorageDriver("s3", NewS3StorageService)
+	RegisterStorageDriver("gcs", NewGCSStorageService)
+	RegisterStorageDriver("memory", func(config *config.Config) (StorageService, error) {
+		return NewMemoryStorageService(config), nil
+	})
+}
+
+// RegisterStorageDriver makes a storage driver available under name, the
+// STORAGE_PROVIDER that selects it. It panics if the name is taken.
+func RegisterStorageDriver(name string, driver StorageDriver) {
+	if _, ok := storageDrivers[name]; ok {
+		panic("storage driver " + name + " registered twice")
+	}
+	storageDrivers[name] = driver
+}
+
+// NewStorageService creates a StorageService with the configured driver
+// after checking the key template of output files
+func NewStorageService(config *config.Config) (StorageService, error) {
+	if err := checkKeyTemplate(config.Storage.KeyTemplate, config.Storage.Dedup); err != nil {
+		return nil, err
+	}
+	driver, ok := storageDrivers[config.Storage.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage provider %q, use one of %s",
+			config.Storage.Provider, strings.Join(slices.Sorted(maps.Keys(storageDrivers)), ", "))
+	}
+	return driver(config)
+}
@@ -2,6 +2,10 @@ package service
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"ffmpeg-api/internal/config"
 	"ffmpeg-api/internal/logger"
 	"fmt"
@@ -9,18 +13,90 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// streamURLExpiry is how long the URLs FFMPEG streams objects from are valid,
+// long enough for it to seek in them while long jobs run
+const streamURLExpiry = 24 * time.Hour
+
 // LocalStorageService implements StorageService using local filesystem
 type LocalStorageService struct {
-	config *config.Config
+	config     *config.Config
+	root       string // directory objects are kept in
+	baseURL    string // URL the keys of objects are appended to
+	signingKey []byte // signs object URLs that expire, if set
 }
 
-// NewLocalStorageService creates a new LocalStorageService
+// NewLocalStorageService creates a new LocalStorageService keeping objects in
+// the temp directory, for development
 func NewLocalStorageService(config *config.Config) StorageService {
 	return &LocalStorageService{
-		config: config,
+		config:  config,
+		root:    filepath.Join(config.Storage.TempDirectory, "uploads"),
+		baseURL: config.Storage.TempDirectory,
+	}
+}
+
+// FilesystemStorageService is a LocalStorageService whose objects this server
+// serves for download
+type FilesystemStorageService struct {
+	*LocalStorageService
+}
+
+// NewFilesystemStorageService creates a new FilesystemStorageService keeping
+// objects in the configured root directory
+func NewFilesystemStorageService(config *config.Config) (StorageService, error) {
+	var signingKey []byte
+	if config.Storage.Private {
+		if config.Storage.URLSigningKey == "" {
+			return nil, errors.New("private filesystem storage needs STORAGE_URL_SIGNING_KEY to sign URLs")
+		}
+		signingKey = []byte(config.Storage.URLSigningKey)
 	}
+	if err := os.MkdirAll(config.Storage.FSRoot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	logger.Info("filesystem storage initialized", "root", config.Storage.FSRoot, "url", config.Storage.FSBaseURL)
+	return &FilesystemStorageService{
+		LocalStorageService: &LocalStorageService{
+			config:     config,
+			root:       config.Storage.FSRoot,
+			baseURL:    config.Storage.FSBaseURL,
+			signingKey: signingKey,
+		},
+	}, nil
+}
+
+// ObjectPath returns the path of the object with the given key, to serve it
+func (s *FilesystemStorageService) ObjectPath(key string) (string, error) {
+	return s.objectPath(key)
+}
+
+// CheckSignature reports whether the expires and signature parameters of a
+// URL of the object with the given key allow downloading it. Any do unless
+// storage is private.
+func (s *FilesystemStorageService) CheckSignature(key, expires, signature string) bool {
+	if s.signingKey == nil {
+		return true
+	}
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return false
+	}
+	mac, err := hex.DecodeString(signature)
+	return err == nil && hmac.Equal(mac, s.signature(key, expires))
+}
+
+// signature returns the HMAC-SHA256 of an object key and the Unix time its
+// URL expires at
+func (s *LocalStorageService) signature(key, expires string) []byte {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return mac.Sum(nil)
 }
 
 func (s *LocalStorageService) DownloadFile(ctx context.Context, url string) (string, error) {
@@ -33,21 +109,43 @@ func (s *LocalStorageService) DownloadFile(ctx context.Context, url string) (str
 	}
 	defer tmpFile.Close()
 
-	// Download file
-	resp, err := http.Get(url)
-	if err != nil {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("failed to download file: %w", err)
-	}
-	defer resp.Body.Close()
+	// Anything but an external URL is the key of an uploaded object
+	var src io.Reader
+	if isExternalURL(url) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", fmt.Errorf("failed to download file: %w", err)
+		}
+		defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+		if resp.StatusCode != http.StatusOK {
+			os.Remove(tmpFile.Name())
+			return "", fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+		}
+		src = resp.Body
+	} else {
+		objectPath, err := s.objectPath(url)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", err
+		}
+		object, err := os.Open(objectPath)
+		if err != nil {
+			os.Remove(tmpFile.Name())
+			return "", fmt.Errorf("failed to open object: %w", err)
+		}
+		defer object.Close()
+		src = &contextReader{ctx: ctx, r: object}
 	}
 
 	// Copy file contents
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
+	if _, err := io.Copy(tmpFile, src); err != nil {
 		os.Remove(tmpFile.Name())
 		return "", fmt.Errorf("failed to save file: %w", err)
 	}
@@ -57,33 +155,89 @@ func (s *LocalStorageService) DownloadFile(ctx context.Context, url string) (str
 
 func (s *LocalStorageService) UploadFile(ctx context.Context, localPath string, objectKey string, userID uint) (string, error) {
 	// For local storage, we'll just copy the file to a permanent location
+	srcFile, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer srcFile.Close()
+
+	return s.UploadStream(ctx, srcFile, -1, objectKey, "", userID)
+}
+
+func (s *LocalStorageService) UploadStream(ctx context.Context, r io.Reader, size int64, objectKey string, contentType string, userID uint) (string, error) {
 	userPath := fmt.Sprintf("user_%d", userID)
-	destPath := filepath.Join(s.config.Storage.TempDirectory, "uploads", userPath, objectKey)
+	destPath := filepath.Join(s.root, userPath, objectKey)
 
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		return "", fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// Copy file
-	srcFile, err := os.Open(localPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open source file: %w", err)
-	}
-	defer srcFile.Close()
-
 	destFile, err := os.Create(destPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to create destination file: %w", err)
 	}
 	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
+	if _, err := io.Copy(destFile, &contextReader{ctx: ctx, r: r}); err != nil {
+		os.Remove(destPath)
 		return "", fmt.Errorf("failed to copy file: %w", err)
 	}
 
-	// Return URL
-	return fmt.Sprintf("%s/%s/%s", s.config.Storage.TempDirectory, userPath, objectKey), nil
+	return s.objectURL(userPath + "/" + objectKey), nil
+}
+
+// Multipart uploads are written to <object>.partial, which completing them
+// renames to the object
+func (s *LocalStorageService) CreateMultipartUpload(ctx context.Context, objectKey string, contentType string, userID uint) (string, error) {
+	destPath := filepath.Join(s.root, fmt.Sprintf("user_%d", userID), objectKey)
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(destPath+".partial", nil, 0644); err != nil {
+		return "", fmt.Errorf("failed to create partial file: %w", err)
+	}
+	return "local", nil
+}
+
+func (s *LocalStorageService) UploadPart(ctx context.Context, objectKey string, uploadID string, n int, offset int64, r io.Reader, size int64, userID uint) error {
+	partialPath := filepath.Join(s.root, fmt.Sprintf("user_%d", userID), objectKey) + ".partial"
+	file, err := os.OpenFile(partialPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer file.Close()
+
+	// Dropping everything from the part on replaces a part written before
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate partial file: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek partial file: %w", err)
+	}
+	if _, err := io.Copy(file, &contextReader{ctx: ctx, r: r}); err != nil {
+		return fmt.Errorf("failed to write part %d: %w", n, err)
+	}
+	return nil
+}
+
+func (s *LocalStorageService) CompleteMultipartUpload(ctx context.Context, objectKey string, uploadID string, userID uint) (string, error) {
+	userPath := fmt.Sprintf("user_%d", userID)
+	destPath := filepath.Join(s.root, userPath, objectKey)
+	if err := os.Rename(destPath+".partial", destPath); err != nil {
+		return "", fmt.Errorf("failed to complete upload: %w", err)
+	}
+	return s.objectURL(userPath + "/" + objectKey), nil
+}
+
+func (s *LocalStorageService) AbortMultipartUpload(ctx context.Context, objectKey string, uploadID string, userID uint) error {
+	partialPath := filepath.Join(s.root, fmt.Sprintf("user_%d", userID), objectKey) + ".partial"
+	if err := os.Remove(partialPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete partial file: %w", err)
+	}
+	// Remove the directory the object would have been in if it's now empty
+	os.Remove(filepath.Dir(partialPath))
+	return nil
 }
 
 func (s *LocalStorageService) DeleteFile(ctx context.Context, localPath string) error {
@@ -92,3 +246,153 @@ func (s *LocalStorageService) DeleteFile(ctx context.Context, localPath string)
 	}
 	return nil
 }
+
+func (s *LocalStorageService) StatFile(ctx context.Context, url string) (int64, error) {
+	if isExternalURL(url) {
+		return statURL(ctx, url)
+	}
+
+	objectPath, err := s.objectPath(url)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(objectPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return info.Size(), nil
+}
+
+func (s *LocalStorageService) ObjectURL(ctx context.Context, objectKey string, userID uint) (string, error) {
+	return s.objectURL(fmt.Sprintf("user_%d/%s", userID, objectKey)), nil
+}
+
+func (s *LocalStorageService) ObjectExists(ctx context.Context, objectKey string, userID uint) (bool, error) {
+	objectPath, err := s.objectPath(fmt.Sprintf("user_%d/%s", userID, objectKey))
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(objectPath); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return true, nil
+}
+
+func (s *LocalStorageService) DeleteObject(ctx context.Context, objectKey string, userID uint) error {
+	objectPath, err := s.objectPath(fmt.Sprintf("user_%d/%s", userID, objectKey))
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(objectPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorageService) OpenFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	if isExternalURL(url) {
+		return openURL(ctx, url)
+	}
+	objectPath, err := s.objectPath(url)
+	if err != nil {
+		return nil, err
+	}
+	object, err := os.Open(objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	return object, nil
+}
+
+func (s *LocalStorageService) StreamURL(ctx context.Context, url string) (string, error) {
+	if isExternalURL(url) {
+		return url, nil
+	}
+	if _, err := s.objectPath(url); err != nil {
+		return "", err
+	}
+	// Only the filesystem driver serves its objects over HTTP
+	if !isExternalURL(s.baseURL) {
+		return "", ErrNoStreamURL
+	}
+	return s.expiringURL(url, streamURLExpiry), nil
+}
+
+// objectURL returns the URL of the object with the given key, signed to
+// expire if there is a signing key
+func (s *LocalStorageService) objectURL(key string) string {
+	return s.expiringURL(key, s.config.Storage.URLExpiry)
+}
+
+// expiringURL returns the URL of the object with the given key, signed to
+// expire after expiry if there is a signing key
+func (s *LocalStorageService) expiringURL(key string, expiry time.Duration) string {
+	url := fmt.Sprintf("%s/%s", s.baseURL, key)
+	if s.signingKey == nil {
+		return url
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	return fmt.Sprintf("%s?expires=%s&signature=%s", url, expires, hex.EncodeToString(s.signature(key, expires)))
+}
+
+// objectPath returns the path of the uploaded object with the given key, such
+// as user_1/input/<uuid>/video.mp4
+func (s *LocalStorageService) objectPath(key string) (string, error) {
+	if !filepath.IsLocal(key) || slices.Contains(strings.Split(key, "/"), "..") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// openURL starts downloading the file at an HTTP URL and returns its body
+func openURL(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download file: status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// statURL returns the size of the file at an HTTP URL from the
+// Content-Length of a HEAD request, or -1 if the server doesn't send it
+func statURL(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to stat file: status code %d", resp.StatusCode)
+	}
+	return resp.ContentLength, nil
+}
+
+// contextReader is an io.Reader that stops reading once its context is done,
+// so long copies can be aborted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// storyboardInputKey is the input key of the single file of a storyboard job
+	storyboardInputKey = "input"
+	// storyboardDir is the directory of a storyboard job's outputs
+	storyboardDir = "storyboard"
+	// maxSheetSize is the most pixels a sprite sheet may be wide or high, the
+	// limit of WebP
+	maxSheetSize = 16383
+)
+
+// SubmitStoryboard queues a storyboard job tiling thumbnails of the request's
+// single input file into sprite sheets as described by req.Storyboard.
+// Defaults are filled in for the interval (5 seconds), the thumbnail width
+// (160 pixels), the grid (5 by 5) and the format (jpg).
+func (s *FFMPEGServiceImpl) SubmitStoryboard(ctx context.Context, req domain.FFMPEGRequest, userID uint) (*domain.FFMPEGResponse, error) {
+	if req.Storyboard == nil || !isInputReference(req.InputFiles[storyboardInputKey]) {
+		return nil, fmt.Errorf("%w: a storyboard job needs an http(s) input URL or upload", ErrInvalidRequest)
+	}
+	if err := s.checkUploadRefs(ctx, req.InputFiles, userID); err != nil {
+		return nil, err
+	}
+	if err := s.webhookService.CheckURL(req.WebhookURL); err != nil {
+		return nil, fmt.Errorf("%w: webhook_url: %v", ErrInvalidRequest, err)
+	}
+	spec := *req.Storyboard
+	if err := normalizeStoryboard(&spec); err != nil {
+		return nil, err
+	}
+	req.Storyboard = &spec
+	if err := s.checkDestinations(ctx, req.Destinations, []string{"sprites", "vtt"}, userID); err != nil {
+		return nil, err
+	}
+
+	job := &domain.JobStatus{
+		UUID:            uuid.New().String(),
+		Type:            domain.JobTypeStoryboard,
+		Status:          domain.JobStatusQueued,
+		UserID:          userID,
+		OriginalRequest: &req,
+		OutputFileTypes: ",image,unknown,",
+	}
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	position, err := s.jobRepo.QueuePosition(ctx, job)
+	if err != nil {
+		logger.Error("failed to get queue position", "uuid", job.UUID, "error", err)
+	}
+
+	s.queue.Notify()
+
+	return &domain.FFMPEGResponse{
+		UUID:          job.UUID,
+		Status:        domain.JobStatusQueued,
+		QueuePosition: position,
+	}, nil
+}
+
+// normalizeStoryboard validates a storyboard spec and fills in its defaults
+func normalizeStoryboard(spec *domain.StoryboardSpec) error {
+	if spec.Interval == 0 {
+		spec.Interval = 5
+	}
+	if spec.Width == 0 {
+		spec.Width = 160
+	}
+	if spec.Columns == 0 {
+		spec.Columns = 5
+	}
+	if spec.Rows == 0 {
+		spec.Rows = 5
+	}
+	if spec.Format == "" {
+		spec.Format = "jpg"
+	}
+
+	switch {
+	case spec.Interval < 0.1 || spec.Interval > 3600:
+		return fmt.Errorf("%w: interval must be between 0.1 and 3600 seconds", ErrInvalidRequest)
+	case spec.Width < 16 || spec.Width > 1920 || spec.Height < 0 || spec.Height > 1920 || (spec.Height > 0 && spec.Height < 16):
+		return fmt.Errorf("%w: thumbnails must be between 16 and 1920 pixels wide and high", ErrInvalidRequest)
+	case spec.Columns < 1 || spec.Rows < 1 || spec.Columns > 50 || spec.Rows > 50:
+		return fmt.Errorf("%w: columns and rows must be between 1 and 50", ErrInvalidRequest)
+	case spec.Columns*spec.Width > maxSheetSize || spec.Rows*spec.Height > maxSheetSize:
+		return fmt.Errorf("%w: sprite sheets may be at most %d pixels wide and high", ErrInvalidRequest, maxSheetSize)
+	case spec.Format != "jpg" && spec.Format != "webp":
+		return fmt.Errorf("%w: format %q must be jpg or webp", ErrInvalidRequest, spec.Format)
+	}
+	return nil
+}
+
+// storyboardArgs returns the FFmpeg arguments extracting a thumbnail every
+// interval from an input and tiling them into numbered sprite sheets in dir
+func storyboardArgs(spec domain.StoryboardSpec, inputPath string, tileHeight int, dir string) []string {
+	args := []string{
+		"-i", inputPath,
+		"-an", "-sn", "-dn",
+		"-vf", fmt.Sprintf("fps=1/%g,scale=w=%d:h=%d,tile=%dx%d", spec.Interval, spec.Width, tileHeight, spec.Columns, spec.Rows),
+	}
+	if spec.Format == "webp" {
+		args = append(args, "-c:v", "libwebp", "-quality", "80")
+	} else {
+		args = append(args, "-q:v", "3")
+	}
+	return append(args, "-f", "image2", absolutePath(filepath.Join(dir, "sprite_%05d."+spec.Format)))
+}
+
+// storyboardVTT returns a WebVTT file with a cue for every thumbnail, whose
+// payload is the sheet the thumbnail is on and its region, relative to the
+// directory of the sheets
+func storyboardVTT(layout domain.StoryboardLayout, sheets []string, duration float64) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	perSheet := layout.Columns * layout.Rows
+	for i := 0; i < layout.Thumbnails; i++ {
+		start := float64(i) * layout.Interval
+		end := start + layout.Interval
+		if duration > 0 {
+			end = min(end, duration)
+		}
+		position := i % perSheet
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), path.Base(sheets[i/perSheet]),
+			position%layout.Columns*layout.TileWidth, position/layout.Columns*layout.TileHeight,
+			layout.TileWidth, layout.TileHeight)
+	}
+	return b.String()
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp, HH:MM:SS.mmm
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// processStoryboardJob downloads and probes the input of a storyboard job,
+// tiles its thumbnails into sprite sheets, writes the WebVTT file mapping
+// time ranges to them and uploads both, keyed sprites and vtt
+func (s *FFMPEGServiceImpl) processStoryboardJob(ctx, jobCtx context.Context, job *domain.JobStatus) {
+	startTime := time.Now()
+	req := *job.OriginalRequest
+	spec := *req.Storyboard
+
+	tempDir := filepath.Join(s.config.FFMPEG.TempDirectory, job.UUID)
+	dir := filepath.Join(tempDir, storyboardDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to create temp directory: %v", err))
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Download and probe the input (25% of progress)
+	input, err := s.downloadVideoInput(jobCtx, req.InputFiles[storyboardInputKey], job.UserID)
+	if input != nil {
+		defer s.storageService.DeleteFile(ctx, input.Path)
+	}
+	if err != nil {
+		s.failJob(ctx, jobCtx, job, err.Error())
+		return
+	}
+	job.InputProbes = domain.MediaProbesMap{storyboardInputKey: *input.Probe}
+
+	// Without a height the thumbnails keep the aspect ratio of the source
+	tileHeight := spec.Height
+	if tileHeight == 0 {
+		tileHeight = max(int(math.Round(float64(spec.Width)*float64(input.Height)/float64(input.Width)/2))*2, 2)
+	}
+	if spec.Rows*tileHeight > maxSheetSize {
+		s.failJob(ctx, jobCtx, job, fmt.Sprintf("sprite sheets would be %d pixels high, more than %d", spec.Rows*tileHeight, maxSheetSize))
+		return
+	}
+	duration := probeDuration(input.Probe)
+	job.ExpectedDuration = duration
+	job.Progress = 25
+	s.saveProgress(ctx, job)
+
+	// Extract and tile the thumbnails (25-75% of progress)
+	ffmpegStartTime := time.Now()
+	if _, err := s.runFFmpeg(ctx, jobCtx, job, tempDir, storyboardArgs(spec, absolutePath(input.Path), tileHeight, dir), nil, 25, 75); err != nil {
+		s.failJob(ctx, jobCtx, job, err.Error())
+		return
+	}
+	job.FFmpegCommandRunSeconds = time.Since(ffmpegStartTime).Seconds()
+
+	spritesName := storyboardDir + "/sprite_*." + spec.Format
+	sheets, err := collectOutputFiles(tempDir, spritesName)
+	if err != nil {
+		s.failJob(ctx, jobCtx, job, fmt.Sprintf("FFmpeg wrote no sprite sheets: %v", err))
+		return
+	}
+
+	// The fps filter takes the first thumbnail at the start and one every
+	// interval after it
+	layout := domain.StoryboardLayout{
+		Interval:   spec.Interval,
+		TileWidth:  spec.Width,
+		TileHeight: tileHeight,
+		Columns:    spec.Columns,
+		Rows:       spec.Rows,
+		Sheets:     len(sheets),
+		Thumbnails: len(sheets) * spec.Columns * spec.Rows,
+	}
+	if duration > 0 {
+		layout.Thumbnails = min(layout.Thumbnails, int(math.Ceil(duration/spec.Interval)))
+	}
+	vttName := storyboardDir + "/storyboard.vtt"
+	if err := os.WriteFile(filepath.Join(tempDir, filepath.FromSlash(vttName)), []byte(storyboardVTT(layout, sheets, duration)), 0644); err != nil {
+		s.failJob(ctx, jobCtx, job, fmt.Sprintf("failed to write WebVTT file: %v", err))
+		return
+	}
+
+	// Upload the sheets and the WebVTT file (75-99% of progress)
+	job.Progress = 75
+	s.saveProgress(ctx, job)
+
+	totalOutputSize, err := s.uploadOutputs(ctx, jobCtx, job, tempDir, map[string]string{"sprites": spritesName, "vtt": vttName})
+	if err != nil {
+		s.failJob(ctx, jobCtx, job, err.Error())
+		return
+	}
+	for key, metadata := range job.OutputFiles {
+		if key == "sprites" {
+			metadata.Width = layout.Columns * layout.TileWidth
+			metadata.Height = layout.Rows * layout.TileHeight
+		}
+		metadata.Storyboard = &layout
+		job.OutputFiles[key] = metadata
+	}
+
+	job.Status = domain.JobStatusSuccess
+	job.Progress = 100
+	job.TotalProcessingSeconds = time.Since(startTime).Seconds()
+	job.Result = "Successfully generated storyboard"
+	if !s.saveJob(ctx, job) {
+		return
+	}
+	s.notifyStatus(ctx, job)
+
+	go s.userRepo.IncrementUsage(ctx, job.UserID)
+	go s.userRepo.IncrementBytesProcessed(ctx, job.UserID, input.Size+totalOutputSize)
+}
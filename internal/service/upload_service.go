@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/repository"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// uploadRefPrefix starts the input files that refer to uploads by their UUID
+const uploadRefPrefix = "upload://"
+
+// unsafeFileNameRegexp matches the characters replaced in the file names of
+// uploads, which become part of their object keys
+var unsafeFileNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// UploadServiceImpl implements UploadService
+type UploadServiceImpl struct {
+	uploadRepo     repository.UploadRepository
+	storageService StorageService
+	config         *config.Config
+}
+
+// NewUploadService creates a new UploadService
+func NewUploadService(uploadRepo repository.UploadRepository, storageService StorageService, config *config.Config) UploadService {
+	return &UploadServiceImpl{
+		uploadRepo:     uploadRepo,
+		storageService: storageService,
+		config:         config,
+	}
+}
+
+// CreateUpload streams a file to storage under user_<id>/input/<uuid>/ and
+// records it, hashing it on the way. The file may be at most the configured
+// upload size and what is left of the user's quota.
+func (s *UploadServiceImpl) CreateUpload(ctx context.Context, r io.Reader, fileName string, contentType string, userID uint) (*domain.Upload, error) {
+	quotaLeft, err := s.quotaLeft(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := min(s.config.Storage.UploadMaxSize, quotaLeft)
+
+	upload := &domain.Upload{
+		UUID:        uuid.New().String(),
+		UserID:      userID,
+		Status:      domain.UploadStatusComplete,
+		FileName:    fileName,
+		ContentType: contentType,
+	}
+	objectKey := uploadObjectKey(upload)
+	upload.ObjectKey = fmt.Sprintf("user_%d/%s", userID, objectKey)
+
+	hash := sha256.New()
+	limited := &limitedReader{r: io.TeeReader(r, hash), remaining: maxSize}
+	if _, err := s.storageService.UploadStream(ctx, limited, -1, objectKey, contentType, userID); err != nil {
+		if limited.exceeded {
+			return nil, s.sizeError(maxSize)
+		}
+		return nil, fmt.Errorf("failed to store upload: %w", err)
+	}
+	upload.SizeBytes = maxSize - limited.remaining
+	upload.Offset = upload.SizeBytes
+	upload.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	if err := s.createUpload(ctx, upload); err != nil {
+		if err := s.storageService.DeleteObject(ctx, objectKey, userID); err != nil {
+			logger.Warn("failed to delete upload", "upload", upload.UUID, "error", err)
+		}
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetUpload returns an upload of the user
+func (s *UploadServiceImpl) GetUpload(ctx context.Context, uuid string, userID uint) (*domain.Upload, error) {
+	upload, err := s.uploadRepo.FindByUUID(ctx, uuid)
+	if err != nil || upload.UserID != userID {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// quotaLeft returns how many bytes the user may still upload
+func (s *UploadServiceImpl) quotaLeft(ctx context.Context, userID uint) (int64, error) {
+	if s.config.Storage.UploadQuota <= 0 {
+		return math.MaxInt64, nil
+	}
+	used, err := s.uploadRepo.SumBytes(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get upload quota usage: %w", err)
+	}
+	return max(s.config.Storage.UploadQuota-used, 0), nil
+}
+
+// createUpload records an upload unless the user's uploads would then take up
+// more than their quota. The quota is checked in the same transaction, so
+// uploads created at the same time, on any node, can't exceed it together.
+func (s *UploadServiceImpl) createUpload(ctx context.Context, upload *domain.Upload) error {
+	if s.config.Storage.UploadQuota <= 0 {
+		if err := s.uploadRepo.Create(ctx, upload); err != nil {
+			return fmt.Errorf("failed to create upload: %w", err)
+		}
+		return nil
+	}
+
+	created, err := s.uploadRepo.CreateWithinQuota(ctx, upload, s.config.Storage.UploadQuota)
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+	if !created {
+		quotaLeft, err := s.quotaLeft(ctx, upload.UserID)
+		if err != nil {
+			return err
+		}
+		return s.quotaError(quotaLeft)
+	}
+	return nil
+}
+
+// sizeError returns the error for an upload larger than maxSize, the smaller
+// of the maximum upload size and what is left of the user's quota
+func (s *UploadServiceImpl) sizeError(maxSize int64) error {
+	if maxSize < s.config.Storage.UploadMaxSize {
+		return s.quotaError(maxSize)
+	}
+	return fmt.Errorf("%w: the maximum is %d MB", ErrUploadTooLarge, s.config.Storage.UploadMaxSize/1024/1024)
+}
+
+// quotaError returns the error for an upload larger than the quotaLeft bytes
+// left of the user's quota
+func (s *UploadServiceImpl) quotaError(quotaLeft int64) error {
+	return fmt.Errorf("%w: %d MB of %d MB left", ErrUploadQuotaExceeded, quotaLeft/1024/1024, s.config.Storage.UploadQuota/1024/1024)
+}
+
+// uploadObjectKey returns the key of an upload's object, relative to the
+// user's prefix
+func uploadObjectKey(upload *domain.Upload) string {
+	return fmt.Sprintf("input/%s/%s", upload.UUID, safeFileName(upload.FileName))
+}
+
+// safeFileName returns the base name of an uploaded file with the characters
+// that don't belong in object keys replaced
+func safeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Trim(unsafeFileNameRegexp.ReplaceAllString(name, "_"), "._")
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+// limitedReader is an io.Reader that fails once more than remaining bytes
+// were read
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		l.exceeded = true
+		return 0, ErrUploadTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"ffmpeg-api/internal/domain"
+	"fmt"
+	"os"
+)
+
+// videoInput is the downloaded and probed video input of a built-in job type
+type videoInput struct {
+	Path     string
+	Size     int64
+	Probe    *domain.MediaProbe
+	Width    int // as displayed, with the rotation applied
+	Height   int
+	HasAudio bool
+}
+
+// downloadVideoInput downloads the single input of a job of the user and
+// probes it. The caller deletes the file with the storage service once done.
+// It fails if the input has no video stream.
+func (s *FFMPEGServiceImpl) downloadVideoInput(jobCtx context.Context, ref string, userID uint) (*videoInput, error) {
+	url, err := s.resolveInput(jobCtx, ref, userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input file: %v", err)
+	}
+	path, err := s.storageService.DownloadFile(jobCtx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download input file: %v", err)
+	}
+	input := &videoInput{Path: path}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return input, fmt.Errorf("failed to get input file size: %v", err)
+	}
+	input.Size = info.Size()
+
+	input.Probe, err = probeMedia(jobCtx, s.config.FFMPEG.ProbePath, path, localInputProtocols)
+	if err != nil {
+		return input, fmt.Errorf("failed to probe input file: %v", err)
+	}
+
+	var video *domain.MediaStream
+	for i, stream := range input.Probe.Streams {
+		switch {
+		case stream.CodecType == "video" && video == nil:
+			video = &input.Probe.Streams[i]
+		case stream.CodecType == "audio":
+			input.HasAudio = true
+		}
+	}
+	if video == nil || video.Width == 0 || video.Height == 0 {
+		return input, fmt.Errorf("the input file has no video stream")
+	}
+
+	input.Width, input.Height = video.Width, video.Height
+	if video.Rotation%180 != 0 {
+		input.Width, input.Height = input.Height, input.Width
+	}
+	return input, nil
+}
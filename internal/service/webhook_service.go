@@ -0,0 +1,407 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"ffmpeg-api/internal/config"
+	"ffmpeg-api/internal/domain"
+	"ffmpeg-api/internal/logger"
+	"ffmpeg-api/internal/repository"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// webhookEvents are the job statuses that trigger a webhook by default
+var webhookEvents = []string{
+	domain.JobStatusProcessing,
+	domain.JobStatusSuccess,
+	domain.JobStatusFailed,
+	domain.JobStatusCancelled,
+}
+
+const (
+	webhookPollInterval = time.Second // how often scheduled attempts are looked for
+	webhookBatchSize    = 100         // most attempts taken per look
+	webhookConcurrency  = 16          // most attempts made at once per node
+	// webhookClaimMargin is how much longer than WEBHOOK_TIMEOUT a node's
+	// claim of an attempt lasts, after which another node makes it
+	webhookClaimMargin = time.Minute
+)
+
+// interruptedAttempt is the error of an attempt the server stopped making
+// before its outcome was stored
+const interruptedAttempt = "the attempt was interrupted before its outcome was recorded"
+
+// nonPublicPrefixes are the address ranges webhooks are not delivered to
+// besides loopback, link-local, multicast and private addresses
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, may translate to private IPv4 addresses
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// errNonPublicAddress is returned when a webhook would connect to an address
+// that is not public
+var errNonPublicAddress = errors.New("webhooks can't be delivered to loopback, link-local or private addresses")
+
+// WebhookServiceImpl implements WebhookService. Delivery attempts are
+// scheduled in the webhook_deliveries table, so retries survive restarts and
+// are shared by the nodes.
+type WebhookServiceImpl struct {
+	deliveryRepo repository.WebhookDeliveryRepository
+	jobRepo      repository.JobRepository
+	userRepo     repository.UserRepository
+	config       *config.Config
+	client       *http.Client
+	wake         chan struct{}
+	slots        chan struct{} // one per attempt being made
+}
+
+// NewWebhookService creates a new WebhookService
+func NewWebhookService(
+	deliveryRepo repository.WebhookDeliveryRepository,
+	jobRepo repository.JobRepository,
+	userRepo repository.UserRepository,
+	config *config.Config,
+) WebhookService {
+	client := &http.Client{Timeout: config.Webhook.Timeout}
+	if !config.Webhook.AllowPrivate {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// A proxy's address would be checked rather than the webhook's
+		transport.Proxy = nil
+		transport.DialContext = publicDialer().DialContext
+		client.Transport = transport
+	}
+	return &WebhookServiceImpl{
+		deliveryRepo: deliveryRepo,
+		jobRepo:      jobRepo,
+		userRepo:     userRepo,
+		config:       config,
+		client:       client,
+		wake:         make(chan struct{}, 1),
+		slots:        make(chan struct{}, webhookConcurrency),
+	}
+}
+
+// Start launches the loop that makes the scheduled delivery attempts once
+// they are due. It stops when ctx is cancelled.
+func (s *WebhookServiceImpl) Start(ctx context.Context) {
+	go func() {
+		for {
+			s.deliverDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			case <-time.After(webhookPollInterval):
+			}
+		}
+	}()
+}
+
+// CheckURL returns an error if webhooks can't be delivered to a URL: it must
+// be an http(s) URL and, unless WEBHOOK_ALLOW_PRIVATE_NETWORKS is set, not
+// name a loopback, link-local or private address. Host names are checked
+// again once resolved, for every connection.
+func (s *WebhookServiceImpl) CheckURL(rawURL string) error {
+	if rawURL == "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("must be an http(s) URL")
+	}
+	if s.config.Webhook.AllowPrivate {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); (err == nil && !isPublicAddr(ip)) || u.Hostname() == "localhost" {
+		return errNonPublicAddress
+	}
+	return nil
+}
+
+// Notify sends the job's current status to its webhook URL in the background,
+// if the job has one and subscribed to the status.
+func (s *WebhookServiceImpl) Notify(ctx context.Context, job *domain.JobStatus) {
+	req := job.OriginalRequest
+	if req == nil || req.WebhookURL == "" {
+		return
+	}
+
+	events := req.WebhookEvents
+	if len(events) == 0 {
+		events = webhookEvents
+	}
+	if !slices.Contains(events, job.Status) {
+		return
+	}
+
+	// Snapshot the job now, it keeps changing while the delivery is retried
+	deliveryUUID := uuid.New().String()
+	payload, err := json.Marshal(domain.WebhookPayload{
+		Event:       job.Status,
+		DeliveryID:  deliveryUUID,
+		Timestamp:   time.Now(),
+		JobUUID:     job.UUID,
+		Status:      job.Status,
+		Progress:    job.Progress,
+		Result:      job.Result,
+		OutputFiles: job.OutputFiles,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+	})
+	if err != nil {
+		logger.Error("failed to encode webhook payload", "uuid", job.UUID, "error", err)
+		return
+	}
+
+	now := time.Now()
+	delivery := domain.WebhookDelivery{
+		DeliveryUUID: deliveryUUID,
+		JobUUID:      job.UUID,
+		UserID:       job.UserID,
+		Event:        job.Status,
+		URL:          req.WebhookURL,
+		Payload:      string(payload),
+		Attempt:      1,
+		ScheduledAt:  &now,
+	}
+	if err := s.deliveryRepo.Create(context.WithoutCancel(ctx), &delivery); err != nil {
+		logger.Error("failed to schedule webhook delivery", "uuid", job.UUID, "error", err)
+		return
+	}
+	s.wakeUp()
+}
+
+// ListDeliveries returns every delivery attempt made for a job
+func (s *WebhookServiceImpl) ListDeliveries(ctx context.Context, jobUUID string, userID uint) ([]domain.WebhookDelivery, error) {
+	if err := s.checkJobOwner(ctx, jobUUID, userID); err != nil {
+		return nil, err
+	}
+	deliveries, err := s.deliveryRepo.FindByJobUUID(ctx, jobUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// Redeliver schedules the payload of an earlier delivery attempt to be sent
+// again, retried like a new delivery, and returns the scheduled attempt.
+func (s *WebhookServiceImpl) Redeliver(ctx context.Context, jobUUID string, deliveryID uint, userID uint) (*domain.WebhookDelivery, error) {
+	if err := s.checkJobOwner(ctx, jobUUID, userID); err != nil {
+		return nil, err
+	}
+
+	previous, err := s.deliveryRepo.FindByID(ctx, deliveryID)
+	if err != nil || previous.JobUUID != jobUUID {
+		return nil, ErrDeliveryNotFound
+	}
+
+	// Sent by the delivery loop, as the receiver may take up to the
+	// webhook timeout to answer
+	now := time.Now()
+	delivery := domain.WebhookDelivery{
+		DeliveryUUID: uuid.New().String(),
+		JobUUID:      previous.JobUUID,
+		UserID:       previous.UserID,
+		Event:        previous.Event,
+		URL:          previous.URL,
+		Payload:      previous.Payload,
+		Attempt:      1,
+		ScheduledAt:  &now,
+	}
+	if err := s.deliveryRepo.Create(ctx, &delivery); err != nil {
+		return nil, err
+	}
+	s.wakeUp()
+	return &delivery, nil
+}
+
+// wakeUp makes the delivery loop look for due attempts now
+func (s *WebhookServiceImpl) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *WebhookServiceImpl) checkJobOwner(ctx context.Context, jobUUID string, userID uint) error {
+	job, err := s.jobRepo.FindByUUID(ctx, jobUUID)
+	if err != nil || job.UserID != userID {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// deliverDue makes the delivery attempts that are due, each in its own
+// goroutine once it is claimed
+func (s *WebhookServiceImpl) deliverDue(ctx context.Context) {
+	due, err := s.deliveryRepo.FindDue(ctx, time.Now(), webhookBatchSize)
+	if err != nil {
+		logger.Error("failed to find due webhook deliveries", "error", err)
+		return
+	}
+	for i := range due {
+		delivery := due[i]
+		until := time.Now().Add(s.config.Webhook.Timeout + webhookClaimMargin)
+		claimed, err := s.deliveryRepo.Claim(ctx, delivery.ID, until, interruptedAttempt)
+		if err != nil {
+			logger.Error("failed to claim webhook delivery", "job_uuid", delivery.JobUUID, "error", err)
+			continue
+		}
+		if !claimed {
+			// Another node makes the attempt
+			continue
+		}
+
+		s.slots <- struct{}{}
+		go func() {
+			defer func() { <-s.slots }()
+			s.deliver(context.WithoutCancel(ctx), &delivery)
+		}()
+	}
+}
+
+// deliver makes a claimed delivery attempt and stores its outcome. A failed
+// attempt schedules the next one until the configured number of attempts is
+// used up, doubling the delay after every failure.
+func (s *WebhookServiceImpl) deliver(ctx context.Context, delivery *domain.WebhookDelivery) {
+	s.attempt(ctx, delivery)
+	delivery.ScheduledAt = nil
+	delivery.ClaimedUntil = nil
+	if err := s.deliveryRepo.Update(ctx, delivery); err != nil {
+		logger.Error("failed to record webhook delivery", "job_uuid", delivery.JobUUID, "error", err)
+		return
+	}
+	if delivery.Success {
+		return
+	}
+
+	logger.Warn("webhook delivery failed",
+		"job_uuid", delivery.JobUUID,
+		"event", delivery.Event,
+		"attempt", delivery.Attempt,
+		"status_code", delivery.StatusCode,
+		"error", delivery.Error)
+
+	if delivery.Attempt >= s.config.Webhook.MaxAttempts {
+		return
+	}
+	scheduledAt := time.Now().Add(s.config.Webhook.RetryBaseDelay << (delivery.Attempt - 1))
+	next := domain.WebhookDelivery{
+		DeliveryUUID: delivery.DeliveryUUID,
+		JobUUID:      delivery.JobUUID,
+		UserID:       delivery.UserID,
+		Event:        delivery.Event,
+		URL:          delivery.URL,
+		Payload:      delivery.Payload,
+		Attempt:      delivery.Attempt + 1,
+		ScheduledAt:  &scheduledAt,
+	}
+	if err := s.deliveryRepo.Create(ctx, &next); err != nil {
+		logger.Error("failed to schedule webhook delivery", "job_uuid", delivery.JobUUID, "error", err)
+	}
+}
+
+// attempt makes a single delivery attempt and stores its outcome on the
+// delivery
+func (s *WebhookServiceImpl) attempt(ctx context.Context, delivery *domain.WebhookDelivery) {
+	start := time.Now()
+	delivery.CreatedAt = start
+	delivery.StatusCode, delivery.Error = s.send(ctx, delivery)
+	delivery.Success = delivery.Error == "" && delivery.StatusCode >= 200 && delivery.StatusCode < 300
+	if delivery.Error == "" && !delivery.Success {
+		delivery.Error = fmt.Sprintf("unexpected status code %d", delivery.StatusCode)
+	}
+	delivery.DurationMs = time.Since(start).Milliseconds()
+}
+
+// send posts the signed payload and returns the response status code, or an
+// error message if no response was received.
+func (s *WebhookServiceImpl) send(ctx context.Context, delivery *domain.WebhookDelivery) (int, string) {
+	user, err := s.userRepo.FindByID(ctx, delivery.UserID)
+	if err != nil {
+		return 0, fmt.Sprintf("failed to find user: %v", err)
+	}
+	if user.WebhookSecret == "" {
+		return 0, "user has no webhook secret, log in again to create one"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Sprintf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ffmpeg-api-webhook")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.DeliveryUUID)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signPayload(user.WebhookSecret, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	return resp.StatusCode, ""
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of payload
+func signPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// publicDialer returns a dialer that only connects to public addresses. The
+// address is checked after the host name is resolved, so DNS can't point
+// webhooks at internal services.
+func publicDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !isPublicAddr(ip) {
+				return fmt.Errorf("%w: %s", errNonPublicAddress, host)
+			}
+			return nil
+		},
+	}
+}
+
+// isPublicAddr reports whether an IP address is a public unicast address
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package validation
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -33,7 +34,7 @@ func Validate(s interface{}) error {
 		if validationErrors, ok := err.(validator.ValidationErrors); ok {
 			fieldErrors := make(map[string]string)
 			for _, e := range validationErrors {
-				fieldErrors[e.Field()] = formatError(e)
+				fieldErrors[fieldPath(e)] = formatError(e)
 			}
 			return &ValidationErrors{Errors: fieldErrors}
 		}
@@ -42,6 +43,15 @@ func Validate(s interface{}) error {
 	return nil
 }
 
+// fieldPath returns the path of the field that failed validation within the
+// validated struct, e.g. "Requests[2].FFmpegCommand"
+func fieldPath(e validator.FieldError) string {
+	if _, path, ok := strings.Cut(e.StructNamespace(), "."); ok {
+		return path
+	}
+	return e.Field()
+}
+
 // formatError formats a validation error into a human-readable message
 func formatError(e validator.FieldError) string {
 	switch e.Tag() {
@@ -57,6 +67,16 @@ func formatError(e validator.FieldError) string {
 		return "should be a valid URL"
 	case "oneof":
 		return fmt.Sprintf("should be one of: %s", e.Param())
+	case "required_without":
+		return fmt.Sprintf("should not be empty unless %s is set", e.Param())
+	case "required_without_all":
+		return fmt.Sprintf("should not be empty unless %s is set", strings.ReplaceAll(e.Param(), " ", " or "))
+	case "required_with":
+		return fmt.Sprintf("should not be empty when %s is set", e.Param())
+	case "excluded_with":
+		return fmt.Sprintf("should not be set together with %s", strings.ReplaceAll(e.Param(), " ", " or "))
+	case "excluded_without":
+		return fmt.Sprintf("should only be set together with %s", e.Param())
 	default:
 		return fmt.Sprintf("failed validation: %s", e.Tag())
 	}